@@ -0,0 +1,28 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"seraphim/lib/db"
+
+	"github.com/spf13/cobra"
+)
+
+// browseCmd represents the browse command
+var browseCmd = &cobra.Command{
+	Use:   "browse <tag>",
+	Short: "Browse the schema of a stored connection",
+	Long: `Open a three pane schema browser on the stored connection tagged <tag>:
+databases, their tables and the columns, keys, indexes, foreign keys,
+approximate row count and size of the highlighted table`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeFirstArgConnectionTag,
+	Run: func(cmd *cobra.Command, args []string) {
+		db.RunBrowse(&seraphimConfig, args[0])
+	},
+}
+
+func init() {
+	databaseCmd.AddCommand(browseCmd)
+}
@@ -0,0 +1,182 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"seraphim/lib/config"
+	dh "seraphim/lib/db/query"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	completionFetchTimeout = 2 * time.Second
+	completionCacheTTL     = 5 * time.Minute
+)
+
+type completionCacheEntry struct {
+	Updated time.Time `json:"updated"`
+	Items   []string  `json:"items"`
+}
+
+// reloadCompletionConfig reads the configuration again, since the --config
+// flag is only parsed by cobra after initConfig already ran for __complete.
+func reloadCompletionConfig() {
+	if cfgFile != "" && cfgFile != viper.ConfigFileUsed() {
+		seraphimConfig = config.SeraphimConfig{}
+		initConfig()
+	}
+}
+
+// completeConnectionTags completes the tags of the stored connections.
+func completeConnectionTags(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	reloadCompletionConfig()
+	return filterPrefix(seraphimConfig.ConnectionTags(), toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeFirstArgConnectionTag completes a stored connection tag for the
+// first positional argument only.
+func completeFirstArgConnectionTag(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return completeConnectionTags(cmd, args, toComplete)
+}
+
+// completeDatabases completes the databases of the connection selected through
+// the --conn flag, fetched live and cached for a few minutes.
+func completeDatabases(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	tag, conn, ok := completionConnection(cmd)
+	if !ok {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	dbs := cachedCompletion(filepath.Join(tag, "databases"), func(ctx context.Context) ([]string, error) {
+		return dh.ListDatabases(ctx, conn)
+	})
+	return filterPrefix(dbs, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeTables completes comma separated db.table values. Databases are
+// completed first, then the tables of the database before the dot.
+func completeTables(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	tag, conn, ok := completionConnection(cmd)
+	if !ok {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	prefix := ""
+	current := toComplete
+	if i := strings.LastIndex(toComplete, ","); i >= 0 {
+		prefix = toComplete[:i+1]
+		current = toComplete[i+1:]
+	}
+
+	db, _, hasDot := strings.Cut(current, ".")
+	if !hasDot {
+		dbs := cachedCompletion(filepath.Join(tag, "databases"), func(ctx context.Context) ([]string, error) {
+			return dh.ListDatabases(ctx, conn)
+		})
+		candidates := make([]string, 0, len(dbs))
+		for _, d := range filterPrefix(dbs, current) {
+			candidates = append(candidates, prefix+d+".")
+		}
+		return candidates, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	}
+
+	tables := cachedCompletion(filepath.Join(tag, "tables", db), func(ctx context.Context) ([]string, error) {
+		return dh.ListTables(ctx, conn, db)
+	})
+	candidates := make([]string, 0, len(tables))
+	for _, t := range tables {
+		if candidate := db + "." + t; strings.HasPrefix(candidate, current) {
+			candidates = append(candidates, prefix+candidate)
+		}
+	}
+	return candidates, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completionConnection resolves the connection named by the --conn flag, or by
+// the first positional argument when the command has no such flag.
+func completionConnection(cmd *cobra.Command) (string, config.StoredConnection, bool) {
+	reloadCompletionConfig()
+	var tag string
+	if f := cmd.Flags().Lookup("conn"); f != nil {
+		tag = f.Value.String()
+	}
+	if tag == "" && cmd.Flags().NArg() > 0 {
+		tag = cmd.Flags().Arg(0)
+	}
+	conn, ok := seraphimConfig.Connection(tag)
+	return tag, conn, ok
+}
+
+// cachedCompletion returns the candidates stored under key in the user cache
+// directory, refreshing them through fetch once they are older than
+// completionCacheTTL. Fetch errors are swallowed since completion must never
+// print anything unexpected to the shell.
+func cachedCompletion(key string, fetch func(ctx context.Context) ([]string, error)) []string {
+	cacheFile := ""
+	if dir, err := os.UserCacheDir(); err == nil {
+		scope := strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(viper.ConfigFileUsed())
+		cacheFile = filepath.Join(dir, "seraphim", "completion", scope, key+".json")
+	}
+
+	var entry completionCacheEntry
+	if cacheFile != "" {
+		if content, err := os.ReadFile(cacheFile); err == nil && json.Unmarshal(content, &entry) == nil {
+			if time.Since(entry.Updated) < completionCacheTTL {
+				return entry.Items
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), completionFetchTimeout)
+	defer cancel()
+	items, err := fetch(ctx)
+	if err != nil {
+		// Serve stale candidates rather than nothing
+		return entry.Items
+	}
+
+	if cacheFile != "" {
+		if content, err := json.Marshal(completionCacheEntry{Updated: time.Now(), Items: items}); err == nil {
+			if os.MkdirAll(filepath.Dir(cacheFile), 0o700) == nil {
+				os.WriteFile(cacheFile, content, 0o600)
+			}
+		}
+	}
+	return items
+}
+
+func filterPrefix(values []string, prefix string) []string {
+	filtered := make([]string, 0, len(values))
+	for _, v := range values {
+		if strings.HasPrefix(v, prefix) {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
+}
+
+// registerConnectionCompletions wires the dynamic completions on the --conn,
+// --db and --tables flags of cmd, whichever are defined.
+func registerConnectionCompletions(cmd *cobra.Command) {
+	completions := map[string]func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective){
+		"conn":   completeConnectionTags,
+		"db":     completeDatabases,
+		"tables": completeTables,
+	}
+	for name, fn := range completions {
+		if cmd.Flags().Lookup(name) != nil {
+			cobra.CheckErr(cmd.RegisterFlagCompletionFunc(name, fn))
+		}
+	}
+}
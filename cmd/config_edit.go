@@ -5,29 +5,40 @@ package cmd
 
 import (
 	"fmt"
-	"log"
 	"seraphim/lib/config"
 
 	"github.com/spf13/cobra"
 )
 
+var rawEdit bool
+
 // editCmd represents the edit command
 var editCmd = &cobra.Command{
 	Use:   "edit",
 	Short: "Edit the configuration from the cli",
+	Long: `Open a tabbed editor covering general settings, branding and stored connections.
+Use ctrl+n / ctrl+p to switch tab and ctrl+s to save.
+With --raw the configuration file is opened in $VISUAL / $EDITOR instead and
+is only saved once it parses and validates`,
 	Run: func(cmd *cobra.Command, args []string) {
-		r := config.RunCfgEditForm(&seraphimConfig)
-		if r.Err != nil {
-			log.Fatal("something went wrong")
+		if rawEdit {
+			if r := config.RunRawEdit(); r.Err == nil {
+				fmt.Printf("%s\n", r.Msg)
+			} else {
+				fmt.Printf("Oh no, something went wrong: \n%v\n", r.Err.Error())
+			}
+			return
 		}
-		if operationResult := config.SaveConfig(r.EditedConfig); operationResult.Err == nil {
-			fmt.Printf("%s\n", operationResult.Msg)
+		if r := config.RunCfgEditForm(&seraphimConfig); r.Err == nil {
+			fmt.Printf("%s\n", r.Msg)
 		} else {
-			fmt.Printf("Oh no, something went wrong: \n%v", operationResult.Err.Error())
+			fmt.Printf("Oh no, something went wrong: \n%v\n", r.Err.Error())
 		}
 	},
 }
 
 func init() {
 	configCmd.AddCommand(editCmd)
+
+	editCmd.Flags().BoolVar(&rawEdit, "raw", false, "Edit the raw configuration file in $VISUAL / $EDITOR")
 }
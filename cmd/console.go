@@ -0,0 +1,33 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"seraphim/lib/db"
+
+	"github.com/spf13/cobra"
+)
+
+var consoleDb string
+
+// consoleCmd represents the console command
+var consoleCmd = &cobra.Command{
+	Use:   "console <tag>",
+	Short: "Interactive SQL console on a stored connection",
+	Long: `Open an interactive SQL console on the stored connection tagged <tag>.
+Statements ending with ";" run on enter, \? lists the available meta-commands.
+History is kept per connection next to the configuration file`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeFirstArgConnectionTag,
+	Run: func(cmd *cobra.Command, args []string) {
+		db.RunConsole(&seraphimConfig, args[0], consoleDb)
+	},
+}
+
+func init() {
+	databaseCmd.AddCommand(consoleCmd)
+
+	consoleCmd.Flags().StringVar(&consoleDb, "db", "", "Database to connect to (defaults to the connection default database)")
+	registerConnectionCompletions(consoleCmd)
+}
@@ -0,0 +1,199 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"seraphim/lib/db"
+	dh "seraphim/lib/db/query"
+	"seraphim/lib/dbcopy"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/huh"
+	"github.com/spf13/cobra"
+)
+
+var (
+	copyFrom      string
+	copyTo        string
+	copyTables    []string
+	copyMode      string
+	copyBatchSize int
+	copyParallel  int
+	copyYes       bool
+)
+
+// copyCmd represents the copy command
+var copyCmd = &cobra.Command{
+	Use:   "copy",
+	Short: "Copy tables from one database to another",
+	Long: `Copy the rows of tables between two databases, which may live on different
+stored connections. Tables missing on the target are created with their
+primary key, indexes and foreign keys, translating column types, defaults and
+auto increments when the providers differ. Conversions that lose information
+are listed before anything is written. Without --tables the tables are picked
+interactively.
+Rows are streamed in batches of --batch-size rows, written by --parallel
+workers per table. Existing target rows are kept with --mode append, deleted
+first with --mode truncate or updated by primary key with --mode upsert`,
+	Example: `  seraphim db copy --from prod/shop --to local/shop
+  seraphim db copy --from prod/shop --to staging/shop --tables orders,customers --mode upsert
+  seraphim db copy --from prod/shop --to local/shop --mode truncate --yes`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !slices.Contains(dbcopy.Modes, copyMode) {
+			return fmt.Errorf("unknown mode %q, expected one of %s", copyMode, strings.Join(dbcopy.Modes, ", "))
+		}
+		if copyBatchSize <= 0 || copyParallel <= 0 {
+			return fmt.Errorf("--batch-size and --parallel must be positive")
+		}
+		cmd.SilenceUsage = true
+
+		fromTag, fromDb, fromConn, err := parseConnectionTarget(copyFrom)
+		if err != nil {
+			return err
+		}
+		toTag, toDb, toConn, err := parseConnectionTarget(copyTo)
+		if err != nil {
+			return err
+		}
+		from := dbcopy.Endpoint{Conn: fromConn, Database: fromDb}
+		to := dbcopy.Endpoint{Conn: toConn, Database: toDb}
+		if fromConn == toConn && fromDb == toDb {
+			return fmt.Errorf("the source and the target are the same database")
+		}
+
+		tables := copyTables
+		if len(tables) == 0 {
+			tables = db.RunTableSelector(fromConn, fromDb)
+			if len(tables) == 0 {
+				fmt.Println("---> No table selected, nothing was copied!")
+				return nil
+			}
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		plan, err := dbcopy.NewPlan(ctx, from, to, tables)
+		if err != nil {
+			return err
+		}
+		if len(plan.Issues) > 0 {
+			fmt.Printf("---> Creating the missing tables loses information:\n")
+			for _, issue := range plan.Issues {
+				fmt.Printf("     %s\n", issue)
+			}
+		}
+
+		question := ""
+		switch {
+		case copyMode == dbcopy.ModeTruncate:
+			question = fmt.Sprintf("Delete the rows of %d table(s) in %s/%s before copying?", len(tables), toTag, toDb)
+		case len(plan.Issues) > 0:
+			question = "Copy with these lossy conversions?"
+		}
+		if question != "" && !copyYes {
+			var confirm bool
+			huh.NewConfirm().
+				Title(question).
+				Affirmative("Yes!").
+				Negative("No.").
+				Value(&confirm).Run()
+			if !confirm {
+				fmt.Println("Aborted operation")
+				return nil
+			}
+		}
+
+		fmt.Printf("---> Copying %d table(s) from %s/%s to %s/%s...\n", len(tables), fromTag, fromDb, toTag, toDb)
+		var total int64
+		opts := dbcopy.Options{Mode: copyMode, BatchSize: copyBatchSize, Parallel: copyParallel}
+		err = dbcopy.Copy(ctx, plan, opts, func(r dbcopy.TableResult) {
+			created := ""
+			if r.Created {
+				created = ", created"
+			}
+			fmt.Printf("     %s: %d row(s) in %s%s\n", r.Table, r.Rows, r.Duration.Round(time.Millisecond), created)
+			for _, w := range r.Warnings {
+				fmt.Fprintf(os.Stderr, "     %s: %s\n", r.Table, w)
+			}
+			total += r.Rows
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("---> Copied %d row(s) successfully!\n", total)
+		return nil
+	},
+}
+
+// completeConnectionTarget completes tag/db values, databases being fetched
+// live once a tag is typed.
+func completeConnectionTarget(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	reloadCompletionConfig()
+	tag, _, hasSlash := strings.Cut(toComplete, "/")
+	conn, ok := seraphimConfig.Connection(tag)
+	if !hasSlash || !ok {
+		candidates := make([]string, 0)
+		for _, t := range filterPrefix(seraphimConfig.ConnectionTags(), toComplete) {
+			candidates = append(candidates, t+"/")
+		}
+		return candidates, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	}
+	dbs := cachedCompletion(filepath.Join(tag, "databases"), func(ctx context.Context) ([]string, error) {
+		return dh.ListDatabases(ctx, conn)
+	})
+	candidates := make([]string, 0, len(dbs))
+	for _, d := range dbs {
+		if candidate := tag + "/" + d; strings.HasPrefix(candidate, toComplete) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates, cobra.ShellCompDirectiveNoFileComp
+}
+
+func init() {
+	databaseCmd.AddCommand(copyCmd)
+
+	copyCmd.Flags().StringVar(&copyFrom, "from", "", "Source as tag/db, the database defaults to the connection default")
+	copyCmd.Flags().StringVar(&copyTo, "to", "", "Target as tag/db, the database defaults to the connection default")
+	copyCmd.Flags().StringSliceVar(&copyTables, "tables", nil, "Tables to copy, picked interactively when omitted")
+	copyCmd.Flags().StringVar(&copyMode, "mode", dbcopy.ModeAppend, "How to write into existing tables: "+strings.Join(dbcopy.Modes, ", "))
+	copyCmd.Flags().IntVar(&copyBatchSize, "batch-size", 1000, "Number of rows written per statement")
+	copyCmd.Flags().IntVar(&copyParallel, "parallel", 4, "Number of workers writing the rows of a table")
+	copyCmd.Flags().BoolVarP(&copyYes, "yes", "y", false, "Do not ask for confirmation in truncate mode or for lossy conversions")
+	copyCmd.MarkFlagRequired("from")
+	copyCmd.MarkFlagRequired("to")
+	copyCmd.RegisterFlagCompletionFunc("from", completeConnectionTarget)
+	copyCmd.RegisterFlagCompletionFunc("to", completeConnectionTarget)
+	copyCmd.RegisterFlagCompletionFunc("mode", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return dbcopy.Modes, cobra.ShellCompDirectiveNoFileComp
+	})
+	copyCmd.RegisterFlagCompletionFunc("tables", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		reloadCompletionConfig()
+		tag, database, conn, err := parseConnectionTarget(copyFrom)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		prefix := ""
+		if i := strings.LastIndex(toComplete, ","); i >= 0 {
+			prefix = toComplete[:i+1]
+		}
+		tables := cachedCompletion(filepath.Join(tag, "tables", database), func(ctx context.Context) ([]string, error) {
+			return dh.ListTables(ctx, conn, database)
+		})
+		candidates := make([]string, 0, len(tables))
+		for _, t := range filterPrefix(tables, strings.TrimPrefix(toComplete, prefix)) {
+			candidates = append(candidates, prefix+t)
+		}
+		return candidates, cobra.ShellCompDirectiveNoFileComp
+	})
+}
@@ -0,0 +1,240 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"seraphim/lib/config"
+	"seraphim/lib/datadiff"
+	dh "seraphim/lib/db/query"
+	"seraphim/lib/schemadiff"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dataDiffOutput    string
+	dataDiffIgnore    []string
+	dataDiffChunkSize int
+	dataDiffMaxRows   int
+	dataDiffExitCode  bool
+
+	// The report reuses the colors of the schema diff
+	dataDiffKinds = map[string]string{
+		datadiff.Missing: schemadiff.Removed,
+		datadiff.Extra:   schemadiff.Added,
+		datadiff.Changed: schemadiff.Changed,
+	}
+	dataDiffMutedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+)
+
+type dataDiffReport struct {
+	From   schemadiff.Target      `json:"from"`
+	To     schemadiff.Target      `json:"to"`
+	Tables []datadiff.TableResult `json:"tables"`
+}
+
+// dataDiffCmd represents the data-diff command
+var dataDiffCmd = &cobra.Command{
+	Use:   "data-diff <tagA>[/db] <tagB>[/db] [table...]",
+	Short: "Compare the rows of tables across two databases",
+	Long: `Compare the rows of one or more tables of two databases by primary key and
+report the rows missing from B, the extra rows of B and the rows whose values
+differ. Without tables every table present on both sides is compared.
+Rows are hashed in chunks of --chunk-size rows and only chunks whose checksums
+differ are compared row by row, so tables are never loaded into memory.
+Values are compared as text, decimals ignoring trailing zeros.`,
+	Example: `  seraphim db data-diff prod/shop replica/shop orders customers
+  seraphim db data-diff prod/shop staging/shop --ignore updated_at,orders.note
+  seraphim db data-diff prod staging -o json --exit-code`,
+	Args: cobra.MinimumNArgs(2),
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) < 2 {
+			return completeConnectionTags(cmd, args, toComplete)
+		}
+		reloadCompletionConfig()
+		tag, database, conn, err := parseConnectionTarget(args[0])
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		tables := cachedCompletion(filepath.Join(tag, "tables", database), func(ctx context.Context) ([]string, error) {
+			return dh.ListTables(ctx, conn, database)
+		})
+		return filterPrefix(tables, toComplete), cobra.ShellCompDirectiveNoFileComp
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if dataDiffOutput != "text" && dataDiffOutput != "json" {
+			return fmt.Errorf("unknown output format %q, expected text or json", dataDiffOutput)
+		}
+		if dataDiffChunkSize <= 0 {
+			return fmt.Errorf("--chunk-size must be positive")
+		}
+		cmd.SilenceUsage = true
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		fromTag, fromDb, fromConn, err := parseConnectionTarget(args[0])
+		if err != nil {
+			return err
+		}
+		toTag, toDb, toConn, err := parseConnectionTarget(args[1])
+		if err != nil {
+			return err
+		}
+		report := dataDiffReport{
+			From:   schemadiff.Target{Tag: fromTag, Database: fromDb, Provider: dh.NormalizeProvider(fromConn.Provider)},
+			To:     schemadiff.Target{Tag: toTag, Database: toDb, Provider: dh.NormalizeProvider(toConn.Provider)},
+			Tables: make([]datadiff.TableResult, 0),
+		}
+
+		tables := args[2:]
+		if len(tables) == 0 {
+			if tables, err = commonTables(ctx, fromConn, report.From, toConn, report.To); err != nil {
+				return err
+			}
+		}
+
+		opts := datadiff.Options{ChunkSize: dataDiffChunkSize, Ignore: dataDiffIgnore, MaxRows: dataDiffMaxRows}
+		failed := false
+		for _, table := range tables {
+			fmt.Fprintf(os.Stderr, "---> Comparing %s...\n", table)
+			result, err := diffTableData(ctx, fromConn, fromDb, toConn, toDb, table, opts)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				result.Table = table
+				result.Error = err.Error()
+				failed = true
+			}
+			report.Tables = append(report.Tables, result)
+		}
+
+		if dataDiffOutput == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(report); err != nil {
+				return err
+			}
+		} else {
+			printDataDiff(report)
+		}
+
+		differ := false
+		for _, t := range report.Tables {
+			differ = differ || !t.Equal()
+		}
+		if failed {
+			return fmt.Errorf("some tables could not be compared")
+		}
+		if dataDiffExitCode && differ {
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+// commonTables returns the tables present in both databases, ordered by name,
+// and warns about the tables present on one side only.
+func commonTables(ctx context.Context, fromConn config.StoredConnection, from schemadiff.Target, toConn config.StoredConnection, to schemadiff.Target) ([]string, error) {
+	fromTables, err := dh.ListTables(ctx, fromConn, from.Database)
+	if err != nil {
+		return nil, err
+	}
+	toTables, err := dh.ListTables(ctx, toConn, to.Database)
+	if err != nil {
+		return nil, err
+	}
+	present := make(map[string]bool, len(toTables))
+	for _, t := range toTables {
+		present[t] = true
+	}
+	common := make([]string, 0, len(fromTables))
+	for _, t := range fromTables {
+		if present[t] {
+			common = append(common, t)
+			delete(present, t)
+		} else {
+			fmt.Fprintf(os.Stderr, "---> Skipping %s, it only exists in %s!\n", t, from)
+		}
+	}
+	for t := range present {
+		fmt.Fprintf(os.Stderr, "---> Skipping %s, it only exists in %s!\n", t, to)
+	}
+	sort.Strings(common)
+	return common, nil
+}
+
+func diffTableData(ctx context.Context, fromConn config.StoredConnection, fromDb string, toConn config.StoredConnection, toDb string, table string, opts datadiff.Options) (datadiff.TableResult, error) {
+	from, err := dh.OpenTableScanner(ctx, fromConn, fromDb, table)
+	if err != nil {
+		return datadiff.TableResult{}, err
+	}
+	defer from.Close()
+	to, err := dh.OpenTableScanner(ctx, toConn, toDb, table)
+	if err != nil {
+		return datadiff.TableResult{}, err
+	}
+	defer to.Close()
+	return datadiff.CompareTable(ctx, from, to, opts)
+}
+
+func printDataDiff(r dataDiffReport) {
+	fmt.Println(diffTitleStyle.Render(fmt.Sprintf("Data diff %s → %s", r.From, r.To)))
+	differ := 0
+	for _, t := range r.Tables {
+		if t.Error != "" {
+			fmt.Println(diffKindStyles[schemadiff.Removed].Render(fmt.Sprintf("! %s: %s", t.Table, t.Error)))
+			continue
+		}
+		if t.Equal() {
+			fmt.Println(diffKindStyles[schemadiff.Added].Render(fmt.Sprintf("✓ %s: %d row(s) identical", t.Table, t.Rows)))
+		} else {
+			differ++
+			fmt.Println(diffKindStyles[schemadiff.Changed].Render(fmt.Sprintf("✗ %s: %d missing, %d extra, %d changed (%d of %d chunk(s) differ)",
+				t.Table, t.Missing, t.Extra, t.Changed, t.MismatchedChunks, t.Chunks)))
+		}
+		if len(t.Skipped) > 0 {
+			fmt.Println(dataDiffMutedStyle.Render("    columns on one side only: " + strings.Join(t.Skipped, ", ")))
+		}
+		for _, d := range t.Differences {
+			key := make([]string, len(d.Key))
+			for i, k := range t.Key {
+				key[i] = k + "=" + d.Key[i]
+			}
+			kind := dataDiffKinds[d.Kind]
+			line := fmt.Sprintf("    %s %s (%s)", diffKindSigns[kind], d.Kind, strings.Join(key, ", "))
+			if len(d.Columns) > 0 {
+				line += ": " + strings.Join(d.Columns, ", ")
+			}
+			fmt.Println(diffKindStyles[kind].Render(line))
+		}
+		if t.Truncated {
+			fmt.Println(dataDiffMutedStyle.Render("    … more differences not shown, raise --max-rows to see them"))
+		}
+	}
+	fmt.Printf("\n---> %d of %d table(s) differ!\n", differ, len(r.Tables))
+}
+
+func init() {
+	databaseCmd.AddCommand(dataDiffCmd)
+
+	dataDiffCmd.Flags().StringVarP(&dataDiffOutput, "output", "o", "text", "Output format: text or json")
+	dataDiffCmd.Flags().StringSliceVar(&dataDiffIgnore, "ignore", nil, "Columns to leave out of the comparison, as column or table.column")
+	dataDiffCmd.Flags().IntVar(&dataDiffChunkSize, "chunk-size", 1000, "Number of rows hashed together before comparing")
+	dataDiffCmd.Flags().IntVar(&dataDiffMaxRows, "max-rows", 100, "Maximum number of differing rows listed per table, 0 lists all")
+	dataDiffCmd.Flags().BoolVar(&dataDiffExitCode, "exit-code", false, "Exit with status 1 when the data differs")
+	dataDiffCmd.RegisterFlagCompletionFunc("output", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"text", "json"}, cobra.ShellCompDirectiveNoFileComp
+	})
+}
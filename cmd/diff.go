@@ -0,0 +1,179 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"seraphim/lib/config"
+	dh "seraphim/lib/db/query"
+	"seraphim/lib/schemadiff"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffOutput   string
+	diffDDL      bool
+	diffExitCode bool
+
+	diffTitleStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("230")).Bold(true)
+	diffKindStyles = map[string]lipgloss.Style{
+		schemadiff.Added:   lipgloss.NewStyle().Foreground(lipgloss.Color("#04B575")),
+		schemadiff.Removed: lipgloss.NewStyle().Foreground(lipgloss.Color("196")),
+		schemadiff.Changed: lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+	}
+	diffKindSigns = map[string]string{
+		schemadiff.Added:   "+",
+		schemadiff.Removed: "-",
+		schemadiff.Changed: "~",
+	}
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff <tagA>[/db] <tagB>[/db]",
+	Short: "Compare the schemas of two databases",
+	Long: `Compare the tables, columns, primary keys, indexes and foreign keys of two
+databases, which may live on different stored connections. The report lists
+what has to change to turn A into B. The database defaults to the default
+database of each connection.
+With --ddl the statements migrating A to B are printed instead, which requires
+both sides to use the same provider`,
+	Example: `  seraphim db diff prod/shop staging/shop
+  seraphim db diff prod staging -o json
+  seraphim db diff prod/shop staging/shop --ddl > migrate.sql`,
+	Args: cobra.ExactArgs(2),
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) >= 2 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return completeConnectionTags(cmd, args, toComplete)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if diffOutput != "text" && diffOutput != "json" {
+			return fmt.Errorf("unknown output format %q, expected text or json", diffOutput)
+		}
+		cmd.SilenceUsage = true
+
+		ctx := context.Background()
+		from, fromTables, err := loadDiffTarget(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		to, toTables, err := loadDiffTarget(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		result := schemadiff.Compare(from, fromTables, to, toTables)
+
+		switch {
+		case diffDDL:
+			statements, err := schemadiff.MigrationDDL(result)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("-- Migrate %s to %s\n", from, to)
+			for _, s := range statements {
+				fmt.Println(s)
+			}
+		case diffOutput == "json":
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(result); err != nil {
+				return err
+			}
+		default:
+			printDiff(result)
+		}
+		if diffExitCode && !result.Empty() {
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+// parseConnectionTarget splits tag[/db] and resolves the stored connection.
+// The database defaults to the default database of the connection.
+func parseConnectionTarget(arg string) (string, string, config.StoredConnection, error) {
+	tag, database := arg, ""
+	conn, ok := seraphimConfig.Connection(tag)
+	if !ok {
+		if i := strings.LastIndex(arg, "/"); i > 0 {
+			tag, database = arg[:i], arg[i+1:]
+			conn, ok = seraphimConfig.Connection(tag)
+		}
+	}
+	if !ok {
+		return "", "", conn, fmt.Errorf("no stored connection tagged %s", tag)
+	}
+	if database == "" {
+		database = conn.DefaultDatabase
+	}
+	if database == "" && conn.IsSQLite() {
+		database = dh.SQLiteDatabase
+	}
+	if database == "" {
+		return "", "", conn, fmt.Errorf("no database given for %s and the connection has no default database", tag)
+	}
+	return tag, database, conn, nil
+}
+
+func loadDiffTarget(ctx context.Context, arg string) (schemadiff.Target, []dh.TableDetails, error) {
+	tag, database, conn, err := parseConnectionTarget(arg)
+	if err != nil {
+		return schemadiff.Target{}, nil, err
+	}
+	target := schemadiff.Target{Tag: tag, Database: database, Provider: dh.NormalizeProvider(conn.Provider)}
+	tables, err := dh.DescribeDatabase(ctx, conn, database)
+	if err != nil {
+		return target, nil, fmt.Errorf("could not read the schema of %s: %w", target, err)
+	}
+	return target, tables, nil
+}
+
+func printDiff(r schemadiff.Result) {
+	fmt.Println(diffTitleStyle.Render(fmt.Sprintf("Schema diff %s → %s", r.From, r.To)))
+	if r.Empty() {
+		fmt.Println("---> The schemas are identical!")
+		return
+	}
+	for _, t := range r.Tables {
+		style := diffKindStyles[t.Kind]
+		fmt.Println(style.Render(fmt.Sprintf("%s table %s", diffKindSigns[t.Kind], t.Table)))
+		for _, c := range t.Changes {
+			label := strings.ReplaceAll(c.Object, "_", " ")
+			if c.Object != schemadiff.PrimaryKeyObject {
+				label += " " + c.Name
+			}
+			line := fmt.Sprintf("    %s %s", diffKindSigns[c.Kind], label)
+			switch c.Kind {
+			case schemadiff.Added:
+				line += " " + c.To
+			case schemadiff.Removed:
+				line += " " + c.From
+			case schemadiff.Changed:
+				line += " " + c.From + " → " + c.To
+			}
+			fmt.Println(diffKindStyles[c.Kind].Render(line))
+		}
+	}
+	added, removed, changed := r.Counts()
+	fmt.Printf("\n---> %d table(s) added, %d removed, %d changed!\n", added, removed, changed)
+}
+
+func init() {
+	databaseCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVarP(&diffOutput, "output", "o", "text", "Output format: text or json")
+	diffCmd.Flags().BoolVar(&diffDDL, "ddl", false, "Print the DDL migrating A to B (same provider only)")
+	diffCmd.Flags().BoolVar(&diffExitCode, "exit-code", false, "Exit with status 1 when the schemas differ")
+	diffCmd.RegisterFlagCompletionFunc("output", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"text", "json"}, cobra.ShellCompDirectiveNoFileComp
+	})
+}
@@ -0,0 +1,92 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"seraphim/lib/doctor"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	doctorPing        bool
+	doctorPingTimeout time.Duration
+
+	doctorSectionStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("230")).Bold(true)
+	doctorDetailStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("244"))
+	doctorStatusStyles = map[doctor.Status]lipgloss.Style{
+		doctor.Pass: lipgloss.NewStyle().Foreground(lipgloss.Color("#04B575")),
+		doctor.Warn: lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+		doctor.Fail: lipgloss.NewStyle().Foreground(lipgloss.Color("196")),
+	}
+	doctorStatusIcons = map[doctor.Status]string{
+		doctor.Pass: "✓",
+		doctor.Warn: "!",
+		doctor.Fail: "✗",
+	}
+)
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the configuration, external tools and stored connections",
+	Long: `Run a set of self-diagnostics and print a pass/warn/fail report:
+- configuration file presence, validity and permissions
+- availability and version of the external dump tools
+- writability of default_dump_path
+- reachability of every stored connection (with --ping)`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		checks := doctor.Run(doctor.Options{
+			ConfigFile:  viper.ConfigFileUsed(),
+			Ping:        doctorPing,
+			PingTimeout: doctorPingTimeout,
+		})
+
+		if printChecks(checks)[doctor.Fail] > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// printChecks prints a pass/warn/fail report grouped by section and returns
+// the number of checks of every status.
+func printChecks(checks []doctor.Check) map[doctor.Status]int {
+	counts := make(map[doctor.Status]int)
+	section := ""
+	for _, c := range checks {
+		if c.Section != section {
+			if section != "" {
+				fmt.Println()
+			}
+			section = c.Section
+			fmt.Println(doctorSectionStyle.Render(section))
+		}
+		counts[c.Status]++
+		style := doctorStatusStyles[c.Status]
+		line := fmt.Sprintf("  %s %s", style.Render(doctorStatusIcons[c.Status]), c.Name)
+		if c.Detail != "" {
+			line += doctorDetailStyle.Render(" — " + c.Detail)
+		}
+		fmt.Println(line)
+	}
+
+	fmt.Printf("\n%s, %s, %s\n",
+		doctorStatusStyles[doctor.Pass].Render(fmt.Sprintf("%d passed", counts[doctor.Pass])),
+		doctorStatusStyles[doctor.Warn].Render(fmt.Sprintf("%d warnings", counts[doctor.Warn])),
+		doctorStatusStyles[doctor.Fail].Render(fmt.Sprintf("%d failed", counts[doctor.Fail])))
+	return counts
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().BoolVar(&doctorPing, "ping", false, "Also ping every stored connection")
+	doctorCmd.Flags().DurationVar(&doctorPingTimeout, "timeout", 5*time.Second, "Timeout for each connection ping")
+}
@@ -4,25 +4,408 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"seraphim/lib/config"
 	"seraphim/lib/db"
+	dh "seraphim/lib/db/query"
+	"seraphim/lib/dump"
+	"seraphim/lib/masking"
+	"seraphim/lib/util"
+	"slices"
+	"strings"
 
+	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
+var (
+	dumpConn       string
+	dumpDbs        []string
+	dumpTables     []string
+	dumpPath       string
+	dumpTarget     string
+	dumpName       string
+	dumpSplit      string
+	dumpJobs       int
+	dumpConsistent bool
+	dumpResumable  bool
+	dumpChunk      int
+	dumpResume     string
+	dumpWhere      []string
+	dumpSample     string
+	dumpLimit      int64
+	dumpFollow     bool
+	dumpNoMask     bool
+	dumpSchema     bool
+	dumpData       bool
+	dumpViews      bool
+	dumpRoutines   bool
+	dumpTriggers   bool
+	dumpEvents     bool
+	dumpGrants     bool
+)
+
+// defaultDumpParallel is the number of files written at once when neither
+// --parallel nor dump_parallel set it.
+const defaultDumpParallel = 4
+
 // dumpCmd represents the dump command
 var dumpCmd = &cobra.Command{
 	Use:     "dump",
 	Aliases: []string{"dmp"},
 	Short:   "Create a database dump",
-	Long:    `Create a dump of the selected database`,
-	Args:    cobra.NoArgs,
+	Long: `Create a dump of the selected database.
+Without --conn the connection, databases and tables are picked interactively.
+With --target-dialect the dump is written by seraphim itself as statements
+for another provider, translating column types, auto increments, booleans,
+enums and defaults. Conversions that lose information are listed before the
+dump is written.
+
+Dump files are named by --filename-template, or dump_filename_template in
+the configuration, relative to the dump directory. The placeholders are
+{tag}, {provider}, {db}, {table}, {tables} (a short hash of the dumped
+tables), {unix}, {date} and {time}, the last two taking a Go layout as in
+{date:2006-01-02}. Missing directories are created. --split, or dump_split,
+writes a single file, a file per database or a file per table.
+
+Files are written in parallel, --parallel or dump_parallel at once, while a
+progress view shows the bytes and rows written along with an estimate of the
+time left, computed from the statistics of the server.
+
+With --consistent every table is read as of the same point in time. mysql
+workers start their transactions under a global read lock, which needs the
+RELOAD privilege, and mysqldump runs with --single-transaction, writing a
+single file. postgres workers share a snapshot exported per database, a
+snapshot never spanning databases. The position of the mysql binary log or
+the postgres WAL location is recorded in the manifest.
+
+With --resumable, for dialect dumps, tables are written in chunks of
+--chunk-rows rows ordered by primary key, tables without one in a single
+chunk. A state file beside the dump records every chunk written; should the
+dump stop, --resume continues it from the last chunk, then the chunks are
+stitched into the dump files.
+
+A dialect dump can hold part of the rows: --where db.table:condition keeps
+the rows of a table matching an SQL condition of the source provider,
+--sample keeps a percentage of the rows of every table picked at random, and
+--limit at most a number of rows per table in primary key order. In the
+interactive flow the highlighted table gets a condition with w and a sample
+with s. --follow-fks adds the rows referenced by the rows kept, parents of
+parents included, so that every foreign key of the dump holds; the rows are
+then read in memory before being written.
+
+The masking rules of the configuration replace the values of sensitive
+columns as rows are written, deterministically so that joins still line up:
+
+  masking:
+    salt: a long random secret
+    rules:
+      - column: shop.customers.email    # db.table.column, * matches any part
+        strategy: email
+      - pattern: (?i)phone              # regular expression on column names
+        strategy: phone
+      - column: shop.*.birth_date
+        strategy: date_shift
+        days: 90
+
+The strategies are fixed (value), hash, email, name, phone, scramble, null
+and date_shift (days, 30 by default). Only dialect dumps can be masked, other
+dumps of masked columns are refused unless --no-mask is given.
+
+--schema-only dumps the definitions of the tables without their rows and
+--data-only their rows without the definitions. --views, --routines,
+--triggers, --events and --grants, or their =false forms, add or leave out
+the other objects of the databases; unset, the client tools dump what they
+dump by default. mysqldump leaves routines, events and grants out, pg_dump
+runs without grants and dumps every other object it knows of. Grants of
+mysql databases are read by seraphim, mysqldump never dumping accounts.
+Dialect dumps hold tables only. In the interactive flow these are chosen on
+a screen following the table selection`,
+	Example: `  seraphim db dump
+  seraphim db dump --conn local --db shop --tables shop.orders,shop.customers
+  seraphim db dump --conn prod --db shop --target-dialect postgres
+  seraphim db dump --conn prod --db shop --consistent --parallel 8 --split per_table --target-dialect mysql
+  seraphim db dump --conn prod --db shop --split per_table --filename-template '{tag}/{db}/{date}_{time}/{table}.sql'
+  seraphim db dump --conn prod --db shop --target-dialect postgres --resumable --chunk-rows 50000
+  seraphim db dump --resume ~/dumps/dump-1700000000.state.json
+  seraphim db dump --conn prod --db shop --target-dialect sqlite --sample 1% --follow-fks
+  seraphim db dump --conn prod --db shop --schema-only --routines --triggers --views=false
+  seraphim db dump --conn prod --db shop --target-dialect mysql --where "shop.orders:created_at > '2024-01-01'" --limit 1000 --follow-fks`,
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		db.RunDumpCommand(&seraphimConfig)
+		if dumpResume != "" {
+			resumeDump()
+			return
+		}
+		if dumpTarget != "" && !slices.Contains(dump.Dialects, dh.NormalizeProvider(dumpTarget)) {
+			fmt.Printf("Unknown dialect %q, expected one of %s\n", dumpTarget, strings.Join(dump.Dialects, ", "))
+			os.Exit(1)
+		}
+		opts := dump.Options{Dialect: dumpTarget, Template: dumpName, Split: dumpSplit, Parallel: dumpJobs, Consistent: dumpConsistent, Resumable: dumpResumable, ChunkRows: dumpChunk}
+		if opts.Template == "" {
+			opts.Template = seraphimConfig.Dump_filename
+		}
+		if opts.Split == "" {
+			opts.Split = seraphimConfig.Dump_split
+		}
+		if opts.Parallel == 0 {
+			opts.Parallel = seraphimConfig.Dump_parallel
+		}
+		if opts.Parallel == 0 {
+			opts.Parallel = defaultDumpParallel
+		}
+		if opts.Parallel < 0 {
+			fmt.Println("--parallel must be positive")
+			os.Exit(1)
+		}
+		if dumpSample != "" {
+			sample, err := dump.ParseSample(dumpSample)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			opts.Sample = sample
+		}
+		if dumpLimit < 0 {
+			fmt.Println("--limit must be positive")
+			os.Exit(1)
+		}
+		if dumpLimit > 0 {
+			opts.Sample.Limit = dumpLimit
+		}
+		filters, err := whereFilters(dumpWhere)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts.Filters = filters
+		opts.FollowForeignKeys = dumpFollow
+		opts.Objects = dumpObjects(cmd)
+		if opts.Mask, err = dumpMasker(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if (opts.Filters != nil || !opts.Sample.IsZero() || opts.FollowForeignKeys) && opts.Dialect == "" {
+			fmt.Println("--where, --sample, --limit and --follow-fks need --target-dialect, the dump being written by seraphim")
+			os.Exit(1)
+		}
+		if opts.Objects.SchemaOnly && (opts.Filters != nil || !opts.Sample.IsZero() || opts.FollowForeignKeys) {
+			fmt.Println("--where, --sample, --limit and --follow-fks select rows, which a --schema-only dump holds none of")
+			os.Exit(1)
+		}
+		if opts.Dialect != "" && len(opts.Objects.Included(dh.IncludeOn)) > 0 {
+			fmt.Println("--views, --routines, --triggers, --events and --grants need the client tools, a dialect dump holding tables only")
+			os.Exit(1)
+		}
+		if opts.Resumable && opts.Dialect == "" {
+			fmt.Println("--resumable needs --target-dialect, the dump being written by seraphim")
+			os.Exit(1)
+		}
+		if opts.Resumable && opts.Consistent {
+			fmt.Println("--resumable and --consistent cannot be used together")
+			os.Exit(1)
+		}
+		if opts.ChunkRows < 0 {
+			fmt.Println("--chunk-rows must be positive")
+			os.Exit(1)
+		}
+		if opts.Split != "" && !slices.Contains(config.DumpSplitModes, opts.Split) {
+			fmt.Printf("Unknown split mode %q, expected one of %s\n", opts.Split, strings.Join(config.DumpSplitModes, ", "))
+			os.Exit(1)
+		}
+		if opts.Template != "" {
+			if err := util.CheckFilenameTemplate(opts.Template); err != nil {
+				fmt.Printf("Invalid file name template %q: %s\n", opts.Template, err)
+				os.Exit(1)
+			}
+		}
+		if dumpConn == "" {
+			db.RunDumpCommand(&seraphimConfig, opts)
+			return
+		}
+
+		conn, ok := seraphimConfig.Connection(dumpConn)
+		if !ok {
+			fmt.Printf("No stored connection tagged %s\n", dumpConn)
+			os.Exit(1)
+		}
+		selectedDbs, selectedTables, err := tableSelection(conn, dumpDbs, dumpTables)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		path := dumpPath
+		if path == "" {
+			path = seraphimConfig.Default_dump_path
+		}
+		create := dump.Create
+		if isatty.IsTerminal(os.Stdout.Fd()) {
+			create = db.RunDump
+		}
+		if create(dumpConn, conn, path, selectedDbs, selectedTables, opts) {
+			fmt.Println("---> Dump created successfully!")
+		} else {
+			fmt.Println("---> Dump was not created!")
+			os.Exit(1)
+		}
 	},
 }
 
 func init() {
 	databaseCmd.AddCommand(dumpCmd)
+
+	dumpCmd.Flags().StringVar(&dumpConn, "conn", "", "Tag of the stored connection to dump, skips the interactive selection")
+	dumpCmd.Flags().StringSliceVar(&dumpDbs, "db", nil, "Databases to dump (defaults to the connection default database)")
+	dumpCmd.Flags().StringSliceVar(&dumpTables, "tables", nil, "Tables to dump as db.table, whole databases are dumped when omitted")
+	dumpCmd.Flags().StringVar(&dumpPath, "path", "", "Directory to write the dump to (defaults to default_dump_path)")
+	dumpCmd.Flags().StringVar(&dumpTarget, "target-dialect", "", "Write the dump for another provider: "+strings.Join(dump.Dialects, ", "))
+	dumpCmd.Flags().StringVar(&dumpName, "filename-template", "", "Name of the dump files relative to the dump directory (defaults to dump_filename_template)")
+	dumpCmd.Flags().StringVar(&dumpSplit, "split", "", "Spread the dump over files: "+strings.Join(config.DumpSplitModes, ", ")+" (defaults to dump_split)")
+	dumpCmd.Flags().IntVar(&dumpJobs, "parallel", 0, fmt.Sprintf("Number of files written at once (defaults to dump_parallel, or %d)", defaultDumpParallel))
+	dumpCmd.Flags().BoolVar(&dumpConsistent, "consistent", false, "Read every table from a single snapshot and record its binlog position or LSN")
+	dumpCmd.Flags().BoolVar(&dumpResumable, "resumable", false, "Write tables in checkpointed chunks so that the dump can be resumed with --resume")
+	dumpCmd.Flags().IntVar(&dumpChunk, "chunk-rows", dump.DefaultChunkRows, "Rows of a chunk of a resumable dump")
+	dumpCmd.Flags().StringVar(&dumpResume, "resume", "", "Resume the dump whose state file is given")
+	dumpCmd.Flags().StringArrayVar(&dumpWhere, "where", nil, "Dump the rows of a table matching a condition, as db.table:condition (repeatable)")
+	dumpCmd.Flags().StringVar(&dumpSample, "sample", "", "Dump a random sample of the rows of every table, e.g. 1%")
+	dumpCmd.Flags().Int64Var(&dumpLimit, "limit", 0, "Dump at most this many rows per table")
+	dumpCmd.Flags().BoolVar(&dumpFollow, "follow-fks", false, "Add the rows referenced by the rows dumped so that foreign keys hold")
+	dumpCmd.Flags().BoolVar(&dumpNoMask, "no-mask", false, "Write the real values of the columns matched by the masking rules")
+	dumpCmd.Flags().BoolVar(&dumpSchema, "schema-only", false, "Dump the definitions of the tables without their rows")
+	dumpCmd.Flags().BoolVar(&dumpData, "data-only", false, "Dump the rows of the tables without their definitions")
+	dumpCmd.Flags().BoolVar(&dumpViews, "views", false, "Dump the views, or leave them out with --views=false")
+	dumpCmd.Flags().BoolVar(&dumpRoutines, "routines", false, "Dump the functions and procedures, or leave them out with --routines=false")
+	dumpCmd.Flags().BoolVar(&dumpTriggers, "triggers", false, "Dump the triggers, or leave them out with --triggers=false")
+	dumpCmd.Flags().BoolVar(&dumpEvents, "events", false, "Dump the scheduled events of mysql, or leave them out with --events=false")
+	dumpCmd.Flags().BoolVar(&dumpGrants, "grants", false, "Dump the privileges granted on the databases, or leave them out with --grants=false")
+	dumpCmd.MarkFlagsMutuallyExclusive("resume", "conn")
+	dumpCmd.MarkFlagsMutuallyExclusive("schema-only", "data-only")
+	dumpCmd.MarkFlagsMutuallyExclusive("resumable", "consistent")
+	registerConnectionCompletions(dumpCmd)
+	dumpCmd.RegisterFlagCompletionFunc("split", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return config.DumpSplitModes, cobra.ShellCompDirectiveNoFileComp
+	})
+	dumpCmd.RegisterFlagCompletionFunc("target-dialect", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return dump.Dialects, cobra.ShellCompDirectiveNoFileComp
+	})
+	dumpCmd.RegisterFlagCompletionFunc("path", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	})
+}
+
+// dumpMasker compiles the masking rules of the configuration, nil with
+// --no-mask.
+func dumpMasker() (*masking.Masker, error) {
+	if dumpNoMask {
+		return nil, nil
+	}
+	return masking.New(seraphimConfig.Masking)
+}
+
+// dumpObjects reads the flags selecting what the dump holds, the object
+// types whose flag is not given being left to the engine writing the dump.
+func dumpObjects(cmd *cobra.Command) dh.DumpObjects {
+	objects := dh.DumpObjects{SchemaOnly: dumpSchema, DataOnly: dumpData}
+	given := map[string]bool{"views": dumpViews, "routines": dumpRoutines, "triggers": dumpTriggers, "events": dumpEvents, "grants": dumpGrants}
+	for _, t := range objects.Types() {
+		if !cmd.Flags().Changed(t.Name) {
+			continue
+		}
+		*t.Include = dh.IncludeOff
+		if given[t.Name] {
+			*t.Include = dh.IncludeOn
+		}
+	}
+	return objects
+}
+
+// whereFilters reads the --where filters, given as db.table:condition.
+func whereFilters(values []string) (map[string]dump.Filter, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	filters := make(map[string]dump.Filter, len(values))
+	for _, v := range values {
+		table, condition, ok := strings.Cut(v, ":")
+		table, condition = strings.TrimSpace(table), strings.TrimSpace(condition)
+		if !ok || !strings.Contains(table, ".") || condition == "" {
+			return nil, fmt.Errorf("invalid filter %q, expected db.table:condition", v)
+		}
+		if _, ok := filters[table]; ok {
+			return nil, fmt.Errorf("several filters on %s, join their conditions with AND", table)
+		}
+		filters[table] = dump.Filter{Where: condition}
+	}
+	return filters, nil
+}
+
+// resumeDump continues the resumable dump whose state is at --resume.
+func resumeDump() {
+	state, err := dump.LoadState(dumpResume)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	conn, ok := seraphimConfig.Connection(state.Connection)
+	if !ok {
+		fmt.Printf("No stored connection tagged %s\n", state.Connection)
+		os.Exit(1)
+	}
+	if state.Options.Mask, err = dumpMasker(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	job, err := dump.Resume(context.Background(), state, conn, dumpJobs)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("---> Resuming the dump of %s started on %s\n", state.Connection, state.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+	run := dump.Execute
+	if isatty.IsTerminal(os.Stdout.Fd()) {
+		run = func(job *dump.Job) bool { return db.RunJob(job, state.Dir) }
+	}
+	if run(job) {
+		fmt.Println("---> Dump created successfully!")
+	} else {
+		fmt.Println("---> Dump was not created!")
+		os.Exit(1)
+	}
+}
+
+// tableSelection builds the selection of the dump flow from databases and
+// db.table names, the connection default database being used when neither
+// names a database.
+func tableSelection(conn config.StoredConnection, dbs []string, tables []string) ([]util.DbListItem, []util.TableListItem, error) {
+	dbs = append([]string(nil), dbs...)
+	if len(dbs) == 0 && conn.DefaultDatabase != "" {
+		dbs = []string{conn.DefaultDatabase}
+	}
+	for _, t := range tables {
+		if d, _, ok := strings.Cut(t, "."); ok && !slices.Contains(dbs, d) {
+			dbs = append(dbs, d)
+		}
+	}
+	if len(dbs) == 0 {
+		return nil, nil, fmt.Errorf("no database selected, use --db or set a default database on the connection")
+	}
+
+	selectedDbs := make([]util.DbListItem, len(dbs))
+	for i, d := range dbs {
+		selectedDbs[i] = util.DbListItem{Name: d, Selected: true}
+	}
+	selectedTables := make([]util.TableListItem, 0, len(tables))
+	for _, t := range tables {
+		d, name, ok := strings.Cut(t, ".")
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid table %q, expected db.table", t)
+		}
+		selectedTables = append(selectedTables, util.TableListItem{Name: name, Db: d, Selected: true})
+	}
+	return selectedDbs, selectedTables, nil
 }
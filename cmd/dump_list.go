@@ -0,0 +1,147 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	dh "seraphim/lib/db/query"
+	"seraphim/lib/dump"
+	"seraphim/lib/util"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	dumpListSort    string
+	dumpListReverse bool
+	dumpListOutput  string
+)
+
+// dumpListSorts orders manifests ascending by each sort key.
+var dumpListSorts = map[string]func(a, b dump.Manifest) bool{
+	"created":    func(a, b dump.Manifest) bool { return a.CreatedAt.Before(b.CreatedAt) },
+	"size":       func(a, b dump.Manifest) bool { return a.Size < b.Size },
+	"rows":       func(a, b dump.Manifest) bool { return a.Rows() < b.Rows() },
+	"duration":   func(a, b dump.Manifest) bool { return a.DurationMs < b.DurationMs },
+	"connection": func(a, b dump.Manifest) bool { return a.Connection < b.Connection },
+	"file":       func(a, b dump.Manifest) bool { return a.File < b.File },
+}
+
+func dumpListSortKeys() []string {
+	keys := make([]string, 0, len(dumpListSorts))
+	for k := range dumpListSorts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// dumpListCmd represents the dump list command
+var dumpListCmd = &cobra.Command{
+	Use:     "list [dir]",
+	Aliases: []string{"ls"},
+	Short:   "List the dumps of a directory",
+	Long: `List the dumps of a directory from their manifests, newest first.
+The directory defaults to default_dump_path. Dumps are sorted by creation
+date, size, rows, duration, connection or file name with --sort, --reverse
+inverting the order`,
+	Example: `  seraphim db dump list
+  seraphim db dump list ~/backups --sort size
+  seraphim db dump list -o json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		less, ok := dumpListSorts[dumpListSort]
+		if !ok {
+			return fmt.Errorf("unknown sort %q, expected one of %s", dumpListSort, strings.Join(dumpListSortKeys(), ", "))
+		}
+		if dumpListOutput != "table" && dumpListOutput != "json" {
+			return fmt.Errorf("unknown output format %q, expected table or json", dumpListOutput)
+		}
+		dir := seraphimConfig.Default_dump_path
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		cmd.SilenceUsage = true
+
+		manifests, errs := dump.ListManifests(dir)
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "---> %s\n", err)
+		}
+		// Newest and largest first unless reversed
+		sort.SliceStable(manifests, func(i, j int) bool {
+			if dumpListReverse {
+				return less(manifests[i], manifests[j])
+			}
+			return less(manifests[j], manifests[i])
+		})
+
+		if dumpListOutput == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(manifests)
+		}
+		if len(manifests) == 0 {
+			fmt.Printf("---> No dump manifest found in %s\n", dir)
+			return nil
+		}
+		return printDumpList(dir, manifests)
+	},
+}
+
+func printDumpList(dir string, manifests []dump.Manifest) error {
+	w, err := dh.NewRowWriter("table", os.Stdout, dh.WriterOptions{})
+	if err != nil {
+		return err
+	}
+	columns := []dh.ColumnInfo{
+		{Name: "FILE"}, {Name: "CONNECTION"}, {Name: "PROVIDER"}, {Name: "DATABASES"},
+		{Name: "TABLES", Kind: dh.KindInteger}, {Name: "ROWS", Kind: dh.KindInteger},
+		{Name: "SIZE"}, {Name: "CREATED"}, {Name: "DURATION"},
+	}
+	if err := w.WriteHeader(columns); err != nil {
+		return err
+	}
+	for _, m := range manifests {
+		provider := m.Provider
+		if m.Dialect != "" && m.Dialect != m.Provider {
+			provider += " → " + m.Dialect
+		}
+		file := m.File
+		if rel, err := filepath.Rel(dir, m.Path); err == nil {
+			file = rel
+		}
+		err := w.WriteRow([]any{
+			file, m.Connection, provider, strings.Join(m.DatabaseNames(), ", "),
+			int64(m.Tables()), m.Rows(), util.HumanSize(m.Size),
+			m.CreatedAt.Local().Format("2006-01-02 15:04:05"), m.Duration().Round(time.Millisecond).String(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func init() {
+	dumpCmd.AddCommand(dumpListCmd)
+
+	dumpListCmd.Flags().StringVar(&dumpListSort, "sort", "created", "Sort by "+strings.Join(dumpListSortKeys(), ", "))
+	dumpListCmd.Flags().BoolVar(&dumpListReverse, "reverse", false, "Oldest, smallest or alphabetical first")
+	dumpListCmd.Flags().StringVarP(&dumpListOutput, "output", "o", "table", "Output format: table or json")
+	dumpListCmd.RegisterFlagCompletionFunc("sort", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return dumpListSortKeys(), cobra.ShellCompDirectiveNoFileComp
+	})
+	dumpListCmd.RegisterFlagCompletionFunc("output", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"table", "json"}, cobra.ShellCompDirectiveNoFileComp
+	})
+	dumpListCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"seraphim/lib/dump"
+	"seraphim/lib/util"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dumpPruneDryRun bool
+	dumpPruneYes    bool
+)
+
+var (
+	dumpPruneKeepStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	dumpPruneRemoveStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("203"))
+	dumpPruneReasonStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("244"))
+)
+
+// dumpPruneCmd represents the dump prune command
+var dumpPruneCmd = &cobra.Command{
+	Use:   "prune [dir]",
+	Short: "Remove the dumps the retention policy does not keep",
+	Long: `Remove the dumps of a directory that the retention policy of the
+configuration does not keep. The directory, searched along with its
+subdirectories, defaults to default_dump_path.
+
+Dumps are grouped by connection tag, read from their manifest. Dumps without
+a manifest are dated from the timestamp of their file name and follow the
+default policy. The files of a split dump, created at the same time, count
+as one dump and are kept or removed together. A dump is kept when any rule
+keeps it:
+
+  retention:
+    keep_last: 3        # the 3 newest dumps
+    keep_daily: 7       # the newest dump of each of the last 7 days
+    keep_weekly: 4      # the newest dump of each of the last 4 weeks
+    keep_monthly: 12    # the newest dump of each of the last 12 months
+    connections:
+      prod:
+        keep_daily: 30
+
+Connections without any rule keep all their dumps`,
+	Example: `  seraphim db dump prune --dry-run
+  seraphim db dump prune ~/backups -y`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := seraphimConfig.Default_dump_path
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		cmd.SilenceUsage = true
+
+		entries, errs := dump.ScanDir(dir)
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "---> %s\n", err)
+		}
+		if len(entries) == 0 {
+			fmt.Printf("---> No dump found in %s\n", dir)
+			return nil
+		}
+
+		decisions := dump.ApplyRetention(entries, seraphimConfig.Retention, time.Now())
+		removed := make([]dump.Decision, 0)
+		var freed int64
+		for _, d := range decisions {
+			connection := d.Connection
+			if connection == "" {
+				connection = "-"
+			}
+			file := d.Path
+			if rel, err := filepath.Rel(dir, d.Path); err == nil {
+				file = rel
+			}
+			line := fmt.Sprintf("%s  %-12s %s", d.CreatedAt.Local().Format("2006-01-02 15:04:05"), connection, file)
+			if d.Keep {
+				fmt.Printf("%s %s %s\n", dumpPruneKeepStyle.Render("keep  "), line, dumpPruneReasonStyle.Render("("+strings.Join(d.Reasons, ", ")+")"))
+				continue
+			}
+			fmt.Printf("%s %s %s\n", dumpPruneRemoveStyle.Render("remove"), line, dumpPruneReasonStyle.Render(util.HumanSize(d.Size)))
+			removed = append(removed, d)
+			freed += d.Size
+		}
+
+		if len(removed) == 0 {
+			fmt.Println("---> Nothing to prune!")
+			return nil
+		}
+		if dumpPruneDryRun {
+			fmt.Printf("---> Would remove %d dump(s), freeing %s\n", len(removed), util.HumanSize(freed))
+			return nil
+		}
+		if !dumpPruneYes {
+			var confirm bool
+			huh.NewConfirm().
+				Title(fmt.Sprintf("Remove %d dump(s) from %s?", len(removed), dir)).
+				Affirmative("Yes!").
+				Negative("No.").
+				Value(&confirm).Run()
+			if !confirm {
+				fmt.Println("Aborted operation")
+				return nil
+			}
+		}
+
+		freed = 0
+		count := 0
+		for _, d := range removed {
+			if err := d.Remove(); err != nil {
+				fmt.Fprintf(os.Stderr, "---> Could not remove %s: %s\n", d.Path, err)
+				continue
+			}
+			count++
+			freed += d.Size
+		}
+		fmt.Printf("---> Removed %d dump(s), freed %s!\n", count, util.HumanSize(freed))
+		if count < len(removed) {
+			return fmt.Errorf("%d dump(s) could not be removed", len(removed)-count)
+		}
+		return nil
+	},
+}
+
+func init() {
+	dumpCmd.AddCommand(dumpPruneCmd)
+
+	dumpPruneCmd.Flags().BoolVar(&dumpPruneDryRun, "dry-run", false, "Show what would be removed without removing anything")
+	dumpPruneCmd.Flags().BoolVarP(&dumpPruneYes, "yes", "y", false, "Do not ask for confirmation")
+	dumpPruneCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	}
+}
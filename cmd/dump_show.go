@@ -0,0 +1,123 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"seraphim/lib/dump"
+	"seraphim/lib/util"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+var dumpShowOutput string
+
+var (
+	dumpShowTitleStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("230")).Bold(true)
+	dumpShowLabelStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("244"))
+)
+
+// dumpShowCmd represents the dump show command
+var dumpShowCmd = &cobra.Command{
+	Use:   "show <file>",
+	Short: "Show the manifest of a dump",
+	Long: `Show where a dump comes from and what it holds, as recorded in its
+manifest. The dump file or its manifest may be given`,
+	Example: `  seraphim db dump show ~/backups/dump-1700000000.sql
+  seraphim db dump show ~/backups/dump-1700000000.sql -o json`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if dumpShowOutput != "text" && dumpShowOutput != "json" {
+			return fmt.Errorf("unknown output format %q, expected text or json", dumpShowOutput)
+		}
+		cmd.SilenceUsage = true
+		m, err := dump.ReadManifest(args[0])
+		if err != nil {
+			return err
+		}
+		if dumpShowOutput == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(m)
+		}
+		printManifest(m)
+		return nil
+	},
+}
+
+func printManifest(m dump.Manifest) {
+	field := func(label string, value string) {
+		if value != "" {
+			fmt.Printf("%s %s\n", dumpShowLabelStyle.Render(fmt.Sprintf("%-17s", label+":")), value)
+		}
+	}
+	fmt.Println(dumpShowTitleStyle.Render(m.Path))
+	field("Connection", m.Connection)
+	field("Provider", m.Provider)
+	field("Dialect", m.Dialect)
+	field("Server version", m.ServerVersion)
+	field("Created", m.CreatedAt.Local().Format(time.RFC1123))
+	field("Duration", m.Duration().Round(time.Millisecond).String())
+	field("Size", fmt.Sprintf("%s (%d bytes)", util.HumanSize(m.Size), m.Size))
+	field("Checksum", m.Checksum)
+	if m.Consistent {
+		field("Consistent", "yes")
+	}
+	if m.Binlog != nil {
+		position := fmt.Sprintf("%s:%d", m.Binlog.File, m.Binlog.Position)
+		if m.Binlog.GTIDSet != "" {
+			position += " (GTID " + m.Binlog.GTIDSet + ")"
+		}
+		field("Binlog position", position)
+	}
+	if m.FollowForeignKeys {
+		field("Foreign keys", "followed")
+	}
+	if m.Objects != nil {
+		field("Objects", m.Objects.String())
+	}
+	field("Seraphim version", m.SeraphimVersion)
+	for _, d := range m.Databases {
+		fmt.Println()
+		fmt.Println(dumpShowTitleStyle.Render(fmt.Sprintf("Database %s", d.Name)))
+		if d.LSN != "" {
+			fmt.Printf("  %s %s\n", dumpShowLabelStyle.Render("LSN:"), d.LSN)
+		}
+		width := 0
+		for _, t := range d.Tables {
+			width = max(width, len(t.Name))
+		}
+		for _, t := range d.Tables {
+			rows := fmt.Sprintf("%d row(s)", t.Rows)
+			if t.Filter != "" {
+				rows += " (" + t.Filter + ")"
+			}
+			if len(t.Masked) > 0 {
+				columns := make([]string, 0, len(t.Masked))
+				for column, strategy := range t.Masked {
+					columns = append(columns, column+" as "+strategy)
+				}
+				sort.Strings(columns)
+				rows += ", masked " + strings.Join(columns, ", ")
+			}
+			fmt.Printf("  %-*s %s\n", width, t.Name, dumpShowLabelStyle.Render(rows))
+		}
+	}
+	fmt.Printf("\n---> %d table(s), %d row(s)\n", m.Tables(), m.Rows())
+}
+
+func init() {
+	dumpCmd.AddCommand(dumpShowCmd)
+
+	dumpShowCmd.Flags().StringVarP(&dumpShowOutput, "output", "o", "text", "Output format: text or json")
+	dumpShowCmd.RegisterFlagCompletionFunc("output", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"text", "json"}, cobra.ShellCompDirectiveNoFileComp
+	})
+}
@@ -0,0 +1,69 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"seraphim/lib/doctor"
+	"seraphim/lib/dump"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	dumpVerifyRestore bool
+	dumpVerifyScratch string
+)
+
+// dumpVerifyCmd represents the dump verify command
+var dumpVerifyCmd = &cobra.Command{
+	Use:   "verify <file>",
+	Short: "Check that a dump is complete and restores",
+	Long: `Check that a dump was written whole:
+- its size and checksum match its manifest
+- its statements are complete, every string, comment and parenthesis being
+  closed, and it ends with the completion markers of seraphim or mysqldump;
+  pg_dump archives are read through with pg_restore
+
+With --restore the dump is then restored into scratch databases and the rows
+of its tables are compared with those recorded in the manifest. sqlite dumps
+are restored into temporary files, mysql and postgres dumps on the server of
+the --scratch connection, a local server standing in for the source, where
+a database is created per database of the dump and dropped afterwards.
+Rows counted for a dump that is neither consistent nor written by seraphim
+may have changed while it was written, differences are then warnings`,
+	Example: `  seraphim db dump verify ~/backups/dump-1700000000.sql
+  seraphim db dump verify ~/backups/dump-1700000000.sql --restore
+  seraphim db dump verify ~/backups/dump-1700000000-shop.dump --restore --scratch local-pg`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := dump.VerifyOptions{Restore: dumpVerifyRestore}
+		if dumpVerifyScratch != "" {
+			if !dumpVerifyRestore {
+				return fmt.Errorf("--scratch needs --restore")
+			}
+			conn, ok := seraphimConfig.Connection(dumpVerifyScratch)
+			if !ok {
+				return fmt.Errorf("no stored connection tagged %s", dumpVerifyScratch)
+			}
+			opts.Scratch = &conn
+		}
+		cmd.SilenceUsage = true
+		if printChecks(dump.Verify(context.Background(), args[0], opts))[doctor.Fail] > 0 {
+			return fmt.Errorf("%s failed verification", args[0])
+		}
+		return nil
+	},
+}
+
+func init() {
+	dumpCmd.AddCommand(dumpVerifyCmd)
+
+	dumpVerifyCmd.Flags().BoolVar(&dumpVerifyRestore, "restore", false, "Restore the dump into scratch databases and compare the rows of its tables with the manifest")
+	dumpVerifyCmd.Flags().StringVar(&dumpVerifyScratch, "scratch", "", "Tag of the stored connection mysql and postgres dumps are restored on")
+	dumpVerifyCmd.RegisterFlagCompletionFunc("scratch", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return filterPrefix(seraphimConfig.ConnectionTags(), toComplete), cobra.ShellCompDirectiveNoFileComp
+	})
+}
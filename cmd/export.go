@@ -0,0 +1,144 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"seraphim/lib/db"
+	dh "seraphim/lib/db/query"
+	"seraphim/lib/export"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportConn      string
+	exportDbs       []string
+	exportTables    []string
+	exportPath      string
+	exportFormat    string
+	exportDelimiter string
+	exportQuote     string
+	exportNoHeader  bool
+	exportNull      string
+	exportExcel     bool
+	exportWhere     []string
+)
+
+var exportQuoteModes = map[string]dh.QuoteMode{
+	"minimal": dh.QuoteMinimal,
+	"all":     dh.QuoteAll,
+	"none":    dh.QuoteNone,
+}
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export tables to CSV, NDJSON or JSON files",
+	Long: `Export the rows of the selected tables, one file per table named
+<db>.<table>.<format>. Rows are streamed to the files so that memory use does
+not grow with the tables.
+CSV files take a custom --delimiter, --quote mode and --null text, --excel
+writes them with a UTF-8 byte order mark and CRLF line endings for Excel.
+--where filters a table with a condition given as table=condition or
+db.table=condition, and may be repeated.
+Without --conn the connection, databases and tables are picked interactively`,
+	Example: `  seraphim db export --format ndjson
+  seraphim db export --conn local --db shop --tables shop.orders --where "orders=total > 100"
+  seraphim db export --conn local --db shop --excel --delimiter ";" --path ~/extracts`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, err := exportOptions()
+		if err != nil {
+			return err
+		}
+		cmd.SilenceUsage = true
+		if exportConn == "" {
+			db.RunExportCommand(&seraphimConfig, opts)
+			return nil
+		}
+
+		conn, ok := seraphimConfig.Connection(exportConn)
+		if !ok {
+			return fmt.Errorf("no stored connection tagged %s", exportConn)
+		}
+		selectedDbs, selectedTables, err := tableSelection(conn, exportDbs, exportTables)
+		if err != nil {
+			return err
+		}
+		path := exportPath
+		if path == "" {
+			path = seraphimConfig.Default_dump_path
+		}
+		if !export.CreateExport(conn, path, selectedDbs, selectedTables, opts) {
+			return fmt.Errorf("export was not created")
+		}
+		fmt.Println("---> Export created successfully!")
+		return nil
+	},
+}
+
+// exportOptions validates the flags of the export command.
+func exportOptions() (export.Options, error) {
+	opts := export.Options{Format: exportFormat, Where: make(map[string]string, len(exportWhere))}
+	if !slices.Contains(export.Formats, exportFormat) {
+		return opts, fmt.Errorf("unknown format %q, expected one of %s", exportFormat, strings.Join(export.Formats, ", "))
+	}
+	quote, ok := exportQuoteModes[exportQuote]
+	if !ok {
+		return opts, fmt.Errorf("unknown quote mode %q, expected minimal, all or none", exportQuote)
+	}
+	delimiter, size := utf8.DecodeRuneInString(exportDelimiter)
+	if exportDelimiter == `\t` {
+		delimiter, size = '\t', 2
+	}
+	if size != len(exportDelimiter) || delimiter == utf8.RuneError {
+		return opts, fmt.Errorf("the delimiter must be a single character")
+	}
+	for _, w := range exportWhere {
+		table, condition, ok := strings.Cut(w, "=")
+		if !ok || strings.TrimSpace(table) == "" {
+			return opts, fmt.Errorf("invalid --where %q, expected table=condition", w)
+		}
+		opts.Where[strings.TrimSpace(table)] = condition
+	}
+	opts.Writer = dh.WriterOptions{
+		Delimiter:  delimiter,
+		Quote:      quote,
+		NoHeader:   exportNoHeader,
+		NullString: exportNull,
+		CRLF:       exportExcel,
+	}
+	opts.BOM = exportExcel
+	return opts, nil
+}
+
+func init() {
+	databaseCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringVar(&exportConn, "conn", "", "Tag of the stored connection to export, skips the interactive selection")
+	exportCmd.Flags().StringSliceVar(&exportDbs, "db", nil, "Databases to export (defaults to the connection default database)")
+	exportCmd.Flags().StringSliceVar(&exportTables, "tables", nil, "Tables to export as db.table, whole databases are exported when omitted")
+	exportCmd.Flags().StringVar(&exportPath, "path", "", "Directory to write the files to (defaults to default_dump_path)")
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", "csv", "File format: "+strings.Join(export.Formats, ", "))
+	exportCmd.Flags().StringVar(&exportDelimiter, "delimiter", ",", `Field delimiter of CSV files, \t for tabs`)
+	exportCmd.Flags().StringVar(&exportQuote, "quote", "minimal", "When CSV fields are quoted: minimal, all or none")
+	exportCmd.Flags().BoolVar(&exportNoHeader, "no-header", false, "Omit the header row of CSV files")
+	exportCmd.Flags().StringVar(&exportNull, "null", "", "Text written for NULL in CSV files")
+	exportCmd.Flags().BoolVar(&exportExcel, "excel", false, "Write CSV files with a byte order mark and CRLF line endings")
+	exportCmd.Flags().StringArrayVar(&exportWhere, "where", nil, "Filter a table as table=condition or db.table=condition, repeatable")
+	registerConnectionCompletions(exportCmd)
+	exportCmd.RegisterFlagCompletionFunc("path", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	})
+	exportCmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return export.Formats, cobra.ShellCompDirectiveNoFileComp
+	})
+	exportCmd.RegisterFlagCompletionFunc("quote", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"minimal", "all", "none"}, cobra.ShellCompDirectiveNoFileComp
+	})
+}
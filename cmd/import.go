@@ -0,0 +1,188 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	dh "seraphim/lib/db/query"
+	"seraphim/lib/dbimport"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	importConn      string
+	importDb        string
+	importTable     string
+	importFormat    string
+	importDelimiter string
+	importNull      string
+	importMap       []string
+	importCreate    bool
+	importBatchSize int
+	importNoBulk    bool
+	importRejects   string
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Load a CSV or NDJSON file into a table",
+	Long: `Load the rows of a CSV or NDJSON file into a table. Columns are matched by
+the name found in the CSV header or in the keys of the first NDJSON object,
+--map renames a column of the file as file_column=table_column or skips it
+as file_column=. With --create a missing table is created with column types
+inferred from the first rows of the file.
+Rows are loaded in transactions of --batch-size rows, through LOAD DATA LOCAL
+INFILE on mysql and COPY on postgres unless --no-bulk is passed. Rows the
+database refuses are written with the reason to the --rejects file, as
+NDJSON, and the import goes on. The file is read from stdin with -`,
+	Example: `  seraphim db import --conn local --db shop --table orders orders.csv
+  seraphim db import --conn local --db shop --table events --create events.ndjson
+  seraphim db import --conn local --db shop --table orders --map "Order ID=id" --map notes= export.csv`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+		format := importFormat
+		if format == "" {
+			format = "csv"
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".ndjson", ".jsonl":
+				format = "ndjson"
+			}
+		}
+		if !slices.Contains(dbimport.Formats, format) {
+			return fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(dbimport.Formats, ", "))
+		}
+		delimiter, size := utf8.DecodeRuneInString(importDelimiter)
+		if importDelimiter == `\t` {
+			delimiter, size = '\t', 2
+		}
+		if size != len(importDelimiter) || delimiter == utf8.RuneError {
+			return fmt.Errorf("the delimiter must be a single character")
+		}
+		mapping := make(map[string]string, len(importMap))
+		for _, m := range importMap {
+			from, to, ok := strings.Cut(m, "=")
+			if !ok {
+				return fmt.Errorf("invalid --map %q, expected file_column=table_column", m)
+			}
+			mapping[strings.TrimSpace(from)] = strings.TrimSpace(to)
+		}
+		if importBatchSize <= 0 {
+			return fmt.Errorf("--batch-size must be positive")
+		}
+		conn, ok := seraphimConfig.Connection(importConn)
+		if !ok {
+			return fmt.Errorf("no stored connection tagged %s", importConn)
+		}
+		database := importDb
+		if database == "" {
+			database = conn.DefaultDatabase
+		}
+		cmd.SilenceUsage = true
+
+		var input io.Reader = os.Stdin
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			input = f
+		}
+
+		rejectsPath := importRejects
+		if rejectsPath == "" {
+			rejectsPath = "import.rejects.ndjson"
+			if path != "-" {
+				rejectsPath = strings.TrimSuffix(path, filepath.Ext(path)) + ".rejects.ndjson"
+			}
+		}
+		rejects, err := os.Create(rejectsPath)
+		if err != nil {
+			return err
+		}
+		defer rejects.Close()
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		result, err := dbimport.Import(ctx, conn, database, importTable, input, dbimport.Options{
+			Format:     format,
+			Delimiter:  delimiter,
+			NullString: importNull,
+			Mapping:    mapping,
+			Create:     importCreate,
+			BatchSize:  importBatchSize,
+			Bulk:       !importNoBulk,
+			Rejects:    rejects,
+		})
+		if result.Rejected == 0 {
+			rejects.Close()
+			os.Remove(rejectsPath)
+		}
+		if result.Created {
+			fmt.Printf("---> Created table %s\n", importTable)
+			for _, issue := range result.Issues {
+				fmt.Printf("     %s\n", issue)
+			}
+		}
+		for _, w := range result.Warnings {
+			fmt.Fprintf(os.Stderr, "     %s\n", w)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("---> Loaded %d row(s) into %s with %s in %s!\n", result.Rows, importTable, result.Method, result.Duration.Round(time.Millisecond))
+		if result.Rejected > 0 {
+			fmt.Printf("---> %d row(s) rejected, see %s\n", result.Rejected, rejectsPath)
+		}
+		return nil
+	},
+}
+
+func init() {
+	databaseCmd.AddCommand(importCmd)
+
+	importCmd.Flags().StringVar(&importConn, "conn", "", "Tag of the stored connection to load into")
+	importCmd.Flags().StringVar(&importDb, "db", "", "Database of the table (defaults to the connection default database)")
+	importCmd.Flags().StringVar(&importTable, "table", "", "Table to load the rows into")
+	importCmd.Flags().StringVarP(&importFormat, "format", "f", "", "File format: "+strings.Join(dbimport.Formats, ", ")+" (defaults to the file extension)")
+	importCmd.Flags().StringVar(&importDelimiter, "delimiter", ",", `Field delimiter of CSV files, \t for tabs`)
+	importCmd.Flags().StringVar(&importNull, "null", "", "Text read as NULL in CSV files")
+	importCmd.Flags().StringArrayVar(&importMap, "map", nil, "Rename a column as file_column=table_column, skip it as file_column=, repeatable")
+	importCmd.Flags().BoolVar(&importCreate, "create", false, "Create the table with inferred column types when missing")
+	importCmd.Flags().IntVar(&importBatchSize, "batch-size", 1000, "Number of rows loaded per transaction")
+	importCmd.Flags().BoolVar(&importNoBulk, "no-bulk", false, "Load with INSERT statements instead of LOAD DATA or COPY")
+	importCmd.Flags().StringVar(&importRejects, "rejects", "", "File receiving the rejected rows (defaults to <file>.rejects.ndjson)")
+	importCmd.MarkFlagRequired("conn")
+	importCmd.MarkFlagRequired("table")
+	registerConnectionCompletions(importCmd)
+	importCmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return dbimport.Formats, cobra.ShellCompDirectiveNoFileComp
+	})
+	importCmd.RegisterFlagCompletionFunc("table", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		tag, conn, ok := completionConnection(cmd)
+		if !ok {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		database := importDb
+		if database == "" {
+			database = conn.DefaultDatabase
+		}
+		tables := cachedCompletion(filepath.Join(tag, "tables", database), func(ctx context.Context) ([]string, error) {
+			return dh.ListTables(ctx, conn, database)
+		})
+		return filterPrefix(tables, toComplete), cobra.ShellCompDirectiveNoFileComp
+	})
+}
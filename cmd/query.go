@@ -0,0 +1,117 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	dh "seraphim/lib/db/query"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	queryConn     string
+	queryDb       string
+	queryFile     string
+	queryOutput   string
+	queryNoHeader bool
+	queryNull     string
+)
+
+// queryCmd represents the query command
+var queryCmd = &cobra.Command{
+	Use:   "query [statement]",
+	Short: "Run a query and print the result",
+	Long: `Run a single statement on a stored connection and stream the result to stdout
+as an aligned table, CSV, TSV, JSON array, NDJSON or Markdown.
+The statement is read from the argument, from --file or from stdin with --file -`,
+	Example: `  seraphim db query --conn local --db shop "SELECT * FROM orders" -o csv
+  seraphim db query --conn local -f report.sql -o ndjson > report.ndjson`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		statement, err := queryStatement(args)
+		if err != nil {
+			return err
+		}
+		conn, ok := seraphimConfig.Connection(queryConn)
+		if !ok {
+			return fmt.Errorf("no stored connection tagged %s", queryConn)
+		}
+		database := queryDb
+		if database == "" {
+			database = conn.DefaultDatabase
+		}
+		// NULL is \N in TSV, where text holding \N is escaped as \\N
+		null := queryNull
+		if !cmd.Flags().Changed("null") && queryOutput == "tsv" {
+			null = `\N`
+		}
+		w, err := dh.NewRowWriter(queryOutput, os.Stdout, dh.WriterOptions{
+			NoHeader:   queryNoHeader,
+			NullString: null,
+		})
+		if err != nil {
+			return err
+		}
+		cmd.SilenceUsage = true
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		count, err := dh.StreamQuery(ctx, conn, database, statement, w)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "---> %d row(s)\n", count)
+		return nil
+	},
+}
+
+func queryStatement(args []string) (string, error) {
+	var statement string
+	switch {
+	case queryFile != "" && len(args) > 0:
+		return "", errors.New("pass the statement either as argument or with --file, not both")
+	case queryFile == "-":
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", err
+		}
+		statement = string(b)
+	case queryFile != "":
+		b, err := os.ReadFile(queryFile)
+		if err != nil {
+			return "", err
+		}
+		statement = string(b)
+	case len(args) > 0:
+		statement = args[0]
+	}
+	statement = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(statement), ";"))
+	if statement == "" {
+		return "", errors.New("no statement given")
+	}
+	return statement, nil
+}
+
+func init() {
+	databaseCmd.AddCommand(queryCmd)
+
+	queryCmd.Flags().StringVar(&queryConn, "conn", "", "Tag of the stored connection to query")
+	queryCmd.Flags().StringVar(&queryDb, "db", "", "Database to run the statement on (defaults to the connection default database)")
+	queryCmd.Flags().StringVarP(&queryFile, "file", "f", "", "Read the statement from a file, - reads stdin")
+	queryCmd.Flags().StringVarP(&queryOutput, "output", "o", "table", "Output format: "+strings.Join(dh.OutputFormats, ", "))
+	queryCmd.Flags().BoolVar(&queryNoHeader, "no-header", false, "Omit the header row in table, CSV and TSV output")
+	queryCmd.Flags().StringVar(&queryNull, "null", "", `Text written for NULL in CSV and TSV output (default empty for CSV, \N for TSV)`)
+	queryCmd.MarkFlagRequired("conn")
+	registerConnectionCompletions(queryCmd)
+	queryCmd.RegisterFlagCompletionFunc("output", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return dh.OutputFormats, cobra.ShellCompDirectiveNoFileComp
+	})
+}
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"seraphim/globals"
 	"seraphim/lib/config"
 
 	"github.com/spf13/cobra"
@@ -22,13 +21,16 @@ var versionRequested bool
 var rootCmd = &cobra.Command{
 	Use:   "seraphim",
 	Short: "Modular and varied tool belt",
-	Long:  "Seraphim aims at providing the user with several commands\nto make life easier\nRequired dependencies:\n- mysqldump",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Thank you for using seraphim")
+	Long:  "Seraphim aims at providing the user with several commands\nto make life easier\nRequired dependencies:\n- mysqldump\nRun 'seraphim doctor' to check your setup",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cmd.Flags().Changed("output") && !versionRequested {
+			return fmt.Errorf("--output is only valid with --version")
+		}
 		if versionRequested {
-			fmt.Printf("\u251C\u279D  App: %s\n", seraphimConfig.Branding.Name)
-			fmt.Printf("\u2514\u279D  Version: %s\n", globals.AppVersion)
+			return printVersion(versionOutput)
 		}
+		fmt.Println("Thank you for using seraphim")
+		return nil
 	},
 }
 
@@ -49,7 +51,11 @@ func init() {
 	// will be global for your application.
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.config/seraphim/seraphim.yaml)")
-	rootCmd.Flags().BoolVarP(&versionRequested, "version", "v", false, "Application version")
+	rootCmd.Flags().BoolVarP(&versionRequested, "version", "v", false, "Print version and build metadata")
+	rootCmd.Flags().StringVarP(&versionOutput, "output", "o", "text", "Output format of --version (text|json)")
+	// --output belongs to the version command, it is only kept here for
+	// --version
+	rootCmd.Flags().MarkHidden("output")
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 }
@@ -74,7 +80,11 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		viper.Unmarshal(&seraphimConfig)
+		if err := viper.Unmarshal(&seraphimConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not load configuration file %s: %v\n", viper.ConfigFileUsed(), err)
+		}
+	} else if _, notFound := err.(viper.ConfigFileNotFoundError); !notFound && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Warning: could not read configuration file: %v\n", err)
 	}
 }
 
@@ -0,0 +1,112 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"seraphim/globals"
+	"seraphim/lib/util"
+
+	"github.com/spf13/cobra"
+)
+
+var versionOutput string
+
+type versionInfo struct {
+	App       string              `json:"app"`
+	Version   string              `json:"version"`
+	Commit    string              `json:"commit"`
+	BuildDate string              `json:"build_date"`
+	GoVersion string              `json:"go_version"`
+	Platform  string              `json:"platform"`
+	Tools     []util.ExternalTool `json:"external_tools"`
+}
+
+// versionCmd represents the version command
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print version, build metadata and detected external tools",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printVersion(versionOutput)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+
+	versionCmd.Flags().StringVarP(&versionOutput, "output", "o", "text", "Output format (text|json)")
+}
+
+func getVersionInfo() versionInfo {
+	info := versionInfo{
+		App:       seraphimConfig.Branding.Name,
+		Version:   globals.AppVersion,
+		Commit:    globals.GitCommit,
+		BuildDate: globals.BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Tools:     util.DetectDumpTools(),
+	}
+	if info.App == "" {
+		info.App = "seraphim"
+	}
+
+	// Fall back on the VCS stamp embedded by the go toolchain when the
+	// metadata was not injected through ldflags
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = s.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
+
+func printVersion(output string) error {
+	info := getVersionInfo()
+	switch output {
+	case "json":
+		content, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+	case "text", "":
+		fmt.Printf("%s\n", info.App)
+		fmt.Printf("├➝  Version: %s\n", info.Version)
+		fmt.Printf("├➝  Commit: %s\n", info.Commit)
+		fmt.Printf("├➝  Built: %s\n", info.BuildDate)
+		fmt.Printf("├➝  Go: %s\n", info.GoVersion)
+		fmt.Printf("├➝  OS/Arch: %s\n", info.Platform)
+		fmt.Printf("└➝  External tools\n")
+		for i, tool := range info.Tools {
+			branch := "├"
+			if i == len(info.Tools)-1 {
+				branch = "└"
+			}
+			if tool.Version != "" {
+				fmt.Printf("    %s➝  %s: %s\n", branch, tool.Name, tool.Version)
+			} else {
+				fmt.Printf("    %s➝  %s: %s\n", branch, tool.Name, tool.Err)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown output format %q, expected text or json", output)
+	}
+	return nil
+}
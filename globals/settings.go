@@ -1,5 +1,9 @@
 package globals
 
+// Build metadata, overridden at build time through
+// -ldflags "-X seraphim/globals.AppVersion=... -X seraphim/globals.GitCommit=... -X seraphim/globals.BuildDate=..."
 var (
 	AppVersion = "v0.0.2"
+	GitCommit  = ""
+	BuildDate  = ""
 )
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// cfgField describes a single scalar setting discovered on a config struct
+// through its struct tags.
+type cfgField struct {
+	Tab    string
+	Label  string
+	Index  []int
+	Secret bool
+}
+
+// cfgTabSpec groups the settings rendered on the same editor tab. A tab backed
+// by a []map[string]StoredConnection field is rendered as a connection manager.
+type cfgTabSpec struct {
+	Name        string
+	Fields      []cfgField
+	Connections []int
+}
+
+// collectCfgTabs walks t and returns the editor tabs in the order they first
+// appear on the struct.
+func collectCfgTabs(t reflect.Type) []cfgTabSpec {
+	tabs := make([]cfgTabSpec, 0)
+	tabIndex := make(map[string]int)
+	getTab := func(name string) *cfgTabSpec {
+		if i, ok := tabIndex[name]; ok {
+			return &tabs[i]
+		}
+		tabs = append(tabs, cfgTabSpec{Name: name})
+		tabIndex[name] = len(tabs) - 1
+		return &tabs[len(tabs)-1]
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tab := f.Tag.Get("tab")
+		if tab == "" || tab == "-" || !f.IsExported() {
+			continue
+		}
+		if f.Type == reflect.TypeOf([]map[string]StoredConnection{}) {
+			getTab(tab).Connections = f.Index
+			continue
+		}
+		for _, field := range collectCfgFields(f.Type, tab, []int{i}, f) {
+			spec := getTab(field.Tab)
+			spec.Fields = append(spec.Fields, field)
+		}
+	}
+	return tabs
+}
+
+// collectCfgFields returns the editable scalar fields reachable from a field
+// of type t, tagging each of them with the inherited tab name.
+func collectCfgFields(t reflect.Type, tab string, index []int, sf reflect.StructField) []cfgField {
+	fields := make([]cfgField, 0)
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() || f.Tag.Get("tab") == "-" {
+				continue
+			}
+			fieldTab := tab
+			if ft := f.Tag.Get("tab"); ft != "" {
+				fieldTab = ft
+			}
+			childIndex := append(append([]int{}, index...), i)
+			fields = append(fields, collectCfgFields(f.Type, fieldTab, childIndex, f)...)
+		}
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		fields = append(fields, cfgField{
+			Tab:    tab,
+			Label:  cfgFieldLabel(sf),
+			Index:  index,
+			Secret: sf.Tag.Get("secret") == "true",
+		})
+	}
+	return fields
+}
+
+func cfgFieldLabel(sf reflect.StructField) string {
+	if label := sf.Tag.Get("label"); label != "" {
+		return label
+	}
+	name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+	if name == "" {
+		name = sf.Name
+	}
+	name = strings.ReplaceAll(name, "_", " ")
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// getCfgValue renders the field at index on v as a string.
+func getCfgValue(v reflect.Value, index []int) string {
+	fv := v.FieldByIndex(index)
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String()
+	case reflect.Bool:
+		return strconv.FormatBool(fv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(fv.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(fv.Float(), 'f', -1, 64)
+	}
+	return ""
+}
+
+// setCfgValue parses raw according to the kind of the field at index and
+// stores it on v, which must be addressable.
+func setCfgValue(v reflect.Value, index []int, raw string) error {
+	fv := v.FieldByIndex(index)
+	raw = strings.TrimSpace(raw)
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(raw)
+	case reflect.Bool:
+		if raw == "" {
+			fv.SetBool(false)
+			return nil
+		}
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if raw == "" {
+			fv.SetInt(0)
+			return nil
+		}
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || fv.OverflowInt(n) {
+			return fmt.Errorf("%q is not a valid number", raw)
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if raw == "" {
+			fv.SetUint(0)
+			return nil
+		}
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil || fv.OverflowUint(n) {
+			return fmt.Errorf("%q is not a valid positive number", raw)
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		if raw == "" {
+			fv.SetFloat(0)
+			return nil
+		}
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid number", raw)
+		}
+		fv.SetFloat(n)
+	}
+	return nil
+}
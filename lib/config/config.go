@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
 
@@ -22,23 +23,110 @@ type ConfigOperationResult struct {
 	Msg string
 }
 
+// Fields carrying a `tab` struct tag are picked up by the configuration editor,
+// nested structs inherit the tab of their parent field and `label` overrides
+// the caption shown next to the input.
 type BrandingConfig struct {
-	Name string `mapstructure:"name"`
+	Name string `mapstructure:"name" yaml:"name" label:"Application name"`
+}
+
+// RetentionPolicy tells which dumps survive a prune. A dump is kept when any
+// rule keeps it, and a policy without rules keeps every dump.
+type RetentionPolicy struct {
+	KeepLast    int `mapstructure:"keep_last" yaml:"keep_last,omitempty" label:"Keep the last N dumps"`
+	KeepDaily   int `mapstructure:"keep_daily" yaml:"keep_daily,omitempty" label:"Keep a daily dump for D days"`
+	KeepWeekly  int `mapstructure:"keep_weekly" yaml:"keep_weekly,omitempty" label:"Keep a weekly dump for W weeks"`
+	KeepMonthly int `mapstructure:"keep_monthly" yaml:"keep_monthly,omitempty" label:"Keep a monthly dump for M months"`
+}
+
+// IsZero reports whether p has no rule.
+func (p RetentionPolicy) IsZero() bool {
+	return p == RetentionPolicy{}
+}
+
+// RetentionConfig holds the default policy along with the policies of
+// connection tags, which replace the default one for the dumps of the tag.
+type RetentionConfig struct {
+	RetentionPolicy `mapstructure:",squash" yaml:",inline"`
+	Connections     map[string]RetentionPolicy `mapstructure:"connections" yaml:"connections,omitempty" tab:"-"`
+}
+
+// Policy returns the policy applying to the dumps of the connection tagged
+// tag.
+func (r RetentionConfig) Policy(tag string) RetentionPolicy {
+	if p, ok := r.Connections[tag]; ok {
+		return p
+	}
+	return r.RetentionPolicy
+}
+
+// MaskingRule replaces the values of the columns it matches in dumps written
+// by seraphim. Column matches db.table.column, with * wildcards, and Pattern
+// is a regular expression matching column names; a rule sets one of them.
+type MaskingRule struct {
+	Column   string `mapstructure:"column" yaml:"column,omitempty"`
+	Pattern  string `mapstructure:"pattern" yaml:"pattern,omitempty"`
+	Strategy string `mapstructure:"strategy" yaml:"strategy"`
+	// Value is the value of the fixed strategy
+	Value string `mapstructure:"value" yaml:"value,omitempty"`
+	// Days bounds the shift of the date_shift strategy, 30 when zero
+	Days int `mapstructure:"days" yaml:"days,omitempty"`
+}
+
+// MaskingConfig lists the masking rules, the first rule matching a column
+// applying to it. Salt keys the hashes every strategy derives its values
+// from, equal values being masked alike across tables.
+type MaskingConfig struct {
+	Salt  string        `mapstructure:"salt" yaml:"salt,omitempty" label:"Masking salt" secret:"true"`
+	Rules []MaskingRule `mapstructure:"rules" yaml:"rules,omitempty" tab:"-"`
 }
 
 type StoredConnection struct {
-	Host            string `mapstructure:"host"`
-	User            string `mapstructure:"user"`
-	Port            int    `mapstructure:"port"`
-	Password        string `mapstructure:"password"`
-	Provider        string `mapstructure:"provider"`
-	DefaultDatabase string `mapstructure:"default_database"`
+	Host            string `mapstructure:"host" yaml:"host" label:"Host (file path for sqlite)"`
+	User            string `mapstructure:"user" yaml:"user" label:"User"`
+	Port            int    `mapstructure:"port" yaml:"port" label:"Port"`
+	Password        string `mapstructure:"password" yaml:"password" label:"Password" secret:"true"`
+	Provider        string `mapstructure:"provider" yaml:"provider" label:"Provider"`
+	DefaultDatabase string `mapstructure:"default_database" yaml:"default_database" label:"Default database"`
+	SSLMode         string `mapstructure:"ssl_mode" yaml:"ssl_mode,omitempty" label:"SSL mode (postgres)"`
+}
+
+// IsSQLite reports whether sc points to a sqlite database file rather than a
+// server, in which case Host holds the file path.
+func (sc StoredConnection) IsSQLite() bool {
+	return sc.Provider == "sqlite" || sc.Provider == "sqlite3"
 }
 
 type SeraphimConfig struct {
-	Branding           BrandingConfig                `mapstructure:"branding"`
-	Stored_Connections []map[string]StoredConnection `mapstructure:"stored_connections"`
-	Default_dump_path  string                        `mapstructure:"default_dump_path"`
+	Default_dump_path  string                        `mapstructure:"default_dump_path" yaml:"default_dump_path" tab:"General" label:"Default dump path"`
+	Dump_filename      string                        `mapstructure:"dump_filename_template" yaml:"dump_filename_template,omitempty" tab:"General" label:"Dump file name template"`
+	Dump_split         string                        `mapstructure:"dump_split" yaml:"dump_split,omitempty" tab:"General" label:"Dump split mode (single_file, per_database, per_table)"`
+	Dump_parallel      int                           `mapstructure:"dump_parallel" yaml:"dump_parallel,omitempty" tab:"General" label:"Dump files written in parallel"`
+	Branding           BrandingConfig                `mapstructure:"branding" yaml:"branding" tab:"Branding"`
+	Stored_Connections []map[string]StoredConnection `mapstructure:"stored_connections" yaml:"stored_connections" tab:"Connections"`
+	Retention          RetentionConfig               `mapstructure:"retention" yaml:"retention,omitempty" tab:"Retention"`
+	Masking            MaskingConfig                 `mapstructure:"masking" yaml:"masking,omitempty" tab:"Masking"`
+}
+
+// Connection returns the stored connection registered under tag.
+func (c SeraphimConfig) Connection(tag string) (StoredConnection, bool) {
+	for _, entry := range c.Stored_Connections {
+		if conn, ok := entry[tag]; ok {
+			return conn, true
+		}
+	}
+	return StoredConnection{}, false
+}
+
+// ConnectionTags returns the tags of every stored connection, in order.
+func (c SeraphimConfig) ConnectionTags() []string {
+	tags := make([]string, 0, len(c.Stored_Connections))
+	for _, entry := range c.Stored_Connections {
+		for tag := range entry {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
 }
 
 func AddConnection(withConf bool, conf SeraphimConfig, newConn StoredConnection, tag string) ConfigOperationResult {
@@ -256,6 +344,19 @@ func SaveConfig(conf SeraphimConfig) ConfigOperationResult {
 
 }
 
+// ConfigDir returns the directory holding the configuration file in use,
+// falling back on the default $HOME/.config/seraphim.
+func ConfigDir() string {
+	if file := viper.ConfigFileUsed(); file != "" {
+		return filepath.Dir(file)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(home, ".config", "seraphim")
+}
+
 func ClearScreen() {
 	var cmd *exec.Cmd
 
@@ -1,9 +1,8 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
+	"reflect"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/cursor"
@@ -14,137 +13,227 @@ import (
 )
 
 var (
-	appConfig    SeraphimConfig
-	focusedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
+	focusedStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
+	blurredStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
 	noStyle             = lipgloss.NewStyle()
 	helpStyle           = blurredStyle.Copy()
 	cursorModeHelpStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("244"))
+	errorStyle          = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
 
-	focusedButton = focusedStyle.Copy().Render("[ Submit ]")
-	blurredButton = fmt.Sprintf("[ %s ]", blurredStyle.Render("Submit"))
-
-	blurredStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+	tabStyle       = lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Padding(0, 1)
+	activeTabStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#FFFDF5")).
+			Background(lipgloss.Color("#25A065")).
+			Padding(0, 1)
 
+	focusedButton = focusedStyle.Copy().Render("[ Save ]")
+	blurredButton = fmt.Sprintf("[ %s ]", blurredStyle.Render("Save"))
 )
 
 type ConfigEditorResult struct {
-	Err error `default:"nil"`  
-	Msg string
+	Err          error
+	Msg          string
 	EditedConfig SeraphimConfig
 }
 
+// RunCfgEditForm opens the tabbed configuration editor on a copy of sconfig
+// and, once the user confirms, saves the edited configuration.
 func RunCfgEditForm(sconfig *SeraphimConfig) ConfigEditorResult {
 
-	appConfig = *sconfig
-	initModel, err := tea.NewProgram(initialModel(), tea.WithAltScreen()).Run()
+	m, err := tea.NewProgram(initialModel(*sconfig), tea.WithAltScreen()).Run()
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
-	}
-	if model, ok := initModel.(CfgEditorModel); ok && model.Result.Err == nil {
-		var indicator string
-		var confirm bool
-		var message string
-		if model.Result.Err == nil && model.Completed {
-			huh.NewConfirm().
-			Title("You sure?").
-			Affirmative("Yes!").
-			Negative("No.").
-			Value(&confirm).Run()
-			if confirm {
-				if r := SaveConfig(model.Result.EditedConfig); r.Err == nil {
-					indicator = "\u2713 "
-					message = r.Msg
-				}else {
-					indicator = "\u2B59 "
-					message = r.Err.Error()
-				}
-			}else {
-				indicator = "\u2B59 "
-				message = "Aborted operation"
-			}
-		} else if model.Result.Err != nil {
-			message = model.Result.Err.Error()
-		}
-		fmt.Println(focusedStyle.Render(fmt.Sprintf("--%s> %s!", indicator, message)))
+		return ConfigEditorResult{Err: err}
+	}
+	model, ok := m.(CfgEditorModel)
+	if !ok || !model.Completed {
+		return ConfigEditorResult{Msg: "Aborted operation"}
 	}
-	return ConfigEditorResult{}
-}
-
-func (cfgm *CfgEditorModel) getEditableFields() {
-
-	cfgm.Fields = make([]textinput.Model, 0)
 
-	defDumpPathInput := textinput.New()
-	defDumpPathInput.Placeholder = "Default dump path: \u21BA " + appConfig.Default_dump_path
-	defDumpPathInput.Focus()
-	defDumpPathInput.PromptStyle = focusedStyle
-	defDumpPathInput.TextStyle = focusedStyle
-	cfgm.Fields = append(cfgm.Fields, defDumpPathInput)
+	var confirm bool
+	huh.NewConfirm().
+		Title("You sure?").
+		Affirmative("Yes!").
+		Negative("No.").
+		Value(&confirm).Run()
+	if !confirm {
+		return ConfigEditorResult{Msg: "Aborted operation"}
+	}
 
+	r := SaveConfig(model.Result.EditedConfig)
+	return ConfigEditorResult{
+		Err:          r.Err,
+		Msg:          r.Msg,
+		EditedConfig: model.Result.EditedConfig,
+	}
 }
 
-func initialModel() CfgEditorModel {
-	initModel := CfgEditorModel{
-		Editing: true,
-	}
-	initModel.getEditableFields()
-	return initModel
+type cfgEditorTab struct {
+	Spec   cfgTabSpec
+	Inputs []textinput.Model
 }
 
 type CfgEditorModel struct {
-	// MultiField form
+	// Working copy of the configuration
+	Config SeraphimConfig
+	// Tabs
+	Tabs       []cfgEditorTab
+	ActiveTab  int
 	FocusIndex int
-	Fields     []textinput.Model
 	CursorMode cursor.Mode
+	// Connection manager
+	ConnCursor     int
+	EditingConn    bool
+	EditingConnIdx int
+	ConnFields     []cfgField
+	ConnInputs     []textinput.Model
+	ConnFocus      int
 	// state
-	Editing   bool
+	StatusMsg string
 	Completed bool
-	Done      bool
 	// result
 	Result ConfigEditorResult
 }
 
+func initialModel(sconfig SeraphimConfig) CfgEditorModel {
+	m := CfgEditorModel{
+		Config: cloneConfig(sconfig),
+	}
+	v := reflect.ValueOf(m.Config)
+	for _, spec := range collectCfgTabs(v.Type()) {
+		tab := cfgEditorTab{Spec: spec}
+		for _, f := range spec.Fields {
+			tab.Inputs = append(tab.Inputs, newCfgInput(f, getCfgValue(v, f.Index)))
+		}
+		m.Tabs = append(m.Tabs, tab)
+	}
+	m.ConnFields = collectCfgFields(reflect.TypeOf(StoredConnection{}), "Connection", nil, reflect.StructField{})
+	m.focusTab()
+	return m
+}
+
+func cloneConfig(c SeraphimConfig) SeraphimConfig {
+	clone := c
+	clone.Stored_Connections = make([]map[string]StoredConnection, 0, len(c.Stored_Connections))
+	for _, entry := range c.Stored_Connections {
+		mapped := make(map[string]StoredConnection, len(entry))
+		for tag, conn := range entry {
+			mapped[tag] = conn
+		}
+		clone.Stored_Connections = append(clone.Stored_Connections, mapped)
+	}
+	return clone
+}
+
+func newCfgInput(f cfgField, value string) textinput.Model {
+	input := textinput.New()
+	input.Prompt = fmt.Sprintf("%s: ", f.Label)
+	input.CharLimit = 256
+	if f.Secret {
+		input.EchoMode = textinput.EchoPassword
+		input.EchoCharacter = '•'
+	}
+	input.SetValue(value)
+	return input
+}
+
 func (cfgm CfgEditorModel) Init() tea.Cmd {
 	return tea.Batch(textinput.Blink, tea.EnterAltScreen)
 }
 
+func (cfgm CfgEditorModel) activeTab() *cfgEditorTab {
+	return &cfgm.Tabs[cfgm.ActiveTab]
+}
+
+func (cfgm CfgEditorModel) isConnectionsTab() bool {
+	return len(cfgm.Tabs) > 0 && cfgm.Tabs[cfgm.ActiveTab].Spec.Connections != nil
+}
+
+// focusTab resets the focus to the first input of the active tab.
+func (cfgm *CfgEditorModel) focusTab() tea.Cmd {
+	cfgm.FocusIndex = 0
+	if len(cfgm.Tabs) == 0 {
+		return nil
+	}
+	return focusInput(cfgm.Tabs[cfgm.ActiveTab].Inputs, cfgm.FocusIndex)
+}
+
+// focusInput focuses inputs[index] and blurs every other input. An index equal
+// to len(inputs) means the submit button is focused.
+func focusInput(inputs []textinput.Model, index int) tea.Cmd {
+	cmds := make([]tea.Cmd, len(inputs))
+	for i := range inputs {
+		if i == index {
+			cmds[i] = inputs[i].Focus()
+			inputs[i].PromptStyle = focusedStyle
+			inputs[i].TextStyle = focusedStyle
+			continue
+		}
+		inputs[i].Blur()
+		inputs[i].PromptStyle = noStyle
+		inputs[i].TextStyle = noStyle
+	}
+	return tea.Batch(cmds...)
+}
+
 func (cfgm CfgEditorModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
+	if msg, ok := msg.(tea.KeyMsg); ok {
 		switch msg.String() {
-		case "ctrl+c", "esc":
-			ClearScreen()
-			os.Exit(0)
+		case "ctrl+c":
+			return cfgm, tea.Quit
 		case "ctrl+r":
 			cfgm.CursorMode++
 			if cfgm.CursorMode > cursor.CursorHide {
 				cfgm.CursorMode = cursor.CursorBlink
 			}
-			cmds := make([]tea.Cmd, len(cfgm.Fields))
-			for i := range cfgm.Fields {
-				cmds[i] = cfgm.Fields[i].Cursor.SetMode(cfgm.CursorMode)
+			cmds := make([]tea.Cmd, 0)
+			for t := range cfgm.Tabs {
+				for i := range cfgm.Tabs[t].Inputs {
+					cmds = append(cmds, cfgm.Tabs[t].Inputs[i].Cursor.SetMode(cfgm.CursorMode))
+				}
+			}
+			for i := range cfgm.ConnInputs {
+				cmds = append(cmds, cfgm.ConnInputs[i].Cursor.SetMode(cfgm.CursorMode))
 			}
 			return cfgm, tea.Batch(cmds...)
+		}
+	}
+
+	if cfgm.EditingConn {
+		return cfgm.updateConnForm(msg)
+	}
+
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "esc":
+			return cfgm, tea.Quit
+		case "ctrl+s":
+			return cfgm.submit()
+		case "ctrl+n", "ctrl+right":
+			cfgm.ActiveTab = (cfgm.ActiveTab + 1) % len(cfgm.Tabs)
+			cfgm.StatusMsg = ""
+			return cfgm, cfgm.focusTab()
+		case "ctrl+p", "ctrl+left":
+			cfgm.ActiveTab = (cfgm.ActiveTab - 1 + len(cfgm.Tabs)) % len(cfgm.Tabs)
+			cfgm.StatusMsg = ""
+			return cfgm, cfgm.focusTab()
+		}
+	}
+
+	if cfgm.isConnectionsTab() {
+		return cfgm.updateConnManager(msg)
+	}
+	return cfgm.updateFieldsTab(msg)
+}
 
+func (cfgm CfgEditorModel) updateFieldsTab(msg tea.Msg) (tea.Model, tea.Cmd) {
+	tab := cfgm.activeTab()
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch s := msg.String(); s {
 		// Set focus to next input
 		case "tab", "shift+tab", "enter", "up", "down":
-			s := msg.String()
-
 			// Did the user press enter while the submit button was focused?
-			// If so, exit.
-			if s == "enter" {
-				if cfgm.FocusIndex == len(cfgm.Fields) {
-					cfgm.Completed = true
-					var confCopy SeraphimConfig
-					b, _ := json.Marshal(appConfig)
-					json.Unmarshal(b, &confCopy)
-					if cfgm.Fields[0].Value() != "" {
-						confCopy.Default_dump_path = cfgm.Fields[0].Value() 
-					}
-					cfgm.Result.EditedConfig = confCopy
-					return cfgm, tea.Quit
-				}
+			if s == "enter" && cfgm.FocusIndex == len(tab.Inputs) {
+				return cfgm.submit()
 			}
 
 			// Cycle indexes
@@ -153,68 +242,272 @@ func (cfgm CfgEditorModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			} else {
 				cfgm.FocusIndex++
 			}
-
-			if cfgm.FocusIndex > len(cfgm.Fields) {
+			if cfgm.FocusIndex > len(tab.Inputs) {
 				cfgm.FocusIndex = 0
 			} else if cfgm.FocusIndex < 0 {
-				cfgm.FocusIndex = len(cfgm.Fields)
+				cfgm.FocusIndex = len(tab.Inputs)
 			}
+			return cfgm, focusInput(tab.Inputs, cfgm.FocusIndex)
+		}
+	}
 
-			cmds := make([]tea.Cmd, len(cfgm.Fields))
-			for i := 0; i <= len(cfgm.Fields)-1; i++ {
+	// Only inputs with Focus() set will respond, so it's safe to simply
+	// update all of them here without any further logic.
+	cmds := make([]tea.Cmd, len(tab.Inputs))
+	for i := range tab.Inputs {
+		tab.Inputs[i], cmds[i] = tab.Inputs[i].Update(msg)
+	}
+	return cfgm, tea.Batch(cmds...)
+}
 
-				if i == cfgm.FocusIndex {
-					// Set focused state
-					cmds[i] = cfgm.Fields[i].Focus()
-					cfgm.Fields[i].PromptStyle = focusedStyle
-					cfgm.Fields[i].TextStyle = focusedStyle
-					continue
-				}
-				// Remove focused state
-				cfgm.Fields[i].Blur()
-				cfgm.Fields[i].PromptStyle = noStyle
-				cfgm.Fields[i].TextStyle = noStyle
+func (cfgm CfgEditorModel) updateConnManager(msg tea.Msg) (tea.Model, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return cfgm, nil
+	}
+	conns := cfgm.Config.Stored_Connections
+	cfgm.StatusMsg = ""
+	switch keyMsg.String() {
+	case "up", "k":
+		if cfgm.ConnCursor > 0 {
+			cfgm.ConnCursor--
+		}
+	case "down", "j":
+		if cfgm.ConnCursor < len(conns)-1 {
+			cfgm.ConnCursor++
+		}
+	case "shift+up", "K":
+		if cfgm.ConnCursor > 0 {
+			conns[cfgm.ConnCursor], conns[cfgm.ConnCursor-1] = conns[cfgm.ConnCursor-1], conns[cfgm.ConnCursor]
+			cfgm.ConnCursor--
+		}
+	case "shift+down", "J":
+		if cfgm.ConnCursor < len(conns)-1 {
+			conns[cfgm.ConnCursor], conns[cfgm.ConnCursor+1] = conns[cfgm.ConnCursor+1], conns[cfgm.ConnCursor]
+			cfgm.ConnCursor++
+		}
+	case "a":
+		return cfgm, cfgm.openConnForm(-1)
+	case "e", "enter":
+		if len(conns) > 0 {
+			return cfgm, cfgm.openConnForm(cfgm.ConnCursor)
+		}
+	case "d", "x", "delete":
+		if len(conns) > 0 {
+			tag, _ := connectionEntry(conns[cfgm.ConnCursor])
+			cfgm.Config.Stored_Connections = append(conns[:cfgm.ConnCursor], conns[cfgm.ConnCursor+1:]...)
+			if cfgm.ConnCursor >= len(cfgm.Config.Stored_Connections) && cfgm.ConnCursor > 0 {
+				cfgm.ConnCursor--
 			}
+			cfgm.StatusMsg = fmt.Sprintf("Removed connection %s", tag)
+		}
+	}
+	return cfgm, nil
+}
 
-			return cfgm, tea.Batch(cmds...)
+// connectionEntry returns the tag and connection stored in a single
+// Stored_Connections entry.
+func connectionEntry(entry map[string]StoredConnection) (string, StoredConnection) {
+	for tag, conn := range entry {
+		return tag, conn
+	}
+	return "", StoredConnection{}
+}
+
+// openConnForm builds the connection form for the entry at index, or for a
+// new connection when index is negative.
+func (cfgm *CfgEditorModel) openConnForm(index int) tea.Cmd {
+	var tag string
+	var conn StoredConnection
+	if index >= 0 {
+		tag, conn = connectionEntry(cfgm.Config.Stored_Connections[index])
+	}
+	v := reflect.ValueOf(conn)
+	cfgm.ConnInputs = []textinput.Model{newCfgInput(cfgField{Label: "Tag"}, tag)}
+	for _, f := range cfgm.ConnFields {
+		value := ""
+		if index >= 0 {
+			value = getCfgValue(v, f.Index)
 		}
+		cfgm.ConnInputs = append(cfgm.ConnInputs, newCfgInput(f, value))
 	}
-	cmd := cfgm.updateFields(msg)
+	cfgm.EditingConn = true
+	cfgm.EditingConnIdx = index
+	cfgm.ConnFocus = 0
+	return focusInput(cfgm.ConnInputs, cfgm.ConnFocus)
+}
 
-	return cfgm, cmd
+func (cfgm CfgEditorModel) updateConnForm(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch s := msg.String(); s {
+		case "esc", "alt+backspace":
+			cfgm.EditingConn = false
+			cfgm.StatusMsg = ""
+			return cfgm, nil
+		case "ctrl+s":
+			return cfgm.commitConnForm()
+		case "tab", "shift+tab", "enter", "up", "down":
+			if s == "enter" && cfgm.ConnFocus == len(cfgm.ConnInputs) {
+				return cfgm.commitConnForm()
+			}
+			if s == "up" || s == "shift+tab" {
+				cfgm.ConnFocus--
+			} else {
+				cfgm.ConnFocus++
+			}
+			if cfgm.ConnFocus > len(cfgm.ConnInputs) {
+				cfgm.ConnFocus = 0
+			} else if cfgm.ConnFocus < 0 {
+				cfgm.ConnFocus = len(cfgm.ConnInputs)
+			}
+			return cfgm, focusInput(cfgm.ConnInputs, cfgm.ConnFocus)
+		}
+	}
+
+	cmds := make([]tea.Cmd, len(cfgm.ConnInputs))
+	for i := range cfgm.ConnInputs {
+		cfgm.ConnInputs[i], cmds[i] = cfgm.ConnInputs[i].Update(msg)
+	}
+	return cfgm, tea.Batch(cmds...)
+}
+
+// commitConnForm validates the connection form and writes it into the working
+// copy of the configuration.
+func (cfgm CfgEditorModel) commitConnForm() (tea.Model, tea.Cmd) {
+	tag := strings.Replace(strings.Trim(cfgm.ConnInputs[0].Value(), " "), " ", "_", -1)
+	if tag == "" {
+		cfgm.StatusMsg = errorStyle.Render("Tag cannot be empty")
+		return cfgm, nil
+	}
+	for i, entry := range cfgm.Config.Stored_Connections {
+		if existing, _ := connectionEntry(entry); existing == tag && i != cfgm.EditingConnIdx {
+			cfgm.StatusMsg = errorStyle.Render(fmt.Sprintf("A connection tagged %s already exists", tag))
+			return cfgm, nil
+		}
+	}
+
+	var conn StoredConnection
+	v := reflect.ValueOf(&conn).Elem()
+	for i, f := range cfgm.ConnFields {
+		if err := setCfgValue(v, f.Index, cfgm.ConnInputs[i+1].Value()); err != nil {
+			cfgm.StatusMsg = errorStyle.Render(fmt.Sprintf("%s: %v", f.Label, err))
+			return cfgm, nil
+		}
+	}
+
+	entry := map[string]StoredConnection{tag: conn}
+	if cfgm.EditingConnIdx < 0 {
+		cfgm.Config.Stored_Connections = append(cfgm.Config.Stored_Connections, entry)
+		cfgm.ConnCursor = len(cfgm.Config.Stored_Connections) - 1
+		cfgm.StatusMsg = fmt.Sprintf("Added connection %s", tag)
+	} else {
+		cfgm.Config.Stored_Connections[cfgm.EditingConnIdx] = entry
+		cfgm.StatusMsg = fmt.Sprintf("Updated connection %s", tag)
+	}
+	cfgm.EditingConn = false
+	return cfgm, nil
+}
+
+// submit applies every tab to the working copy and quits the program when all
+// the values could be parsed.
+func (cfgm CfgEditorModel) submit() (tea.Model, tea.Cmd) {
+	edited := cloneConfig(cfgm.Config)
+	v := reflect.ValueOf(&edited).Elem()
+	for t, tab := range cfgm.Tabs {
+		for i, f := range tab.Spec.Fields {
+			if err := setCfgValue(v, f.Index, tab.Inputs[i].Value()); err != nil {
+				cfgm.ActiveTab = t
+				cfgm.FocusIndex = i
+				cfgm.StatusMsg = errorStyle.Render(fmt.Sprintf("%s: %v", f.Label, err))
+				return cfgm, focusInput(tab.Inputs, i)
+			}
+		}
+	}
+	cfgm.Completed = true
+	cfgm.Result.EditedConfig = edited
+	return cfgm, tea.Quit
 }
 
 func (cfgm CfgEditorModel) View() string {
 	var b strings.Builder
 
-	for i := range cfgm.Fields {
-		b.WriteString(cfgm.Fields[i].View())
-		if i < len(cfgm.Fields)-1 {
+	tabs := make([]string, len(cfgm.Tabs))
+	for i, tab := range cfgm.Tabs {
+		if i == cfgm.ActiveTab {
+			tabs[i] = activeTabStyle.Render(tab.Spec.Name)
+		} else {
+			tabs[i] = tabStyle.Render(tab.Spec.Name)
+		}
+	}
+	b.WriteString(lipgloss.JoinHorizontal(lipgloss.Top, tabs...))
+	b.WriteString("\n\n")
+
+	switch {
+	case cfgm.EditingConn:
+		b.WriteString(cfgm.viewConnForm())
+	case cfgm.isConnectionsTab():
+		b.WriteString(cfgm.viewConnManager())
+	case len(cfgm.Tabs) > 0:
+		b.WriteString(viewInputs(cfgm.activeTab().Inputs, cfgm.FocusIndex))
+		b.WriteString(helpStyle.Render("cursor mode is "))
+		b.WriteString(cursorModeHelpStyle.Render(cfgm.CursorMode.String()))
+		b.WriteString(helpStyle.Render(" (ctrl+r to change style) • ctrl+n/ctrl+p switch tab • ctrl+s save • esc quit"))
+	}
+
+	if cfgm.StatusMsg != "" {
+		fmt.Fprintf(&b, "\n\n%s", cfgm.StatusMsg)
+	}
+
+	return b.String()
+}
+
+func viewInputs(inputs []textinput.Model, focusIndex int) string {
+	var b strings.Builder
+	for i := range inputs {
+		b.WriteString(inputs[i].View())
+		if i < len(inputs)-1 {
 			b.WriteRune('\n')
 		}
 	}
 
 	button := &blurredButton
-	if cfgm.FocusIndex == len(cfgm.Fields) {
+	if focusIndex == len(inputs) {
 		button = &focusedButton
 	}
 	fmt.Fprintf(&b, "\n\n%s\n\n", *button)
-
-	b.WriteString(helpStyle.Render("cursor mode is "))
-	b.WriteString(cursorModeHelpStyle.Render(cfgm.CursorMode.String()))
-	b.WriteString(helpStyle.Render(" (ctrl+r to change style) or (ctrl+c / esc to quit)"))
-
 	return b.String()
 }
 
-func (cfgm *CfgEditorModel) updateFields(msg tea.Msg) tea.Cmd {
-	cmds := make([]tea.Cmd, len(cfgm.Fields))
-
-	// Only text Fields with Focus() set will respond, so it's safe to simply
-	// update all of them here without any further logic.
-	for i := range cfgm.Fields {
-		cfgm.Fields[i], cmds[i] = cfgm.Fields[i].Update(msg)
+func (cfgm CfgEditorModel) viewConnManager() string {
+	var b strings.Builder
+	if len(cfgm.Config.Stored_Connections) == 0 {
+		b.WriteString(blurredStyle.Render("No stored connections yet"))
+		b.WriteRune('\n')
 	}
+	for i, entry := range cfgm.Config.Stored_Connections {
+		tag, conn := connectionEntry(entry)
+		line := fmt.Sprintf("%d. %s  %s@%s:%d  %s", i+1, tag, conn.User, conn.Host, conn.Port, conn.Provider)
+		if i == cfgm.ConnCursor {
+			b.WriteString(focusedStyle.Render("❯ " + line))
+		} else {
+			b.WriteString("  " + line)
+		}
+		b.WriteRune('\n')
+	}
+	b.WriteRune('\n')
+	b.WriteString(helpStyle.Render("a add • e/enter edit • d remove • shift+↑/↓ reorder • ctrl+n/ctrl+p switch tab • ctrl+s save • esc quit"))
+	return b.String()
+}
 
-	return tea.Batch(cmds...)
+func (cfgm CfgEditorModel) viewConnForm() string {
+	var b strings.Builder
+	if cfgm.EditingConnIdx < 0 {
+		b.WriteString(focusedStyle.Render("New connection"))
+	} else {
+		b.WriteString(focusedStyle.Render("Edit connection"))
+	}
+	b.WriteString("\n\n")
+	b.WriteString(viewInputs(cfgm.ConnInputs, cfgm.ConnFocus))
+	b.WriteString(helpStyle.Render("enter on Save or ctrl+s to keep the connection • esc go back"))
+	return b.String()
 }
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/charmbracelet/huh"
+	"github.com/spf13/viper"
+)
+
+// RunRawEdit opens a copy of the configuration file in $VISUAL / $EDITOR and
+// only replaces the original once the edited copy parses and validates. The
+// user is offered to re-open the editor or discard the changes otherwise.
+func RunRawEdit() ConfigOperationResult {
+
+	file := viper.ConfigFileUsed()
+	original, err := os.ReadFile(file)
+	if err != nil {
+		return ConfigOperationResult{
+			Err: fmt.Errorf("could not read configuration file, run 'seraphim config init' first: %w", err),
+		}
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return ConfigOperationResult{Err: err}
+	}
+
+	tmp, err := os.CreateTemp("", "seraphim-*"+filepath.Ext(file))
+	if err != nil {
+		return ConfigOperationResult{Err: err}
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(original); err != nil {
+		tmp.Close()
+		return ConfigOperationResult{Err: err}
+	}
+	tmp.Close()
+
+	for {
+		if err := openInEditor(tmp.Name()); err != nil {
+			return ConfigOperationResult{Err: err}
+		}
+
+		edited, err := os.ReadFile(tmp.Name())
+		if err != nil {
+			return ConfigOperationResult{Err: err}
+		}
+		if bytes.Equal(edited, original) {
+			return ConfigOperationResult{Msg: "Nothing to edit"}
+		}
+
+		errs := make([]error, 0)
+		if conf, err := ParseConfig(edited); err != nil {
+			errs = append(errs, SplitErrors(err)...)
+		} else {
+			errs = append(errs, conf.Validate()...)
+		}
+
+		if len(errs) == 0 {
+			if err := os.WriteFile(file, edited, info.Mode().Perm()); err != nil {
+				return ConfigOperationResult{Err: err}
+			}
+			return ConfigOperationResult{Msg: "Successfully saved configuration"}
+		}
+
+		fmt.Println(errorStyle.Render("The edited configuration is not valid:"))
+		for _, e := range errs {
+			fmt.Println(errorStyle.Render("  ⭙ " + e.Error()))
+		}
+
+		reopen := true
+		err = huh.NewSelect[bool]().
+			Title("What do you want to do?").
+			Options(
+				huh.NewOption("Re-open the editor", true),
+				huh.NewOption("Discard changes", false),
+			).
+			Value(&reopen).Run()
+		if err != nil || !reopen {
+			return ConfigOperationResult{Msg: "Discarded changes, configuration left untouched"}
+		}
+	}
+}
+
+// openInEditor runs the user's preferred editor on path and waits for it to
+// exit.
+func openInEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		} else {
+			editor = "vi"
+		}
+	}
+
+	args := strings.Fields(editor)
+	if len(args) == 0 {
+		return errors.New("no editor configured, set $VISUAL or $EDITOR")
+	}
+	cmd := exec.Command(args[0], append(args[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %q exited with an error: %w", editor, err)
+	}
+	return nil
+}
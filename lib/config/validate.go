@@ -0,0 +1,163 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"seraphim/lib/util"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// SupportedProviders lists the values accepted as StoredConnection.Provider.
+var SupportedProviders = []string{"mysql", "postgres", "postgresql", "sqlite", "sqlite3"}
+
+// DumpSplitModes lists the values accepted as dump_split, an empty value
+// leaving the choice to the provider.
+var DumpSplitModes = []string{"single_file", "per_database", "per_table"}
+
+// MaskingStrategies lists the values accepted as the strategy of a masking
+// rule.
+var MaskingStrategies = []string{"fixed", "hash", "email", "name", "phone", "scramble", "null", "date_shift"}
+
+// ParseConfig decodes raw YAML the same way initConfig does, but rejects keys
+// that do not map to a SeraphimConfig field so that typos are reported instead
+// of being silently dropped.
+func ParseConfig(content []byte) (SeraphimConfig, error) {
+	var conf SeraphimConfig
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(content)); err != nil {
+		return conf, err
+	}
+	if err := v.UnmarshalExact(&conf); err != nil {
+		return conf, err
+	}
+	return conf, nil
+}
+
+// SplitErrors flattens decoding errors that aggregate several problems.
+func SplitErrors(err error) []error {
+	if multi, ok := err.(interface{ WrappedErrors() []error }); ok {
+		return multi.WrappedErrors()
+	}
+	return []error{err}
+}
+
+// Validate reports every problem found in the configuration.
+func (c SeraphimConfig) Validate() []error {
+	errs := make([]error, 0)
+	seen := make(map[string]bool)
+	for i, entry := range c.Stored_Connections {
+		if len(entry) != 1 {
+			errs = append(errs, fmt.Errorf("stored_connections[%d]: expected exactly one tag, found %d", i, len(entry)))
+		}
+		for tag, conn := range entry {
+			if strings.TrimSpace(tag) == "" {
+				errs = append(errs, fmt.Errorf("stored_connections[%d]: tag cannot be empty", i))
+			} else if strings.Contains(tag, " ") {
+				errs = append(errs, fmt.Errorf("stored_connections[%d]: tag %q cannot contain spaces", i, tag))
+			}
+			if seen[tag] {
+				errs = append(errs, fmt.Errorf("stored_connections[%d]: duplicate tag %q", i, tag))
+			}
+			seen[tag] = true
+			for _, err := range conn.Validate() {
+				errs = append(errs, fmt.Errorf("stored_connections[%d] (%s): %w", i, tag, err))
+			}
+		}
+	}
+	if c.Dump_filename != "" {
+		if err := util.CheckFilenameTemplate(c.Dump_filename); err != nil {
+			errs = append(errs, fmt.Errorf("dump_filename_template: %w", err))
+		}
+	}
+	if c.Dump_split != "" && !slices.Contains(DumpSplitModes, c.Dump_split) {
+		errs = append(errs, fmt.Errorf("dump_split: unknown mode %q, expected one of %s", c.Dump_split, strings.Join(DumpSplitModes, ", ")))
+	}
+	if c.Dump_parallel < 0 {
+		errs = append(errs, fmt.Errorf("dump_parallel: cannot be negative"))
+	}
+	errs = append(errs, c.Retention.validate("retention")...)
+	for tag, p := range c.Retention.Connections {
+		if !seen[tag] {
+			errs = append(errs, fmt.Errorf("retention.connections: unknown connection tag %q", tag))
+		}
+		errs = append(errs, p.validate("retention.connections."+tag)...)
+	}
+	errs = append(errs, c.Masking.validate()...)
+	return errs
+}
+
+func (m MaskingConfig) validate() []error {
+	errs := make([]error, 0)
+	if len(m.Rules) > 0 && m.Salt == "" {
+		errs = append(errs, fmt.Errorf("masking.salt: required by the masking rules, masked values could be guessed without it"))
+	}
+	for i, r := range m.Rules {
+		rule := fmt.Sprintf("masking.rules[%d]", i)
+		switch {
+		case r.Column == "" && r.Pattern == "":
+			errs = append(errs, fmt.Errorf("%s: set a column or a pattern", rule))
+		case r.Column != "" && r.Pattern != "":
+			errs = append(errs, fmt.Errorf("%s: set a column or a pattern, not both", rule))
+		case r.Column != "" && strings.Count(r.Column, ".") < 2:
+			errs = append(errs, fmt.Errorf("%s: column %q must be db.table.column", rule, r.Column))
+		case r.Column != "":
+			if _, err := path.Match(r.Column, ""); err != nil {
+				errs = append(errs, fmt.Errorf("%s: column %q: %w", rule, r.Column, err))
+			}
+		default:
+			if _, err := regexp.Compile(r.Pattern); err != nil {
+				errs = append(errs, fmt.Errorf("%s: pattern %q: %w", rule, r.Pattern, err))
+			}
+		}
+		if !slices.Contains(MaskingStrategies, r.Strategy) {
+			errs = append(errs, fmt.Errorf("%s: unknown strategy %q, expected one of %s", rule, r.Strategy, strings.Join(MaskingStrategies, ", ")))
+		}
+		if r.Days < 0 {
+			errs = append(errs, fmt.Errorf("%s: days cannot be negative", rule))
+		}
+	}
+	return errs
+}
+
+func (p RetentionPolicy) validate(path string) []error {
+	errs := make([]error, 0)
+	rules := []struct {
+		name  string
+		value int
+	}{{"keep_last", p.KeepLast}, {"keep_daily", p.KeepDaily}, {"keep_weekly", p.KeepWeekly}, {"keep_monthly", p.KeepMonthly}}
+	for _, r := range rules {
+		if r.value < 0 {
+			errs = append(errs, fmt.Errorf("%s: %s cannot be negative", path, r.name))
+		}
+	}
+	return errs
+}
+
+// Validate reports every problem found in a single stored connection.
+func (sc StoredConnection) Validate() []error {
+	errs := make([]error, 0)
+	if !isSupportedProvider(sc.Provider) {
+		errs = append(errs, fmt.Errorf("unsupported provider %q, expected one of %s", sc.Provider, strings.Join(SupportedProviders, ", ")))
+	}
+	if sc.Host == "" {
+		if sc.IsSQLite() {
+			errs = append(errs, fmt.Errorf("host must be the path of the sqlite database file"))
+		} else {
+			errs = append(errs, fmt.Errorf("host cannot be empty"))
+		}
+	}
+	if sc.Port < 0 || sc.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d is out of range", sc.Port))
+	}
+	return errs
+}
+
+func isSupportedProvider(provider string) bool {
+	return slices.Contains(SupportedProviders, provider)
+}
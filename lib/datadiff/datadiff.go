@@ -0,0 +1,298 @@
+// Package datadiff compares the rows of a table on two connections by primary
+// key. Both sides are hashed in key ranges of a fixed number of rows and only
+// the ranges whose checksums differ are compared row by row, so memory use is
+// bounded by the chunk size rather than the table size.
+package datadiff
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	dh "seraphim/lib/db/query"
+	"strings"
+)
+
+// Kinds of row differences. Missing rows exist only on the from side, extra
+// rows only on the to side.
+const (
+	Missing = "missing"
+	Extra   = "extra"
+	Changed = "changed"
+)
+
+type Options struct {
+	// ChunkSize is the number of rows hashed together before comparing
+	ChunkSize int
+	// Ignore lists columns left out of the comparison, as column or
+	// table.column
+	Ignore []string
+	// MaxRows caps the differences kept per table, 0 keeps them all
+	MaxRows int
+}
+
+// ignored reports whether column of table is in the ignore list.
+func (o Options) ignored(table string, column string) bool {
+	for _, entry := range o.Ignore {
+		t, c := "", entry
+		if i := strings.LastIndex(entry, "."); i >= 0 {
+			t, c = entry[:i], entry[i+1:]
+		}
+		if strings.EqualFold(c, column) && (t == "" || strings.EqualFold(t, table)) {
+			return true
+		}
+	}
+	return false
+}
+
+type RowDiff struct {
+	Kind string   `json:"kind"`
+	Key  []string `json:"key"`
+	// Columns lists the columns whose values differ for changed rows
+	Columns []string `json:"columns,omitempty"`
+}
+
+type TableResult struct {
+	Table    string   `json:"table"`
+	Key      []string `json:"key"`
+	Compared []string `json:"compared_columns"`
+	// Skipped lists the columns that exist on one side only
+	Skipped []string `json:"skipped_columns,omitempty"`
+
+	Rows             int64 `json:"rows"`
+	Chunks           int   `json:"chunks"`
+	MismatchedChunks int   `json:"mismatched_chunks"`
+	Missing          int64 `json:"missing"`
+	Extra            int64 `json:"extra"`
+	Changed          int64 `json:"changed"`
+
+	Differences []RowDiff `json:"differences,omitempty"`
+	// Truncated is set when more differences were found than MaxRows
+	Truncated bool `json:"truncated,omitempty"`
+	// Error is set by callers when the table could not be compared
+	Error string `json:"error,omitempty"`
+}
+
+// Equal reports whether no row differs.
+func (t TableResult) Equal() bool {
+	return t.Missing == 0 && t.Extra == 0 && t.Changed == 0
+}
+
+func (t *TableResult) record(d RowDiff, maxRows int) {
+	switch d.Kind {
+	case Missing:
+		t.Missing++
+	case Extra:
+		t.Extra++
+	case Changed:
+		t.Changed++
+	}
+	if maxRows > 0 && len(t.Differences) >= maxRows {
+		t.Truncated = true
+		return
+	}
+	t.Differences = append(t.Differences, d)
+}
+
+// chunk is a key range of the from side with the number and the checksum of
+// its rows. The checksum is the sum of the row hashes, so it does not depend
+// on the order in which a provider returns rows that collate equally.
+type chunk struct {
+	Range dh.KeyRange
+	Rows  int64
+	Sum   uint64
+}
+
+func (c *chunk) add(hash uint64) {
+	c.Rows++
+	c.Sum += hash
+}
+
+// digest is the textual form of a row: its key followed by the compared
+// columns.
+type digest struct {
+	Key   []string
+	Cells []string
+}
+
+// normalize renders a value so that equal values read from different
+// providers compare equal. Decimals drop trailing fractional zeros.
+func normalize(v any, kind dh.ValueKind) string {
+	text, ok := dh.FormatTypedValue(v, kind)
+	if !ok {
+		return "\x00NULL"
+	}
+	if kind == dh.KindDecimal && strings.Contains(text, ".") {
+		text = strings.TrimRight(strings.TrimRight(text, "0"), ".")
+	}
+	return text
+}
+
+func newDigest(infos []dh.ColumnInfo, values []any, keyColumns int) digest {
+	cells := make([]string, len(values))
+	for i, v := range values {
+		cells[i] = normalize(v, infos[i].Kind)
+	}
+	return digest{Key: cells[:keyColumns], Cells: cells[keyColumns:]}
+}
+
+func (d digest) id() string {
+	return strings.Join(d.Key, "\x00")
+}
+
+func (d digest) hash() uint64 {
+	h := fnv.New64a()
+	for _, s := range d.Key {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	h.Write([]byte{1})
+	for _, s := range d.Cells {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// columnPairs matches the columns of both sides by name, ignoring case, and
+// returns the names to select on each side along with the unmatched ones.
+func columnPairs(from *dh.TableScanner, to *dh.TableScanner, opts Options) ([]string, []string, []string) {
+	isKey := func(name string) bool {
+		for _, k := range from.Key {
+			if strings.EqualFold(k, name) {
+				return true
+			}
+		}
+		return false
+	}
+	toNames := make(map[string]string, len(to.Columns))
+	for _, c := range to.Columns {
+		toNames[strings.ToLower(c.Name)] = c.Name
+	}
+	fromColumns, toColumns, skipped := make([]string, 0), make([]string, 0), make([]string, 0)
+	for _, c := range from.Columns {
+		if isKey(c.Name) || opts.ignored(from.Table, c.Name) {
+			delete(toNames, strings.ToLower(c.Name))
+			continue
+		}
+		name, ok := toNames[strings.ToLower(c.Name)]
+		if !ok {
+			skipped = append(skipped, c.Name)
+			continue
+		}
+		delete(toNames, strings.ToLower(c.Name))
+		fromColumns = append(fromColumns, c.Name)
+		toColumns = append(toColumns, name)
+	}
+	for _, c := range to.Columns {
+		if _, ok := toNames[strings.ToLower(c.Name)]; ok && !opts.ignored(to.Table, c.Name) {
+			skipped = append(skipped, c.Name)
+		}
+	}
+	return fromColumns, toColumns, skipped
+}
+
+// CompareTable compares the rows of the table read by from with the rows of
+// the table read by to. Both must have the same primary key columns.
+func CompareTable(ctx context.Context, from *dh.TableScanner, to *dh.TableScanner, opts Options) (TableResult, error) {
+	if opts.ChunkSize <= 0 {
+		return TableResult{}, fmt.Errorf("invalid chunk size %d", opts.ChunkSize)
+	}
+	result := TableResult{Table: from.Table, Key: from.Key, Differences: make([]RowDiff, 0)}
+	if !strings.EqualFold(strings.Join(from.Key, ","), strings.Join(to.Key, ",")) {
+		return result, fmt.Errorf("the primary keys of %s differ: (%s) and (%s)",
+			from.Table, strings.Join(from.Key, ", "), strings.Join(to.Key, ", "))
+	}
+	fromColumns, toColumns, skipped := columnPairs(from, to, opts)
+	result.Compared, result.Skipped = fromColumns, skipped
+	keyColumns := len(from.Key)
+
+	// Cut the from side into chunks while hashing it in a single pass
+	chunks := make([]chunk, 0)
+	current := chunk{}
+	err := from.Scan(ctx, fromColumns, dh.KeyRange{}, func(infos []dh.ColumnInfo, values []any) error {
+		current.add(newDigest(infos, values, keyColumns).hash())
+		if current.Rows == int64(opts.ChunkSize) {
+			last := append([]any(nil), values[:keyColumns]...)
+			current.Range.To = last
+			chunks = append(chunks, current)
+			current = chunk{Range: dh.KeyRange{From: last}}
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	// The last chunk is open ended and catches the rows of to past the end of
+	// from
+	chunks = append(chunks, current)
+	for _, c := range chunks {
+		result.Rows += c.Rows
+	}
+	result.Chunks = len(chunks)
+
+	for _, c := range chunks {
+		theirs := chunk{}
+		err := to.Scan(ctx, toColumns, c.Range, func(infos []dh.ColumnInfo, values []any) error {
+			theirs.add(newDigest(infos, values, keyColumns).hash())
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+		if theirs.Rows == c.Rows && theirs.Sum == c.Sum {
+			continue
+		}
+		result.MismatchedChunks++
+		if err := compareChunk(ctx, from, to, fromColumns, toColumns, c.Range, opts, &result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// compareChunk compares the rows of a mismatching key range one by one. Only
+// the from side of the range is held in memory.
+func compareChunk(ctx context.Context, from *dh.TableScanner, to *dh.TableScanner, fromColumns []string, toColumns []string, r dh.KeyRange, opts Options, result *TableResult) error {
+	keyColumns := len(from.Key)
+	rows := make([]digest, 0, opts.ChunkSize)
+	positions := make(map[string]int, opts.ChunkSize)
+	err := from.Scan(ctx, fromColumns, r, func(infos []dh.ColumnInfo, values []any) error {
+		d := newDigest(infos, values, keyColumns)
+		positions[d.id()] = len(rows)
+		rows = append(rows, d)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	matched := make([]bool, len(rows))
+	err = to.Scan(ctx, toColumns, r, func(infos []dh.ColumnInfo, values []any) error {
+		d := newDigest(infos, values, keyColumns)
+		i, ok := positions[d.id()]
+		if !ok {
+			result.record(RowDiff{Kind: Extra, Key: d.Key}, opts.MaxRows)
+			return nil
+		}
+		matched[i] = true
+		differing := make([]string, 0)
+		for c, cell := range rows[i].Cells {
+			if cell != d.Cells[c] {
+				differing = append(differing, fromColumns[c])
+			}
+		}
+		if len(differing) > 0 {
+			result.record(RowDiff{Kind: Changed, Key: d.Key, Columns: differing}, opts.MaxRows)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, d := range rows {
+		if !matched[i] {
+			result.record(RowDiff{Kind: Missing, Key: d.Key}, opts.MaxRows)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,434 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"seraphim/lib/config"
+	dh "seraphim/lib/db/query"
+	"seraphim/lib/util"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+)
+
+const (
+	browseDatabasesPane = iota
+	browseTablesPane
+	browseDetailsPane
+)
+
+var (
+	browsePaneStyle = lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("240"))
+	browseFocusedPaneStyle = browsePaneStyle.Copy().
+				BorderForeground(lipgloss.Color("205"))
+	browseHeadingStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("230"))
+)
+
+type browseDatabasesMsg struct {
+	Names []string
+	Err   error
+}
+
+type browseTablesMsg struct {
+	Database string
+	Names    []string
+	Err      error
+}
+
+type browseDetailsMsg struct {
+	Key     string
+	Details dh.TableDetails
+	Err     error
+}
+
+// BrowseModel is a three pane schema browser: databases, tables of the
+// highlighted database and the structure of the highlighted table.
+type BrowseModel struct {
+	Tag          string
+	Connection   config.StoredConnection
+	DelegateKeys *delegateKeyMap
+
+	Databases list.Model
+	Tables    list.Model
+	Details   viewport.Model
+	Preview   *tablePreview
+
+	Focus       int
+	Database    string
+	DetailsKey  string
+	DetailCache map[string]dh.TableDetails
+	Loading     bool
+	Err         error
+	Width       int
+	Height      int
+}
+
+// RunBrowse opens the schema browser on the stored connection tagged tag.
+func RunBrowse(sconfig *config.SeraphimConfig, tag string) {
+	conn, ok := sconfig.Connection(tag)
+	if !ok {
+		fmt.Printf("No stored connection tagged %s\n", tag)
+		os.Exit(1)
+	}
+
+	delegateKeys := newDelegateKeyMap()
+	m := BrowseModel{
+		Tag:          tag,
+		Connection:   conn,
+		DelegateKeys: delegateKeys,
+		Databases:    newBrowseList(delegateKeys),
+		Tables:       newBrowseList(delegateKeys),
+		Details:      viewport.New(0, 0),
+		DetailCache:  make(map[string]dh.TableDetails),
+		Loading:      true,
+	}
+	if _, err := tea.NewProgram(m, tea.WithAltScreen()).Run(); err != nil {
+		fmt.Printf("FATAL -- Alas, there's been an error: %v", err)
+		os.Exit(1)
+	}
+}
+
+func newBrowseList(keys *delegateKeyMap) list.Model {
+	l := list.New(nil, newItemDelegate(keys), 0, 0)
+	l.SetShowTitle(false)
+	l.SetShowHelp(false)
+	l.SetShowFilter(true)
+	l.Styles.Title = titleStyle
+	return l
+}
+
+func (m BrowseModel) Init() tea.Cmd {
+	conn := m.Connection
+	return func() tea.Msg {
+		names, err := dh.ListDatabases(context.Background(), conn)
+		return browseDatabasesMsg{Names: names, Err: err}
+	}
+}
+
+func (m BrowseModel) loadTables(database string) tea.Cmd {
+	conn := m.Connection
+	return func() tea.Msg {
+		names, err := dh.ListTables(context.Background(), conn, database)
+		return browseTablesMsg{Database: database, Names: names, Err: err}
+	}
+}
+
+// syncDetails shows the structure of the highlighted table, loading it in the
+// background the first time it is highlighted.
+func (m *BrowseModel) syncDetails() tea.Cmd {
+	item, ok := m.Tables.SelectedItem().(util.TableListItem)
+	if !ok {
+		return nil
+	}
+	key := item.Db + "." + item.Name
+	if key == m.DetailsKey {
+		return nil
+	}
+	m.DetailsKey = key
+	m.Details.GotoTop()
+	if details, ok := m.DetailCache[key]; ok {
+		m.Details.SetContent(renderTableDetails(details))
+		return nil
+	}
+	m.Details.SetContent(blurredStyle.Render("Loading…"))
+	conn := m.Connection
+	return func() tea.Msg {
+		details, err := dh.DescribeTable(context.Background(), conn, item.Db, item.Name)
+		return browseDetailsMsg{Key: key, Details: details, Err: err}
+	}
+}
+
+func (m *BrowseModel) resize() {
+	// status and help lines below the panes, two rows of border
+	height := m.Height - 4
+	if height < 3 {
+		height = 3
+	}
+	dbWidth := m.Width / 5
+	tablesWidth := m.Width / 4
+	detailsWidth := m.Width - dbWidth - tablesWidth - 6
+	if detailsWidth < 10 {
+		detailsWidth = 10
+	}
+	m.Databases.SetSize(dbWidth, height)
+	m.Tables.SetSize(tablesWidth, height)
+	m.Details.Width = detailsWidth
+	m.Details.Height = height
+}
+
+// filtering reports whether the focused list is capturing keys for its filter.
+func (m BrowseModel) filtering() bool {
+	switch m.Focus {
+	case browseDatabasesPane:
+		return m.Databases.FilterState() == list.Filtering
+	case browseTablesPane:
+		return m.Tables.FilterState() == list.Filtering
+	}
+	return false
+}
+
+func (m BrowseModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.Width, m.Height = msg.Width, msg.Height
+		m.resize()
+		if m.Preview != nil {
+			m.Preview.SetSize(msg.Width, msg.Height)
+		}
+		return m, nil
+
+	case previewPageMsg:
+		if m.Preview != nil {
+			return m, m.Preview.Update(msg)
+		}
+		return m, nil
+
+	case browseDatabasesMsg:
+		m.Loading = false
+		if msg.Err != nil {
+			m.Err = msg.Err
+			return m, nil
+		}
+		items := make([]list.Item, len(msg.Names))
+		for i, name := range msg.Names {
+			items[i] = util.DbListItem{Name: name}
+		}
+		cmd := m.Databases.SetItems(items)
+		// Start on the default database, or on the only one of a sqlite file
+		for i, name := range msg.Names {
+			if name == m.Connection.DefaultDatabase || len(msg.Names) == 1 {
+				m.Databases.Select(i)
+				m.Loading = true
+				return m, tea.Batch(cmd, m.loadTables(name))
+			}
+		}
+		return m, cmd
+
+	case browseTablesMsg:
+		m.Loading = false
+		if msg.Err != nil {
+			m.Err = msg.Err
+			return m, nil
+		}
+		m.Err = nil
+		m.Database = msg.Database
+		items := make([]list.Item, len(msg.Names))
+		for i, name := range msg.Names {
+			items[i] = util.TableListItem{Name: name, Db: msg.Database}
+		}
+		m.Tables.ResetFilter()
+		m.Tables.Select(0)
+		cmd := m.Tables.SetItems(items)
+		m.DetailsKey = ""
+		m.Details.SetContent("")
+		detailsCmd := m.syncDetails()
+		return m, tea.Batch(cmd, detailsCmd)
+
+	case browseDetailsMsg:
+		if msg.Err != nil {
+			if msg.Key == m.DetailsKey {
+				m.Details.SetContent(consoleErrStyle.Render(msg.Err.Error()))
+			}
+			return m, nil
+		}
+		m.DetailCache[msg.Key] = msg.Details
+		if msg.Key == m.DetailsKey {
+			m.Details.SetContent(renderTableDetails(msg.Details))
+		}
+		return m, nil
+
+	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" {
+			return m, tea.Quit
+		}
+		if m.Preview != nil {
+			cmd := m.Preview.Update(msg)
+			if m.Preview.Done {
+				m.Preview = nil
+			}
+			return m, cmd
+		}
+		if msg.String() == "q" && !m.filtering() {
+			return m, tea.Quit
+		}
+		if !m.filtering() {
+			if key.Matches(msg, m.DelegateKeys.choose) {
+				return m.forward()
+			}
+			switch msg.String() {
+			case "p":
+				if m.Focus == browseDatabasesPane {
+					break
+				}
+				if item, ok := m.Tables.SelectedItem().(util.TableListItem); ok {
+					var cmd tea.Cmd
+					m.Preview, cmd = newTablePreview(m.Connection, item.Db, item.Name, m.Width, m.Height)
+					return m, cmd
+				}
+			case "tab":
+				return m.forward()
+			case "esc", "alt+backspace", "shift+tab":
+				if m.Focus > browseDatabasesPane {
+					m.Focus--
+					return m, nil
+				}
+			}
+		}
+	}
+
+	var cmd tea.Cmd
+	switch m.Focus {
+	case browseDatabasesPane:
+		m.Databases, cmd = m.Databases.Update(msg)
+	case browseTablesPane:
+		m.Tables, cmd = m.Tables.Update(msg)
+		detailsCmd := m.syncDetails()
+		return m, tea.Batch(cmd, detailsCmd)
+	case browseDetailsPane:
+		m.Details, cmd = m.Details.Update(msg)
+	}
+	return m, cmd
+}
+
+// forward moves the focus to the pane on the right, loading the tables of the
+// highlighted database when leaving the databases pane.
+func (m BrowseModel) forward() (tea.Model, tea.Cmd) {
+	switch m.Focus {
+	case browseDatabasesPane:
+		item, ok := m.Databases.SelectedItem().(util.DbListItem)
+		if !ok {
+			return m, nil
+		}
+		m.Focus = browseTablesPane
+		if item.Name != m.Database {
+			m.Loading = true
+			return m, m.loadTables(item.Name)
+		}
+	case browseTablesPane:
+		if m.Tables.SelectedItem() != nil {
+			m.Focus = browseDetailsPane
+		}
+	}
+	return m, nil
+}
+
+// renderTableDetails formats the structure of a table for the details pane.
+func renderTableDetails(d dh.TableDetails) string {
+	var b strings.Builder
+	b.WriteString(browseHeadingStyle.Render(d.Name))
+	stats := make([]string, 0, 2)
+	if d.RowCount >= 0 {
+		stats = append(stats, fmt.Sprintf("~%d rows", d.RowCount))
+	}
+	if d.Size >= 0 {
+		stats = append(stats, util.HumanSize(d.Size))
+	}
+	if len(stats) > 0 {
+		b.WriteString(blurredStyle.Render("  " + strings.Join(stats, " · ")))
+	}
+	b.WriteString("\n\n")
+
+	primary := make(map[string]bool, len(d.PrimaryKey))
+	for _, c := range d.PrimaryKey {
+		primary[c] = true
+	}
+	rows := [][]string{{"", "column", "type", "null", "default"}}
+	for _, c := range d.Columns {
+		key, null, def := "", "", ""
+		if primary[c.Name] {
+			key = "PK"
+		}
+		if c.Nullable {
+			null = "yes"
+		}
+		if c.Default.Valid {
+			def = c.Default.String
+		}
+		rows = append(rows, []string{key, c.Name, c.Type, null, def})
+	}
+	b.WriteString(browseHeadingStyle.Render("Columns") + "\n")
+	b.WriteString(alignColumns(rows))
+
+	if len(d.PrimaryKey) > 0 {
+		b.WriteString("\n" + browseHeadingStyle.Render("Primary key") + "\n")
+		b.WriteString("  (" + strings.Join(d.PrimaryKey, ", ") + ")\n")
+	}
+	if len(d.Indexes) > 0 {
+		b.WriteString("\n" + browseHeadingStyle.Render("Indexes") + "\n")
+		for _, idx := range d.Indexes {
+			unique := ""
+			if idx.Unique {
+				unique = blurredStyle.Render(" unique")
+			}
+			fmt.Fprintf(&b, "  %s (%s)%s\n", idx.Name, strings.Join(idx.Columns, ", "), unique)
+		}
+	}
+	if len(d.ForeignKeys) > 0 {
+		b.WriteString("\n" + browseHeadingStyle.Render("Foreign keys") + "\n")
+		for _, fk := range d.ForeignKeys {
+			fmt.Fprintf(&b, "  %s (%s) → %s(%s)\n", fk.Name, strings.Join(fk.Columns, ", "), fk.ReferencedTable, strings.Join(fk.ReferencedColumns, ", "))
+		}
+	}
+	return b.String()
+}
+
+// alignColumns lays rows out as left aligned columns, the first row being
+// rendered as a header.
+func alignColumns(rows [][]string) string {
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if w := runewidth.StringWidth(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+	var b strings.Builder
+	for r, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = runewidth.FillRight(cell, widths[i])
+		}
+		line := "  " + strings.TrimRight(strings.Join(cells, "  "), " ")
+		if r == 0 {
+			line = blurredStyle.Render(line)
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+func (m BrowseModel) View() string {
+	if m.Preview != nil {
+		return m.Preview.View()
+	}
+	panes := []string{m.Databases.View(), m.Tables.View(), m.Details.View()}
+	widths := []int{m.Databases.Width(), m.Tables.Width(), m.Details.Width}
+	for i, pane := range panes {
+		style := browsePaneStyle
+		if i == m.Focus {
+			style = browseFocusedPaneStyle
+		}
+		panes[i] = style.Copy().Width(widths[i]).Height(m.Details.Height).Render(pane)
+	}
+
+	status := fmt.Sprintf("%s ❯ %s", m.Tag, m.Database)
+	if m.Loading {
+		status += " • loading…"
+	}
+	if m.Err != nil {
+		status = consoleErrStyle.Render(m.Err.Error())
+	} else {
+		status = consoleStatusStyle.Render(status)
+	}
+	help := blurredStyle.Render(fmt.Sprintf("[%s/tab] open • [esc] back • [/] filter • [p] preview rows • [q] quit", m.DelegateKeys.choose.Help().Key))
+	return lipgloss.JoinHorizontal(lipgloss.Top, panes...) + "\n" + status + "\n" + help
+}
@@ -0,0 +1,473 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"seraphim/lib/config"
+	dh "seraphim/lib/db/query"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	consoleMaxRows     = 1000
+	consoleMaxHistory  = 500
+	consoleEditorLines = 5
+)
+
+var (
+	consoleStatusStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("244"))
+	consoleTxStyle     = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("#FFFDF5")).
+				Background(lipgloss.Color("214")).
+				Padding(0, 1)
+	consoleErrStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+
+	consoleMetaHelp = [][]string{
+		{`\l`, "list databases"},
+		{`\dt`, "list tables of the current database"},
+		{`\d <table>`, "describe the columns of a table"},
+		{`\c <database>`, "connect to another database"},
+		{`\begin`, "start a transaction"},
+		{`\commit`, "commit the current transaction"},
+		{`\rollback`, "roll back the current transaction"},
+		{`\?`, "show this help"},
+		{`\q`, "quit"},
+	}
+)
+
+// consoleResultMsg carries the result of a statement along with the state
+// of the session after it, read by the command that ran it so that the
+// model never reads the session while a statement runs.
+type consoleResultMsg struct {
+	Statement     string
+	Result        dh.StatementResult
+	Err           error
+	Database      string
+	InTransaction bool
+}
+
+type consoleSessionMsg struct {
+	Session *dh.Session
+	Err     error
+}
+
+type ConsoleModel struct {
+	Tag        string
+	Connection config.StoredConnection
+	Session    *dh.Session
+
+	// Database and InTransaction mirror the session between statements
+	Database      string
+	InTransaction bool
+
+	Editor  textarea.Model
+	Results resultGrid
+
+	History      []string
+	HistoryIndex int
+	Draft        string
+
+	FocusResults bool
+	Running      bool
+	// Cancel cancels the context of the running statement
+	Cancel context.CancelFunc
+	// Quitting leaves the console once the running statement returns, the
+	// session being closed only then
+	Quitting    bool
+	ConfirmQuit bool
+	Status      string
+	Err         error
+	Width       int
+	Height      int
+}
+
+// RunConsole opens an interactive SQL console on the stored connection tagged
+// tag, connected to database (or the connection default when empty).
+func RunConsole(sconfig *config.SeraphimConfig, tag string, database string) {
+	conn, ok := sconfig.Connection(tag)
+	if !ok {
+		fmt.Printf("No stored connection tagged %s\n", tag)
+		os.Exit(1)
+	}
+	if database == "" {
+		database = conn.DefaultDatabase
+	}
+
+	session, err := dh.NewSession(context.Background(), conn, database)
+	if err != nil {
+		fmt.Printf("Could not connect to %s: %v\n", tag, err)
+		os.Exit(1)
+	}
+
+	editor := textarea.New()
+	editor.Placeholder = `Type SQL terminated by ";" or a \ meta-command, \? for help`
+	editor.ShowLineNumbers = false
+	editor.SetHeight(consoleEditorLines)
+	editor.Focus()
+
+	m := ConsoleModel{
+		Tag:        tag,
+		Connection: conn,
+		Session:    session,
+		Database:   session.Database,
+		Editor:     editor,
+		Results:    newResultGrid(),
+		History:    loadConsoleHistory(tag),
+	}
+	m.HistoryIndex = len(m.History)
+
+	final, err := tea.NewProgram(m, tea.WithAltScreen()).Run()
+	if model, ok := final.(ConsoleModel); ok && model.Session != nil {
+		model.Session.Close()
+	}
+	if err != nil {
+		fmt.Printf("FATAL -- Alas, there's been an error: %v", err)
+		os.Exit(1)
+	}
+}
+
+func consoleHistoryFile(tag string) string {
+	return filepath.Join(config.ConfigDir(), "history", tag+".json")
+}
+
+func loadConsoleHistory(tag string) []string {
+	history := make([]string, 0)
+	if content, err := os.ReadFile(consoleHistoryFile(tag)); err == nil {
+		json.Unmarshal(content, &history)
+	}
+	return history
+}
+
+func saveConsoleHistory(tag string, history []string) {
+	file := consoleHistoryFile(tag)
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return
+	}
+	if content, err := json.Marshal(history); err == nil {
+		os.WriteFile(file, content, 0o600)
+	}
+}
+
+func (m ConsoleModel) Init() tea.Cmd {
+	return tea.Batch(textarea.Blink, tea.EnterAltScreen)
+}
+
+func (m *ConsoleModel) pushHistory(statement string) {
+	if n := len(m.History); n == 0 || m.History[n-1] != statement {
+		m.History = append(m.History, statement)
+		if len(m.History) > consoleMaxHistory {
+			m.History = m.History[len(m.History)-consoleMaxHistory:]
+		}
+		saveConsoleHistory(m.Tag, m.History)
+	}
+	m.HistoryIndex = len(m.History)
+	m.Draft = ""
+}
+
+func (m *ConsoleModel) resize() {
+	m.Editor.SetWidth(m.Width)
+	// status line, help line and the blank lines around the editor
+	gridHeight := m.Height - consoleEditorLines - 5
+	if gridHeight < 3 {
+		gridHeight = 3
+	}
+	m.Results.SetSize(m.Width, gridHeight)
+}
+
+func (m ConsoleModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.Width, m.Height = msg.Width, msg.Height
+		m.resize()
+		return m, nil
+
+	case consoleResultMsg:
+		m.finish()
+		if m.Quitting {
+			return m, tea.Quit
+		}
+		m.Database, m.InTransaction = msg.Database, msg.InTransaction
+		if msg.Err != nil {
+			m.Err = msg.Err
+			m.Status = ""
+			return m, nil
+		}
+		m.Err = nil
+		m.Status = fmt.Sprintf("%s (%s)", msg.Result.Message, msg.Result.Duration.Round(time.Millisecond))
+		if msg.Result.Columns != nil {
+			m.Results.SetData(msg.Result.Columns, msg.Result.Rows)
+		}
+		return m, nil
+
+	case consoleSessionMsg:
+		m.finish()
+		if m.Quitting {
+			if msg.Session != nil {
+				msg.Session.Close()
+			}
+			return m, tea.Quit
+		}
+		if msg.Err != nil {
+			m.Err = msg.Err
+			return m, nil
+		}
+		m.Session.Close()
+		m.Session = msg.Session
+		m.Database, m.InTransaction = msg.Session.Database, false
+		m.Err = nil
+		m.Status = fmt.Sprintf("Connected to %s", m.Database)
+		return m, nil
+
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c":
+			if m.Running && m.Cancel != nil {
+				m.Cancel()
+				m.Cancel = nil
+				m.Status = "Cancelling…"
+				return m, nil
+			}
+			return m.quit()
+		case "tab":
+			m.FocusResults = !m.FocusResults
+			if m.FocusResults {
+				m.Editor.Blur()
+				m.Results.Focus()
+				return m, nil
+			}
+			m.Results.Blur()
+			return m, m.Editor.Focus()
+		}
+		m.ConfirmQuit = false
+
+		if m.FocusResults {
+			if msg.String() == "esc" {
+				m.FocusResults = false
+				m.Results.Blur()
+				return m, m.Editor.Focus()
+			}
+			var cmd tea.Cmd
+			m.Results, cmd = m.Results.Update(msg)
+			return m, cmd
+		}
+
+		switch msg.String() {
+		case "enter", "alt+enter":
+			input := strings.TrimSpace(m.Editor.Value())
+			if input == "" {
+				return m, nil
+			}
+			if msg.String() == "alt+enter" || strings.HasPrefix(input, `\`) || strings.HasSuffix(input, ";") {
+				if m.Running {
+					return m, nil
+				}
+				m.pushHistory(input)
+				m.Editor.Reset()
+				return m.run(input)
+			}
+		case "up":
+			if m.Editor.Line() == 0 && m.HistoryIndex > 0 {
+				if m.HistoryIndex == len(m.History) {
+					m.Draft = m.Editor.Value()
+				}
+				m.HistoryIndex--
+				m.Editor.SetValue(m.History[m.HistoryIndex])
+				return m, nil
+			}
+		case "down":
+			if m.Editor.Line() == m.Editor.LineCount()-1 && m.HistoryIndex < len(m.History) {
+				m.HistoryIndex++
+				if m.HistoryIndex == len(m.History) {
+					m.Editor.SetValue(m.Draft)
+				} else {
+					m.Editor.SetValue(m.History[m.HistoryIndex])
+				}
+				return m, nil
+			}
+		}
+	}
+
+	var cmd tea.Cmd
+	m.Editor, cmd = m.Editor.Update(msg)
+	return m, cmd
+}
+
+// quit leaves the console, asking for a second confirmation when a
+// transaction would be rolled back.
+func (m ConsoleModel) quit() (tea.Model, tea.Cmd) {
+	if m.InTransaction && !m.ConfirmQuit {
+		m.ConfirmQuit = true
+		m.Status = consoleErrStyle.Render("A transaction is in progress, quit again to roll it back and leave")
+		return m, nil
+	}
+	if m.Running {
+		if m.Cancel != nil {
+			m.Cancel()
+			m.Cancel = nil
+		}
+		m.Quitting = true
+		m.Status = "Waiting for the running statement to stop…"
+		return m, nil
+	}
+	return m, tea.Quit
+}
+
+// finish marks the running statement as done, releasing its context.
+func (m *ConsoleModel) finish() {
+	m.Running = false
+	if m.Cancel != nil {
+		m.Cancel()
+		m.Cancel = nil
+	}
+}
+
+// start marks a statement as running and returns the context it runs in,
+// cancelled by ctrl+c or when leaving the console.
+func (m *ConsoleModel) start() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.Running = true
+	m.Cancel = cancel
+	return ctx
+}
+
+// run executes a statement or a meta-command in the background.
+func (m ConsoleModel) run(input string) (tea.Model, tea.Cmd) {
+	m.Err = nil
+	if !strings.HasPrefix(input, `\`) {
+		ctx := m.start()
+		m.Status = "Running…"
+		session := m.Session
+		return m, func() tea.Msg {
+			r, err := session.Execute(ctx, input, consoleMaxRows)
+			return sessionResult(session, input, r, err)
+		}
+	}
+
+	fields := strings.Fields(strings.TrimSuffix(input, ";"))
+	command, args := fields[0], fields[1:]
+	session := m.Session
+	database := m.Database
+	conn := m.Connection
+	switch command {
+	case `\q`:
+		return m.quit()
+	case `\?`:
+		m.Results.SetData([]string{"Command", "Description"}, consoleMetaHelp)
+		m.Status = "Statements run in the current transaction, if any"
+		return m, nil
+	case `\begin`, `\commit`, `\rollback`:
+		statement := map[string]string{`\begin`: "BEGIN", `\commit`: "COMMIT", `\rollback`: "ROLLBACK"}[command]
+		ctx := m.start()
+		return m, func() tea.Msg {
+			r, err := session.Execute(ctx, statement, 0)
+			return sessionResult(session, input, r, err)
+		}
+	case `\l`:
+		ctx := m.start()
+		return m, func() tea.Msg {
+			dbs, err := dh.ListDatabases(ctx, conn)
+			return sessionResult(session, input, singleColumnResult("Database", dbs), err)
+		}
+	case `\dt`:
+		ctx := m.start()
+		return m, func() tea.Msg {
+			tables, err := dh.ListTables(ctx, conn, database)
+			return sessionResult(session, input, singleColumnResult("Table", tables), err)
+		}
+	case `\d`:
+		if len(args) != 1 {
+			m.Err = fmt.Errorf(`usage: \d <table>`)
+			return m, nil
+		}
+		ctx := m.start()
+		return m, func() tea.Msg {
+			columns, err := dh.ListColumns(ctx, conn, database, args[0])
+			r := dh.StatementResult{Columns: []string{"Column", "Type", "Nullable", "Default"}}
+			for _, c := range columns {
+				def := dh.NullDisplay
+				if c.Default.Valid {
+					def = c.Default.String
+				}
+				r.Rows = append(r.Rows, []string{c.Name, c.Type, fmt.Sprint(c.Nullable), def})
+			}
+			r.Message = fmt.Sprintf("%d column(s)", len(r.Rows))
+			return sessionResult(session, input, r, err)
+		}
+	case `\c`:
+		if len(args) != 1 {
+			m.Err = fmt.Errorf(`usage: \c <database>`)
+			return m, nil
+		}
+		if m.InTransaction {
+			m.Err = fmt.Errorf("commit or roll back the current transaction before switching database")
+			return m, nil
+		}
+		ctx := m.start()
+		return m, func() tea.Msg {
+			s, err := dh.NewSession(ctx, conn, args[0])
+			return consoleSessionMsg{Session: s, Err: err}
+		}
+	}
+	m.Err = fmt.Errorf(`unknown meta-command %s, \? lists the available ones`, command)
+	return m, nil
+}
+
+// sessionResult reports the result of input along with the state of
+// session, called by the command running input.
+func sessionResult(session *dh.Session, input string, r dh.StatementResult, err error) consoleResultMsg {
+	return consoleResultMsg{Statement: input, Result: r, Err: err, Database: session.Database, InTransaction: session.InTransaction()}
+}
+
+func singleColumnResult(title string, values []string) dh.StatementResult {
+	r := dh.StatementResult{Columns: []string{title}}
+	for _, v := range values {
+		r.Rows = append(r.Rows, []string{v})
+	}
+	r.Message = fmt.Sprintf("%d row(s)", len(values))
+	return r
+}
+
+func (m ConsoleModel) View() string {
+	var b strings.Builder
+
+	b.WriteString(m.Results.View())
+	b.WriteString("\n\n")
+	b.WriteString(m.Editor.View())
+	b.WriteString("\n")
+
+	status := []string{titleStyle.Render(m.Tag)}
+	if m.Session != nil {
+		db := m.Database
+		if db == "" {
+			db = "(no database)"
+		}
+		status = append(status, consoleStatusStyle.Render(db))
+		if m.InTransaction {
+			status = append(status, consoleTxStyle.Render("IN TRANSACTION"))
+		}
+	}
+	if m.Err != nil {
+		status = append(status, consoleErrStyle.Render(m.Err.Error()))
+	} else if m.Status != "" {
+		status = append(status, consoleStatusStyle.Render(m.Status))
+	}
+	b.WriteString(strings.Join(status, " "))
+	b.WriteString("\n")
+
+	if m.FocusResults {
+		b.WriteString(blurredStyle.Render("↑/↓ rows • ←/→ columns • tab/esc back to the editor • ctrl+c quit"))
+	} else if m.Running {
+		b.WriteString(blurredStyle.Render("ctrl+c cancel the running statement"))
+	} else {
+		b.WriteString(blurredStyle.Render(`enter runs statements ending with ";" • alt+enter run now • ↑/↓ history • tab results • \? help • ctrl+c quit`))
+	}
+	return b.String()
+}
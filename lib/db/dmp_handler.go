@@ -6,8 +6,13 @@ import (
 	"os"
 	"seraphim/lib/config"
 	dh "seraphim/lib/db/query"
+	"seraphim/lib/dump"
+	"seraphim/lib/export"
 	"seraphim/lib/util"
+	"sort"
+	"strings"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
@@ -16,10 +21,11 @@ import (
 
 /**
 * Flow
-* --> dumpCmd --> RunDumpCommand --> RunDbSelector --> RunMultiSelectList(Tables) --> CreateDump
+* --> dumpCmd --> RunDumpCommand --> RunDbSelector --> RunMultiSelectList(Tables) --> RunDump
 * List Selector for connections
 * List Selector for DB
 * List MultiSelector for Table
+* Toggles for the objects dumped
 * Input for path
 * Banner to show result
  */
@@ -30,8 +36,15 @@ type DbDumpModel struct {
 	TablesList            list.Model
 	DelegateKeys          *delegateKeyMap
 	DumpPathInput         textinput.Model
+	Preview               *tablePreview
 	Err                   error
+	// FilterInput edits the where condition or the sample of the highlighted
+	// table, EditingFilter telling which while it is typed
+	FilterInput   textinput.Model
+	EditingFilter string
+	FilterErr     error
 
+	SelectedConnectionTag     string
 	SelectedConnectionDetails config.StoredConnection
 	SelectedDatabases         []util.DbListItem
 	SelectedTables            []util.TableListItem
@@ -40,8 +53,19 @@ type DbDumpModel struct {
 	ChoosingDatabases         bool
 	ChoosingTables            bool
 	TypingPath                bool
-	Success                   bool
-	Done                      bool
+	// SelectOnly ends the flow once tables are chosen, for commands that only
+	// need the selection
+	SelectOnly bool
+	// Filtering lets the rows of the tables be filtered and sampled
+	Filtering bool
+	// ChoosingObjects shows, between the tables and the path, the screen
+	// choosing the Objects dumped when ObjectsStep is set
+	ChoosingObjects bool
+	ObjectsStep     bool
+	Objects         dh.DumpObjects
+	ObjectsCursor   int
+	Success         bool
+	Done            bool
 }
 
 func (dbm DbDumpModel) Init() tea.Cmd {
@@ -70,7 +94,7 @@ var (
 
 	allTablesSelected = make(map[string]bool, 0)
 	anyDbSelected     bool
-	anyTableSelected   bool
+	anyTableSelected  bool
 )
 
 func (dbm DbDumpModel) updateConnChoosingView(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -89,6 +113,7 @@ func (dbm DbDumpModel) updateConnChoosingView(msg tea.Msg) (tea.Model, tea.Cmd)
 			for _, conn := range seraphimConfig.Stored_Connections {
 				t := conn[selectedItem.Tag]
 				if t != (config.StoredConnection{}) {
+					dbm.SelectedConnectionTag = selectedItem.Tag
 					dbm.SelectedConnectionDetails = t
 					break
 				}
@@ -174,26 +199,12 @@ func (dbm DbDumpModel) updateDbChoosingView(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 				dbm.SelectedDatabases = selectedDbs
 
-				tableListItems := make([]list.Item, 0)
-				for _, db := range dbm.SelectedDatabases {
-					dbTables := dh.FetchTablesForDb(db.Name, dbm.SelectedConnectionDetails)
-					tableListItems = append(tableListItems, util.TableListItem{
-						Name: "All",
-						Db:   db.Name,
-					})
-					for _, table := range dbTables {
-						tableListItems = append(tableListItems, util.TableListItem{
-							Name: table,
-							Db:   db.Name,
-						})
+				dbm.TablesList = newTablesList(dbm.SelectedConnectionDetails, dbm.SelectedDatabases, true)
+				if dbm.Filtering {
+					dbm.TablesList.AdditionalShortHelpKeys = func() []key.Binding {
+						return []key.Binding{previewKey, whereKey, sampleKey}
 					}
 				}
-
-				TablesList := list.New(tableListItems, listDelegate, 0, 0)
-				TablesList.SetShowFilter(true)
-				TablesList.SetShowTitle(false)
-				TablesList.Styles.Title = titleStyle
-				dbm.TablesList = TablesList
 				dbm.ChoosingTables = true
 				return dbm, func() tea.Msg {
 					return tea.WindowSizeMsg{
@@ -209,7 +220,54 @@ func (dbm DbDumpModel) updateDbChoosingView(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return dbm, cmd
 }
 
+// newTablesList lists the tables of every selected database, along with
+// their views when asked, each preceded by an "All" item selecting the whole
+// database.
+func newTablesList(conn config.StoredConnection, dbs []util.DbListItem, views bool) list.Model {
+	tableListItems := make([]list.Item, 0)
+	for _, db := range dbs {
+		dbTables := dh.FetchTablesForDb(db.Name, conn)
+		if views {
+			dbTables = append(dbTables, dh.FetchViewsForDb(db.Name, conn)...)
+			sort.Strings(dbTables)
+		}
+		tableListItems = append(tableListItems, util.TableListItem{
+			Name: "All",
+			Db:   db.Name,
+		})
+		for _, table := range dbTables {
+			tableListItems = append(tableListItems, util.TableListItem{
+				Name: table,
+				Db:   db.Name,
+			})
+		}
+	}
+
+	TablesList := list.New(tableListItems, listDelegate, 0, 0)
+	TablesList.SetShowFilter(true)
+	TablesList.SetShowTitle(false)
+	TablesList.Styles.Title = titleStyle
+	TablesList.AdditionalShortHelpKeys = func() []key.Binding {
+		return []key.Binding{previewKey}
+	}
+	return TablesList
+}
+
+var (
+	whereKey = key.NewBinding(
+		key.WithKeys("w"),
+		key.WithHelp("w", "filter rows"),
+	)
+	sampleKey = key.NewBinding(
+		key.WithKeys("s"),
+		key.WithHelp("s", "sample rows"),
+	)
+)
+
 func (dbm DbDumpModel) updateTableChoosingView(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if dbm.EditingFilter != "" {
+		return dbm.updateFilterInput(msg)
+	}
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		dbm.TablesList.SetSize(msg.Width, msg.Height)
@@ -217,6 +275,32 @@ func (dbm DbDumpModel) updateTableChoosingView(msg tea.Msg) (tea.Model, tea.Cmd)
 		switch msg.String() {
 		case "ctrl+c", "q":
 			return dbm, tea.Quit
+		case "p":
+			if dbm.TablesList.FilterState() == list.Filtering {
+				break
+			}
+			if item, ok := dbm.TablesList.SelectedItem().(util.TableListItem); ok && item.Name != "All" {
+				var cmd tea.Cmd
+				dbm.Preview, cmd = newTablePreview(dbm.SelectedConnectionDetails, item.Db, item.Name, dbm.TablesList.Width(), dbm.TablesList.Height())
+				return dbm, cmd
+			}
+		case "w", "s":
+			if !dbm.Filtering || dbm.TablesList.FilterState() == list.Filtering {
+				break
+			}
+			if item, ok := dbm.TablesList.SelectedItem().(util.TableListItem); ok && item.Name != "All" {
+				dbm.EditingFilter = "where"
+				dbm.FilterInput.SetValue(item.Where)
+				dbm.FilterInput.Placeholder = "created_at > '2024-01-01'"
+				if msg.String() == "s" {
+					dbm.EditingFilter = "sample"
+					dbm.FilterInput.SetValue(item.Sample)
+					dbm.FilterInput.Placeholder = "1% or 500"
+				}
+				dbm.FilterInput.CursorEnd()
+				dbm.FilterErr = nil
+				return dbm, dbm.FilterInput.Focus()
+			}
 		case " ":
 			selectedItem := dbm.TablesList.SelectedItem().(util.TableListItem)
 			availableItems := dbm.TablesList.Items()
@@ -257,6 +341,9 @@ func (dbm DbDumpModel) updateTableChoosingView(msg tea.Msg) (tea.Model, tea.Cmd)
 			}
 			anyTableSelected = aTs
 		case "alt+backspace", "esc":
+			if dbm.SelectOnly {
+				return dbm, tea.Quit
+			}
 			dbm.ChoosingDatabases = true
 			dbm.SelectedTables = make([]util.TableListItem, 0)
 			anyTableSelected = false
@@ -273,6 +360,14 @@ func (dbm DbDumpModel) updateTableChoosingView(msg tea.Msg) (tea.Model, tea.Cmd)
 						dbm.SelectedTables = append(dbm.SelectedTables, casted)
 					}
 				}
+				if dbm.SelectOnly {
+					dbm.Done = true
+					return dbm, tea.Quit
+				}
+				if dbm.ObjectsStep {
+					dbm.ChoosingObjects = true
+					return dbm, nil
+				}
 				dbm.TypingPath = true
 			}
 			return dbm, nil
@@ -283,6 +378,122 @@ func (dbm DbDumpModel) updateTableChoosingView(msg tea.Msg) (tea.Model, tea.Cmd)
 	return dbm, cmd
 }
 
+// updateFilterInput edits the where condition or the sample of the
+// highlighted table, an empty value removing it.
+func (dbm DbDumpModel) updateFilterInput(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "ctrl+c":
+			return dbm, tea.Quit
+		case "esc":
+			dbm.EditingFilter = ""
+			return dbm, nil
+		case "enter":
+			value := strings.TrimSpace(dbm.FilterInput.Value())
+			if dbm.EditingFilter == "sample" && value != "" {
+				if _, err := dump.ParseSample(value); err != nil {
+					dbm.FilterErr = err
+					return dbm, nil
+				}
+			}
+			highlighted := dbm.TablesList.SelectedItem().(util.TableListItem)
+			items := dbm.TablesList.Items()
+			for i, item := range items {
+				casted := item.(util.TableListItem)
+				if casted.Db == highlighted.Db && casted.Name == highlighted.Name {
+					if dbm.EditingFilter == "where" {
+						casted.Where = value
+					} else {
+						casted.Sample = value
+					}
+					items[i] = casted
+				}
+			}
+			dbm.TablesList.SetItems(items)
+			dbm.EditingFilter = ""
+			return dbm, nil
+		}
+	}
+	var cmd tea.Cmd
+	dbm.FilterInput, cmd = dbm.FilterInput.Update(msg)
+	return dbm, cmd
+}
+
+// contentLabels describe the parts of the tables a dump holds, indexed by
+// objectsContent.
+var contentLabels = []string{"schema and data", "schema only", "data only"}
+
+func objectsContent(o dh.DumpObjects) int {
+	switch {
+	case o.SchemaOnly:
+		return 1
+	case o.DataOnly:
+		return 2
+	}
+	return 0
+}
+
+// updateObjectsView chooses what the dump holds: the first row the parts
+// of the tables, the others each type of objects.
+func (dbm DbDumpModel) updateObjectsView(msg tea.Msg) (tea.Model, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return dbm, nil
+	}
+	types := dbm.Objects.Types()
+	switch keyMsg.String() {
+	case "ctrl+c", "q":
+		return dbm, tea.Quit
+	case "up", "k":
+		dbm.ObjectsCursor = max(dbm.ObjectsCursor-1, 0)
+	case "down", "j":
+		dbm.ObjectsCursor = min(dbm.ObjectsCursor+1, len(types))
+	case " ", "right", "l", "left", "h":
+		steps := 1
+		if keyMsg.String() == "left" || keyMsg.String() == "h" {
+			steps = 2
+		}
+		if dbm.ObjectsCursor == 0 {
+			content := (objectsContent(dbm.Objects) + steps) % len(contentLabels)
+			dbm.Objects.SchemaOnly = content == 1
+			dbm.Objects.DataOnly = content == 2
+			break
+		}
+		include := types[dbm.ObjectsCursor-1].Include
+		for i := 0; i < steps; i++ {
+			*include = include.Next()
+		}
+	case "alt+backspace", "esc":
+		dbm.ChoosingObjects = false
+		dbm.ChoosingTables = true
+		dbm.SelectedTables = make([]util.TableListItem, 0)
+		return dbm, tea.ClearScreen
+	case "enter":
+		dbm.ChoosingObjects = false
+		dbm.TypingPath = true
+	}
+	return dbm, nil
+}
+
+func (dbm DbDumpModel) objectsView() string {
+	var b strings.Builder
+	b.WriteString(pathInputTitleStyle.Render("Choose what the dump holds:") + "\n\n")
+	row := func(i int, label string, value string) {
+		line := fmt.Sprintf("  %-10s %s", label, value)
+		if i == dbm.ObjectsCursor {
+			line = focusedStyle.Render(fmt.Sprintf("\u276F %-10s %s", label, value))
+		}
+		b.WriteString(line + "\n")
+	}
+	row(0, "Tables", contentLabels[objectsContent(dbm.Objects)])
+	for i, t := range dbm.Objects.Types() {
+		row(i+1, strings.ToUpper(t.Name[:1])+t.Name[1:], t.Include.String())
+	}
+	b.WriteString("\n" + blurredStyle.Render("default leaves the choice to mysqldump or pg_dump, dialect dumps hold tables only"))
+	b.WriteString("\n\n" + blurredStyle.Render("[↑/↓] move • [space/←/→] change • [enter] continue • [ALT+Backspace] go back • [CTRL+C] quit"))
+	return b.String()
+}
+
 func (dbm DbDumpModel) updatePathInputView(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
@@ -293,9 +504,13 @@ func (dbm DbDumpModel) updatePathInputView(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "ctrl+c":
 			return dbm, tea.Quit
 		case "alt+backspace":
-			dbm.ChoosingTables = true
 			dbm.DumpPathInput.SetValue("")
 			dbm.TypingPath = false
+			if dbm.ObjectsStep {
+				dbm.ChoosingObjects = true
+				return dbm, tea.ClearScreen
+			}
+			dbm.ChoosingTables = true
 			var cmd tea.Cmd
 			dbm.TablesList, cmd = dbm.TablesList.Update(msg)
 			return dbm, tea.Batch(tea.ClearScreen, cmd)
@@ -316,8 +531,31 @@ func (dbm DbDumpModel) updatePathInputView(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return dbm, cmd
 }
 
+func (dbm DbDumpModel) updatePreview(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		dbm.TablesList.SetSize(msg.Width, msg.Height)
+		dbm.Preview.SetSize(msg.Width, msg.Height)
+		return dbm, nil
+	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" {
+			return dbm, tea.Quit
+		}
+	}
+	cmd := dbm.Preview.Update(msg)
+	if dbm.Preview.Done {
+		dbm.Preview = nil
+		return dbm, tea.Batch(tea.ClearScreen, cmd)
+	}
+	return dbm, cmd
+}
+
 func (dbm DbDumpModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
+	if dbm.Preview != nil {
+		return dbm.updatePreview(msg)
+	}
+
 	if dbm.ChoosingConnection {
 		return dbm.updateConnChoosingView(msg)
 	}
@@ -327,6 +565,9 @@ func (dbm DbDumpModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	if dbm.ChoosingTables {
 		return dbm.updateTableChoosingView(msg)
 	}
+	if dbm.ChoosingObjects {
+		return dbm.updateObjectsView(msg)
+	}
 
 	if dbm.TypingPath {
 		return dbm.updatePathInputView(msg)
@@ -350,6 +591,25 @@ func (dbm DbDumpModel) View() string {
 
 	if dbm.ChoosingTables {
 		s = fmt.Sprintf("Select a one or more tables: \n%s", dbm.TablesList.View())
+		if dbm.EditingFilter != "" {
+			item := dbm.TablesList.SelectedItem().(util.TableListItem)
+			title := fmt.Sprintf("Rows of %s.%s matching:", item.Db, item.Name)
+			if dbm.EditingFilter == "sample" {
+				title = fmt.Sprintf("Sample of %s.%s, a percentage or a number of rows:", item.Db, item.Name)
+			}
+			help := blurredStyle.Render("[enter] apply, empty to remove • [esc] cancel")
+			if dbm.FilterErr != nil {
+				help = progressFailedStyle.Render(dbm.FilterErr.Error())
+			}
+			s += "\n\n" + pathInputTitleStyle.Render(title) + "\n" + dbm.FilterInput.View() + "\n" + help
+		}
+		if dbm.Preview != nil {
+			s = dbm.Preview.View()
+		}
+	}
+
+	if dbm.ChoosingObjects {
+		s = dbm.objectsView()
 	}
 
 	if dbm.TypingPath {
@@ -363,7 +623,11 @@ func (dbm DbDumpModel) View() string {
 	return s
 }
 
-func RunDumpCommand(sconfig *config.SeraphimConfig) {
+// runDumpSelector picks a connection, databases, tables and a path
+// interactively. ok is false when the user left before choosing them all.
+// With filtering, the rows of the tables can be filtered and sampled. With
+// objects, what the dump holds is chosen too, starting from objects.
+func runDumpSelector(sconfig *config.SeraphimConfig, filtering bool, objects *dh.DumpObjects) (DbDumpModel, bool) {
 	seraphimConfig = *sconfig
 
 	input := textinput.New()
@@ -394,10 +658,21 @@ func RunDumpCommand(sconfig *config.SeraphimConfig) {
 	StoredConnectionList.SetShowTitle(false)
 	StoredConnectionList.Styles.Title = titleStyle
 
+	filterInput := textinput.New()
+	filterInput.Cursor.Style = cursorStyle
+	filterInput.TextStyle = focusedStyle
+	filterInput.Prompt = focusedStyle.Render("\u276F ")
+
 	initialModel := DbDumpModel{
 		StoredConnectionsList: StoredConnectionList,
 		ChoosingConnection:    true,
 		DumpPathInput:         input,
+		FilterInput:           filterInput,
+		Filtering:             filtering,
+	}
+	if objects != nil {
+		initialModel.ObjectsStep = true
+		initialModel.Objects = *objects
 	}
 
 	p := tea.NewProgram(initialModel, tea.WithAltScreen())
@@ -407,17 +682,113 @@ func RunDumpCommand(sconfig *config.SeraphimConfig) {
 		os.Exit(1)
 	}
 
-	if model, ok := dbm.(DbDumpModel); ok {
-		if model.SelectedConnectionDetails != (config.StoredConnection{}) && model.InputDumpPathValue != "" && len(model.SelectedDatabases) != 0 && len(model.SelectedTables) != 0 {
-			success := dh.CreateDump(model.SelectedConnectionDetails, model.InputDumpPathValue, model.SelectedDatabases, model.SelectedTables)
-			if success {
-				fmt.Println(focusedStyle.Render("---> Dump created successfully!"))
-			} else {
-				fmt.Println(focusedStyle.Render("---> Dump was not created!"))
-			}
+	model, ok := dbm.(DbDumpModel)
+	if !ok {
+		log.Fatal("something went wrong")
+	}
+	return model, model.SelectedConnectionDetails != (config.StoredConnection{}) && model.InputDumpPathValue != "" && len(model.SelectedDatabases) != 0 && len(model.SelectedTables) != 0
+}
+
+// RunDumpCommand picks a connection, databases, tables and a path
+// interactively and dumps them as opts tells. The filters typed for the
+// tables of a dialect dump add to those of opts, and the objects chosen
+// replace those of opts.
+func RunDumpCommand(sconfig *config.SeraphimConfig, opts dump.Options) {
+	model, ok := runDumpSelector(sconfig, opts.Dialect != "", &opts.Objects)
+	if !ok {
+		return
+	}
+	opts.Objects = model.Objects
+	filters, err := tableFilters(model.TablesList.Items())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(filters) > 0 {
+		merged := make(map[string]dump.Filter, len(opts.Filters)+len(filters))
+		for table, f := range opts.Filters {
+			merged[table] = f
 		}
+		for table, f := range filters {
+			merged[table] = f
+		}
+		opts.Filters = merged
+	}
+	if RunDump(model.SelectedConnectionTag, model.SelectedConnectionDetails, model.InputDumpPathValue, model.SelectedDatabases, model.SelectedTables, opts) {
+		fmt.Println(focusedStyle.Render("---> Dump created successfully!"))
 	} else {
-		log.Fatal("something went wrong")
+		fmt.Println(focusedStyle.Render("---> Dump was not created!"))
+	}
+}
+
+// RunExportCommand picks a connection, databases, tables and a directory
+// interactively and exports the rows of the tables, one file per table.
+func RunExportCommand(sconfig *config.SeraphimConfig, opts export.Options) {
+	model, ok := runDumpSelector(sconfig, false, nil)
+	if !ok {
+		return
+	}
+	if export.CreateExport(model.SelectedConnectionDetails, model.InputDumpPathValue, model.SelectedDatabases, model.SelectedTables, opts) {
+		fmt.Println(focusedStyle.Render("---> Export created successfully!"))
+	} else {
+		fmt.Println(focusedStyle.Render("---> Export was not created!"))
 	}
+}
+
+// tableFilters returns the filters typed for the dumped tables among items.
+func tableFilters(items []list.Item) (map[string]dump.Filter, error) {
+	filters := make(map[string]dump.Filter)
+	for _, item := range items {
+		t, ok := item.(util.TableListItem)
+		if !ok || (t.Where == "" && t.Sample == "") || !(t.Selected || allTablesSelected[t.Db]) {
+			continue
+		}
+		f := dump.Filter{Where: t.Where}
+		if t.Sample != "" {
+			sample, err := dump.ParseSample(t.Sample)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", t.Db, t.Name, err)
+			}
+			f.Sample = sample
+		}
+		filters[t.Db+"."+t.Name] = f
+	}
+	return filters, nil
+}
 
+// RunTableSelector lets the user pick tables of database on conn with the
+// table multi-select of the dump flow. Picking "All" selects every table. It
+// returns nil when the user leaves without choosing.
+func RunTableSelector(conn config.StoredConnection, database string) []string {
+	listDelegate = newItemDelegate(newDelegateKeyMap())
+	allTablesSelected[database] = false
+	dbs := []util.DbListItem{{Name: database, Selected: true}}
+
+	initialModel := DbDumpModel{
+		SelectedConnectionDetails: conn,
+		SelectedDatabases:         dbs,
+		TablesList:                newTablesList(conn, dbs, false),
+		ChoosingTables:            true,
+		SelectOnly:                true,
+	}
+
+	p := tea.NewProgram(initialModel, tea.WithAltScreen())
+	dbm, err := p.Run()
+	if err != nil {
+		fmt.Printf("FATAL -- Alas, there's been an error: %v", err)
+		os.Exit(1)
+	}
+
+	model, ok := dbm.(DbDumpModel)
+	if !ok || !model.Done {
+		return nil
+	}
+	tables := make([]string, 0, len(model.SelectedTables))
+	for _, t := range model.SelectedTables {
+		if t.Name == "All" {
+			return dh.FetchTablesForDb(database, conn)
+		}
+		tables = append(tables, t.Name)
+	}
+	return tables
 }
@@ -0,0 +1,297 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"seraphim/lib/config"
+	"seraphim/lib/dump"
+	"seraphim/lib/util"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// progressBarWidth is the number of cells of the progress bar of a file.
+const progressBarWidth = 24
+
+// maxProgressLines bounds the files listed while a dump runs, the others
+// being summed up.
+const maxProgressLines = 12
+
+var (
+	progressDoneStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	progressFailedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	progressEmptyStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("238"))
+)
+
+type dumpProgressMsg dump.Progress
+
+type dumpDoneMsg struct{ err error }
+
+// fileProgress is the last progress reported for a file of the dump.
+type fileProgress struct {
+	dump.Progress
+	started  time.Time
+	finished time.Time
+}
+
+// dumpProgressModel shows the files of a dump job while they are written.
+type dumpProgressModel struct {
+	job       *dump.Job
+	dir       string
+	files     []fileProgress
+	start     time.Time
+	spinner   spinner.Model
+	cancel    context.CancelFunc
+	cancelled bool
+	done      bool
+	err       error
+}
+
+func newDumpProgressModel(job *dump.Job, dir string, cancel context.CancelFunc) dumpProgressModel {
+	s := spinner.New()
+	s.Spinner = spinner.MiniDot
+	s.Style = focusedStyle
+	return dumpProgressModel{
+		job:     job,
+		dir:     dir,
+		files:   make([]fileProgress, len(job.Files)),
+		start:   time.Now(),
+		spinner: s,
+		cancel:  cancel,
+	}
+}
+
+func (m dumpProgressModel) Init() tea.Cmd {
+	return m.spinner.Tick
+}
+
+func (m dumpProgressModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" || msg.String() == "q" {
+			// The job stops its workers and reports back
+			m.cancelled = true
+			m.cancel()
+		}
+	case dumpProgressMsg:
+		f := &m.files[msg.File]
+		if f.started.IsZero() {
+			f.started = time.Now()
+		}
+		f.Progress = dump.Progress(msg)
+		if msg.Done {
+			f.finished = time.Now()
+		}
+	case dumpDoneMsg:
+		m.done = true
+		m.err = msg.err
+		return m, tea.Quit
+	case spinner.TickMsg:
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+	}
+	return m, nil
+}
+
+// fraction estimates how much of Files[i] is written, -1 when nothing tells.
+// Rows are compared to the row estimates for dialect dumps and bytes to the
+// size of the tables otherwise, which compressed archives undershoot.
+func (m dumpProgressModel) fraction(i int) float64 {
+	f, estimate := m.files[i], m.job.Files[i]
+	switch {
+	case f.Done:
+		return 1
+	case estimate.EstimatedRows > 0 && f.Rows > 0:
+		return min(float64(f.Rows)/float64(estimate.EstimatedRows), 0.99)
+	case estimate.EstimatedBytes > 0:
+		return min(float64(f.Bytes)/float64(estimate.EstimatedBytes), 0.99)
+	case f.started.IsZero():
+		return 0
+	}
+	return -1
+}
+
+func progressBar(fraction float64) string {
+	filled := int(fraction * progressBarWidth)
+	return focusedStyle.Render(strings.Repeat("█", filled)) + progressEmptyStyle.Render(strings.Repeat("░", progressBarWidth-filled))
+}
+
+// eta extrapolates the time left from the time spent on a fraction of the
+// work.
+func eta(elapsed time.Duration, fraction float64) string {
+	if fraction <= 0 || fraction >= 1 {
+		return "-"
+	}
+	left := time.Duration(float64(elapsed) * (1 - fraction) / fraction)
+	return left.Round(time.Second).String()
+}
+
+func (m dumpProgressModel) fileLine(i int) string {
+	f, estimate := m.files[i], m.job.Files[i]
+	name := estimate.Path
+	if rel, err := filepath.Rel(m.dir, estimate.Path); err == nil {
+		name = rel
+	}
+	elapsed := time.Since(f.started)
+	if !f.finished.IsZero() {
+		elapsed = f.finished.Sub(f.started)
+	}
+	fraction := m.fraction(i)
+
+	var status string
+	switch {
+	case f.Err != nil:
+		status = progressFailedStyle.Render("✗")
+	case f.Done:
+		status = progressDoneStyle.Render("✓")
+	case f.started.IsZero():
+		status = blurredStyle.Render("·")
+	default:
+		status = m.spinner.View()
+	}
+
+	bar := blurredStyle.Render(strings.Repeat("░", progressBarWidth))
+	percent := "    "
+	if fraction >= 0 {
+		bar = progressBar(fraction)
+		percent = fmt.Sprintf("%3.0f%%", fraction*100)
+	}
+
+	details := util.HumanSize(f.Bytes)
+	rows := f.Rows
+	approx := ""
+	if rows == 0 && fraction > 0 && estimate.EstimatedRows > 0 {
+		// The client tools do not tell the rows they wrote
+		rows, approx = int64(fraction*float64(estimate.EstimatedRows)), "~"
+	}
+	if seconds := elapsed.Seconds(); rows > 0 && seconds > 0 {
+		details += fmt.Sprintf(", %s%.0f rows/s", approx, float64(rows)/seconds)
+	}
+	switch {
+	case f.Err != nil:
+		details = progressFailedStyle.Render(f.Err.Error())
+	case f.Done:
+		details += ", " + elapsed.Round(time.Millisecond).String()
+	case !f.started.IsZero() && fraction > 0:
+		details += ", ETA " + eta(elapsed, fraction)
+	}
+	return fmt.Sprintf(" %s %s %s %s %s", status, bar, percent, name, blurredStyle.Render(details))
+}
+
+func (m dumpProgressModel) View() string {
+	var b strings.Builder
+	done, running, failed := 0, 0, 0
+	var bytes int64
+	var written, total float64
+	for i, f := range m.files {
+		bytes += f.Bytes
+		switch {
+		case f.Err != nil:
+			failed++
+		case f.Done:
+			done++
+		case !f.started.IsZero():
+			running++
+		}
+		// Files weigh their estimated rows, one row at least
+		weight := float64(max(m.job.Files[i].EstimatedRows, 1))
+		total += weight
+		if fraction := m.fraction(i); fraction > 0 {
+			written += fraction * weight
+		}
+	}
+
+	elapsed := time.Since(m.start)
+	fraction := 0.0
+	if total > 0 {
+		fraction = written / total
+	}
+	b.WriteString(pathInputTitleStyle.Render(fmt.Sprintf("Dumping %d file(s)", len(m.files))))
+	b.WriteString(blurredStyle.Render(fmt.Sprintf("  %d done, %d running, %s written in %s, ETA %s",
+		done, running, util.HumanSize(bytes), elapsed.Round(time.Second), eta(elapsed, fraction))))
+	b.WriteString("\n\n")
+
+	// Running and failed files first when they do not all fit
+	listed := make([]int, 0, len(m.files))
+	if len(m.files) <= maxProgressLines {
+		for i := range m.files {
+			listed = append(listed, i)
+		}
+	} else {
+		for i, f := range m.files {
+			if f.Err != nil || (!f.started.IsZero() && !f.Done) {
+				listed = append(listed, i)
+			}
+		}
+	}
+	for _, i := range listed {
+		b.WriteString(m.fileLine(i) + "\n")
+	}
+	if hidden := len(m.files) - len(listed); hidden > 0 {
+		b.WriteString(blurredStyle.Render(fmt.Sprintf("   ... %d more file(s), %d done, %d waiting", hidden, done, len(m.files)-done-running-failed)) + "\n")
+	}
+
+	switch {
+	case m.done:
+	case m.cancelled:
+		b.WriteString("\n" + blurredStyle.Render("Stopping..."))
+	default:
+		b.WriteString("\n" + blurredStyle.Render("q/ctrl+c: cancel"))
+	}
+	return b.String() + "\n"
+}
+
+// RunDump dumps the selected databases and tables of the connection tagged
+// tag into dir while showing the progress of every file of the dump.
+func RunDump(tag string, conn config.StoredConnection, dir string, selectedDbs []util.DbListItem, selectedTables []util.TableListItem, opts dump.Options) bool {
+	job, err := dump.Prepare(context.Background(), tag, conn, dir, selectedDbs, selectedTables, opts)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return RunJob(job, dir)
+}
+
+// RunJob writes the files of a prepared job into dir while showing their
+// progress.
+func RunJob(job *dump.Job, dir string) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	job.PrintIssues()
+	job.PrintFilters()
+
+	p := tea.NewProgram(newDumpProgressModel(job, dir, cancel))
+	go func() {
+		err := job.Run(ctx, func(progress dump.Progress) {
+			p.Send(dumpProgressMsg(progress))
+		})
+		p.Send(dumpDoneMsg{err: err})
+	}()
+	final, err := p.Run()
+	if err != nil {
+		fmt.Printf("FATAL -- Alas, there's been an error: %v", err)
+		return false
+	}
+	if m := final.(dumpProgressModel); m.err != nil {
+		if m.cancelled {
+			fmt.Println("Aborted operation")
+		} else {
+			fmt.Println(m.err)
+		}
+		job.PrintResumeHint()
+		return false
+	}
+	for _, err := range job.Finish() {
+		fmt.Printf("---> %s\n", err)
+	}
+	for _, f := range job.Files {
+		fmt.Printf("---> Dump written to %s\n", f.Path)
+	}
+	return true
+}
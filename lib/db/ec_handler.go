@@ -92,6 +92,7 @@ func (m StoredConnectionEditorModel) updateEditingView(msg tea.Msg) (tea.Model,
 						Port:            newConnPort,
 						Provider:        newConnProvider,
 						DefaultDatabase: newConnDefDb,
+						SSLMode:         m.ChosenConnection.SSLMode,
 					}
 					m.EditResult = config.EditConnection(appConfig, m.ChosenConnection, newConn, m.ChosenConnectionTag, newConnTag)
 
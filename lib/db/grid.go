@@ -0,0 +1,188 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/table"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+)
+
+const gridMaxCellWidth = 40
+
+// gridColumnMarker prefixes the title of the column under the cursor. The
+// table truncates cells by their rune width, so styling them would cut their
+// escape sequences.
+const gridColumnMarker = "▸"
+
+var (
+	gridStyles = func() table.Styles {
+		s := table.DefaultStyles()
+		s.Header = s.Header.Copy().
+			BorderStyle(lipgloss.NormalBorder()).
+			BorderForeground(lipgloss.Color("240")).
+			BorderBottom(true).
+			Foreground(lipgloss.Color("230"))
+		s.Selected = s.Selected.Copy().
+			Foreground(lipgloss.Color("#FFFDF5")).
+			Background(lipgloss.Color("#25A065")).
+			Bold(false)
+		return s
+	}()
+)
+
+// resultGrid is a table.Model with a column cursor, scrolling horizontally
+// through result sets wider than the terminal to keep that column visible.
+type resultGrid struct {
+	Table   table.Model
+	Columns []string
+	Rows    [][]string
+	// Col is the column under the cursor, ColOffset the first visible one
+	// and ColEnd the one following the last visible one
+	Col       int
+	ColOffset int
+	ColEnd    int
+	Width     int
+	Height    int
+}
+
+func newResultGrid() resultGrid {
+	t := table.New(table.WithStyles(gridStyles))
+	return resultGrid{Table: t}
+}
+
+// SetData replaces the grid content and scrolls back to the top left corner.
+func (g *resultGrid) SetData(columns []string, rows [][]string) {
+	g.Columns = columns
+	g.Rows = rows
+	g.Col = 0
+	g.ColOffset = 0
+	g.refresh()
+	g.Table.GotoTop()
+}
+
+func (g *resultGrid) SetSize(width int, height int) {
+	g.Width = width
+	g.Height = height
+	g.Table.SetHeight(height)
+	g.refresh()
+}
+
+func (g *resultGrid) Focus() { g.Table.Focus() }
+func (g *resultGrid) Blur()  { g.Table.Blur() }
+
+// Cursor returns the index of the highlighted row.
+func (g resultGrid) Cursor() int { return g.Table.Cursor() }
+
+// Cell returns the full text of the cell under the cursor, untruncated.
+func (g resultGrid) Cell() (string, bool) {
+	row := g.Table.Cursor()
+	if row < 0 || row >= len(g.Rows) || g.Col >= len(g.Rows[row]) {
+		return "", false
+	}
+	return g.Rows[row][g.Col], true
+}
+
+func (g *resultGrid) MoveLeft() {
+	if g.Col > 0 {
+		g.Col--
+		if g.Col < g.ColOffset {
+			g.ColOffset = g.Col
+		}
+		g.refresh()
+	}
+}
+
+func (g *resultGrid) MoveRight() {
+	if g.Col < len(g.Columns)-1 {
+		g.Col++
+		g.refresh()
+		for g.Col >= g.ColEnd && g.ColOffset < g.Col {
+			g.ColOffset++
+			g.refresh()
+		}
+	}
+}
+
+// gridCell flattens a value so that it fits on a single table line.
+func gridCell(value string) string {
+	value = strings.NewReplacer("\r\n", "↵", "\n", "↵", "\t", " ").Replace(value)
+	return runewidth.Truncate(value, gridMaxCellWidth, "…")
+}
+
+// refresh rebuilds the visible columns starting at ColOffset.
+func (g *resultGrid) refresh() {
+	cols := make([]table.Column, 0)
+	used := 0
+	last := len(g.Columns)
+	for i := g.ColOffset; i < len(g.Columns); i++ {
+		width := runewidth.StringWidth(g.Columns[i])
+		for _, row := range g.Rows {
+			if i < len(row) {
+				if w := runewidth.StringWidth(gridCell(row[i])); w > width {
+					width = w
+				}
+			}
+		}
+		// Cells have a padding of one on each side
+		if g.Width > 0 && used+width+2 > g.Width && len(cols) > 0 {
+			last = i
+			break
+		}
+		title := g.Columns[i]
+		if i == g.Col {
+			title = gridColumnMarker + title
+			if w := runewidth.StringWidth(title); w > width {
+				width = w
+			}
+		}
+		cols = append(cols, table.Column{Title: title, Width: width})
+		used += width + 2
+	}
+	g.ColEnd = last
+
+	rows := make([]table.Row, len(g.Rows))
+	for r, row := range g.Rows {
+		visible := make(table.Row, 0, len(cols))
+		for i := g.ColOffset; i < last && i < len(row); i++ {
+			visible = append(visible, gridCell(row[i]))
+		}
+		rows[r] = visible
+	}
+	// Clear the rows first so that the table never renders a row with more
+	// cells than columns
+	cursor := g.Table.Cursor()
+	g.Table.SetRows(nil)
+	g.Table.SetColumns(cols)
+	g.Table.SetRows(rows)
+	if cursor < len(rows) {
+		g.Table.SetCursor(cursor)
+	}
+	if g.Width > 0 {
+		g.Table.SetWidth(g.Width)
+	}
+}
+
+func (g resultGrid) Update(msg tea.Msg) (resultGrid, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "left", "h":
+			g.MoveLeft()
+			return g, nil
+		case "right", "l":
+			g.MoveRight()
+			return g, nil
+		}
+	}
+	var cmd tea.Cmd
+	g.Table, cmd = g.Table.Update(msg)
+	return g, cmd
+}
+
+func (g resultGrid) View() string {
+	if len(g.Columns) == 0 {
+		return ""
+	}
+	return g.Table.View()
+}
@@ -0,0 +1,284 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"seraphim/lib/config"
+	dh "seraphim/lib/db/query"
+	"strings"
+
+	"github.com/aymanbagabas/go-osc52/v2"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const previewPageSize = 100
+
+// previewKey opens the data preview of the highlighted table from the screens
+// listing tables.
+var previewKey = key.NewBinding(
+	key.WithKeys("p"),
+	key.WithHelp("p", "preview rows"),
+)
+
+type previewPageMsg struct {
+	Table string
+	Page  dh.Page
+	Err   error
+}
+
+// previewCursor is where a page starts: after a primary key value or, for
+// tables without a primary key, at an offset.
+type previewCursor struct {
+	After  []any
+	Offset int
+}
+
+// tablePreview is a paged grid of the rows of a single table, opened on top
+// of the screen listing the tables. Done is set once the user leaves it.
+type tablePreview struct {
+	Connection config.StoredConnection
+	Database   string
+	Table      string
+
+	Grid        resultGrid
+	FilterInput textinput.Model
+	Page        dh.Page
+	Cursors     []previewCursor
+	Filter      *dh.RowFilter
+
+	Filtering bool
+	Loading   bool
+	Done      bool
+	Status    string
+	Err       error
+	Width     int
+	Height    int
+}
+
+func newTablePreview(conn config.StoredConnection, database string, table string, width int, height int) (*tablePreview, tea.Cmd) {
+	input := textinput.New()
+	input.Prompt = focusedStyle.Render("filter ❯ ")
+	input.Placeholder = "column=value, column!=value or column~text"
+	input.Cursor.Style = cursorStyle
+
+	p := &tablePreview{
+		Connection:  conn,
+		Database:    database,
+		Table:       table,
+		Grid:        newResultGrid(),
+		FilterInput: input,
+		Cursors:     []previewCursor{{}},
+	}
+	p.SetSize(width, height)
+	p.Grid.Focus()
+	return p, p.load()
+}
+
+func (p *tablePreview) SetSize(width int, height int) {
+	p.Width, p.Height = width, height
+	// title, filter input, status and help lines plus the two header lines
+	// of the grid
+	gridHeight := height - 6
+	if gridHeight < 3 {
+		gridHeight = 3
+	}
+	p.Grid.SetSize(width, gridHeight)
+}
+
+// load fetches the page starting at the last cursor.
+func (p *tablePreview) load() tea.Cmd {
+	p.Loading = true
+	cursor := p.Cursors[len(p.Cursors)-1]
+	conn, database := p.Connection, p.Database
+	req := dh.PageRequest{
+		Table:  p.Table,
+		Filter: p.Filter,
+		After:  cursor.After,
+		Offset: cursor.Offset,
+		Limit:  previewPageSize,
+	}
+	return func() tea.Msg {
+		page, err := dh.FetchPage(context.Background(), conn, database, req)
+		return previewPageMsg{Table: req.Table, Page: page, Err: err}
+	}
+}
+
+func (p *tablePreview) setPage(page dh.Page) {
+	p.Page = page
+	columns := make([]string, len(page.Columns))
+	for i, c := range page.Columns {
+		columns[i] = c.Name
+	}
+	rows := make([][]string, len(page.Rows))
+	for r, values := range page.Rows {
+		rows[r] = make([]string, len(values))
+		for i, v := range values {
+			rows[r][i] = previewText(v, page.Columns[i].Kind)
+		}
+	}
+	p.Grid.SetData(columns, rows)
+}
+
+func previewText(v any, kind dh.ValueKind) string {
+	if text, ok := dh.FormatTypedValue(v, kind); ok {
+		return text
+	}
+	return dh.NullDisplay
+}
+
+// copyToClipboard asks the terminal to set the clipboard with an OSC52
+// sequence, which also works over ssh.
+func copyToClipboard(text string) {
+	seq := osc52.New(text)
+	if os.Getenv("TMUX") != "" {
+		seq = seq.Tmux()
+	} else if strings.HasPrefix(os.Getenv("TERM"), "screen") {
+		seq = seq.Screen()
+	}
+	seq.WriteTo(os.Stderr)
+}
+
+func (p *tablePreview) Update(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case previewPageMsg:
+		if msg.Table != p.Table {
+			return nil
+		}
+		p.Loading = false
+		if msg.Err != nil {
+			p.Err = msg.Err
+			return nil
+		}
+		p.Err = nil
+		p.setPage(msg.Page)
+		p.Status = ""
+		return nil
+
+	case tea.KeyMsg:
+		if p.Filtering {
+			switch msg.String() {
+			case "esc":
+				p.Filtering = false
+				p.FilterInput.Blur()
+				return nil
+			case "enter":
+				p.Filtering = false
+				p.FilterInput.Blur()
+				p.Filter = nil
+				if text := strings.TrimSpace(p.FilterInput.Value()); text != "" {
+					filter, err := dh.ParseRowFilter(text)
+					if err != nil {
+						p.Err = err
+						return nil
+					}
+					p.Filter = &filter
+				}
+				p.Cursors = []previewCursor{{}}
+				return p.load()
+			}
+			var cmd tea.Cmd
+			p.FilterInput, cmd = p.FilterInput.Update(msg)
+			return cmd
+		}
+
+		switch msg.String() {
+		case "esc", "q", "alt+backspace":
+			p.Done = true
+			return nil
+		case "/":
+			p.Filtering = true
+			return p.FilterInput.Focus()
+		case "n", "]":
+			if p.Loading || !p.Page.HasMore {
+				return nil
+			}
+			current := p.Cursors[len(p.Cursors)-1]
+			next := previewCursor{After: p.Page.NextAfter()}
+			if len(p.Page.Key) == 0 {
+				next.Offset = current.Offset + len(p.Page.Rows)
+			}
+			p.Cursors = append(p.Cursors, next)
+			return p.load()
+		case "p", "[":
+			if p.Loading || len(p.Cursors) == 1 {
+				return nil
+			}
+			p.Cursors = p.Cursors[:len(p.Cursors)-1]
+			return p.load()
+		case "y":
+			if cell, ok := p.Grid.Cell(); ok {
+				copyToClipboard(cell)
+				p.Status = fmt.Sprintf("Copied %s of row %d", p.Grid.Columns[p.Grid.Col], p.rowNumber())
+			}
+			return nil
+		case "Y":
+			if row, ok := p.currentRow(); ok {
+				copyToClipboard(strings.Join(row, "\t"))
+				p.Status = fmt.Sprintf("Copied row %d", p.rowNumber())
+			}
+			return nil
+		}
+	}
+
+	var cmd tea.Cmd
+	p.Grid, cmd = p.Grid.Update(msg)
+	return cmd
+}
+
+// currentRow returns the full text of the highlighted row, untruncated.
+func (p tablePreview) currentRow() ([]string, bool) {
+	cursor := p.Grid.Cursor()
+	if cursor < 0 || cursor >= len(p.Grid.Rows) {
+		return nil, false
+	}
+	return p.Grid.Rows[cursor], true
+}
+
+func (p tablePreview) rowNumber() int {
+	return (len(p.Cursors)-1)*previewPageSize + p.Grid.Cursor() + 1
+}
+
+func (p tablePreview) View() string {
+	title := titleStyle.Render(p.Database+"."+p.Table) + " " +
+		blurredStyle.Render(fmt.Sprintf("page %d", len(p.Cursors)))
+	if len(p.Page.Key) > 0 {
+		title += blurredStyle.Render(" • ordered by " + strings.Join(p.Page.Key, ", "))
+	}
+	if p.Filter != nil {
+		title += focusedStyle.Render(" • where " + p.Filter.String())
+	}
+
+	filter := ""
+	if p.Filtering {
+		filter = p.FilterInput.View()
+	}
+
+	status := ""
+	switch {
+	case p.Err != nil:
+		status = consoleErrStyle.Render(p.Err.Error())
+	case p.Loading:
+		status = consoleStatusStyle.Render("Loading…")
+	case p.Status != "":
+		status = consoleStatusStyle.Render(p.Status)
+	case len(p.Page.Rows) == 0:
+		status = consoleStatusStyle.Render("No rows")
+	default:
+		first := (len(p.Cursors)-1)*previewPageSize + 1
+		more := ""
+		if p.Page.HasMore {
+			more = ", more available"
+		}
+		column := ""
+		if p.Grid.Col < len(p.Grid.Columns) {
+			column = " • column " + p.Grid.Columns[p.Grid.Col]
+		}
+		status = consoleStatusStyle.Render(fmt.Sprintf("rows %d-%d%s%s", first, first+len(p.Page.Rows)-1, more, column))
+	}
+
+	help := blurredStyle.Render("[n/p] next/previous page • [←/→ h/l] column • [/] filter • [y/Y] copy cell/row • [esc] back")
+	return title + "\n" + filter + "\n" + p.Grid.View() + "\n" + status + "\n" + help
+}
@@ -0,0 +1,174 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"seraphim/lib/config"
+)
+
+// SQLiteDatabase is the name under which the single database of a sqlite
+// file is listed.
+const SQLiteDatabase = "main"
+
+// ListDatabases returns the databases visible to conn.
+func ListDatabases(ctx context.Context, conn config.StoredConnection) ([]string, error) {
+	db, err := Open(conn, "")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	switch NormalizeProvider(conn.Provider) {
+	case "mysql":
+		return queryStrings(ctx, db, "SHOW DATABASES")
+	case "postgres":
+		return queryStrings(ctx, db, "SELECT datname FROM pg_database WHERE NOT datistemplate AND datallowconn ORDER BY datname")
+	case "sqlite":
+		return []string{SQLiteDatabase}, db.PingContext(ctx)
+	default:
+		return nil, fmt.Errorf("unknown provider %q", conn.Provider)
+	}
+}
+
+// ListTables returns the base tables of database. Postgres tables living
+// outside the public schema are qualified with their schema name.
+func ListTables(ctx context.Context, conn config.StoredConnection, database string) ([]string, error) {
+	db, err := Open(conn, database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return listTables(ctx, db, NormalizeProvider(conn.Provider))
+}
+
+func listTables(ctx context.Context, db *sql.DB, provider string) ([]string, error) {
+	switch provider {
+	case "mysql":
+		return queryStrings(ctx, db, "SHOW FULL TABLES WHERE Table_type = 'BASE TABLE'")
+	case "postgres":
+		return queryStrings(ctx, db, `SELECT CASE WHEN table_schema = 'public' THEN table_name ELSE table_schema || '.' || table_name END
+			FROM information_schema.tables
+			WHERE table_type = 'BASE TABLE' AND table_schema NOT IN ('pg_catalog', 'information_schema')
+			ORDER BY table_schema, table_name`)
+	case "sqlite":
+		return queryStrings(ctx, db, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	default:
+		return nil, fmt.Errorf("unknown provider %q", provider)
+	}
+}
+
+// ListViews returns the views of database, named as ListTables names
+// tables.
+func ListViews(ctx context.Context, conn config.StoredConnection, database string) ([]string, error) {
+	db, err := Open(conn, database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	switch NormalizeProvider(conn.Provider) {
+	case "mysql":
+		return queryStrings(ctx, db, "SHOW FULL TABLES WHERE Table_type = 'VIEW'")
+	case "postgres":
+		return queryStrings(ctx, db, `SELECT CASE WHEN table_schema = 'public' THEN table_name ELSE table_schema || '.' || table_name END
+			FROM information_schema.views
+			WHERE table_schema NOT IN ('pg_catalog', 'information_schema')
+			ORDER BY table_schema, table_name`)
+	case "sqlite":
+		return queryStrings(ctx, db, "SELECT name FROM sqlite_master WHERE type = 'view' ORDER BY name")
+	default:
+		return nil, fmt.Errorf("unknown provider %q", conn.Provider)
+	}
+}
+
+// TableEstimate is the size of a table as estimated by the statistics of
+// the server.
+type TableEstimate struct {
+	Rows  int64
+	Bytes int64
+}
+
+// EstimateTables returns the estimated size of the tables of database, named
+// as ListTables names them. The estimates come from the catalog, without
+// scanning the tables, except for sqlite which keeps no statistics and whose
+// rows are counted; the size of sqlite tables is not estimated.
+func EstimateTables(ctx context.Context, conn config.StoredConnection, database string) (map[string]TableEstimate, error) {
+	db, err := Open(conn, database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	provider := NormalizeProvider(conn.Provider)
+	var query string
+	switch provider {
+	case "mysql":
+		query = `SELECT TABLE_NAME, COALESCE(TABLE_ROWS, 0), COALESCE(DATA_LENGTH, 0)
+			FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_TYPE = 'BASE TABLE'`
+	case "postgres":
+		query = `SELECT CASE WHEN n.nspname = 'public' THEN c.relname ELSE n.nspname || '.' || c.relname END,
+			GREATEST(c.reltuples, 0)::bigint, pg_table_size(c.oid)
+			FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relkind IN ('r', 'p') AND n.nspname NOT IN ('pg_catalog', 'information_schema')`
+	case "sqlite":
+		tables, err := listTables(ctx, db, provider)
+		if err != nil {
+			return nil, err
+		}
+		estimates := make(map[string]TableEstimate, len(tables))
+		for _, t := range tables {
+			var rows int64
+			if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+QuoteTable(provider, t)).Scan(&rows); err != nil {
+				return nil, err
+			}
+			estimates[t] = TableEstimate{Rows: rows}
+		}
+		return estimates, nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", conn.Provider)
+	}
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	estimates := make(map[string]TableEstimate)
+	for rows.Next() {
+		var name string
+		var e TableEstimate
+		if err := rows.Scan(&name, &e.Rows, &e.Bytes); err != nil {
+			return nil, err
+		}
+		estimates[name] = e
+	}
+	return estimates, rows.Err()
+}
+
+// queryStrings runs query and collects the first column of every row.
+func queryStrings(ctx context.Context, db *sql.DB, query string, args ...any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0)
+	dest := make([]any, len(columns))
+	for i := range dest {
+		dest[i] = new(sql.RawBytes)
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		values = append(values, string(*dest[0].(*sql.RawBytes)))
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,102 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"seraphim/lib/config"
+	"strconv"
+
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	"modernc.org/sqlite"
+)
+
+// NormalizeProvider maps the provider aliases accepted in the configuration
+// to the name used throughout the query package.
+func NormalizeProvider(provider string) string {
+	switch provider {
+	case "postgresql", "pg":
+		return "postgres"
+	case "sqlite3":
+		return "sqlite"
+	}
+	return provider
+}
+
+// DriverAndDSN returns the database/sql driver name and data source name used
+// to reach db on conn. An empty db connects to the server default.
+func DriverAndDSN(conn config.StoredConnection, db string) (string, string, error) {
+	switch NormalizeProvider(conn.Provider) {
+	case "mysql":
+		cfg := mysql.NewConfig()
+		cfg.User = conn.User
+		cfg.Passwd = conn.Password
+		cfg.Net = "tcp"
+		cfg.Addr = fmt.Sprintf("%s:%d", conn.Host, conn.Port)
+		cfg.DBName = db
+		return "mysql", cfg.FormatDSN(), nil
+	case "postgres":
+		if db == "" {
+			db = conn.DefaultDatabase
+		}
+		if db == "" {
+			db = "postgres"
+		}
+		dsn := url.URL{
+			Scheme: "postgres",
+			User:   url.UserPassword(conn.User, conn.Password),
+			Host:   conn.Host + ":" + strconv.Itoa(conn.Port),
+			Path:   "/" + db,
+		}
+		if conn.SSLMode != "" {
+			dsn.RawQuery = url.Values{"sslmode": {conn.SSLMode}}.Encode()
+		}
+		return "postgres", dsn.String(), nil
+	case "sqlite":
+		// A sqlite file holds a single database so db is ignored. mode=rw
+		// keeps a mistyped path from silently creating an empty database
+		path := (&url.URL{Path: conn.Host}).EscapedPath()
+		return "sqlite", "file:" + path + "?mode=rw&_pragma=foreign_keys(1)", nil
+	default:
+		return "", "", fmt.Errorf("unknown provider %q", conn.Provider)
+	}
+}
+
+// Open returns a handle on db for the given stored connection. The caller is
+// responsible for closing it.
+func Open(conn config.StoredConnection, db string) (*sql.DB, error) {
+	driver, dsn, err := DriverAndDSN(conn, db)
+	if err != nil {
+		return nil, err
+	}
+	return sql.Open(driver, dsn)
+}
+
+// Ping opens conn, checks that the server answers and returns its version.
+func Ping(ctx context.Context, conn config.StoredConnection) (string, error) {
+	db, err := Open(conn, "")
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	if err := db.PingContext(ctx); err != nil {
+		return "", err
+	}
+	return ServerVersion(ctx, db)
+}
+
+// ServerVersion returns the version string reported by the server behind db.
+func ServerVersion(ctx context.Context, db *sql.DB) (string, error) {
+	statement := "SELECT VERSION()"
+	if _, ok := db.Driver().(*sqlite.Driver); ok {
+		statement = "SELECT 'SQLite ' || sqlite_version()"
+	}
+	var version string
+	if err := db.QueryRowContext(ctx, statement).Scan(&version); err != nil {
+		return "", err
+	}
+	return version, nil
+}
@@ -1,201 +1,266 @@
 package query
 
 import (
-	"database/sql"
+	"bytes"
+	"context"
 	"fmt"
 	"log"
+	"os"
 	"os/exec"
-	"runtime"
+	"path/filepath"
 	"seraphim/lib/config"
-	"seraphim/lib/util"
+	"strconv"
 	"strings"
-	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	pgcommands "github.com/habx/pg-commands"
 )
 
 func FetchTablesForDb(db string, conn config.StoredConnection) []string {
-	tables := make([]string, 0)
-	switch conn.Provider {
-	case "mysql":
-		db, err := sql.Open(conn.Provider, fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", conn.User, conn.Password, conn.Host, conn.Port, db))
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer db.Close()
-
-		// Ping the database to check the connection
-		err = db.Ping()
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		// Query to get the list of databases
-		rows, err := db.Query("SHOW TABLES")
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var tableName string
-			err := rows.Scan(&tableName)
-			if err != nil {
-				log.Fatal(err)
-			}
-			tables = append(tables, tableName)
-		}
-
-		// Check for errors from iterating over rows
-		if err = rows.Err(); err != nil {
-			log.Fatal(err)
-		}
-
-	case "postgres":
-
-	default:
-		log.Fatal("Unknown provider")
+	tables, err := ListTables(context.Background(), conn, db)
+	if err != nil {
+		log.Fatal(err)
 	}
 	return tables
 }
 
-func FetchDbList(conn config.StoredConnection) []string {
-	dbs := make([]string, 0)
-	switch conn.Provider {
-	case "mysql":
-		db, err := sql.Open(conn.Provider, fmt.Sprintf("%s:%s@tcp(%s:%d)/", conn.User, conn.Password, conn.Host, conn.Port))
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer db.Close()
-
-		// Ping the database to check the connection
-		err = db.Ping()
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		// Query to get the list of databases
-		rows, err := db.Query("SHOW DATABASES")
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer rows.Close()
+// FetchViewsForDb returns the views of db, named as FetchTablesForDb names
+// tables.
+func FetchViewsForDb(db string, conn config.StoredConnection) []string {
+	views, err := ListViews(context.Background(), conn, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return views
+}
 
-		for rows.Next() {
-			var dbName string
-			err := rows.Scan(&dbName)
-			if err != nil {
-				log.Fatal(err)
-			}
-			dbs = append(dbs, dbName)
-		}
+func FetchDbList(conn config.StoredConnection) []string {
+	dbs, err := ListDatabases(context.Background(), conn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return dbs
+}
 
-		// Check for errors from iterating over rows
-		if err = rows.Err(); err != nil {
-			log.Fatal(err)
-		}
+// DumpFile is a file written by CreateDump along with the databases it
+// holds.
+type DumpFile struct {
+	Path      string
+	Databases []DumpDatabase
+}
 
-	case "postgres":
+// DumpDatabase is a database of a dump file, every table being dumped when
+// Tables is nil.
+type DumpDatabase struct {
+	Name   string
+	Tables []string
+}
 
-	default:
-		log.Fatal("Unknown provider")
-	}
-	return dbs
+// DumpOptions asks the client tools for a consistent dump holding the
+// objects selected.
+type DumpOptions struct {
+	// SingleTransaction dumps the databases of a mysql file inside a single
+	// transaction
+	SingleTransaction bool
+	// SourceData is the mysqldump option recording the position of the
+	// binary log in the file, e.g. --source-data=2, empty to skip it
+	SourceData string
+	// Snapshot is a postgres snapshot exported by another transaction
+	Snapshot string
+	Objects  DumpObjects
 }
 
-func CreateDump(selected config.StoredConnection, dumpPath string, selectedDbs []util.DbListItem, selectedTables []util.TableListItem) bool {
+// WriteDump writes file with the client tools of the provider of selected.
+// A mysql file may hold several databases while a postgres archive holds a
+// single one.
+func WriteDump(ctx context.Context, selected config.StoredConnection, file DumpFile, opts DumpOptions) error {
 	username := selected.User
 	password := selected.Password
 	hostname := selected.Host
 	port := selected.Port
 	driver := selected.Provider
 
-	dumpDir := dumpPath
-	dumpFilenameFormat := fmt.Sprintf("%s-%v.sql", "dump", time.Now().Unix())
+	dumpDir, dumpFilename := filepath.Split(file.Path)
 	switch driver {
 	case "mysql":
 		// I'd rather use a golang library to avoid external dependencies but
 		// no library offers the same flexibility
-		sql := fmt.Sprintf("mysqldump -u %s -p%s ", username, password)
-		isFirst := true
-		if len(selectedDbs) > 0 {
-			for _, db := range selectedDbs {
-				var b strings.Builder
-				b.WriteString(sql)
-				b.WriteString(" " + db.Name)
-				if len(selectedTables) > 0 {
-					for _, v := range selectedTables {
-						if v.Db == db.Name {
-							if v.Name == "All" {
-								b.Reset()
-								b.WriteString(sql)
-								b.WriteString(" " + db.Name)
-								break
-							}
-							b.WriteString(" " + v.Name)
-						}
-					}
+		if _, err := exec.LookPath("mysqldump"); err != nil {
+			return fmt.Errorf("mysqldump was not found in $PATH, run 'seraphim doctor' for details")
+		}
+		args := []string{"--host=" + hostname, "--port=" + strconv.Itoa(port), "--user=" + username, "--password=" + password}
+		if opts.SingleTransaction {
+			args = append(args, "--single-transaction")
+		}
+		if opts.SourceData != "" {
+			args = append(args, opts.SourceData)
+		}
+		args = append(args, opts.Objects.mysqldumpArgs()...)
+		if opts.Objects.Views == IncludeOff {
+			for _, db := range file.Databases {
+				if db.Tables != nil {
+					continue
 				}
-				if isFirst {
-					b.WriteString(fmt.Sprintf(" > %s", dumpFilenameFormat))
-					isFirst = false
-				} else {
-					b.WriteString(fmt.Sprintf(" >> %s", dumpFilenameFormat))
+				views, err := ListViews(ctx, selected, db.Name)
+				if err != nil {
+					return fmt.Errorf("%s: %w", db.Name, err)
 				}
-				if cmdRes := handleCommandForSys(dumpDir, b.String()); !cmdRes {
-					return false
+				for _, view := range views {
+					args = append(args, "--ignore-table="+db.Name+"."+view)
 				}
 			}
 		}
-		return true
+		// A single transaction needs a single command
+		if opts.SingleTransaction && len(file.Databases) > 1 {
+			names := make([]string, len(file.Databases))
+			for i, db := range file.Databases {
+				if db.Tables != nil {
+					return fmt.Errorf("a consistent dump of several databases cannot select tables")
+				}
+				names[i] = db.Name
+			}
+			if err := runMysqldump(ctx, file.Path, append(append(args, "--databases", "--"), names...), false); err != nil {
+				return err
+			}
+			return writeGrants(ctx, selected, file, opts.Objects)
+		}
+		for i, db := range file.Databases {
+			dbArgs := append(append(args[:len(args):len(args)], "--", db.Name), db.Tables...)
+			if err := runMysqldump(ctx, file.Path, dbArgs, i > 0); err != nil {
+				return fmt.Errorf("%s: %w", db.Name, err)
+			}
+		}
+		return writeGrants(ctx, selected, file, opts.Objects)
 	case "postgres":
-		dump, _ := pgcommands.NewDump(&pgcommands.Postgres{
+		if len(file.Databases) != 1 {
+			return fmt.Errorf("a postgres archive holds a single database, found %d", len(file.Databases))
+		}
+		db := file.Databases[0]
+		dump, err := pgcommands.NewDump(&pgcommands.Postgres{
 			Host:     hostname,
 			Port:     port,
-			DB:       "",
+			DB:       db.Name,
 			Username: username,
 			Password: password,
 		})
+		if err != nil {
+			return err
+		}
+		dump.SetPath(dumpDir)
+		dump.SetFileName(dumpFilename)
+		for _, table := range db.Tables {
+			dump.Options = append(dump.Options, "--table="+table)
+		}
+		if opts.Snapshot != "" {
+			dump.Options = append(dump.Options, "--snapshot="+opts.Snapshot)
+		}
+		// pg-commands leaves the privileges out unless asked for them, and
+		// drops the objects first, which pg_dump refuses for rows only
+		dropped := make(map[string]bool)
+		if opts.Objects.Grants == IncludeOn {
+			dropped["--no-acl"] = true
+		}
+		if opts.Objects.DataOnly {
+			dropped["--clean"] = true
+		}
+		kept := make([]string, 0, len(dump.Options))
+		for _, option := range dump.Options {
+			if !dropped[option] {
+				kept = append(kept, option)
+			}
+		}
+		dump.Options = kept
+		dump.Options = append(dump.Options, opts.Objects.pgDumpArgs()...)
+		if opts.Objects.Views == IncludeOff && db.Tables == nil {
+			views, err := ListViews(ctx, selected, db.Name)
+			if err != nil {
+				return err
+			}
+			for _, view := range views {
+				dump.Options = append(dump.Options, "--exclude-table="+view)
+			}
+		}
 		dumpExec := dump.Exec(pgcommands.ExecOptions{StreamPrint: false})
 		if dumpExec.Error != nil {
-			fmt.Println(dumpExec.Error.Err)
-			fmt.Println(dumpExec.Output)
-			return false
-
-		} else {
-			fmt.Println("Dump success")
-			fmt.Println(dumpExec.Output)
-			return true
+			return fmt.Errorf("%w: %s", dumpExec.Error.Err, strings.TrimSpace(dumpExec.Output))
 		}
+		return nil
 	default:
-		log.Fatal("Unknown driver")
-		return false
+		return fmt.Errorf("%s databases cannot be dumped with client tools, use a target dialect", driver)
 	}
 }
 
-func handleCommandForSys(dir string, command string) bool {
+// mysqldumpCompleted starts the last line mysqldump writes.
+var mysqldumpCompleted = []byte("-- Dump completed")
 
-	switch runtime.GOOS {
-	case "windows":
-		cmd := exec.Command("cmd", "/c", command)
-		cmd.Dir = dir
-		err := cmd.Run()
+// writeGrants adds the grants on the databases of a mysql file to it when
+// objects asks for them, ahead of the line mysqldump ends the file with so
+// that the file still reads as complete.
+func writeGrants(ctx context.Context, selected config.StoredConnection, file DumpFile, objects DumpObjects) error {
+	if objects.Grants != IncludeOn {
+		return nil
+	}
+	var grants bytes.Buffer
+	for _, db := range file.Databases {
+		lines, err := mysqlGrants(ctx, selected, db.Name)
 		if err != nil {
-			serr := err.Error()
-			fmt.Println(serr)
-			return false
+			return fmt.Errorf("%s: %w", db.Name, err)
 		}
-	default:
-		cmd := exec.Command("bash", "-c", command)
-		cmd.Dir = dir
-		err := cmd.Run()
-		if err != nil {
-			serr := err.Error()
-			fmt.Println(serr)
-			return false
+		if len(lines) > 0 {
+			fmt.Fprintf(&grants, "-- Grants on %s\n%s\n\n", db.Name, strings.Join(lines, "\n"))
+		}
+	}
+	if grants.Len() == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(file.Path, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	tail := make([]byte, min(info.Size(), 4096))
+	if _, err := f.ReadAt(tail, info.Size()-int64(len(tail))); err != nil {
+		return err
+	}
+	at := info.Size()
+	var completed []byte
+	if i := bytes.LastIndex(tail, mysqldumpCompleted); i >= 0 && (i == 0 || tail[i-1] == '\n') {
+		at -= int64(len(tail) - i)
+		completed = tail[i:]
+	}
+	if _, err := f.WriteAt(append(grants.Bytes(), completed...), at); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// runMysqldump runs mysqldump with args, writing its output to path or,
+// when appending, adding it to the end of path. The error holds what
+// mysqldump wrote to stderr.
+func runMysqldump(ctx context.Context, path string, args []string, appending bool) error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appending {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	out, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	cmd := exec.CommandContext(ctx, "mysqldump", args...)
+	cmd.Stdout = out
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
 		}
+		return err
 	}
-	return true
+	return out.Close()
 }
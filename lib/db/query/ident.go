@@ -0,0 +1,49 @@
+package query
+
+import (
+	"strconv"
+	"strings"
+)
+
+// QuoteIdent quotes a single identifier for provider.
+func QuoteIdent(provider string, name string) string {
+	if NormalizeProvider(provider) == "mysql" {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// QuoteTable quotes a table name as returned by ListTables, keeping the schema
+// of qualified Postgres names as a separate identifier.
+func QuoteTable(provider string, table string) string {
+	if NormalizeProvider(provider) == "postgres" {
+		schema, name := SplitTableName(table)
+		return QuoteIdent(provider, schema) + "." + QuoteIdent(provider, name)
+	}
+	return QuoteIdent(provider, table)
+}
+
+// Placeholder returns the bind parameter marker for the n-th argument,
+// starting at 1.
+func Placeholder(provider string, n int) string {
+	if NormalizeProvider(provider) == "postgres" {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// castText returns expr converted to text so that it can be matched with LIKE
+// whatever its type.
+func castText(provider string, expr string) string {
+	if NormalizeProvider(provider) == "mysql" {
+		return "CAST(" + expr + " AS CHAR)"
+	}
+	return "CAST(" + expr + " AS TEXT)"
+}
+
+// likeEscaper escapes the LIKE wildcards of a literal search text. The pattern
+// must be followed by likeEscape, ! avoids the backslash quoting differences
+// between providers.
+var likeEscaper = strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`)
+
+const likeEscape = " ESCAPE '!'"
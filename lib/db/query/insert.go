@@ -0,0 +1,38 @@
+package query
+
+import "strings"
+
+// MaxParameters is the number of bind parameters a single statement may
+// carry on each provider.
+var MaxParameters = map[string]int{
+	"mysql":    65535,
+	"postgres": 65535,
+	"sqlite":   32766,
+}
+
+// InsertStatement returns a multi row INSERT of rows rows of columns into
+// table, each value bound to its own parameter.
+func InsertStatement(provider string, table string, columns []string, rows int) string {
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = QuoteIdent(provider, col)
+	}
+	var b strings.Builder
+	b.WriteString("INSERT INTO " + QuoteTable(provider, table) + " (" + strings.Join(quoted, ", ") + ") VALUES ")
+	n := 0
+	for r := 0; r < rows; r++ {
+		if r > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(")
+		for i := range columns {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			n++
+			b.WriteString(Placeholder(provider, n))
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
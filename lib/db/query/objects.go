@@ -0,0 +1,234 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"seraphim/lib/config"
+	"strings"
+)
+
+// Include tells whether a dump holds a type of objects.
+type Include int
+
+const (
+	// IncludeDefault leaves the choice to the engine writing the dump
+	IncludeDefault Include = iota
+	IncludeOn
+	IncludeOff
+)
+
+func (i Include) String() string {
+	switch i {
+	case IncludeOn:
+		return "on"
+	case IncludeOff:
+		return "off"
+	}
+	return "default"
+}
+
+func (i Include) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+func (i *Include) UnmarshalText(text []byte) error {
+	for _, value := range []Include{IncludeDefault, IncludeOn, IncludeOff} {
+		if value.String() == string(text) {
+			*i = value
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid value %q, expected default, on or off", text)
+}
+
+// Next returns the include following i, cycling through every value.
+func (i Include) Next() Include {
+	return (i + 1) % 3
+}
+
+// DumpObjects selects what a dump holds: the definitions of the tables,
+// their rows and the other objects of the databases.
+type DumpObjects struct {
+	SchemaOnly bool    `json:"schema_only,omitempty"`
+	DataOnly   bool    `json:"data_only,omitempty"`
+	Views      Include `json:"views,omitempty"`
+	Routines   Include `json:"routines,omitempty"`
+	Triggers   Include `json:"triggers,omitempty"`
+	Events     Include `json:"events,omitempty"`
+	Grants     Include `json:"grants,omitempty"`
+}
+
+// ObjectType is a type of objects a dump may hold besides tables.
+type ObjectType struct {
+	Name    string
+	Include *Include
+}
+
+// Types returns the object types of o in a fixed order.
+func (o *DumpObjects) Types() []ObjectType {
+	return []ObjectType{
+		{"views", &o.Views},
+		{"routines", &o.Routines},
+		{"triggers", &o.Triggers},
+		{"events", &o.Events},
+		{"grants", &o.Grants},
+	}
+}
+
+// Included returns the names of the object types set to include.
+func (o DumpObjects) Included(include Include) []string {
+	names := make([]string, 0)
+	for _, t := range o.Types() {
+		if *t.Include == include {
+			names = append(names, t.Name)
+		}
+	}
+	return names
+}
+
+// Content describes the part of the tables a dump holds, empty for both
+// their definitions and their rows.
+func (o DumpObjects) Content() string {
+	switch {
+	case o.SchemaOnly:
+		return "schema"
+	case o.DataOnly:
+		return "data"
+	}
+	return ""
+}
+
+// String lists what o changes from a dump of every object the engine
+// writing it dumps by default, e.g. "schema only, routines on".
+func (o DumpObjects) String() string {
+	parts := make([]string, 0)
+	if content := o.Content(); content != "" {
+		parts = append(parts, content+" only")
+	}
+	for _, t := range o.Types() {
+		if *t.Include != IncludeDefault {
+			parts = append(parts, t.Name+" "+t.Include.String())
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Check reports the objects the client tools of provider cannot dump as
+// asked, tables being true when the dump selects tables instead of whole
+// databases.
+func (o DumpObjects) Check(provider string, tables bool) error {
+	if o.SchemaOnly && o.DataOnly {
+		return fmt.Errorf("a dump cannot be both schema only and data only")
+	}
+	if on := o.Included(IncludeOn); o.DataOnly && len(on) > 0 {
+		return fmt.Errorf("a data only dump holds rows only, it cannot dump %s", strings.Join(on, ", "))
+	}
+	switch NormalizeProvider(provider) {
+	case "postgres":
+		if o.Events == IncludeOn {
+			return fmt.Errorf("postgres databases have no events")
+		}
+		if o.DataOnly {
+			return nil
+		}
+		// pg_dump writes the functions of whole databases only and the
+		// triggers of every table it dumps
+		if o.Routines == IncludeOn && tables {
+			return fmt.Errorf("pg_dump dumps routines with whole databases only, do not select tables")
+		}
+		if o.Routines == IncludeOff && !tables {
+			return fmt.Errorf("pg_dump cannot leave routines out of a whole database, select tables")
+		}
+		if o.Triggers == IncludeOff {
+			return fmt.Errorf("pg_dump cannot leave triggers out")
+		}
+	case "mysql":
+		if o.Views == IncludeOn && tables {
+			return fmt.Errorf("mysqldump dumps views with whole databases only, do not select tables")
+		}
+	}
+	return nil
+}
+
+// mysqldumpArgs returns the mysqldump options dumping the objects of o.
+func (o DumpObjects) mysqldumpArgs() []string {
+	args := make([]string, 0)
+	switch {
+	case o.SchemaOnly:
+		args = append(args, "--no-data")
+	case o.DataOnly:
+		// Triggers are part of the definition of their tables
+		return append(args, "--no-create-info", "--skip-triggers")
+	}
+	switch o.Routines {
+	case IncludeOn:
+		args = append(args, "--routines")
+	case IncludeOff:
+		args = append(args, "--skip-routines")
+	}
+	switch o.Triggers {
+	case IncludeOn:
+		args = append(args, "--triggers")
+	case IncludeOff:
+		args = append(args, "--skip-triggers")
+	}
+	switch o.Events {
+	case IncludeOn:
+		args = append(args, "--events")
+	case IncludeOff:
+		args = append(args, "--skip-events")
+	}
+	return args
+}
+
+// pgDumpArgs returns the pg_dump options dumping the objects of o.
+func (o DumpObjects) pgDumpArgs() []string {
+	args := make([]string, 0)
+	switch {
+	case o.SchemaOnly:
+		args = append(args, "--schema-only")
+	case o.DataOnly:
+		args = append(args, "--data-only")
+	}
+	return args
+}
+
+// mysqlGrants returns the GRANT statements of the accounts holding
+// privileges on database, leaving out their privileges on other databases.
+// mysqldump never dumps them, the accounts living in the mysql database.
+func mysqlGrants(ctx context.Context, conn config.StoredConnection, database string) ([]string, error) {
+	db, err := Open(conn, database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	grantees, err := queryStrings(ctx, db, `SELECT grantee FROM information_schema.schema_privileges WHERE table_schema = ?
+		UNION SELECT grantee FROM information_schema.table_privileges WHERE table_schema = ?
+		UNION SELECT grantee FROM information_schema.column_privileges WHERE table_schema = ?`, database, database, database)
+	if err != nil {
+		return nil, err
+	}
+	// Database names of grants escape the wildcards of LIKE
+	scopes := []string{
+		" ON `" + database + "`.",
+		" ON `" + strings.NewReplacer("_", `\_`, "%", `\%`).Replace(database) + "`.",
+	}
+	grants := make([]string, 0)
+	for _, grantee := range grantees {
+		// The grantee comes quoted, e.g. 'user'@'host'
+		lines, err := queryStrings(ctx, db, "SHOW GRANTS FOR "+grantee)
+		if err != nil {
+			return nil, fmt.Errorf("grants of %s: %w", grantee, err)
+		}
+		for _, line := range lines {
+			for _, scope := range scopes {
+				if strings.Contains(line, scope) {
+					grants = append(grants, line+";")
+					break
+				}
+			}
+		}
+	}
+	return grants, nil
+}
@@ -0,0 +1,183 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"seraphim/lib/config"
+	"strings"
+)
+
+// RowFilter restricts previewed rows on the value of a single column.
+// Op is "=" or "!=" for exact matches and "~" for a substring match.
+type RowFilter struct {
+	Column string
+	Op     string
+	Value  string
+}
+
+// ParseRowFilter parses filters written as column=value, column!=value or
+// column~text.
+func ParseRowFilter(text string) (RowFilter, error) {
+	i := strings.IndexAny(text, "=~!")
+	if i <= 0 {
+		return RowFilter{}, fmt.Errorf("invalid filter %q, expected column=value, column!=value or column~text", text)
+	}
+	f := RowFilter{Column: strings.TrimSpace(text[:i])}
+	rest := text[i:]
+	for _, op := range []string{"!=", "=", "~"} {
+		if strings.HasPrefix(rest, op) {
+			f.Op = op
+			f.Value = strings.TrimSpace(rest[len(op):])
+			return f, nil
+		}
+	}
+	return RowFilter{}, fmt.Errorf("invalid filter %q, expected column=value, column!=value or column~text", text)
+}
+
+func (f RowFilter) String() string {
+	return f.Column + " " + f.Op + " " + f.Value
+}
+
+// PageRequest selects a page of table rows. With a primary key the page
+// starts after the key values in After, otherwise Offset rows are skipped.
+type PageRequest struct {
+	Table  string
+	Filter *RowFilter
+	After  []any
+	Offset int
+	Limit  int
+}
+
+type Page struct {
+	Columns []ColumnInfo
+	Rows    [][]any
+	// Key lists the primary key columns the rows are ordered by, empty when
+	// the table has none and pages are offset based
+	Key     []string
+	HasMore bool
+}
+
+// NextAfter returns the key values of the last row, to be passed as After to
+// fetch the following page.
+func (p Page) NextAfter() []any {
+	if len(p.Rows) == 0 || len(p.Key) == 0 {
+		return nil
+	}
+	last := p.Rows[len(p.Rows)-1]
+	after := make([]any, len(p.Key))
+	for i, k := range p.Key {
+		for c, col := range p.Columns {
+			if col.Name == k {
+				after[i] = last[c]
+			}
+		}
+	}
+	return after
+}
+
+// KeyArg converts a scanned key value into a bind argument. Text scanned as
+// bytes would otherwise be sent as binary data, and would not survive a
+// round trip through JSON.
+func KeyArg(v any) any {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
+
+// FetchPage reads a page of rows of table using keyset pagination on the
+// primary key, so that deep pages cost the same as the first one.
+func FetchPage(ctx context.Context, conn config.StoredConnection, database string, req PageRequest) (Page, error) {
+	var page Page
+	db, err := Open(conn, database)
+	if err != nil {
+		return page, err
+	}
+	defer db.Close()
+
+	provider := NormalizeProvider(conn.Provider)
+	columns, err := listColumns(ctx, db, provider, database, req.Table)
+	if err != nil {
+		return page, err
+	}
+	if page.Key, err = primaryKey(ctx, db, provider, database, req.Table); err != nil {
+		return page, err
+	}
+
+	conditions := make([]string, 0, 2)
+	args := make([]any, 0)
+	if f := req.Filter; f != nil {
+		known := false
+		for _, c := range columns {
+			known = known || c.Name == f.Column
+		}
+		if !known {
+			return page, fmt.Errorf("unknown column %q", f.Column)
+		}
+		column := QuoteIdent(provider, f.Column)
+		switch f.Op {
+		case "~":
+			args = append(args, "%"+likeEscaper.Replace(f.Value)+"%")
+			conditions = append(conditions, castText(provider, column)+" LIKE "+Placeholder(provider, len(args))+likeEscape)
+		default:
+			args = append(args, f.Value)
+			operator := "="
+			if f.Op == "!=" {
+				operator = "<>"
+			}
+			conditions = append(conditions, castText(provider, column)+" "+operator+" "+Placeholder(provider, len(args)))
+		}
+	}
+
+	key := make([]string, len(page.Key))
+	for i, k := range page.Key {
+		key[i] = QuoteIdent(provider, k)
+	}
+	if len(key) > 0 && len(req.After) == len(key) {
+		markers := make([]string, len(key))
+		for i, v := range req.After {
+			args = append(args, KeyArg(v))
+			markers[i] = Placeholder(provider, len(args))
+		}
+		conditions = append(conditions, "("+strings.Join(key, ", ")+") > ("+strings.Join(markers, ", ")+")")
+	}
+
+	statement := "SELECT * FROM " + QuoteTable(provider, req.Table)
+	if len(conditions) > 0 {
+		statement += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if len(key) > 0 {
+		statement += " ORDER BY " + strings.Join(key, ", ")
+	}
+	// One extra row tells whether a next page exists
+	statement += fmt.Sprintf(" LIMIT %d", req.Limit+1)
+	if len(key) == 0 && req.Offset > 0 {
+		statement += fmt.Sprintf(" OFFSET %d", req.Offset)
+	}
+
+	rows, err := db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	if page.Columns, err = ColumnInfos(rows); err != nil {
+		return page, err
+	}
+	for rows.Next() {
+		if len(page.Rows) == req.Limit {
+			page.HasMore = true
+			break
+		}
+		values := make([]any, len(page.Columns))
+		dest := make([]any, len(values))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return page, err
+		}
+		page.Rows = append(page.Rows, values)
+	}
+	return page, rows.Err()
+}
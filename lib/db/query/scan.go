@@ -0,0 +1,116 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"seraphim/lib/config"
+	"strings"
+)
+
+// KeyRange selects rows by primary key: rows after From, exclusive, up to and
+// including To. A nil bound leaves that side of the range open.
+type KeyRange struct {
+	From []any
+	To   []any
+}
+
+// TableScanner reads the rows of a single table in primary key order over one
+// connection, one key range at a time.
+type TableScanner struct {
+	Table   string
+	Columns []Column
+	Key     []string
+
+	db       *sql.DB
+	provider string
+}
+
+// OpenTableScanner opens a connection to database and reads the columns and
+// primary key of table. Tables without a primary key cannot be scanned.
+func OpenTableScanner(ctx context.Context, conn config.StoredConnection, database string, table string) (*TableScanner, error) {
+	db, err := Open(conn, database)
+	if err != nil {
+		return nil, err
+	}
+	s := &TableScanner{Table: table, db: db, provider: NormalizeProvider(conn.Provider)}
+	if s.Columns, err = listColumns(ctx, db, s.provider, database, table); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if s.Key, err = primaryKey(ctx, db, s.provider, database, table); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if len(s.Key) == 0 {
+		db.Close()
+		return nil, fmt.Errorf("table %s has no primary key", table)
+	}
+	return s, nil
+}
+
+func (s *TableScanner) Close() error {
+	return s.db.Close()
+}
+
+// Scan streams the key columns followed by columns for every row in r, in key
+// order. fn receives the description of the result columns with each row and
+// must not keep the values slice, which is reused.
+func (s *TableScanner) Scan(ctx context.Context, columns []string, r KeyRange, fn func(columns []ColumnInfo, values []any) error) error {
+	key := make([]string, len(s.Key))
+	for i, k := range s.Key {
+		key[i] = QuoteIdent(s.provider, k)
+	}
+	selected := append([]string(nil), key...)
+	for _, c := range columns {
+		selected = append(selected, QuoteIdent(s.provider, c))
+	}
+
+	conditions := make([]string, 0, 2)
+	args := make([]any, 0, 2*len(key))
+	bound := func(operator string, values []any) {
+		markers := make([]string, len(values))
+		for i, v := range values {
+			args = append(args, KeyArg(v))
+			markers[i] = Placeholder(s.provider, len(args))
+		}
+		conditions = append(conditions, "("+strings.Join(key, ", ")+") "+operator+" ("+strings.Join(markers, ", ")+")")
+	}
+	if r.From != nil {
+		bound(">", r.From)
+	}
+	if r.To != nil {
+		bound("<=", r.To)
+	}
+
+	statement := "SELECT " + strings.Join(selected, ", ") + " FROM " + QuoteTable(s.provider, s.Table)
+	if len(conditions) > 0 {
+		statement += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	statement += " ORDER BY " + strings.Join(key, ", ")
+
+	rows, err := s.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	infos, err := ColumnInfos(rows)
+	if err != nil {
+		return err
+	}
+	values := make([]any, len(infos))
+	dest := make([]any, len(infos))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if err := fn(infos, values); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,411 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"seraphim/lib/config"
+	"sort"
+	"strings"
+)
+
+type Column struct {
+	Name     string
+	Type     string
+	Nullable bool
+	Default  sql.NullString
+	// Extra holds mysql column attributes such as auto_increment
+	Extra string
+}
+
+type Index struct {
+	Name    string
+	Unique  bool
+	Primary bool
+	Columns []string
+}
+
+type ForeignKey struct {
+	Name              string
+	Columns           []string
+	ReferencedTable   string
+	ReferencedColumns []string
+}
+
+// TableDetails describes the structure of a table. RowCount and Size are
+// estimates taken from the server statistics, -1 when unknown.
+type TableDetails struct {
+	Name        string
+	Columns     []Column
+	PrimaryKey  []string
+	Indexes     []Index
+	ForeignKeys []ForeignKey
+	RowCount    int64
+	Size        int64
+}
+
+// SplitTableName splits a possibly schema qualified Postgres table name.
+func SplitTableName(table string) (string, string) {
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		return schema, name
+	}
+	return "public", table
+}
+
+// ListColumns returns the columns of table in database, in ordinal order.
+func ListColumns(ctx context.Context, conn config.StoredConnection, database string, table string) ([]Column, error) {
+	db, err := Open(conn, database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return listColumns(ctx, db, NormalizeProvider(conn.Provider), database, table)
+}
+
+func listColumns(ctx context.Context, db *sql.DB, provider string, database string, table string) ([]Column, error) {
+	var rows *sql.Rows
+	var err error
+	switch provider {
+	case "mysql":
+		rows, err = db.QueryContext(ctx, `SELECT column_name, column_type, is_nullable = 'YES', column_default,
+				TRIM(REPLACE(extra, 'DEFAULT_GENERATED', ''))
+			FROM information_schema.columns
+			WHERE table_schema = ? AND table_name = ?
+			ORDER BY ordinal_position`, database, table)
+	case "postgres":
+		schema, name := SplitTableName(table)
+		// format_type keeps the length, precision and scale of the type
+		rows, err = db.QueryContext(ctx, `SELECT a.attname, format_type(a.atttypid, a.atttypmod),
+				NOT a.attnotnull, pg_get_expr(d.adbin, d.adrelid), ''
+			FROM pg_attribute a
+			JOIN pg_class c ON c.oid = a.attrelid
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+			LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+			WHERE n.nspname = $1 AND c.relname = $2 AND a.attnum > 0 AND NOT a.attisdropped
+			ORDER BY a.attnum`, schema, name)
+	case "sqlite":
+		rows, err = db.QueryContext(ctx, `SELECT name, type, "notnull" = 0, dflt_value, ''
+			FROM pragma_table_info(?) ORDER BY cid`, table)
+	default:
+		return nil, fmt.Errorf("unknown provider %q", provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make([]Column, 0)
+	for rows.Next() {
+		var c Column
+		if err := rows.Scan(&c.Name, &c.Type, &c.Nullable, &c.Default, &c.Extra); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s not found in %s", table, database)
+	}
+	return columns, nil
+}
+
+// primaryKey returns the primary key columns of table in key order, empty for
+// tables without a primary key.
+func primaryKey(ctx context.Context, db *sql.DB, provider string, database string, table string) ([]string, error) {
+	switch provider {
+	case "mysql":
+		return queryStrings(ctx, db, `SELECT column_name FROM information_schema.key_column_usage
+			WHERE table_schema = ? AND table_name = ? AND constraint_name = 'PRIMARY'
+			ORDER BY ordinal_position`, database, table)
+	case "postgres":
+		schema, name := SplitTableName(table)
+		return queryStrings(ctx, db, `SELECT a.attname
+			FROM pg_index ix
+			JOIN pg_class t ON t.oid = ix.indrelid
+			JOIN pg_namespace n ON n.oid = t.relnamespace
+			CROSS JOIN LATERAL unnest(ix.indkey::int2[]) WITH ORDINALITY AS k(attnum, ord)
+			JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+			WHERE ix.indisprimary AND n.nspname = $1 AND t.relname = $2
+			ORDER BY k.ord`, schema, name)
+	case "sqlite":
+		return queryStrings(ctx, db, `SELECT name FROM pragma_table_info(?) WHERE pk > 0 ORDER BY pk`, table)
+	}
+	return nil, fmt.Errorf("unknown provider %q", provider)
+}
+
+// DescribeTable collects the columns, keys, indexes and size statistics of
+// table in database.
+func DescribeTable(ctx context.Context, conn config.StoredConnection, database string, table string) (TableDetails, error) {
+	db, err := Open(conn, database)
+	if err != nil {
+		return TableDetails{Name: table, RowCount: -1, Size: -1}, err
+	}
+	defer db.Close()
+
+	return describeTable(ctx, db, NormalizeProvider(conn.Provider), database, table)
+}
+
+// DescribeDatabase describes every base table of database, ordered by name.
+func DescribeDatabase(ctx context.Context, conn config.StoredConnection, database string) ([]TableDetails, error) {
+	db, err := Open(conn, database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	provider := NormalizeProvider(conn.Provider)
+	tables, err := listTables(ctx, db, provider)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tables)
+	details := make([]TableDetails, 0, len(tables))
+	for _, table := range tables {
+		d, err := describeTable(ctx, db, provider, database, table)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", table, err)
+		}
+		details = append(details, d)
+	}
+	return details, nil
+}
+
+func describeTable(ctx context.Context, db *sql.DB, provider string, database string, table string) (TableDetails, error) {
+	details := TableDetails{Name: table, RowCount: -1, Size: -1}
+	var err error
+	if details.Columns, err = listColumns(ctx, db, provider, database, table); err != nil {
+		return details, err
+	}
+	if details.PrimaryKey, err = primaryKey(ctx, db, provider, database, table); err != nil {
+		return details, err
+	}
+	switch provider {
+	case "mysql":
+		err = describeMySQL(ctx, db, database, table, &details)
+	case "postgres":
+		err = describePostgres(ctx, db, table, &details)
+	case "sqlite":
+		err = describeSQLite(ctx, db, table, &details)
+	}
+	return details, err
+}
+
+// keyedRow is one column of a multi column index or foreign key. Rows of the
+// same key are consecutive and grouped by groupIndexes or groupForeignKeys.
+type keyedRow struct {
+	Key       string
+	Unique    bool
+	Primary   bool
+	Column    string
+	RefTable  string
+	RefColumn string
+}
+
+func groupIndexes(rows []keyedRow) []Index {
+	indexes := make([]Index, 0)
+	for _, r := range rows {
+		if n := len(indexes); n > 0 && indexes[n-1].Name == r.Key {
+			indexes[n-1].Columns = append(indexes[n-1].Columns, r.Column)
+			continue
+		}
+		indexes = append(indexes, Index{Name: r.Key, Unique: r.Unique, Primary: r.Primary, Columns: []string{r.Column}})
+	}
+	return indexes
+}
+
+func groupForeignKeys(rows []keyedRow) []ForeignKey {
+	fks := make([]ForeignKey, 0)
+	for _, r := range rows {
+		if n := len(fks); n > 0 && fks[n-1].Name == r.Key {
+			fks[n-1].Columns = append(fks[n-1].Columns, r.Column)
+			fks[n-1].ReferencedColumns = append(fks[n-1].ReferencedColumns, r.RefColumn)
+			continue
+		}
+		fks = append(fks, ForeignKey{
+			Name:              r.Key,
+			Columns:           []string{r.Column},
+			ReferencedTable:   r.RefTable,
+			ReferencedColumns: []string{r.RefColumn},
+		})
+	}
+	return fks
+}
+
+// queryKeyed runs query and scans every row into a keyedRow using the field
+// pointers returned by fields.
+func queryKeyed(ctx context.Context, db *sql.DB, fields func(*keyedRow) []any, query string, args ...any) ([]keyedRow, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]keyedRow, 0)
+	for rows.Next() {
+		var r keyedRow
+		if err := rows.Scan(fields(&r)...); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+func indexFields(r *keyedRow) []any {
+	return []any{&r.Key, &r.Unique, &r.Primary, &r.Column}
+}
+func fkFields(r *keyedRow) []any {
+	return []any{&r.Key, &r.Column, &r.RefTable, &r.RefColumn}
+}
+
+func describeMySQL(ctx context.Context, db *sql.DB, database string, table string, details *TableDetails) error {
+	indexes, err := queryKeyed(ctx, db, indexFields, `SELECT index_name, non_unique = 0, index_name = 'PRIMARY', column_name
+		FROM information_schema.statistics
+		WHERE table_schema = ? AND table_name = ?
+		ORDER BY index_name = 'PRIMARY' DESC, index_name, seq_in_index`, database, table)
+	if err != nil {
+		return err
+	}
+	details.Indexes = groupIndexes(indexes)
+
+	fks, err := queryKeyed(ctx, db, fkFields, `SELECT constraint_name, column_name,
+			CASE WHEN referenced_table_schema = table_schema THEN referenced_table_name
+				ELSE CONCAT(referenced_table_schema, '.', referenced_table_name) END,
+			referenced_column_name
+		FROM information_schema.key_column_usage
+		WHERE table_schema = ? AND table_name = ? AND referenced_table_name IS NOT NULL
+		ORDER BY constraint_name, ordinal_position`, database, table)
+	if err != nil {
+		return err
+	}
+	details.ForeignKeys = groupForeignKeys(fks)
+
+	var rowCount, size sql.NullInt64
+	err = db.QueryRowContext(ctx, `SELECT table_rows, data_length + index_length
+		FROM information_schema.tables
+		WHERE table_schema = ? AND table_name = ?`, database, table).Scan(&rowCount, &size)
+	if err != nil {
+		return err
+	}
+	if rowCount.Valid {
+		details.RowCount = rowCount.Int64
+	}
+	if size.Valid {
+		details.Size = size.Int64
+	}
+	return nil
+}
+
+func describePostgres(ctx context.Context, db *sql.DB, table string, details *TableDetails) error {
+	schema, name := SplitTableName(table)
+	indexes, err := queryKeyed(ctx, db, indexFields, `SELECT i.relname, ix.indisunique, ix.indisprimary, COALESCE(a.attname, '(expression)')
+		FROM pg_index ix
+		JOIN pg_class t ON t.oid = ix.indrelid
+		JOIN pg_class i ON i.oid = ix.indexrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		CROSS JOIN LATERAL unnest(ix.indkey::int2[]) WITH ORDINALITY AS k(attnum, ord)
+		LEFT JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+		WHERE n.nspname = $1 AND t.relname = $2
+		ORDER BY ix.indisprimary DESC, i.relname, k.ord`, schema, name)
+	if err != nil {
+		return err
+	}
+	details.Indexes = groupIndexes(indexes)
+
+	fks, err := queryKeyed(ctx, db, fkFields, `SELECT c.conname, a.attname,
+			CASE WHEN rn.nspname = 'public' THEN rt.relname ELSE rn.nspname || '.' || rt.relname END,
+			ra.attname
+		FROM pg_constraint c
+		JOIN pg_class t ON t.oid = c.conrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		JOIN pg_class rt ON rt.oid = c.confrelid
+		JOIN pg_namespace rn ON rn.oid = rt.relnamespace
+		CROSS JOIN LATERAL unnest(c.conkey, c.confkey) WITH ORDINALITY AS k(col, refcol, ord)
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.col
+		JOIN pg_attribute ra ON ra.attrelid = c.confrelid AND ra.attnum = k.refcol
+		WHERE c.contype = 'f' AND n.nspname = $1 AND t.relname = $2
+		ORDER BY c.conname, k.ord`, schema, name)
+	if err != nil {
+		return err
+	}
+	details.ForeignKeys = groupForeignKeys(fks)
+
+	// reltuples is -1 for tables that were never analyzed
+	return db.QueryRowContext(ctx, `SELECT GREATEST(c.reltuples, -1)::bigint, pg_total_relation_size(c.oid)
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relname = $2`, schema, name).Scan(&details.RowCount, &details.Size)
+}
+
+func describeSQLite(ctx context.Context, db *sql.DB, table string, details *TableDetails) error {
+	indexes, err := queryKeyed(ctx, db, indexFields, `SELECT il.name, il."unique", il.origin = 'pk', COALESCE(ii.name, '(expression)')
+		FROM pragma_index_list(?) il, pragma_index_info(il.name) ii
+		ORDER BY il.origin = 'pk' DESC, il.name, ii.seqno`, table)
+	if err != nil {
+		return err
+	}
+	details.Indexes = groupIndexes(indexes)
+
+	// sqlite foreign keys are anonymous, they are named after their id. A
+	// foreign key naming no column references the primary key
+	fks, err := queryKeyed(ctx, db, fkFields, `SELECT 'fk_' || fk.id, fk."from", fk."table",
+			COALESCE(fk."to", (SELECT ti.name FROM pragma_table_info(fk."table") ti WHERE ti.pk = fk.seq + 1), fk."from")
+		FROM pragma_foreign_key_list(?) fk ORDER BY fk.id, fk.seq`, table)
+	if err != nil {
+		return err
+	}
+	details.ForeignKeys = groupForeignKeys(fks)
+
+	// sqlite keeps no row estimate, counting is exact
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+QuoteIdent("sqlite", table)).Scan(&details.RowCount); err != nil {
+		return err
+	}
+	// dbstat is an optional compile time feature
+	var size sql.NullInt64
+	if err := db.QueryRowContext(ctx, "SELECT SUM(pgsize) FROM dbstat WHERE name = ?", table).Scan(&size); err == nil && size.Valid {
+		details.Size = size.Int64
+	}
+	return nil
+}
+
+// DependencyOrder sorts tables so that referenced tables come before the
+// tables referencing them. Tables in a reference cycle keep their order.
+func DependencyOrder(tables []TableDetails) []TableDetails {
+	selected := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		selected[t.Name] = true
+	}
+	ordered := make([]TableDetails, 0, len(tables))
+	placed := make(map[string]bool, len(tables))
+	for len(ordered) < len(tables) {
+		progress := false
+		for _, t := range tables {
+			if placed[t.Name] {
+				continue
+			}
+			ready := true
+			for _, fk := range t.ForeignKeys {
+				if fk.ReferencedTable != t.Name && selected[fk.ReferencedTable] && !placed[fk.ReferencedTable] {
+					ready = false
+				}
+			}
+			if ready {
+				ordered = append(ordered, t)
+				placed[t.Name] = true
+				progress = true
+			}
+		}
+		if !progress {
+			for _, t := range tables {
+				if !placed[t.Name] {
+					ordered = append(ordered, t)
+					placed[t.Name] = true
+				}
+			}
+		}
+	}
+	return ordered
+}
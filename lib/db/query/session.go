@@ -0,0 +1,241 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"seraphim/lib/config"
+	"strings"
+	"time"
+)
+
+// Session keeps a single server connection open so that session state such
+// as the current database and open transactions survive between statements.
+type Session struct {
+	Provider string
+	Database string
+
+	db   *sql.DB
+	conn *sql.Conn
+	tx   *sql.Tx
+}
+
+type StatementResult struct {
+	Columns      []string
+	Rows         [][]string
+	RowsAffected int64
+	Truncated    bool
+	Duration     time.Duration
+	Message      string
+}
+
+// NewSession connects to database on sc and pins a connection from the pool.
+func NewSession(ctx context.Context, sc config.StoredConnection, database string) (*Session, error) {
+	db, err := Open(sc, database)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &Session{
+		Provider: NormalizeProvider(sc.Provider),
+		Database: database,
+		db:       db,
+		conn:     conn,
+	}
+	if current, err := s.currentDatabase(ctx); err == nil {
+		s.Database = current
+	}
+	return s, nil
+}
+
+func (s *Session) currentDatabase(ctx context.Context) (string, error) {
+	statement := "SELECT DATABASE()"
+	switch s.Provider {
+	case "postgres":
+		statement = "SELECT current_database()"
+	case "sqlite":
+		return SQLiteDatabase, nil
+	}
+	var current sql.NullString
+	if err := s.conn.QueryRowContext(ctx, statement).Scan(&current); err != nil {
+		return "", err
+	}
+	return current.String, nil
+}
+
+func (s *Session) InTransaction() bool {
+	return s.tx != nil
+}
+
+func (s *Session) Begin(ctx context.Context) error {
+	if s.tx != nil {
+		return errors.New("a transaction is already in progress")
+	}
+	// The transaction outlives the statement beginning it, whose context
+	// would roll it back once cancelled
+	tx, err := s.conn.BeginTx(context.WithoutCancel(ctx), nil)
+	if err != nil {
+		return err
+	}
+	s.tx = tx
+	return nil
+}
+
+func (s *Session) Commit() error {
+	if s.tx == nil {
+		return errors.New("no transaction in progress")
+	}
+	err := s.tx.Commit()
+	s.tx = nil
+	return err
+}
+
+func (s *Session) Rollback() error {
+	if s.tx == nil {
+		return errors.New("no transaction in progress")
+	}
+	err := s.tx.Rollback()
+	s.tx = nil
+	return err
+}
+
+// Close rolls back any pending transaction and releases the connection.
+func (s *Session) Close() error {
+	if s.tx != nil {
+		s.tx.Rollback()
+		s.tx = nil
+	}
+	s.conn.Close()
+	return s.db.Close()
+}
+
+// returnsRows reports whether statement is expected to produce a result set.
+func returnsRows(statement string) bool {
+	fields := strings.Fields(strings.TrimLeft(statement, "( \t\n"))
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "SHOW", "WITH", "EXPLAIN", "DESCRIBE", "DESC", "VALUES", "TABLE", "PRAGMA", "CALL":
+		return true
+	}
+	return strings.Contains(strings.ToUpper(statement), " RETURNING ")
+}
+
+// Execute runs a single statement inside the current transaction, if any.
+// Transaction control statements are routed to Begin, Commit and Rollback so
+// that the session always knows whether a transaction is open. At most
+// maxRows rows are kept in the result.
+func (s *Session) Execute(ctx context.Context, statement string, maxRows int) (StatementResult, error) {
+	start := time.Now()
+	statement = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(statement), ";"))
+	keyword := strings.ToUpper(strings.Join(strings.Fields(statement), " "))
+
+	var r StatementResult
+	switch keyword {
+	case "BEGIN", "START TRANSACTION", "BEGIN TRANSACTION", "BEGIN WORK":
+		if err := s.Begin(ctx); err != nil {
+			return r, err
+		}
+		r.Message = "Transaction started"
+	case "COMMIT", "COMMIT WORK", "END":
+		if err := s.Commit(); err != nil {
+			return r, err
+		}
+		r.Message = "Transaction committed"
+	case "ROLLBACK", "ROLLBACK WORK", "ABORT":
+		if err := s.Rollback(); err != nil {
+			return r, err
+		}
+		r.Message = "Transaction rolled back"
+	default:
+		var err error
+		if returnsRows(statement) {
+			r, err = s.query(ctx, statement, maxRows)
+		} else {
+			r, err = s.exec(ctx, statement)
+		}
+		if err != nil {
+			return r, err
+		}
+		if s.tx == nil {
+			if current, err := s.currentDatabase(ctx); err == nil {
+				s.Database = current
+			}
+		}
+	}
+	r.Duration = time.Since(start)
+	return r, nil
+}
+
+func (s *Session) query(ctx context.Context, statement string, maxRows int) (StatementResult, error) {
+	var r StatementResult
+	var rows *sql.Rows
+	var err error
+	if s.tx != nil {
+		rows, err = s.tx.QueryContext(ctx, statement)
+	} else {
+		rows, err = s.conn.QueryContext(ctx, statement)
+	}
+	if err != nil {
+		return r, err
+	}
+	defer rows.Close()
+
+	if r.Columns, err = rows.Columns(); err != nil {
+		return r, err
+	}
+	values := make([]any, len(r.Columns))
+	dest := make([]any, len(r.Columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if maxRows > 0 && len(r.Rows) >= maxRows {
+			r.Truncated = true
+			break
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return r, err
+		}
+		row := make([]string, len(values))
+		for i, v := range values {
+			row[i] = FormatValue(v)
+		}
+		r.Rows = append(r.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return r, err
+	}
+	r.Message = fmt.Sprintf("%d row(s)", len(r.Rows))
+	if r.Truncated {
+		r.Message = fmt.Sprintf("first %d row(s), result truncated", len(r.Rows))
+	}
+	return r, nil
+}
+
+func (s *Session) exec(ctx context.Context, statement string) (StatementResult, error) {
+	var r StatementResult
+	var res sql.Result
+	var err error
+	if s.tx != nil {
+		res, err = s.tx.ExecContext(ctx, statement)
+	} else {
+		res, err = s.conn.ExecContext(ctx, statement)
+	}
+	if err != nil {
+		return r, err
+	}
+	if affected, err := res.RowsAffected(); err == nil {
+		r.RowsAffected = affected
+		r.Message = fmt.Sprintf("%d row(s) affected", affected)
+	} else {
+		r.Message = "OK"
+	}
+	return r, nil
+}
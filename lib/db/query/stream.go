@@ -0,0 +1,51 @@
+package query
+
+import (
+	"context"
+	"seraphim/lib/config"
+)
+
+// StreamQuery runs statement on database and hands every row to w as soon as
+// it is scanned, so that memory use does not grow with the result set. It
+// returns the number of rows written.
+func StreamQuery(ctx context.Context, conn config.StoredConnection, database string, statement string, w RowWriter) (int64, error) {
+	db, err := Open(conn, database)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, statement)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := ColumnInfos(rows)
+	if err != nil {
+		return 0, err
+	}
+	if err := w.WriteHeader(columns); err != nil {
+		return 0, err
+	}
+
+	var count int64
+	values := make([]any, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return count, err
+		}
+		if err := w.WriteRow(values); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	return count, w.Close()
+}
@@ -0,0 +1,164 @@
+package query
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// NullDisplay is how NULL values are rendered in human readable output.
+const NullDisplay = "NULL"
+
+type ValueKind int
+
+const (
+	KindText ValueKind = iota
+	KindInteger
+	KindDecimal
+	KindBool
+	KindDate
+	KindTime
+	KindTimestamp
+	KindTimestampTZ
+	KindBinary
+)
+
+type ColumnInfo struct {
+	Name         string
+	DatabaseType string
+	Kind         ValueKind
+}
+
+// ColumnKind classifies the type name reported by the mysql and postgres
+// drivers through sql.ColumnType.DatabaseTypeName.
+func ColumnKind(databaseType string) ValueKind {
+	t := strings.TrimPrefix(strings.ToUpper(databaseType), "UNSIGNED ")
+	switch t {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT", "YEAR",
+		"INT2", "INT4", "INT8", "SERIAL", "BIGSERIAL":
+		return KindInteger
+	case "DECIMAL", "NUMERIC", "FLOAT", "DOUBLE", "REAL", "FLOAT4", "FLOAT8":
+		return KindDecimal
+	case "BOOL", "BOOLEAN":
+		return KindBool
+	case "DATE":
+		return KindDate
+	case "TIME", "TIMETZ":
+		return KindTime
+	case "DATETIME", "TIMESTAMP":
+		return KindTimestamp
+	case "TIMESTAMPTZ":
+		return KindTimestampTZ
+	case "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BINARY", "VARBINARY", "BIT", "GEOMETRY", "BYTEA":
+		return KindBinary
+	}
+	return KindText
+}
+
+// ColumnInfos describes the columns of a result set.
+func ColumnInfos(rows *sql.Rows) ([]ColumnInfo, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]ColumnInfo, len(types))
+	for i, t := range types {
+		infos[i] = ColumnInfo{
+			Name:         t.Name(),
+			DatabaseType: t.DatabaseTypeName(),
+			Kind:         ColumnKind(t.DatabaseTypeName()),
+		}
+	}
+	return infos, nil
+}
+
+// FormatValue renders a value scanned into an `any` destination as text.
+// NULL becomes NullDisplay, binary data that is not valid UTF-8 is hex encoded
+// with a 0x prefix and times use RFC 3339.
+func FormatValue(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return NullDisplay
+	case []byte:
+		if utf8.Valid(val) {
+			return string(val)
+		}
+		return "0x" + hex.EncodeToString(val)
+	case string:
+		return val
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	case bool:
+		return strconv.FormatBool(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(val), 'f', -1, 32)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// FormatTypedValue renders v as text according to the kind of its column.
+// Binary columns are always hex encoded and times are formatted without
+// inventing a time zone for types that do not carry one. ok is false for
+// NULL.
+func FormatTypedValue(v any, kind ValueKind) (string, bool) {
+	if v == nil {
+		return "", false
+	}
+	switch val := v.(type) {
+	case []byte:
+		if kind == KindBinary {
+			return "0x" + hex.EncodeToString(val), true
+		}
+		return string(val), true
+	case time.Time:
+		switch kind {
+		case KindDate:
+			return val.Format("2006-01-02"), true
+		case KindTime:
+			return val.Format("15:04:05.999999"), true
+		case KindTimestamp:
+			return val.Format("2006-01-02 15:04:05.999999"), true
+		}
+		return val.Format(time.RFC3339Nano), true
+	}
+	return FormatValue(v), true
+}
+
+// JSONValue converts v into a value that encoding/json renders faithfully:
+// numbers stay numbers (decimals keep their exact digits), binary becomes
+// base64 and times become strings.
+func JSONValue(v any, kind ValueKind) any {
+	if v == nil {
+		return nil
+	}
+	text, _ := FormatTypedValue(v, kind)
+	switch kind {
+	case KindInteger, KindDecimal:
+		if _, err := strconv.ParseFloat(text, 64); err == nil && json.Valid([]byte(text)) {
+			return json.Number(text)
+		}
+	case KindBool:
+		if b, err := strconv.ParseBool(text); err == nil {
+			return b
+		}
+	case KindBinary:
+		if b, ok := v.([]byte); ok {
+			return b
+		}
+	}
+	switch val := v.(type) {
+	case int64, float64, float32, bool:
+		return val
+	}
+	return text
+}
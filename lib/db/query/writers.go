@@ -0,0 +1,371 @@
+package query
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// OutputFormats lists the formats accepted by NewRowWriter.
+var OutputFormats = []string{"table", "csv", "tsv", "json", "ndjson", "markdown"}
+
+type QuoteMode int
+
+const (
+	QuoteMinimal QuoteMode = iota
+	QuoteAll
+	QuoteNone
+)
+
+type WriterOptions struct {
+	// Delimiter separates CSV fields, defaults to ','
+	Delimiter rune
+	// Quote selects when CSV fields are quoted
+	Quote QuoteMode
+	// NoHeader skips the header row of delimited and tabular formats
+	NoHeader bool
+	// NullString is written for NULL in delimited formats, defaults to empty
+	NullString string
+	// CRLF terminates CSV lines with \r\n
+	CRLF bool
+}
+
+// RowWriter receives a result set one row at a time so that results never
+// have to be held in memory as a whole.
+type RowWriter interface {
+	WriteHeader(columns []ColumnInfo) error
+	WriteRow(values []any) error
+	Close() error
+}
+
+// NewRowWriter returns a RowWriter producing format on w.
+func NewRowWriter(format string, w io.Writer, opts WriterOptions) (RowWriter, error) {
+	bw := bufio.NewWriter(w)
+	switch format {
+	case "table", "":
+		return &tableWriter{w: bw, opts: opts}, nil
+	case "csv":
+		if opts.Delimiter == 0 {
+			opts.Delimiter = ','
+		}
+		return &csvWriter{w: bw, opts: opts}, nil
+	case "tsv":
+		return &tsvWriter{w: bw, opts: opts}, nil
+	case "json":
+		return &jsonWriter{w: bw}, nil
+	case "ndjson":
+		return &jsonWriter{w: bw, lines: true}, nil
+	case "markdown", "md":
+		return &markdownWriter{w: bw}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, expected one of %s", format, strings.Join(OutputFormats, ", "))
+}
+
+// tableBufferedRows is how many rows the aligned table reads ahead to size
+// its columns. Later rows keep those widths and simply overflow.
+const tableBufferedRows = 500
+
+type tableWriter struct {
+	w       *bufio.Writer
+	opts    WriterOptions
+	columns []ColumnInfo
+	widths  []int
+	pending [][]string
+	flushed bool
+}
+
+func (t *tableWriter) WriteHeader(columns []ColumnInfo) error {
+	t.columns = columns
+	t.widths = make([]int, len(columns))
+	for i, c := range columns {
+		t.widths[i] = runewidth.StringWidth(c.Name)
+	}
+	return nil
+}
+
+func (t *tableWriter) cells(values []any) []string {
+	cells := make([]string, len(values))
+	for i, v := range values {
+		text, ok := FormatTypedValue(v, t.columns[i].Kind)
+		if !ok {
+			text = NullDisplay
+		}
+		cells[i] = strings.NewReplacer("\r\n", "↵", "\n", "↵", "\t", " ").Replace(text)
+	}
+	return cells
+}
+
+func (t *tableWriter) WriteRow(values []any) error {
+	cells := t.cells(values)
+	if t.flushed {
+		return t.writeLine(cells)
+	}
+	for i, c := range cells {
+		if w := runewidth.StringWidth(c); w > t.widths[i] {
+			t.widths[i] = w
+		}
+	}
+	t.pending = append(t.pending, cells)
+	if len(t.pending) >= tableBufferedRows {
+		return t.flush()
+	}
+	return nil
+}
+
+func (t *tableWriter) writeLine(cells []string) error {
+	padded := make([]string, len(cells))
+	for i, c := range cells {
+		padded[i] = runewidth.FillRight(c, t.widths[i])
+	}
+	_, err := fmt.Fprintln(t.w, strings.TrimRight(strings.Join(padded, " | "), " "))
+	return err
+}
+
+func (t *tableWriter) flush() error {
+	t.flushed = true
+	if !t.opts.NoHeader {
+		names := make([]string, len(t.columns))
+		rules := make([]string, len(t.columns))
+		for i, c := range t.columns {
+			names[i] = c.Name
+			rules[i] = strings.Repeat("-", t.widths[i])
+		}
+		if err := t.writeLine(names); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(t.w, strings.Join(rules, "-+-")); err != nil {
+			return err
+		}
+	}
+	for _, cells := range t.pending {
+		if err := t.writeLine(cells); err != nil {
+			return err
+		}
+	}
+	t.pending = nil
+	return nil
+}
+
+func (t *tableWriter) Close() error {
+	if !t.flushed {
+		if err := t.flush(); err != nil {
+			return err
+		}
+	}
+	return t.w.Flush()
+}
+
+type csvWriter struct {
+	w       *bufio.Writer
+	opts    WriterOptions
+	columns []ColumnInfo
+}
+
+func (c *csvWriter) field(value string) string {
+	switch c.opts.Quote {
+	case QuoteNone:
+		return value
+	case QuoteMinimal:
+		// Empty strings are quoted to tell them from NULL
+		if value != "" && !strings.ContainsAny(value, string(c.opts.Delimiter)+"\"\r\n") && value[0] != ' ' && value[0] != '\t' {
+			return value
+		}
+	}
+	return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+}
+
+func (c *csvWriter) writeFields(fields []string) error {
+	for i, f := range fields {
+		if i > 0 {
+			c.w.WriteRune(c.opts.Delimiter)
+		}
+		c.w.WriteString(f)
+	}
+	if c.opts.CRLF {
+		_, err := c.w.WriteString("\r\n")
+		return err
+	}
+	return c.w.WriteByte('\n')
+}
+
+func (c *csvWriter) WriteHeader(columns []ColumnInfo) error {
+	c.columns = columns
+	if c.opts.NoHeader {
+		return nil
+	}
+	fields := make([]string, len(columns))
+	for i, col := range columns {
+		fields[i] = c.field(col.Name)
+	}
+	return c.writeFields(fields)
+}
+
+func (c *csvWriter) WriteRow(values []any) error {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		text, ok := FormatTypedValue(v, c.columns[i].Kind)
+		if !ok {
+			// NULL is never quoted so that it stays distinguishable from an
+			// empty string
+			fields[i] = c.opts.NullString
+			continue
+		}
+		fields[i] = c.field(text)
+	}
+	return c.writeFields(fields)
+}
+
+func (c *csvWriter) Close() error {
+	return c.w.Flush()
+}
+
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+type tsvWriter struct {
+	w       *bufio.Writer
+	opts    WriterOptions
+	columns []ColumnInfo
+}
+
+func (t *tsvWriter) WriteHeader(columns []ColumnInfo) error {
+	t.columns = columns
+	if t.opts.NoHeader {
+		return nil
+	}
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = tsvEscaper.Replace(c.Name)
+	}
+	_, err := fmt.Fprintln(t.w, strings.Join(names, "\t"))
+	return err
+}
+
+func (t *tsvWriter) WriteRow(values []any) error {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		text, ok := FormatTypedValue(v, t.columns[i].Kind)
+		if !ok {
+			fields[i] = t.opts.NullString
+			continue
+		}
+		fields[i] = tsvEscaper.Replace(text)
+	}
+	_, err := fmt.Fprintln(t.w, strings.Join(fields, "\t"))
+	return err
+}
+
+func (t *tsvWriter) Close() error {
+	return t.w.Flush()
+}
+
+type jsonWriter struct {
+	w       *bufio.Writer
+	lines   bool
+	columns []ColumnInfo
+	keys    [][]byte
+	count   int
+}
+
+func (j *jsonWriter) WriteHeader(columns []ColumnInfo) error {
+	j.columns = columns
+	j.keys = make([][]byte, len(columns))
+	for i, c := range columns {
+		key, err := json.Marshal(c.Name)
+		if err != nil {
+			return err
+		}
+		j.keys[i] = key
+	}
+	if !j.lines {
+		_, err := j.w.WriteString("[")
+		return err
+	}
+	return nil
+}
+
+// WriteRow writes an object keeping the column order of the result set.
+func (j *jsonWriter) WriteRow(values []any) error {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.Write(j.keys[i])
+		b.WriteByte(':')
+		value, err := json.Marshal(JSONValue(v, j.columns[i].Kind))
+		if err != nil {
+			return err
+		}
+		b.Write(value)
+	}
+	b.WriteByte('}')
+
+	switch {
+	case j.lines:
+		b.WriteByte('\n')
+	case j.count == 0:
+		j.w.WriteString("\n  ")
+	default:
+		j.w.WriteString(",\n  ")
+	}
+	j.count++
+	_, err := j.w.Write(b.Bytes())
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	if !j.lines {
+		if j.count > 0 {
+			j.w.WriteString("\n")
+		}
+		j.w.WriteString("]\n")
+	}
+	return j.w.Flush()
+}
+
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>")
+
+type markdownWriter struct {
+	w       *bufio.Writer
+	columns []ColumnInfo
+}
+
+func (m *markdownWriter) WriteHeader(columns []ColumnInfo) error {
+	m.columns = columns
+	names := make([]string, len(columns))
+	rules := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = markdownEscaper.Replace(c.Name)
+		rules[i] = "---"
+		if c.Kind == KindInteger || c.Kind == KindDecimal {
+			rules[i] = "--:"
+		}
+	}
+	_, err := fmt.Fprintf(m.w, "| %s |\n| %s |\n", strings.Join(names, " | "), strings.Join(rules, " | "))
+	return err
+}
+
+func (m *markdownWriter) WriteRow(values []any) error {
+	cells := make([]string, len(values))
+	for i, v := range values {
+		text, ok := FormatTypedValue(v, m.columns[i].Kind)
+		if !ok {
+			text = "*" + NullDisplay + "*"
+		} else {
+			text = markdownEscaper.Replace(text)
+		}
+		cells[i] = text
+	}
+	_, err := fmt.Fprintf(m.w, "| %s |\n", strings.Join(cells, " | "))
+	return err
+}
+
+func (m *markdownWriter) Close() error {
+	return m.w.Flush()
+}
@@ -0,0 +1,511 @@
+// Package dbcopy copies tables between stored connections, creating missing
+// target tables and streaming rows in batches written by parallel workers.
+package dbcopy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"seraphim/lib/config"
+	dh "seraphim/lib/db/query"
+	"seraphim/lib/schemadiff"
+	"seraphim/lib/typemap"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Modes of writing rows into target tables that already hold data.
+const (
+	ModeAppend   = "append"
+	ModeTruncate = "truncate"
+	ModeUpsert   = "upsert"
+)
+
+var Modes = []string{ModeAppend, ModeTruncate, ModeUpsert}
+
+// Endpoint is a database on a stored connection.
+type Endpoint struct {
+	Conn     config.StoredConnection
+	Database string
+}
+
+func (e Endpoint) provider() string {
+	return dh.NormalizeProvider(e.Conn.Provider)
+}
+
+type Options struct {
+	Mode      string
+	BatchSize int
+	// Parallel is the number of workers writing the batches of a table
+	Parallel int
+}
+
+type TableResult struct {
+	Table    string
+	Rows     int64
+	Created  bool
+	Duration time.Duration
+	Warnings []string
+}
+
+// Plan describes a copy before anything is written: the tables in copy
+// order, the definitions of the tables missing on the target and the lossy
+// conversions creating them involves.
+type Plan struct {
+	From   Endpoint
+	To     Endpoint
+	Tables []dh.TableDetails
+	// Create maps the tables missing on the target to their definition for
+	// the target provider
+	Create map[string]dh.TableDetails
+	Issues []typemap.Issue
+
+	// existing tracks the tables present on the target once created
+	existing map[string]bool
+}
+
+// NewPlan describes tables on the source and checks which ones exist on the
+// target. Missing tables are translated for the provider of the target.
+func NewPlan(ctx context.Context, from Endpoint, to Endpoint, tables []string) (*Plan, error) {
+	p := &Plan{From: from, To: to, Create: make(map[string]dh.TableDetails), Issues: make([]typemap.Issue, 0), existing: make(map[string]bool)}
+	targetTables, err := dh.ListTables(ctx, to.Conn, to.Database)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range targetTables {
+		p.existing[t] = true
+	}
+
+	details := make([]dh.TableDetails, 0, len(tables))
+	for _, table := range tables {
+		d, err := dh.DescribeTable(ctx, from.Conn, from.Database, table)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", table, err)
+		}
+		details = append(details, d)
+	}
+	p.Tables = dh.DependencyOrder(details)
+
+	mapper := typemap.New(from.provider(), to.provider())
+	for _, d := range p.Tables {
+		if p.existing[d.Name] {
+			continue
+		}
+		mapped, issues := mapper.Table(d)
+		p.Create[d.Name] = mapped
+		p.Issues = append(p.Issues, issues...)
+	}
+	for _, d := range p.Tables {
+		p.existing[d.Name] = true
+	}
+	return p, nil
+}
+
+// copier holds the state shared by the tables of one copy.
+type copier struct {
+	from, to Endpoint
+	source   *sql.DB
+	target   *sql.DB
+	opts     Options
+	existing map[string]bool
+}
+
+// Copy executes plan. Tables missing on the target are created first, then
+// rows are copied parents first so that foreign keys of existing target
+// tables are satisfied. done is called as soon as a table has been copied.
+func Copy(ctx context.Context, plan *Plan, opts Options, done func(TableResult)) error {
+	if opts.BatchSize <= 0 || opts.Parallel <= 0 {
+		return fmt.Errorf("the batch size and parallelism must be positive")
+	}
+	switch opts.Mode {
+	case ModeAppend, ModeTruncate, ModeUpsert:
+	default:
+		return fmt.Errorf("unknown mode %q, expected one of %s", opts.Mode, strings.Join(Modes, ", "))
+	}
+	from, to := plan.From, plan.To
+	// sqlite allows a single writer at a time
+	if to.provider() == "sqlite" {
+		opts.Parallel = 1
+	}
+
+	c := &copier{from: from, to: to, opts: opts, existing: plan.existing}
+	var err error
+	if c.source, err = dh.Open(from.Conn, from.Database); err != nil {
+		return err
+	}
+	defer c.source.Close()
+	if c.target, err = dh.Open(to.Conn, to.Database); err != nil {
+		return err
+	}
+	defer c.target.Close()
+	c.target.SetMaxOpenConns(opts.Parallel)
+
+	details := plan.Tables
+	created := make(map[string]bool)
+	for _, d := range details {
+		definition, ok := plan.Create[d.Name]
+		if !ok {
+			continue
+		}
+		if err := c.createTable(ctx, definition); err != nil {
+			return fmt.Errorf("could not create %s: %w", d.Name, err)
+		}
+		created[d.Name] = true
+	}
+
+	if opts.Mode == ModeTruncate {
+		// Children first, so that no foreign key blocks the deletes
+		for i := len(details) - 1; i >= 0; i-- {
+			if created[details[i].Name] {
+				continue
+			}
+			if _, err := c.target.ExecContext(ctx, "DELETE FROM "+dh.QuoteTable(to.provider(), details[i].Name)); err != nil {
+				return fmt.Errorf("could not empty %s: %w", details[i].Name, err)
+			}
+		}
+	}
+
+	for _, d := range details {
+		start := time.Now()
+		result, err := c.copyTable(ctx, d)
+		if err != nil {
+			return fmt.Errorf("could not copy %s: %w", d.Name, err)
+		}
+		if err := c.advanceSequences(ctx, d); err != nil {
+			return fmt.Errorf("could not advance the sequences of %s: %w", d.Name, err)
+		}
+		result.Created = created[d.Name]
+		result.Duration = time.Since(start)
+		done(result)
+	}
+
+	return c.addForeignKeys(ctx, details, created)
+}
+
+func (c *copier) createTable(ctx context.Context, d dh.TableDetails) error {
+	// Inline foreign keys must only reference tables that will exist
+	d.ForeignKeys = c.resolvableForeignKeys(d)
+	for _, statement := range schemadiff.CreateTableDDL(c.to.provider(), d) {
+		if strings.HasPrefix(statement, "--") {
+			continue
+		}
+		if _, err := c.target.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolvableForeignKeys returns the foreign keys of d whose referenced table
+// exists on the target or is part of the copy.
+func (c *copier) resolvableForeignKeys(d dh.TableDetails) []dh.ForeignKey {
+	fks := make([]dh.ForeignKey, 0, len(d.ForeignKeys))
+	for _, fk := range d.ForeignKeys {
+		if c.existing[fk.ReferencedTable] || fk.ReferencedTable == d.Name {
+			fks = append(fks, fk)
+		}
+	}
+	return fks
+}
+
+// addForeignKeys adds the foreign keys of the created tables once their rows
+// are in place. sqlite tables got them on creation.
+func (c *copier) addForeignKeys(ctx context.Context, details []dh.TableDetails, created map[string]bool) error {
+	for _, d := range details {
+		if !created[d.Name] {
+			continue
+		}
+		for _, fk := range c.resolvableForeignKeys(d) {
+			statement := schemadiff.ForeignKeyDDL(c.to.provider(), d.Name, fk)
+			if statement == "" {
+				continue
+			}
+			if _, err := c.target.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("could not add foreign key %s to %s: %w", fk.Name, d.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// advanceSequences moves the postgres sequences and mysql AUTO_INCREMENT
+// counters of the target table of d past the ids it was filled with,
+// explicit ids not advancing postgres sequences. sqlite numbers new rows
+// from the largest id.
+func (c *copier) advanceSequences(ctx context.Context, d dh.TableDetails) error {
+	provider := c.to.provider()
+	if provider == "sqlite" {
+		return nil
+	}
+	columns, err := dh.ListColumns(ctx, c.to.Conn, c.to.Database, d.Name)
+	if err != nil {
+		return err
+	}
+	table := dh.QuoteTable(provider, d.Name)
+	for _, col := range columns {
+		column := dh.QuoteIdent(provider, col.Name)
+		switch provider {
+		case "postgres":
+			base := strings.SplitN(col.Type, "(", 2)[0]
+			if dh.ColumnKind(base) != dh.KindInteger {
+				continue
+			}
+			// Serial and identity columns own a sequence
+			var sequence sql.NullString
+			if err := c.target.QueryRowContext(ctx, "SELECT pg_get_serial_sequence($1, $2)", table, col.Name).Scan(&sequence); err != nil {
+				return err
+			}
+			if !sequence.Valid {
+				continue
+			}
+			statement := fmt.Sprintf("SELECT setval($1, MAX(%s)) FROM %s HAVING MAX(%s) IS NOT NULL", column, table, column)
+			if _, err := c.target.ExecContext(ctx, statement, sequence.String); err != nil {
+				return err
+			}
+		case "mysql":
+			if !strings.Contains(strings.ToLower(col.Extra), "auto_increment") {
+				continue
+			}
+			var max sql.NullInt64
+			if err := c.target.QueryRowContext(ctx, fmt.Sprintf("SELECT MAX(%s) FROM %s", column, table)).Scan(&max); err != nil {
+				return err
+			}
+			if !max.Valid {
+				continue
+			}
+			if _, err := c.target.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s AUTO_INCREMENT = %d", table, max.Int64+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// insertColumns matches the source columns of d with the writable columns of
+// the target table by name, ignoring case.
+func (c *copier) insertColumns(ctx context.Context, d dh.TableDetails) ([]string, []string, []string, error) {
+	targetColumns, err := dh.ListColumns(ctx, c.to.Conn, c.to.Database, d.Name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	names := make(map[string]string, len(targetColumns))
+	for _, col := range targetColumns {
+		// Generated columns are computed by the target
+		if !strings.Contains(strings.ToUpper(col.Extra), "GENERATED") {
+			names[strings.ToLower(col.Name)] = col.Name
+		}
+	}
+	source, target, warnings := make([]string, 0), make([]string, 0), make([]string, 0)
+	for _, col := range d.Columns {
+		name, ok := names[strings.ToLower(col.Name)]
+		if !ok || strings.Contains(strings.ToUpper(col.Extra), "GENERATED") {
+			warnings = append(warnings, fmt.Sprintf("column %s is not copied, it is missing or generated on the target", col.Name))
+			continue
+		}
+		source = append(source, col.Name)
+		target = append(target, name)
+	}
+	if len(source) == 0 {
+		return nil, nil, nil, fmt.Errorf("no column in common with the target table")
+	}
+	return source, target, warnings, nil
+}
+
+// insertStatement returns the statement inserting rows rows of columns into
+// table, with the conflict clause of the upsert mode.
+func (c *copier) insertStatement(table string, columns []string, key []string, rows int) string {
+	provider := c.to.provider()
+	statement := dh.InsertStatement(provider, table, columns, rows)
+	if c.opts.Mode != ModeUpsert {
+		return statement
+	}
+
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = dh.QuoteIdent(provider, col)
+	}
+	isKey := make(map[string]bool, len(key))
+	quotedKey := make([]string, len(key))
+	for i, k := range key {
+		isKey[strings.ToLower(k)] = true
+		quotedKey[i] = dh.QuoteIdent(provider, k)
+	}
+	updates := make([]string, 0, len(columns))
+	for i, col := range columns {
+		if isKey[strings.ToLower(col)] {
+			continue
+		}
+		if provider == "mysql" {
+			updates = append(updates, quoted[i]+" = VALUES("+quoted[i]+")")
+		} else {
+			updates = append(updates, quoted[i]+" = excluded."+quoted[i])
+		}
+	}
+	switch {
+	case provider == "mysql" && len(updates) == 0:
+		// Only key columns, rewriting the first one keeps existing rows
+		return statement + " ON DUPLICATE KEY UPDATE " + quotedKey[0] + " = " + quotedKey[0]
+	case provider == "mysql":
+		return statement + " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+	case len(updates) == 0:
+		return statement + " ON CONFLICT (" + strings.Join(quotedKey, ", ") + ") DO NOTHING"
+	default:
+		return statement + " ON CONFLICT (" + strings.Join(quotedKey, ", ") + ") DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+}
+
+// targetKey maps the primary key of the source table to target column names.
+func targetKey(d dh.TableDetails, source []string, target []string) ([]string, error) {
+	key := make([]string, 0, len(d.PrimaryKey))
+	for _, k := range d.PrimaryKey {
+		for i, col := range source {
+			if strings.EqualFold(col, k) {
+				key = append(key, target[i])
+			}
+		}
+	}
+	if len(key) == 0 || len(key) != len(d.PrimaryKey) {
+		return nil, fmt.Errorf("upsert needs a primary key copied to the target")
+	}
+	return key, nil
+}
+
+// bindValue converts a scanned value into an argument for the target. Text
+// scanned as bytes would otherwise be written as binary data, and sqlite
+// stores times as text in the format of their column type.
+func bindValue(v any, kind dh.ValueKind, provider string) any {
+	switch val := v.(type) {
+	case []byte:
+		if kind != dh.KindBinary {
+			return string(val)
+		}
+	case time.Time:
+		// Times of day read as timestamps would be written with a date
+		if provider == "sqlite" || kind == dh.KindTime {
+			text, _ := dh.FormatTypedValue(val, kind)
+			return text
+		}
+	}
+	return v
+}
+
+func (c *copier) copyTable(ctx context.Context, d dh.TableDetails) (TableResult, error) {
+	result := TableResult{Table: d.Name}
+	source, target, warnings, err := c.insertColumns(ctx, d)
+	if err != nil {
+		return result, err
+	}
+	result.Warnings = warnings
+	var key []string
+	if c.opts.Mode == ModeUpsert {
+		if key, err = targetKey(d, source, target); err != nil {
+			return result, err
+		}
+	}
+
+	batchSize := c.opts.BatchSize
+	if limit := dh.MaxParameters[c.to.provider()] / len(target); batchSize > limit {
+		batchSize = limit
+	}
+	fullStatement := c.insertStatement(d.Name, target, key, batchSize)
+
+	// Rows referencing rows of their own table are written in key order by
+	// a single worker so that parents are inserted first
+	workers := c.opts.Parallel
+	for _, fk := range d.ForeignKeys {
+		if fk.ReferencedTable == d.Name {
+			workers = 1
+		}
+	}
+	group, ctx := errgroup.WithContext(ctx)
+	batches := make(chan [][]any, workers)
+	var rows int64
+	for w := 0; w < workers; w++ {
+		group.Go(func() error {
+			for batch := range batches {
+				statement := fullStatement
+				if len(batch) != batchSize {
+					statement = c.insertStatement(d.Name, target, key, len(batch))
+				}
+				args := make([]any, 0, len(batch)*len(target))
+				for _, row := range batch {
+					args = append(args, row...)
+				}
+				if _, err := c.target.ExecContext(ctx, statement, args...); err != nil {
+					return err
+				}
+				atomic.AddInt64(&rows, int64(len(batch)))
+			}
+			return nil
+		})
+	}
+
+	group.Go(func() error {
+		defer close(batches)
+		quoted := make([]string, len(source))
+		for i, col := range source {
+			quoted[i] = dh.QuoteIdent(c.from.provider(), col)
+		}
+		statement := "SELECT " + strings.Join(quoted, ", ") + " FROM " + dh.QuoteTable(c.from.provider(), d.Name)
+		if len(d.PrimaryKey) > 0 {
+			key := make([]string, len(d.PrimaryKey))
+			for i, k := range d.PrimaryKey {
+				key[i] = dh.QuoteIdent(c.from.provider(), k)
+			}
+			// Key order keeps self referencing rows after their parents in
+			// the common case of increasing keys
+			statement += " ORDER BY " + strings.Join(key, ", ")
+		}
+		cursor, err := c.source.QueryContext(ctx, statement)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close()
+		infos, err := dh.ColumnInfos(cursor)
+		if err != nil {
+			return err
+		}
+
+		batch := make([][]any, 0, batchSize)
+		for cursor.Next() {
+			values := make([]any, len(infos))
+			dest := make([]any, len(infos))
+			for i := range values {
+				dest[i] = &values[i]
+			}
+			if err := cursor.Scan(dest...); err != nil {
+				return err
+			}
+			for i, v := range values {
+				values[i] = bindValue(v, infos[i].Kind, c.to.provider())
+			}
+			batch = append(batch, values)
+			if len(batch) == batchSize {
+				select {
+				case batches <- batch:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				batch = make([][]any, 0, batchSize)
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			return err
+		}
+		if len(batch) > 0 {
+			select {
+			case batches <- batch:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	err = group.Wait()
+	result.Rows = atomic.LoadInt64(&rows)
+	return result, err
+}
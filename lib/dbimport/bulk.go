@@ -0,0 +1,97 @@
+package dbimport
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	dh "seraphim/lib/db/query"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// loadDataEscaper escapes values for the default field and line terminators
+// of LOAD DATA.
+var loadDataEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`, "\x00", `\0`)
+
+// errLoadIncomplete fails a LOAD DATA that lost or altered rows, the bulk
+// load itself working.
+var errLoadIncomplete = errors.New("rows were not loaded as sent")
+
+// readerID numbers the reader handlers registered with the mysql driver.
+var readerID atomic.Int64
+
+// loadData streams rows to LOAD DATA LOCAL INFILE. The server turns errors
+// into warnings with LOCAL, so a batch loading fewer rows than sent or
+// raising warnings fails to have its rows inserted one by one instead.
+func (imp *importer) loadData(ctx context.Context, tx *sql.Tx, rows [][]any) error {
+	var buf bytes.Buffer
+	for _, row := range rows {
+		for i, v := range row {
+			if i > 0 {
+				buf.WriteByte('\t')
+			}
+			switch val := v.(type) {
+			case nil:
+				buf.WriteString(`\N`)
+			case []byte:
+				buf.WriteString(loadDataEscaper.Replace(string(val)))
+			default:
+				buf.WriteString(loadDataEscaper.Replace(fmt.Sprint(val)))
+			}
+		}
+		buf.WriteByte('\n')
+	}
+
+	name := fmt.Sprintf("seraphim-import-%d", readerID.Add(1))
+	mysql.RegisterReaderHandler(name, func() io.Reader { return bytes.NewReader(buf.Bytes()) })
+	defer mysql.DeregisterReaderHandler(name)
+
+	quoted := make([]string, len(imp.columns))
+	for i, c := range imp.columns {
+		quoted[i] = dh.QuoteIdent(imp.provider, c.target)
+	}
+	res, err := tx.ExecContext(ctx, fmt.Sprintf("LOAD DATA LOCAL INFILE 'Reader::%s' INTO TABLE %s CHARACTER SET utf8mb4 (%s)",
+		name, dh.QuoteTable(imp.provider, imp.table), strings.Join(quoted, ", ")))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n != int64(len(rows)) {
+		return fmt.Errorf("%w: %d of %d row(s) loaded", errLoadIncomplete, n, len(rows))
+	}
+	// Values the server truncated or converted are loaded all the same
+	var warnings int64
+	if err := tx.QueryRowContext(ctx, "SHOW COUNT(*) WARNINGS").Scan(&warnings); err != nil {
+		return err
+	}
+	if warnings > 0 {
+		return fmt.Errorf("%w: %d warning(s) loading %d row(s)", errLoadIncomplete, warnings, len(rows))
+	}
+	return nil
+}
+
+// copyIn streams rows to COPY FROM STDIN.
+func (imp *importer) copyIn(ctx context.Context, tx *sql.Tx, rows [][]any) error {
+	columns := make([]string, len(imp.columns))
+	for i, c := range imp.columns {
+		columns[i] = c.target
+	}
+	schema, name := dh.SplitTableName(imp.table)
+	stmt, err := tx.PrepareContext(ctx, pq.CopyInSchema(schema, name, columns...))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return err
+		}
+	}
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
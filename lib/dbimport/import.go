@@ -0,0 +1,449 @@
+// Package dbimport loads CSV and NDJSON files into a table, creating it with
+// inferred column types when asked, and reports the rows it rejects.
+package dbimport
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"seraphim/lib/config"
+	dh "seraphim/lib/db/query"
+	"seraphim/lib/schemadiff"
+	"seraphim/lib/typemap"
+	"strings"
+	"time"
+)
+
+type Options struct {
+	// Format is one of Formats
+	Format string
+	// Delimiter separates CSV fields, defaults to ','
+	Delimiter rune
+	// NullString is read as NULL in CSV files
+	NullString string
+	// Mapping renames columns of the file into columns of the table, an
+	// empty name skips the column
+	Mapping map[string]string
+	// Create creates a missing table with column types inferred from the
+	// first rows of the file
+	Create bool
+	// BatchSize is the number of rows loaded per transaction
+	BatchSize int
+	// Bulk loads batches with LOAD DATA LOCAL INFILE on mysql and COPY on
+	// postgres, falling back to INSERT when they fail
+	Bulk bool
+	// Rejects receives the rejected records as NDJSON, may be nil
+	Rejects io.Writer
+}
+
+type Result struct {
+	Table    string
+	Rows     int64
+	Rejected int64
+	Created  bool
+	// Method tells how the rows were loaded
+	Method   string
+	Duration time.Duration
+	Warnings []string
+	// Issues are the lossy conversions of the inferred types when the table
+	// was created
+	Issues []typemap.Issue
+}
+
+// rejection is a line of the rejects file.
+type rejection struct {
+	Line   int    `json:"line"`
+	Error  string `json:"error"`
+	Record any    `json:"record"`
+}
+
+// column is a column of the file loaded into the table.
+type column struct {
+	index  int
+	target string
+	bool   bool
+	binary bool
+}
+
+// importer holds the state of one import.
+type importer struct {
+	provider string
+	database string
+	table    string
+	db       *sql.DB
+	opts     Options
+	columns  []column
+	bulk     bool
+	result   *Result
+	rejects  *json.Encoder
+}
+
+// Import loads the records read from r into table of database.
+func Import(ctx context.Context, conn config.StoredConnection, database string, table string, r io.Reader, opts Options) (Result, error) {
+	start := time.Now()
+	result := Result{Table: table, Method: "insert", Warnings: make([]string, 0)}
+	if opts.BatchSize <= 0 {
+		return result, fmt.Errorf("the batch size must be positive")
+	}
+	reader, err := NewReader(opts.Format, r, opts.Delimiter, opts.NullString)
+	if err != nil {
+		return result, err
+	}
+
+	imp := &importer{provider: dh.NormalizeProvider(conn.Provider), database: database, table: table, opts: opts, result: &result}
+	if opts.Rejects != nil {
+		imp.rejects = json.NewEncoder(opts.Rejects)
+	}
+	if imp.db, err = dh.Open(conn, database); err != nil {
+		return result, err
+	}
+	defer imp.db.Close()
+
+	names, err := imp.fileColumns(reader.Columns())
+	if err != nil {
+		return result, err
+	}
+
+	tables, err := dh.ListTables(ctx, conn, database)
+	if err != nil {
+		return result, err
+	}
+	exists := false
+	for _, t := range tables {
+		exists = exists || t == table
+	}
+
+	// The records read to infer types are loaded afterwards
+	pending := make([]Record, 0)
+	if !exists {
+		if !opts.Create {
+			return result, fmt.Errorf("table %s does not exist in %s, pass --create to create it", table, database)
+		}
+		for len(pending) < inferSample {
+			record, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return result, err
+			}
+			pending = append(pending, record)
+		}
+		if err := imp.createTable(ctx, names, pending); err != nil {
+			return result, fmt.Errorf("could not create %s: %w", table, err)
+		}
+		result.Created = true
+	}
+
+	targetColumns, err := dh.ListColumns(ctx, conn, database, table)
+	if err != nil {
+		return result, err
+	}
+	if err := imp.matchColumns(names, targetColumns); err != nil {
+		return result, err
+	}
+	imp.bulk = opts.Bulk && (imp.provider == "mysql" || imp.provider == "postgres")
+	if imp.bulk {
+		result.Method = map[string]string{"mysql": "load data", "postgres": "copy"}[imp.provider]
+	}
+
+	batchSize := opts.BatchSize
+	if limit := dh.MaxParameters[imp.provider] / len(imp.columns); batchSize > limit {
+		batchSize = limit
+	}
+	batch := make([]Record, 0, batchSize)
+	next := func() (Record, error) {
+		if len(pending) > 0 {
+			record := pending[0]
+			pending = pending[1:]
+			return record, nil
+		}
+		return reader.Next()
+	}
+	for {
+		record, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		if record.Err != nil {
+			if err := imp.reject(record, record.Err); err != nil {
+				return result, err
+			}
+			continue
+		}
+		batch = append(batch, record)
+		if len(batch) == batchSize {
+			if err := imp.load(ctx, batch); err != nil {
+				return result, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := imp.load(ctx, batch); err != nil {
+			return result, err
+		}
+	}
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+// fileColumns returns the table column each column of the file is loaded
+// into, empty for skipped columns.
+func (imp *importer) fileColumns(header []string) ([]string, error) {
+	names := make([]string, len(header))
+	seen := make(map[string]bool, len(header))
+	for i, h := range header {
+		names[i] = h
+		if target, ok := imp.opts.Mapping[h]; ok {
+			names[i] = target
+		}
+		if names[i] == "" {
+			continue
+		}
+		if seen[strings.ToLower(names[i])] {
+			return nil, fmt.Errorf("column %s is loaded twice, skip one with --map %s=", names[i], h)
+		}
+		seen[strings.ToLower(names[i])] = true
+	}
+	for from := range imp.opts.Mapping {
+		found := false
+		for _, h := range header {
+			found = found || h == from
+		}
+		if !found {
+			return nil, fmt.Errorf("mapped column %s is not in the file", from)
+		}
+	}
+	return names, nil
+}
+
+// createTable creates the table with the loaded columns of the file, typed
+// after the values of sample.
+func (imp *importer) createTable(ctx context.Context, names []string, sample []Record) error {
+	loaded := make([]string, 0, len(names))
+	indexes := make([]int, 0, len(names))
+	for i, name := range names {
+		if name != "" {
+			loaded = append(loaded, name)
+			indexes = append(indexes, i)
+		}
+	}
+	projected := make([]Record, 0, len(sample))
+	for _, r := range sample {
+		if r.Err != nil {
+			continue
+		}
+		values := make([]any, len(indexes))
+		for i, index := range indexes {
+			values[i] = r.Values[index]
+		}
+		projected = append(projected, Record{Values: values})
+	}
+
+	definition, issues := typemap.New("postgres", imp.provider).Table(inferTable(imp.table, loaded, projected))
+	imp.result.Issues = issues
+	for _, statement := range schemadiff.CreateTableDDL(imp.provider, definition) {
+		if _, err := imp.db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchColumns matches the loaded columns of the file with the columns of the
+// table by name, ignoring case.
+func (imp *importer) matchColumns(names []string, targetColumns []dh.Column) error {
+	byName := make(map[string]dh.Column, len(targetColumns))
+	for _, c := range targetColumns {
+		byName[strings.ToLower(c.Name)] = c
+	}
+	unknown := make([]string, 0)
+	for i, name := range names {
+		if name == "" {
+			continue
+		}
+		c, ok := byName[strings.ToLower(name)]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		typ := strings.ToLower(c.Type)
+		base := strings.TrimSpace(strings.SplitN(typ, "(", 2)[0])
+		imp.columns = append(imp.columns, column{
+			index:  i,
+			target: c.Name,
+			bool:   typ == "tinyint(1)" || base == "bool" || base == "boolean",
+			binary: dh.ColumnKind(base) == dh.KindBinary,
+		})
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("column(s) %s not found in %s, rename them with --map file_column=table_column or skip them with --map file_column=",
+			strings.Join(unknown, ", "), imp.table)
+	}
+	if len(imp.columns) == 0 {
+		return fmt.Errorf("no column of the file is loaded")
+	}
+	return nil
+}
+
+// values converts a record into the arguments of the loaded columns.
+// Booleans are written as 1 and 0 outside of postgres and binary values are
+// decoded from the encoding of the exports: hex with a 0x prefix in CSV
+// files, base64 in NDJSON files.
+func (imp *importer) values(r Record) ([]any, error) {
+	values := make([]any, len(imp.columns))
+	for i, c := range imp.columns {
+		s, ok := r.Values[c.index].(string)
+		if !ok {
+			continue
+		}
+		values[i] = s
+		switch {
+		case c.bool && imp.provider != "postgres":
+			switch strings.ToLower(strings.TrimSpace(s)) {
+			case "true", "t", "yes", "y", "on":
+				values[i] = "1"
+			case "false", "f", "no", "n", "off":
+				values[i] = "0"
+			}
+		case c.binary && imp.opts.Format == "csv" && strings.HasPrefix(s, "0x"):
+			b, err := hex.DecodeString(s[2:])
+			if err != nil {
+				return nil, fmt.Errorf("column %s: invalid hex value", c.target)
+			}
+			values[i] = b
+		case c.binary && imp.opts.Format == "ndjson":
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: invalid base64 value", c.target)
+			}
+			values[i] = b
+		}
+	}
+	return values, nil
+}
+
+func (imp *importer) reject(r Record, err error) error {
+	imp.result.Rejected++
+	if imp.rejects == nil {
+		return nil
+	}
+	return imp.rejects.Encode(rejection{Line: r.Line, Error: err.Error(), Record: r.Raw})
+}
+
+// load writes a batch in a transaction. A failing bulk load or multi row
+// INSERT is retried row by row to reject the offending rows only.
+func (imp *importer) load(ctx context.Context, batch []Record) error {
+	rows := make([][]any, 0, len(batch))
+	records := make([]Record, 0, len(batch))
+	for _, r := range batch {
+		values, err := imp.values(r)
+		if err != nil {
+			if err := imp.reject(r, err); err != nil {
+				return err
+			}
+			continue
+		}
+		rows = append(rows, values)
+		records = append(records, r)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	if imp.bulk {
+		bulkErr := imp.inTx(ctx, func(tx *sql.Tx) error {
+			if imp.provider == "mysql" {
+				return imp.loadData(ctx, tx, rows)
+			}
+			return imp.copyIn(ctx, tx, rows)
+		})
+		if bulkErr == nil {
+			imp.result.Rows += int64(len(rows))
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rejected := imp.result.Rejected
+		if err := imp.insert(ctx, rows, records); err != nil {
+			return err
+		}
+		// The batch was fine, the bulk load itself is unavailable
+		if imp.result.Rejected == rejected && !errors.Is(bulkErr, errLoadIncomplete) {
+			imp.bulk = false
+			imp.result.Method = "insert"
+			imp.result.Warnings = append(imp.result.Warnings, fmt.Sprintf("bulk load unavailable, falling back to INSERT: %s", bulkErr))
+		}
+		return nil
+	}
+	return imp.insert(ctx, rows, records)
+}
+
+func (imp *importer) inTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := imp.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// insert writes rows with a multi row INSERT, then row by row when it fails.
+func (imp *importer) insert(ctx context.Context, rows [][]any, records []Record) error {
+	err := imp.inTx(ctx, func(tx *sql.Tx) error {
+		args := make([]any, 0, len(rows)*len(imp.columns))
+		for _, row := range rows {
+			args = append(args, row...)
+		}
+		_, err := tx.ExecContext(ctx, imp.insertStatement(len(rows)), args...)
+		return err
+	})
+	if err == nil {
+		imp.result.Rows += int64(len(rows))
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(rows) == 1 {
+		return imp.reject(records[0], err)
+	}
+
+	statement := imp.insertStatement(1)
+	for i, row := range rows {
+		if _, err := imp.db.ExecContext(ctx, statement, row...); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := imp.reject(records[i], err); err != nil {
+				return err
+			}
+			continue
+		}
+		imp.result.Rows++
+	}
+	return nil
+}
+
+// insertStatement returns the INSERT of rows rows into the matched target
+// columns.
+func (imp *importer) insertStatement(rows int) string {
+	targets := make([]string, len(imp.columns))
+	for i, c := range imp.columns {
+		targets[i] = c.target
+	}
+	return dh.InsertStatement(imp.provider, imp.table, targets, rows)
+}
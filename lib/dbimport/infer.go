@@ -0,0 +1,111 @@
+package dbimport
+
+import (
+	"regexp"
+	dh "seraphim/lib/db/query"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// inferSample is the number of records read ahead to infer column types.
+const inferSample = 1000
+
+// inferred types, ordered so that two types widen to the larger one except
+// where noted in widen.
+type inferred int
+
+const (
+	inferNone inferred = iota
+	inferBool
+	inferInt
+	inferFloat
+	inferDate
+	inferTimestamp
+	inferTimestampTZ
+	inferText
+)
+
+var (
+	dateValue      = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	timestampValue = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?$`)
+)
+
+func inferValue(s string) inferred {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "true", "false":
+		return inferBool
+	}
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return inferInt
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil && !strings.ContainsAny(s, "xXnN") {
+		return inferFloat
+	}
+	if dateValue.MatchString(s) {
+		return inferDate
+	}
+	if timestampValue.MatchString(s) {
+		return inferTimestamp
+	}
+	if _, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return inferTimestampTZ
+	}
+	return inferText
+}
+
+// widen returns the type holding values of both a and b.
+func widen(a inferred, b inferred) inferred {
+	switch {
+	case a == b || b == inferNone:
+		return a
+	case a == inferNone:
+		return b
+	case a > b:
+		a, b = b, a
+	}
+	switch {
+	case a == inferInt && b == inferFloat:
+		return inferFloat
+	case a == inferDate && b == inferTimestamp:
+		return inferTimestamp
+	case a == inferTimestamp && b == inferTimestampTZ:
+		return inferTimestampTZ
+	}
+	return inferText
+}
+
+// inferTypes are postgres type names, translated for other providers by
+// typemap.
+var inferTypes = map[inferred]string{
+	inferNone:        "text",
+	inferBool:        "boolean",
+	inferInt:         "bigint",
+	inferFloat:       "double precision",
+	inferDate:        "date",
+	inferTimestamp:   "timestamp",
+	inferTimestampTZ: "timestamptz",
+	inferText:        "text",
+}
+
+// inferTable returns the definition of table with a nullable column per
+// name, typed after the values of sample.
+func inferTable(table string, names []string, sample []Record) dh.TableDetails {
+	types := make([]inferred, len(names))
+	for _, r := range sample {
+		if r.Err != nil {
+			continue
+		}
+		for i, v := range r.Values {
+			if s, ok := v.(string); ok {
+				types[i] = widen(types[i], inferValue(s))
+			}
+		}
+	}
+	t := dh.TableDetails{Name: table, Columns: make([]dh.Column, len(names))}
+	for i, name := range names {
+		t.Columns[i] = dh.Column{Name: name, Type: inferTypes[types[i]], Nullable: true}
+	}
+	return t
+}
@@ -0,0 +1,233 @@
+package dbimport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Formats lists the file formats an import reads.
+var Formats = []string{"csv", "ndjson"}
+
+// Record is a row of the input file. Values holds a string or nil per
+// column, Raw the record as read for the rejects file. Err is set when the
+// record could not be parsed.
+type Record struct {
+	Line   int
+	Values []any
+	Raw    any
+	Err    error
+}
+
+// Reader reads the records of an input file.
+type Reader interface {
+	// Columns returns the column names of the header
+	Columns() []string
+	// Next returns the next record, io.EOF once the input is exhausted
+	Next() (Record, error)
+}
+
+// NewReader returns a Reader of format on r. delimiter separates CSV fields
+// and fields equal to null are read as NULL.
+func NewReader(format string, r io.Reader, delimiter rune, null string) (Reader, error) {
+	switch format {
+	case "csv":
+		return newCSVReader(r, delimiter, null)
+	case "ndjson":
+		return newNDJSONReader(r)
+	}
+	return nil, fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(Formats, ", "))
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns []string
+	null    string
+}
+
+func newCSVReader(r io.Reader, delimiter rune, null string) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	if delimiter != 0 {
+		cr.Comma = delimiter
+	}
+	// Field counts are checked per record so that a short row is rejected
+	// rather than ending the import
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty, a header row is expected")
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Excel starts UTF-8 files with a byte order mark
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	for i, name := range header {
+		header[i] = strings.TrimSpace(name)
+	}
+	return &csvReader{r: cr, columns: header, null: null}, nil
+}
+
+func (c *csvReader) Columns() []string {
+	return c.columns
+}
+
+func (c *csvReader) Next() (Record, error) {
+	fields, err := c.r.Read()
+	if err == io.EOF {
+		return Record{}, err
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return Record{Line: parseErr.StartLine, Raw: fields, Err: parseErr.Err}, nil
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	line, _ := c.r.FieldPos(0)
+	record := Record{Line: line, Raw: fields}
+	if len(fields) != len(c.columns) {
+		record.Err = fmt.Errorf("%d field(s) where the header has %d", len(fields), len(c.columns))
+		return record, nil
+	}
+	record.Values = make([]any, len(fields))
+	for i, f := range fields {
+		if f != c.null {
+			record.Values[i] = f
+		}
+	}
+	return record, nil
+}
+
+// maxLine is the longest NDJSON line accepted.
+const maxLine = 64 << 20
+
+type ndjsonReader struct {
+	s       *bufio.Scanner
+	line    int
+	columns []string
+	index   map[string]int
+	first   *Record
+}
+
+// newNDJSONReader reads the first object to take the columns from its keys,
+// in the order they appear.
+func newNDJSONReader(r io.Reader) (*ndjsonReader, error) {
+	n := &ndjsonReader{s: bufio.NewScanner(r), index: make(map[string]int)}
+	n.s.Buffer(make([]byte, 0, 64*1024), maxLine)
+	for n.s.Scan() {
+		n.line++
+		text := bytes.TrimSpace(n.s.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		keys, err := objectKeys(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n.line, err)
+		}
+		for _, k := range keys {
+			if _, ok := n.index[k]; !ok {
+				n.index[k] = len(n.columns)
+				n.columns = append(n.columns, k)
+			}
+		}
+		first := n.record(text)
+		n.first = &first
+		return n, nil
+	}
+	if err := n.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("the file is empty, objects are expected")
+}
+
+// objectKeys returns the keys of a JSON object in document order.
+func objectKeys(text []byte) ([]string, error) {
+	d := json.NewDecoder(bytes.NewReader(text))
+	if t, err := d.Token(); err != nil || t != json.Delim('{') {
+		return nil, errors.New("a JSON object is expected")
+	}
+	keys := make([]string, 0)
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, t.(string))
+		var skip json.RawMessage
+		if err := d.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func (n *ndjsonReader) Columns() []string {
+	return n.columns
+}
+
+// record converts an object into the values of the columns. Nested objects
+// and arrays are kept as JSON text.
+func (n *ndjsonReader) record(text []byte) Record {
+	raw := json.RawMessage(append([]byte(nil), text...))
+	record := Record{Line: n.line, Raw: raw}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(text, &object); err != nil {
+		// Invalid JSON is kept as text in the rejects file
+		record.Raw = string(text)
+		record.Err = err
+		return record
+	}
+	record.Values = make([]any, len(n.columns))
+	for key, value := range object {
+		i, ok := n.index[key]
+		if !ok {
+			record.Err = fmt.Errorf("unknown field %q", key)
+			return record
+		}
+		var v any
+		d := json.NewDecoder(bytes.NewReader(value))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			record.Err = err
+			return record
+		}
+		switch val := v.(type) {
+		case nil:
+		case string:
+			record.Values[i] = val
+		case json.Number:
+			record.Values[i] = val.String()
+		case bool:
+			record.Values[i] = fmt.Sprint(val)
+		default:
+			record.Values[i] = string(value)
+		}
+	}
+	return record
+}
+
+func (n *ndjsonReader) Next() (Record, error) {
+	if n.first != nil {
+		first := *n.first
+		n.first = nil
+		return first, nil
+	}
+	for n.s.Scan() {
+		n.line++
+		text := bytes.TrimSpace(n.s.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		return n.record(text), nil
+	}
+	if err := n.s.Err(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
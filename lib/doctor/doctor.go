@@ -0,0 +1,194 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"seraphim/lib/config"
+	dh "seraphim/lib/db/query"
+	"seraphim/lib/util"
+	"time"
+)
+
+type Status int
+
+const (
+	Pass Status = iota
+	Warn
+	Fail
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "pass"
+	case Warn:
+		return "warn"
+	default:
+		return "fail"
+	}
+}
+
+type Check struct {
+	Section string
+	Name    string
+	Status  Status
+	Detail  string
+}
+
+type Options struct {
+	ConfigFile  string
+	Ping        bool
+	PingTimeout time.Duration
+}
+
+// toolsByProvider lists the external binaries each provider needs to dump.
+var toolsByProvider = map[string][]string{
+	"mysql":    {"mysqldump"},
+	"postgres": {"pg_dump", "pg_restore"},
+}
+
+// Run executes every diagnostic and returns the results in report order.
+func Run(opts Options) []Check {
+	checks := make([]Check, 0)
+
+	conf, configChecks := checkConfigFile(opts.ConfigFile)
+	checks = append(checks, configChecks...)
+	checks = append(checks, checkTools(conf)...)
+	checks = append(checks, checkDumpPath(conf.Default_dump_path))
+	if opts.Ping {
+		checks = append(checks, checkConnections(conf, opts.PingTimeout)...)
+	}
+	return checks
+}
+
+func checkConfigFile(file string) (config.SeraphimConfig, []Check) {
+	var conf config.SeraphimConfig
+	section := "Configuration"
+	if file == "" {
+		return conf, []Check{{
+			Section: section,
+			Name:    "Configuration file",
+			Status:  Fail,
+			Detail:  "no configuration file found, run 'seraphim config init'",
+		}}
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return conf, []Check{{Section: section, Name: "Configuration file", Status: Fail, Detail: err.Error()}}
+	}
+	checks := []Check{{Section: section, Name: "Configuration file", Status: Pass, Detail: file}}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return conf, append(checks, Check{Section: section, Name: "Configuration is valid", Status: Fail, Detail: err.Error()})
+	}
+	conf, err = config.ParseConfig(content)
+	if err != nil {
+		for _, e := range config.SplitErrors(err) {
+			checks = append(checks, Check{Section: section, Name: "Configuration is valid", Status: Fail, Detail: e.Error()})
+		}
+	} else if errs := conf.Validate(); len(errs) > 0 {
+		for _, e := range errs {
+			checks = append(checks, Check{Section: section, Name: "Configuration is valid", Status: Fail, Detail: e.Error()})
+		}
+	} else {
+		checks = append(checks, Check{Section: section, Name: "Configuration is valid", Status: Pass})
+	}
+
+	perm := Check{Section: section, Name: "Configuration file permissions", Status: Pass, Detail: info.Mode().Perm().String()}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o004 != 0 {
+		perm.Status = Warn
+		perm.Detail = fmt.Sprintf("%s is world-readable and stores plaintext passwords, run 'chmod 600 %s'", info.Mode().Perm(), file)
+	}
+	return conf, append(checks, perm)
+}
+
+func checkTools(conf config.SeraphimConfig) []Check {
+	required := make(map[string]bool)
+	for _, entry := range conf.Stored_Connections {
+		for _, conn := range entry {
+			for _, tool := range toolsByProvider[dh.NormalizeProvider(conn.Provider)] {
+				required[tool] = true
+			}
+		}
+	}
+
+	checks := make([]Check, 0)
+	for _, tool := range util.DetectDumpTools() {
+		check := Check{Section: "External tools", Name: tool.Name}
+		switch {
+		case tool.Found && tool.Err == "":
+			check.Status = Pass
+			check.Detail = tool.Version
+		case tool.Found:
+			check.Status = Warn
+			check.Detail = fmt.Sprintf("%s found but could not read its version: %s", tool.Path, tool.Err)
+		case required[tool.Name]:
+			check.Status = Fail
+			check.Detail = tool.Err + ", required by at least one stored connection"
+		default:
+			check.Status = Warn
+			check.Detail = tool.Err
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func checkDumpPath(path string) Check {
+	check := Check{Section: "Dumps", Name: "Default dump path"}
+	if path == "" {
+		check.Status = Warn
+		check.Detail = "default_dump_path is not set, dumps will need an explicit path"
+		return check
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		check.Status = Fail
+		check.Detail = err.Error()
+		return check
+	}
+	if !info.IsDir() {
+		check.Status = Fail
+		check.Detail = fmt.Sprintf("%s is not a directory", path)
+		return check
+	}
+	probe, err := os.CreateTemp(path, ".seraphim-doctor-*")
+	if err != nil {
+		check.Status = Fail
+		check.Detail = fmt.Sprintf("%s is not writable: %v", path, err)
+		return check
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	check.Status = Pass
+	check.Detail = path
+	return check
+}
+
+func checkConnections(conf config.SeraphimConfig, timeout time.Duration) []Check {
+	checks := make([]Check, 0)
+	for _, entry := range conf.Stored_Connections {
+		for tag, conn := range entry {
+			check := Check{Section: "Connections", Name: tag}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			version, err := dh.Ping(ctx, conn)
+			cancel()
+			if err != nil {
+				check.Status = Fail
+				check.Detail = err.Error()
+			} else {
+				check.Status = Pass
+				check.Detail = fmt.Sprintf("%s@%s:%d (%s)", conn.User, conn.Host, conn.Port, version)
+			}
+			checks = append(checks, check)
+		}
+	}
+	if len(checks) == 0 {
+		checks = append(checks, Check{Section: "Connections", Name: "Stored connections", Status: Warn, Detail: "no stored connections to ping"})
+	}
+	return checks
+}
@@ -0,0 +1,327 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	dh "seraphim/lib/db/query"
+	"sync"
+	"time"
+)
+
+// StateSuffix is appended to the name of the state file of a resumable dump.
+const StateSuffix = ".state.json"
+
+// DefaultChunkRows is the number of rows of a chunk of a resumable dump when
+// none is given.
+const DefaultChunkRows = 100000
+
+// stateVersion is the version of the state file layout.
+const stateVersion = 1
+
+// State is the checkpoint of a resumable dump, saved beside its files after
+// every chunk written so that an interrupted dump resumes from the last one.
+type State struct {
+	Version    int         `json:"version"`
+	Connection string      `json:"connection"`
+	Dir        string      `json:"dir"`
+	Options    Options     `json:"options"`
+	CreatedAt  time.Time   `json:"created_at"`
+	Files      []FileState `json:"files"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// FileState is the progress of a file of a resumable dump.
+type FileState struct {
+	Path   string       `json:"path"`
+	Tables []TableState `json:"tables"`
+	// Done is set once the chunks are stitched into the file
+	Done bool `json:"done"`
+}
+
+// TableState is the progress of a table, written in chunks of rows ordered
+// by primary key. LastKey is the key of the last row written, tables without
+// a primary key being written in a single chunk.
+type TableState struct {
+	Database string `json:"database"`
+	Table    string `json:"table"`
+	Chunks   int    `json:"chunks"`
+	Rows     int64  `json:"rows"`
+	LastKey  []any  `json:"last_key,omitempty"`
+	Done     bool   `json:"done"`
+}
+
+// Path returns where the state is saved.
+func (s *State) Path() string {
+	return s.path
+}
+
+// newState describes the files of a resumable dump of the connection tagged
+// tag, the tables of their plans fixing what is dumped when it resumes.
+func newState(tag string, dir string, opts Options, start time.Time, files []FileJob) *State {
+	s := &State{
+		Version:    stateVersion,
+		Connection: tag,
+		Dir:        dir,
+		Options:    opts,
+		CreatedAt:  start.UTC().Truncate(time.Second),
+		Files:      make([]FileState, 0, len(files)),
+		path:       filepath.Join(dir, fmt.Sprintf("dump-%d%s", start.Unix(), StateSuffix)),
+	}
+	for _, f := range files {
+		fs := FileState{Path: f.Path, Tables: make([]TableState, 0)}
+		for _, plan := range f.plans {
+			for _, t := range plan.Tables {
+				fs.Tables = append(fs.Tables, TableState{Database: plan.Database, Table: t.Source.Name})
+			}
+		}
+		s.Files = append(s.Files, fs)
+	}
+	return s
+}
+
+// LoadState reads the state of a resumable dump saved at path.
+func LoadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	// Keys keep the precision of 64 bit integers
+	d.UseNumber()
+	s := &State{}
+	if err := d.Decode(s); err != nil {
+		return nil, fmt.Errorf("invalid dump state %s: %w", path, err)
+	}
+	if s.Version != stateVersion {
+		return nil, fmt.Errorf("dump state %s has version %d, expected %d", path, s.Version, stateVersion)
+	}
+	for f := range s.Files {
+		for t := range s.Files[f].Tables {
+			key := s.Files[f].Tables[t].LastKey
+			for k, v := range key {
+				if n, ok := v.(json.Number); ok {
+					if integer, err := n.Int64(); err == nil {
+						key[k] = integer
+					} else if float, err := n.Float64(); err == nil {
+						key[k] = float
+					}
+				}
+			}
+		}
+	}
+	s.path = path
+	return s, nil
+}
+
+// update changes the state and saves it, the state being shared by the
+// workers of the dump.
+func (s *State) update(change func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change()
+	return s.save()
+}
+
+// save writes the state through a temporary file, a crash never leaving a
+// truncated state behind.
+func (s *State) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// dumpFiles returns the files of the dump described by the state.
+func (s *State) dumpFiles() []FileJob {
+	files := make([]FileJob, 0, len(s.Files))
+	for _, fs := range s.Files {
+		file := FileJob{}
+		file.Path = fs.Path
+		for _, t := range fs.Tables {
+			n := len(file.Databases)
+			if n == 0 || file.Databases[n-1].Name != t.Database {
+				file.Databases = append(file.Databases, dh.DumpDatabase{Name: t.Database})
+				n++
+			}
+			file.Databases[n-1].Tables = append(file.Databases[n-1].Tables, t.Table)
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// partsDir is the directory holding the chunks of a file until they are
+// stitched into it.
+func partsDir(path string) string {
+	return path + ".parts"
+}
+
+func chunkPath(path string, table int, chunk int) string {
+	return filepath.Join(partsDir(path), fmt.Sprintf("%04d-%06d.sql", table, chunk))
+}
+
+// writeChunkedFile writes the tables of Files[i] in chunks, skipping those
+// a previous run completed, then stitches the chunks into the file.
+func (j *Job) writeChunkedFile(ctx context.Context, i int, progress func(rows int64)) ([]DatabaseManifest, error) {
+	file := &j.Files[i]
+	s := j.state
+	s.mu.Lock()
+	fs := s.Files[i]
+	fs.Tables = append([]TableState(nil), fs.Tables...)
+	s.mu.Unlock()
+
+	index := make(map[string]int, len(fs.Tables))
+	for ti, t := range fs.Tables {
+		index[t.Database+"."+t.Table] = ti
+		progress(t.Rows)
+	}
+	if fs.Done {
+		return chunkedManifests(fs), nil
+	}
+	if err := os.MkdirAll(partsDir(file.Path), 0o755); err != nil {
+		return nil, err
+	}
+
+	for _, plan := range file.plans {
+		db, release, err := j.source(ctx, plan.Database)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range plan.Tables {
+			ti, ok := index[plan.Database+"."+t.Source.Name]
+			if !ok {
+				release()
+				return nil, fmt.Errorf("%s.%s is not in the dump state", plan.Database, t.Source.Name)
+			}
+			ts := &fs.Tables[ti]
+			limit := j.opts.ChunkRows
+			if len(t.Source.PrimaryKey) == 0 {
+				limit = 0
+			}
+			for !ts.Done {
+				if err := ctx.Err(); err != nil {
+					release()
+					return nil, err
+				}
+				n, last, err := writeChunk(ctx, chunkPath(file.Path, ti, ts.Chunks), db, j.conn.Provider, plan.Dialect, t, ts.LastKey, limit, progress)
+				if err != nil {
+					release()
+					return nil, fmt.Errorf("%s: %w", t.Source.Name, err)
+				}
+				ts.Chunks++
+				ts.Rows += n
+				ts.LastKey = last
+				ts.Done = limit == 0 || n < int64(limit)
+				done := *ts
+				if err := s.update(func() { s.Files[i].Tables[ti] = done }); err != nil {
+					release()
+					return nil, fmt.Errorf("could not save the dump state: %w", err)
+				}
+			}
+		}
+		release()
+	}
+
+	if err := stitch(file.Path, file.plans, fs, index); err != nil {
+		return nil, err
+	}
+	if err := s.update(func() { s.Files[i].Done = true }); err != nil {
+		return nil, fmt.Errorf("could not save the dump state: %w", err)
+	}
+	os.RemoveAll(partsDir(file.Path))
+	return chunkedManifests(fs), nil
+}
+
+// writeChunk writes the rows of t following the key after to path, through
+// a temporary file so that an interrupted chunk is written again.
+func writeChunk(ctx context.Context, path string, db queryer, provider string, dialect string, t dialectTable, after []any, limit int, progress func(rows int64)) (int64, []any, error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	out := bufio.NewWriter(f)
+	n, last, err := writeRows(ctx, out, db, provider, dialect, t, after, limit, progress)
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, nil, err
+	}
+	return n, last, os.Rename(tmp, path)
+}
+
+// stitch writes the file at path from the statements of its plans and the
+// chunks of their tables, in the layout of a dump written at once.
+func stitch(path string, plans []DialectPlan, fs FileState, index map[string]int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	out := bufio.NewWriter(f)
+	for _, plan := range plans {
+		dumped := dumpedTables(plan)
+		writePreamble(out, plan)
+		for _, t := range plan.Tables {
+			ti := index[plan.Database+"."+t.Source.Name]
+			writeTableDDL(out, plan, t, dumped)
+			for c := 0; c < fs.Tables[ti].Chunks; c++ {
+				if err := appendFile(out, chunkPath(path, ti, c)); err != nil {
+					return err
+				}
+			}
+			if fs.Tables[ti].Rows > 0 {
+				writeSequences(out, plan.Dialect, t)
+			}
+		}
+		writeEpilogue(out, plan, dumped)
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func appendFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// chunkedManifests returns the row counts of the tables of a file written in
+// chunks.
+func chunkedManifests(fs FileState) []DatabaseManifest {
+	databases := make([]DatabaseManifest, 0)
+	for _, t := range fs.Tables {
+		n := len(databases)
+		if n == 0 || databases[n-1].Name != t.Database {
+			databases = append(databases, DatabaseManifest{Name: t.Database, Tables: make([]TableManifest, 0)})
+			n++
+		}
+		databases[n-1].Tables = append(databases[n-1].Tables, TableManifest{Name: t.Table, Rows: t.Rows})
+	}
+	return databases
+}
@@ -0,0 +1,597 @@
+package dump
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"seraphim/globals"
+	"seraphim/lib/config"
+	dh "seraphim/lib/db/query"
+	"seraphim/lib/masking"
+	"seraphim/lib/typemap"
+	"seraphim/lib/util"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often the progress of the files being written is
+// reported.
+const progressInterval = 200 * time.Millisecond
+
+// Job is a dump ready to be written: its files are planned and, for dialect
+// dumps, their tables translated.
+type Job struct {
+	Files []FileJob
+	// Issues are the conversions of a dialect dump losing information
+	Issues []typemap.Issue
+
+	conn     config.StoredConnection
+	opts     Options
+	manifest Manifest
+	start    time.Time
+	source   sourceFunc
+	snapshot *snapshot
+	dumpOpts dh.DumpOptions
+	state    *State
+}
+
+// FileJob is a file of a dump along with the size of its tables, as
+// estimated by the server.
+type FileJob struct {
+	dh.DumpFile
+	EstimatedRows  int64
+	EstimatedBytes int64
+
+	plans     []DialectPlan
+	databases []DatabaseManifest
+}
+
+// Progress reports how far the writing of Files[File] of a job went. Rows
+// are only known for dialect dumps, the client tools of the providers not
+// telling how many they wrote.
+type Progress struct {
+	File  int
+	Rows  int64
+	Bytes int64
+	Done  bool
+	Err   error
+}
+
+// Prepare plans the dump of the selected databases and tables of the
+// connection tagged tag into dir, creating the directories of its files.
+// The state of a resumable dump is saved before anything is written.
+func Prepare(ctx context.Context, tag string, conn config.StoredConnection, dir string, selectedDbs []util.DbListItem, selectedTables []util.TableListItem, opts Options) (*Job, error) {
+	if opts.Resumable {
+		switch {
+		case opts.Dialect == "":
+			return nil, fmt.Errorf("resumable dumps are written by seraphim, set a target dialect")
+		case opts.Consistent:
+			return nil, fmt.Errorf("a resumable dump cannot be consistent, its snapshot ending with the run")
+		}
+		if opts.ChunkRows <= 0 {
+			opts.ChunkRows = DefaultChunkRows
+		}
+	}
+	if opts.subset() || opts.FollowForeignKeys {
+		switch {
+		case opts.Dialect == "":
+			return nil, fmt.Errorf("filtered dumps are written by seraphim, set a target dialect")
+		case opts.Resumable:
+			return nil, fmt.Errorf("a filtered dump cannot be resumable")
+		}
+	}
+	if opts.Objects.SchemaOnly && (opts.subset() || opts.FollowForeignKeys) {
+		return nil, fmt.Errorf("a schema only dump holds no rows to filter")
+	}
+	if opts.Dialect != "" {
+		if on := opts.Objects.Included(dh.IncludeOn); len(on) > 0 {
+			return nil, fmt.Errorf("dialect dumps hold tables only, %s cannot be translated, dump them with the client tools", strings.Join(on, ", "))
+		}
+		if opts.Objects.SchemaOnly && opts.Objects.DataOnly {
+			return nil, fmt.Errorf("a dump cannot be both schema only and data only")
+		}
+	}
+	start := time.Now()
+	files, err := planFiles(ctx, tag, conn, dir, selectedDbs, selectedTables, opts, start)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Consistent && opts.Dialect == "" && dh.NormalizeProvider(conn.Provider) == "mysql" && len(files) > 1 {
+		return nil, fmt.Errorf("mysqldump cannot share a snapshot between files, use the %s split mode or a target dialect for a consistent dump", SplitSingleFile)
+	}
+	if opts.Dialect == "" {
+		if err := opts.Objects.Check(conn.Provider, selectsTables(files)); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Dialect == "" && opts.Mask != nil && !opts.Objects.SchemaOnly {
+		if err := checkUnmasked(ctx, conn, files, opts.Mask); err != nil {
+			return nil, err
+		}
+	}
+	jobFiles := make([]FileJob, 0, len(files))
+	for _, f := range files {
+		jobFiles = append(jobFiles, FileJob{DumpFile: f})
+	}
+	job, err := newJob(ctx, tag, conn, opts, start, jobFiles)
+	if err != nil {
+		return nil, err
+	}
+	for table := range opts.Filters {
+		if !job.dumps(table) {
+			return nil, fmt.Errorf("filter on %s, which is not dumped", table)
+		}
+	}
+	if opts.Resumable {
+		job.state = newState(tag, dir, opts, start, job.Files)
+		if err := job.state.save(); err != nil {
+			return nil, fmt.Errorf("could not save the dump state: %w", err)
+		}
+	}
+	return job, nil
+}
+
+// Resume plans the rest of the resumable dump whose state was saved, conn
+// being the connection tagged state.Connection. parallel, when positive,
+// overrides the number of files written at once.
+func Resume(ctx context.Context, state *State, conn config.StoredConnection, parallel int) (*Job, error) {
+	opts := state.Options
+	if parallel > 0 {
+		opts.Parallel = parallel
+	}
+	job, err := newJob(ctx, state.Connection, conn, opts, time.Now(), state.dumpFiles())
+	if err != nil {
+		return nil, err
+	}
+	job.manifest.CreatedAt = state.CreatedAt
+	job.state = state
+	return job, nil
+}
+
+// newJob sizes and, for dialect dumps, translates the tables of files.
+func newJob(ctx context.Context, tag string, conn config.StoredConnection, opts Options, start time.Time, files []FileJob) (*Job, error) {
+	job := &Job{
+		Issues: make([]typemap.Issue, 0),
+		conn:   conn,
+		opts:   opts,
+		start:  start,
+	}
+	job.manifest = Manifest{
+		Connection:      tag,
+		Provider:        dh.NormalizeProvider(conn.Provider),
+		SeraphimVersion: globals.AppVersion,
+		CreatedAt:       job.start.UTC().Truncate(time.Second),
+	}
+	if opts.Dialect != "" {
+		job.manifest.Dialect = dh.NormalizeProvider(opts.Dialect)
+	}
+	job.manifest.FollowForeignKeys = opts.FollowForeignKeys
+	if opts.Objects != (dh.DumpObjects{}) {
+		objects := opts.Objects
+		job.manifest.Objects = &objects
+	}
+	if version, err := dh.Ping(ctx, conn); err == nil {
+		job.manifest.ServerVersion = version
+	}
+
+	// Estimates only feed the progress, a database without statistics is
+	// dumped all the same
+	estimates := make(map[string]map[string]dh.TableEstimate)
+	for _, f := range files {
+		for _, d := range f.Databases {
+			if _, ok := estimates[d.Name]; !ok {
+				estimates[d.Name], _ = dh.EstimateTables(ctx, conn, d.Name)
+			}
+		}
+	}
+
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file.Path), 0o755); err != nil {
+			return nil, err
+		}
+		for _, d := range file.Databases {
+			for table, e := range estimates[d.Name] {
+				if d.Tables == nil || slices.Contains(d.Tables, table) {
+					file.EstimatedRows += e.Rows
+					file.EstimatedBytes += e.Bytes
+				}
+			}
+			if opts.Dialect == "" {
+				continue
+			}
+			plan, err := PlanDialect(ctx, conn, d.Name, d.Tables, opts.Dialect)
+			if err != nil {
+				return nil, err
+			}
+			plan.FollowForeignKeys = opts.FollowForeignKeys
+			plan.SchemaOnly = opts.Objects.SchemaOnly
+			plan.DataOnly = opts.Objects.DataOnly
+			for i, t := range plan.Tables {
+				plan.Tables[i].Filter = opts.filter(d.Name, t.Source.Name)
+				plan.Tables[i].Masks, plan.Tables[i].Masked = masks(opts.Mask, d.Name, t.Source)
+			}
+			file.plans = append(file.plans, plan)
+			job.Issues = append(job.Issues, plan.Issues...)
+		}
+		job.Files = append(job.Files, file)
+	}
+	return job, nil
+}
+
+// StatePath returns where the state of a resumable job is saved, empty for
+// other jobs.
+func (j *Job) StatePath() string {
+	if j.state == nil {
+		return ""
+	}
+	return j.state.Path()
+}
+
+// PrintIssues lists the conversions of the job losing information.
+func (j *Job) PrintIssues() {
+	if len(j.Issues) == 0 {
+		return
+	}
+	fmt.Printf("---> Converting to %s loses information:\n", j.manifest.Dialect)
+	for _, issue := range j.Issues {
+		fmt.Printf("     %s\n", issue)
+	}
+}
+
+// Run writes the files of the job, opts.Parallel of them at once. progress,
+// when set, is called from the workers as the files grow and once each is
+// done, hence it must be safe for concurrent use. The first failure stops
+// the other files and the files of the job are removed, unless the job is
+// resumable: its state and chunks are then kept for the next run.
+func (j *Job) Run(ctx context.Context, progress func(Progress)) error {
+	if progress == nil {
+		progress = func(Progress) {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := min(max(j.opts.Parallel, 1), len(j.Files))
+	j.source = directSource(j.conn)
+	j.dumpOpts.Objects = j.opts.Objects
+	if j.opts.Consistent {
+		j.manifest.Consistent = true
+		switch {
+		case j.opts.Dialect == "" && j.manifest.Provider == "mysql":
+			// mysqldump takes its own snapshot
+			j.dumpOpts.SingleTransaction = true
+			j.dumpOpts.SourceData = sourceDataOption(ctx, j.conn, j.manifest.ServerVersion)
+		default:
+			if j.manifest.Provider == "sqlite" {
+				workers = 1
+			}
+			snap, err := takeSnapshot(ctx, j.conn, j.databaseNames(), workers)
+			if err != nil {
+				return err
+			}
+			defer snap.release()
+			j.snapshot = snap
+			j.source = snap.source
+			j.manifest.Binlog = snap.Binlog
+		}
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var failure error
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := j.writeFile(ctx, i, progress); err != nil {
+					once.Do(func() {
+						failure = fmt.Errorf("%s: %w", j.Files[i].Path, err)
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+	for i := range j.Files {
+		if ctx.Err() != nil {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	if failure == nil {
+		failure = ctx.Err()
+	}
+	switch {
+	case failure != nil && j.state == nil:
+		for _, f := range j.Files {
+			os.Remove(f.Path)
+		}
+	case failure == nil && j.state != nil:
+		os.Remove(j.state.Path())
+	}
+	return failure
+}
+
+// writeFile writes Files[i], reporting its size while it grows.
+func (j *Job) writeFile(ctx context.Context, i int, progress func(Progress)) error {
+	file := &j.Files[i]
+	var rows atomic.Int64
+	report := func(done bool, err error) {
+		p := Progress{File: i, Rows: rows.Load(), Done: done, Err: err}
+		if info, err := os.Stat(file.Path); err == nil {
+			p.Bytes = info.Size()
+		}
+		progress(p)
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				report(false, nil)
+			}
+		}
+	}()
+
+	var err error
+	if j.state != nil {
+		file.databases, err = j.writeChunkedFile(ctx, i, func(n int64) { rows.Add(n) })
+	} else if j.opts.Dialect != "" {
+		file.databases, err = writeDialectFile(ctx, j.source, j.conn.Provider, file.Path, file.plans, func(n int64) { rows.Add(n) })
+	} else if file.databases, err = countRows(ctx, j.conn, j.source, file.Databases, !j.opts.Objects.SchemaOnly); err == nil {
+		opts := j.dumpOpts
+		if j.snapshot != nil {
+			opts.Snapshot = j.snapshot.exported[file.Databases[0].Name]
+		}
+		err = dh.WriteDump(ctx, j.conn, file.DumpFile, opts)
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		describeTables(file.databases, file.plans)
+	}
+	if err == nil && j.snapshot != nil {
+		for d := range file.databases {
+			file.databases[d].LSN = j.snapshot.LSN[file.databases[d].Name]
+		}
+	}
+	if err == nil && j.dumpOpts.SourceData != "" {
+		// mysqldump wrote the position of its snapshot at the top of the file
+		if position, perr := readSourceData(file.Path); perr == nil {
+			j.manifest.Binlog = position
+		}
+	}
+	close(stop)
+	<-stopped
+	report(true, err)
+	return err
+}
+
+// Finish writes the manifest of every file of the job and returns the
+// problems met.
+func (j *Job) Finish() []error {
+	errs := make([]error, 0)
+	j.manifest.DurationMs = time.Since(j.start).Milliseconds()
+	for _, f := range j.Files {
+		m := j.manifest
+		m.Databases = f.databases
+		if _, err := WriteManifest(f.Path, m); err != nil {
+			errs = append(errs, fmt.Errorf("could not write the manifest of %s: %w", f.Path, err))
+		}
+	}
+	return errs
+}
+
+// Create dumps the selected databases and tables of the connection tagged
+// tag into dir, spread over files as opts tells, and writes a manifest next
+// to every dump file.
+func Create(tag string, conn config.StoredConnection, dir string, selectedDbs []util.DbListItem, selectedTables []util.TableListItem, opts Options) bool {
+	job, err := Prepare(context.Background(), tag, conn, dir, selectedDbs, selectedTables, opts)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return Execute(job)
+}
+
+// Execute writes the files of a prepared job and their manifests.
+func Execute(job *Job) bool {
+	job.PrintIssues()
+	job.PrintFilters()
+	if err := job.Run(context.Background(), nil); err != nil {
+		fmt.Println(err)
+		job.PrintResumeHint()
+		return false
+	}
+	for _, err := range job.Finish() {
+		fmt.Printf("---> %s\n", err)
+	}
+	for _, f := range job.Files {
+		fmt.Printf("---> Dump written to %s\n", f.Path)
+	}
+	return true
+}
+
+// PrintResumeHint tells how to resume a resumable job that failed.
+func (j *Job) PrintResumeHint() {
+	if j.state != nil {
+		fmt.Printf("---> Resume the dump with: seraphim db dump --resume %s\n", j.state.Path())
+	}
+}
+
+// masks returns the masks of the columns of t in database along with the
+// strategy of every masked column.
+func masks(m *masking.Masker, database string, t dh.TableDetails) ([]masking.Func, map[string]string) {
+	if m == nil {
+		return nil, nil
+	}
+	var funcs []masking.Func
+	var masked map[string]string
+	for i, c := range t.Columns {
+		f := m.Column(database, t.Name, c.Name)
+		if f == nil {
+			continue
+		}
+		if funcs == nil {
+			funcs = make([]masking.Func, len(t.Columns))
+			masked = make(map[string]string)
+		}
+		funcs[i] = f
+		masked[c.Name] = m.Strategy(database, t.Name, c.Name)
+	}
+	return funcs, masked
+}
+
+// checkUnmasked fails when masking rules match columns of files, which the
+// client tools of the providers would write as they are.
+func checkUnmasked(ctx context.Context, conn config.StoredConnection, files []dh.DumpFile, m *masking.Masker) error {
+	for _, f := range files {
+		for _, d := range f.Databases {
+			tables := d.Tables
+			if tables == nil {
+				var err error
+				if tables, err = dh.ListTables(ctx, conn, d.Name); err != nil {
+					return err
+				}
+			}
+			for _, t := range tables {
+				columns, err := dh.ListColumns(ctx, conn, d.Name, t)
+				if err != nil {
+					return err
+				}
+				for _, c := range columns {
+					if m.Column(d.Name, t, c.Name) != nil {
+						return fmt.Errorf("masking rules match %s.%s.%s, set a target dialect so that seraphim masks the dump", d.Name, t, c.Name)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// describeTables records the filters and masks of the tables of plans in
+// the manifests of databases.
+func describeTables(databases []DatabaseManifest, plans []DialectPlan) {
+	for _, plan := range plans {
+		for _, t := range plan.Tables {
+			for d := range databases {
+				if databases[d].Name != plan.Database {
+					continue
+				}
+				for i := range databases[d].Tables {
+					if databases[d].Tables[i].Name == t.Source.Name {
+						databases[d].Tables[i].Filter = t.Filter.String()
+						databases[d].Tables[i].Masked = t.Masked
+					}
+				}
+			}
+		}
+	}
+}
+
+// dumps tells whether the job dumps table, named db.table.
+func (j *Job) dumps(table string) bool {
+	for _, f := range j.Files {
+		for _, plan := range f.plans {
+			for _, t := range plan.Tables {
+				if plan.Database+"."+t.Source.Name == table {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// PrintFilters lists the filters of the tables of the job.
+func (j *Job) PrintFilters() {
+	for _, f := range j.Files {
+		for _, plan := range f.plans {
+			for _, t := range plan.Tables {
+				if !t.Filter.IsZero() {
+					fmt.Printf("---> %s.%s: %s\n", plan.Database, t.Source.Name, t.Filter)
+				}
+			}
+		}
+	}
+}
+
+// databaseNames returns the databases dumped by the job.
+func (j *Job) databaseNames() []string {
+	names := make([]string, 0)
+	for _, f := range j.Files {
+		for _, d := range f.Databases {
+			if !slices.Contains(names, d.Name) {
+				names = append(names, d.Name)
+			}
+		}
+	}
+	return names
+}
+
+// countRows counts the rows of the tables of databases, read from source,
+// every table of a database without tables. Without count the tables are
+// listed with no rows, as a schema only dump holds.
+func countRows(ctx context.Context, conn config.StoredConnection, source sourceFunc, dumped []dh.DumpDatabase, count bool) ([]DatabaseManifest, error) {
+	provider := dh.NormalizeProvider(conn.Provider)
+	databases := make([]DatabaseManifest, 0, len(dumped))
+	for _, d := range dumped {
+		tables := d.Tables
+		if tables == nil {
+			var err error
+			if tables, err = dh.ListTables(ctx, conn, d.Name); err != nil {
+				return nil, err
+			}
+		}
+		db, release, err := source(ctx, d.Name)
+		if err != nil {
+			return nil, err
+		}
+		counts := make([]TableManifest, 0, len(tables))
+		for _, t := range tables {
+			var rows int64
+			if !count {
+				counts = append(counts, TableManifest{Name: t})
+				continue
+			}
+			if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+dh.QuoteTable(provider, t)).Scan(&rows); err != nil {
+				release()
+				return nil, fmt.Errorf("%s.%s: %w", d.Name, t, err)
+			}
+			counts = append(counts, TableManifest{Name: t, Rows: rows})
+		}
+		release()
+		databases = append(databases, DatabaseManifest{Name: d.Name, Tables: counts})
+	}
+	return databases, nil
+}
+
+// selectsTables tells whether files select tables of a database instead of
+// dumping it whole.
+func selectsTables(files []dh.DumpFile) bool {
+	for _, f := range files {
+		for _, d := range f.Databases {
+			if d.Tables != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,409 @@
+// Package dump writes SQL dumps natively, without the client tools of the
+// providers, so that the statements can target another provider.
+package dump
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"seraphim/lib/config"
+	dh "seraphim/lib/db/query"
+	"seraphim/lib/masking"
+	"seraphim/lib/schemadiff"
+	"seraphim/lib/typemap"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialects lists the providers a dump can be written for.
+var Dialects = []string{"mysql", "postgres", "sqlite"}
+
+// insertBatch is the number of rows written per INSERT statement.
+const insertBatch = 100
+
+// dialectTable is a source table along with its definition in the dialect of
+// the dump.
+type dialectTable struct {
+	Source dh.TableDetails
+	Target dh.TableDetails
+	// Filter selects the rows dumped, every row when zero
+	Filter Filter
+	// Masks mask the values of the source columns, nil for columns written
+	// as they are, and Masked holds the strategy of every masked column
+	Masks  []masking.Func
+	Masked map[string]string
+}
+
+// DialectPlan lists the tables of a database to dump and the conversions
+// that lose information.
+type DialectPlan struct {
+	Database string
+	Dialect  string
+	Tables   []dialectTable
+	Issues   []typemap.Issue
+	// FollowForeignKeys adds the rows referenced by the filtered rows of the
+	// tables, see readSubset
+	FollowForeignKeys bool
+	// SchemaOnly leaves the rows out and DataOnly the definitions of the
+	// tables, the rows then filling existing tables
+	SchemaOnly bool
+	DataOnly   bool
+}
+
+// PlanDialect describes tables of database, every table when empty, and
+// translates them into dialect.
+func PlanDialect(ctx context.Context, conn config.StoredConnection, database string, tables []string, dialect string) (DialectPlan, error) {
+	plan := DialectPlan{Database: database, Dialect: dh.NormalizeProvider(dialect), Issues: make([]typemap.Issue, 0)}
+	if len(tables) == 0 {
+		var err error
+		if tables, err = dh.ListTables(ctx, conn, database); err != nil {
+			return plan, err
+		}
+	}
+	details := make([]dh.TableDetails, 0, len(tables))
+	for _, table := range tables {
+		d, err := dh.DescribeTable(ctx, conn, database, table)
+		if err != nil {
+			return plan, fmt.Errorf("%s: %w", table, err)
+		}
+		details = append(details, d)
+	}
+
+	mapper := typemap.New(conn.Provider, plan.Dialect)
+	for _, d := range dh.DependencyOrder(details) {
+		target, issues := mapper.Table(d)
+		plan.Tables = append(plan.Tables, dialectTable{Source: d, Target: target})
+		plan.Issues = append(plan.Issues, issues...)
+	}
+	return plan, nil
+}
+
+func dialectHeader(dialect string) []string {
+	switch dialect {
+	case "mysql":
+		return []string{"SET NAMES utf8mb4;", "SET FOREIGN_KEY_CHECKS = 0;"}
+	case "postgres":
+		return []string{"SET client_encoding = 'UTF8';", "SET standard_conforming_strings = on;"}
+	default:
+		return []string{"PRAGMA foreign_keys = OFF;", "BEGIN TRANSACTION;"}
+	}
+}
+
+func dialectFooter(dialect string) []string {
+	switch dialect {
+	case "mysql":
+		return []string{"SET FOREIGN_KEY_CHECKS = 1;"}
+	case "sqlite":
+		return []string{"COMMIT;"}
+	}
+	return nil
+}
+
+// WriteDialect writes the tables of plan, their rows and their foreign keys
+// as statements of the dialect of the plan. It returns the number of rows
+// written per table. progress, when set, is called with the number of rows
+// written since its previous call.
+func WriteDialect(ctx context.Context, w io.Writer, conn config.StoredConnection, plan DialectPlan, progress func(rows int64)) ([]TableManifest, error) {
+	db, err := dh.Open(conn, plan.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return writeDialect(ctx, w, db, conn.Provider, plan, progress)
+}
+
+func writeDialect(ctx context.Context, w io.Writer, db queryer, provider string, plan DialectPlan, progress func(rows int64)) ([]TableManifest, error) {
+	out := bufio.NewWriter(w)
+	writePreamble(out, plan)
+	var subset map[string]*subsetTable
+	if plan.FollowForeignKeys && !plan.SchemaOnly {
+		var err error
+		if subset, err = readSubset(ctx, db, provider, plan); err != nil {
+			return nil, err
+		}
+	}
+	counts := make([]TableManifest, 0, len(plan.Tables))
+	dumped := dumpedTables(plan)
+	for _, t := range plan.Tables {
+		writeTableDDL(out, plan, t, dumped)
+		var rows int64
+		switch {
+		case plan.SchemaOnly:
+		case subset != nil:
+			rows = subset[t.Source.Name].write(out, plan.Dialect, t, progress)
+		default:
+			var err error
+			if rows, _, err = writeRows(ctx, out, db, provider, plan.Dialect, t, nil, 0, progress); err != nil {
+				return nil, fmt.Errorf("%s: %w", t.Source.Name, err)
+			}
+		}
+		if rows > 0 {
+			writeSequences(out, plan.Dialect, t)
+		}
+		counts = append(counts, TableManifest{Name: t.Source.Name, Rows: rows})
+	}
+	writeEpilogue(out, plan, dumped)
+	return counts, out.Flush()
+}
+
+func dumpedTables(plan DialectPlan) map[string]bool {
+	dumped := make(map[string]bool, len(plan.Tables))
+	for _, t := range plan.Tables {
+		dumped[t.Source.Name] = true
+	}
+	return dumped
+}
+
+func writePreamble(out *bufio.Writer, plan DialectPlan) {
+	fmt.Fprintf(out, "-- Dump of %s written by seraphim for %s on %s\n", plan.Database, plan.Dialect, time.Now().Format(time.RFC3339))
+	for _, line := range dialectHeader(plan.Dialect) {
+		fmt.Fprintln(out, line)
+	}
+}
+
+// writeTableDDL creates t, dumped holding the tables of the dump its
+// foreign keys may reference. Data only plans create no table.
+func writeTableDDL(out *bufio.Writer, plan DialectPlan, t dialectTable, dumped map[string]bool) {
+	fmt.Fprintf(out, "\n-- Table %s\n", t.Source.Name)
+	if plan.DataOnly {
+		return
+	}
+	target := t.Target
+	// Inline sqlite foreign keys must reference dumped tables
+	fks := make([]dh.ForeignKey, 0, len(target.ForeignKeys))
+	for _, fk := range target.ForeignKeys {
+		if dumped[fk.ReferencedTable] {
+			fks = append(fks, fk)
+		}
+	}
+	target.ForeignKeys = fks
+	for _, statement := range schemadiff.CreateTableDDL(plan.Dialect, target) {
+		fmt.Fprintln(out, statement)
+	}
+}
+
+// writeEpilogue adds the foreign keys once every table is filled, then the
+// completion marker of the database.
+func writeEpilogue(out *bufio.Writer, plan DialectPlan, dumped map[string]bool) {
+	if plan.Dialect != "sqlite" && !plan.DataOnly {
+		fmt.Fprintln(out)
+		for _, t := range plan.Tables {
+			for _, fk := range t.Target.ForeignKeys {
+				if dumped[fk.ReferencedTable] {
+					fmt.Fprintln(out, schemadiff.ForeignKeyDDL(plan.Dialect, t.Target.Name, fk))
+				}
+			}
+		}
+	}
+	for _, line := range dialectFooter(plan.Dialect) {
+		fmt.Fprintln(out, line)
+	}
+	fmt.Fprintln(out, completedMarker(plan.Database))
+}
+
+// completedMarker ends the statements of a database, telling a complete
+// dump from a truncated one.
+func completedMarker(database string) string {
+	return fmt.Sprintf("-- Dump of %s completed", database)
+}
+
+// writeRows writes the rows of t selected by its filter as multi row INSERT
+// statements and returns their number, reporting them to progress after
+// every statement. With a limit, at most limit rows following the primary
+// key after are written in key order, and the key of the last one is
+// returned.
+func writeRows(ctx context.Context, out *bufio.Writer, db queryer, provider string, dialect string, t dialectTable, after []any, limit int, progress func(rows int64)) (int64, []any, error) {
+	keys := columnIndexes(t.Source, t.Source.PrimaryKey)
+	conditions := t.Filter.conditions(provider)
+	if len(after) > 0 {
+		key := make([]string, len(keys))
+		params := make([]string, len(keys))
+		for i, k := range keys {
+			key[i] = dh.QuoteIdent(provider, t.Source.Columns[k].Name)
+			params[i] = dh.Placeholder(provider, i+1)
+		}
+		conditions = append(conditions, "("+strings.Join(key, ", ")+") > ("+strings.Join(params, ", ")+")")
+	}
+	if t.Filter.Limit > 0 && (limit == 0 || t.Filter.Limit < int64(limit)) {
+		limit = int(t.Filter.Limit)
+	}
+	query := rowQuery(provider, t, conditions, limit)
+	rows, err := db.QueryContext(ctx, query, after...)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+	infos, err := dh.ColumnInfos(rows)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	w := newInsertWriter(out, dialect, t, infos, progress)
+	values := make([]any, len(infos))
+	dest := make([]any, len(infos))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	var last []any
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return w.count, nil, err
+		}
+		w.write(values)
+		if limit > 0 && len(keys) > 0 {
+			last = make([]any, len(keys))
+			for i, k := range keys {
+				last[i] = dh.KeyArg(values[k])
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return w.count, nil, err
+	}
+	return w.close(), last, nil
+}
+
+// rowQuery selects the columns of t matching every condition. With a limit,
+// the first limit rows in primary key order are selected, whichever come
+// first without a primary key.
+func rowQuery(provider string, t dialectTable, conditions []string, limit int) string {
+	columns := make([]string, len(t.Source.Columns))
+	for i, c := range t.Source.Columns {
+		columns[i] = dh.QuoteIdent(provider, c.Name)
+	}
+	query := "SELECT " + strings.Join(columns, ", ") + " FROM " + dh.QuoteTable(provider, t.Source.Name)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if limit > 0 {
+		if len(t.Source.PrimaryKey) > 0 {
+			key := make([]string, 0, len(t.Source.PrimaryKey))
+			for _, k := range columnIndexes(t.Source, t.Source.PrimaryKey) {
+				key = append(key, columns[k])
+			}
+			query += " ORDER BY " + strings.Join(key, ", ")
+		}
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+	return query
+}
+
+// columnIndexes returns the positions of names among the columns of t, in
+// the order of names, e.g. that of the columns of a primary key.
+func columnIndexes(t dh.TableDetails, names []string) []int {
+	indexes := make([]int, 0, len(names))
+	for _, name := range names {
+		for i, c := range t.Columns {
+			if c.Name == name {
+				indexes = append(indexes, i)
+				break
+			}
+		}
+	}
+	return indexes
+}
+
+// insertWriter writes rows of a table as multi row INSERT statements of
+// insertBatch rows.
+type insertWriter struct {
+	out      *bufio.Writer
+	insert   string
+	literals []typemap.LiteralFunc
+	infos    []dh.ColumnInfo
+	masks    []masking.Func
+	cells    []string
+	count    int64
+	progress func(rows int64)
+}
+
+func newInsertWriter(out *bufio.Writer, dialect string, t dialectTable, infos []dh.ColumnInfo, progress func(rows int64)) *insertWriter {
+	if progress == nil {
+		progress = func(int64) {}
+	}
+	target := make([]string, len(t.Target.Columns))
+	literals := make([]typemap.LiteralFunc, len(t.Target.Columns))
+	for i, c := range t.Target.Columns {
+		target[i] = dh.QuoteIdent(dialect, c.Name)
+		literals[i] = typemap.Literal(dialect, c)
+	}
+	return &insertWriter{
+		out:      out,
+		insert:   "INSERT INTO " + dh.QuoteTable(dialect, t.Target.Name) + " (" + strings.Join(target, ", ") + ") VALUES",
+		literals: literals,
+		infos:    infos,
+		masks:    t.Masks,
+		cells:    make([]string, len(infos)),
+		progress: progress,
+	}
+}
+
+func (w *insertWriter) write(values []any) {
+	for i, v := range values {
+		if i < len(w.masks) && w.masks[i] != nil {
+			v = w.masks[i](v, w.infos[i].Kind)
+		}
+		w.cells[i] = w.literals[i](v, w.infos[i].Kind)
+	}
+	if w.count%insertBatch == 0 {
+		if w.count > 0 {
+			w.out.WriteString(";\n")
+			w.progress(insertBatch)
+		}
+		w.out.WriteString(w.insert + "\n  (")
+	} else {
+		w.out.WriteString(",\n  (")
+	}
+	w.out.WriteString(strings.Join(w.cells, ", ") + ")")
+	w.count++
+}
+
+// close ends the last statement and returns the number of rows written.
+func (w *insertWriter) close() int64 {
+	if w.count > 0 {
+		w.out.WriteString(";\n")
+		w.progress((w.count-1)%insertBatch + 1)
+	}
+	return w.count
+}
+
+// writeSequences moves the postgres sequences of t past the ids it was
+// filled with, explicit ids not advancing them.
+func writeSequences(out *bufio.Writer, dialect string, t dialectTable) {
+	if dialect != "postgres" {
+		return
+	}
+	for _, c := range t.Target.Columns {
+		if strings.HasSuffix(c.Type, "serial") {
+			table, column := dh.QuoteTable(dialect, t.Target.Name), dh.QuoteIdent(dialect, c.Name)
+			fmt.Fprintf(out, "SELECT setval(pg_get_serial_sequence('%s', '%s'), MAX(%s)) FROM %s;\n",
+				strings.ReplaceAll(table, "'", "''"), strings.ReplaceAll(c.Name, "'", "''"), column, table)
+		}
+	}
+}
+
+// writeDialectFile writes the databases of plans into a new file at path,
+// reading them from source, and returns the rows written per database.
+func writeDialectFile(ctx context.Context, source sourceFunc, provider string, path string, plans []DialectPlan, progress func(rows int64)) ([]DatabaseManifest, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	databases := make([]DatabaseManifest, 0, len(plans))
+	for _, plan := range plans {
+		db, release, err := source(ctx, plan.Database)
+		if err != nil {
+			return nil, err
+		}
+		tables, err := writeDialect(ctx, f, db, provider, plan, progress)
+		release()
+		if err != nil {
+			return nil, err
+		}
+		databases = append(databases, DatabaseManifest{Name: plan.Database, Tables: tables})
+	}
+	return databases, f.Close()
+}
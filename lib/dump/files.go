@@ -0,0 +1,165 @@
+package dump
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"seraphim/lib/config"
+	dh "seraphim/lib/db/query"
+	"seraphim/lib/masking"
+	"seraphim/lib/util"
+	"time"
+)
+
+// Split modes tell how the selection of a dump is spread over files.
+const (
+	SplitSingleFile  = "single_file"
+	SplitPerDatabase = "per_database"
+	SplitPerTable    = "per_table"
+)
+
+// Options tunes how a dump is written.
+type Options struct {
+	// Dialect is the provider the dump is written for by seraphim, the
+	// client tools of the connection provider writing it when empty
+	Dialect string `json:"dialect,omitempty"`
+	// Template names the files of the dump relative to the dump directory,
+	// see util.FilenamePlaceholders
+	Template string `json:"template,omitempty"`
+	// Split is one of the split modes, the default of the provider when
+	// empty
+	Split string `json:"split,omitempty"`
+	// Parallel is the number of files written at once
+	Parallel int `json:"parallel,omitempty"`
+	// Consistent reads every table of a database, and every database when
+	// the provider allows it, as of a single point in time
+	Consistent bool `json:"consistent,omitempty"`
+	// Resumable writes tables in chunks of ChunkRows rows ordered by primary
+	// key, checkpointed in a state file the dump resumes from
+	Resumable bool `json:"resumable,omitempty"`
+	ChunkRows int  `json:"chunk_rows,omitempty"`
+	// Sample keeps part of the rows of the tables without a sample of their
+	// own
+	Sample Sample `json:"sample,omitempty"`
+	// Filters select the rows of tables, keyed by db.table
+	Filters map[string]Filter `json:"filters,omitempty"`
+	// FollowForeignKeys adds the rows referenced by the rows dumped, keeping
+	// a filtered dump referentially consistent
+	FollowForeignKeys bool `json:"follow_foreign_keys,omitempty"`
+	// Mask masks the values of the columns matched by the masking rules,
+	// which dialect dumps only can do
+	Mask *masking.Masker `json:"-"`
+	// Objects selects the definitions, rows and other objects dumped
+	Objects dh.DumpObjects `json:"objects"`
+}
+
+// defaultTemplates name the files of each split mode when no template is
+// configured.
+var defaultTemplates = map[string]string{
+	SplitSingleFile:  "dump-{unix}",
+	SplitPerDatabase: "dump-{unix}-{db}",
+	SplitPerTable:    "dump-{unix}-{db}-{table}",
+}
+
+// split returns the split mode of a dump of conn. pg_dump archives cannot
+// hold several databases, hence postgres dumps default to a file per
+// database unless written for another dialect.
+func (o Options) split(conn config.StoredConnection) string {
+	if o.Split != "" {
+		return o.Split
+	}
+	if o.Dialect == "" && dh.NormalizeProvider(conn.Provider) == "postgres" {
+		return SplitPerDatabase
+	}
+	return SplitSingleFile
+}
+
+// extension returns the extension given to files whose template has none.
+func (o Options) extension(conn config.StoredConnection) string {
+	if o.Dialect == "" && dh.NormalizeProvider(conn.Provider) == "postgres" {
+		return ".dump"
+	}
+	return ".sql"
+}
+
+// planFiles spreads the selected databases and tables over the files of the
+// dump according to the split mode of opts and names them from its template.
+func planFiles(ctx context.Context, tag string, conn config.StoredConnection, dir string, selectedDbs []util.DbListItem, selectedTables []util.TableListItem, opts Options, start time.Time) ([]dh.DumpFile, error) {
+	split := opts.split(conn)
+	template := opts.Template
+	if template == "" {
+		template = defaultTemplates[split]
+	}
+	if err := util.CheckFilenameTemplate(template); err != nil {
+		return nil, fmt.Errorf("dump file name template %q: %w", template, err)
+	}
+
+	databases := make([]dh.DumpDatabase, 0, len(selectedDbs))
+	for _, d := range selectedDbs {
+		databases = append(databases, dh.DumpDatabase{Name: d.Name, Tables: util.TablesOf(d.Name, selectedTables)})
+	}
+	vars := util.FilenameVars{Tag: tag, Provider: dh.NormalizeProvider(conn.Provider), Time: start}
+	var groups [][]dh.DumpDatabase
+	switch split {
+	case SplitSingleFile:
+		if len(databases) > 1 && opts.Dialect == "" && vars.Provider == "postgres" {
+			return nil, fmt.Errorf("a postgres archive holds a single database, use the %s split mode", SplitPerDatabase)
+		}
+		groups = [][]dh.DumpDatabase{databases}
+	case SplitPerDatabase:
+		for _, d := range databases {
+			groups = append(groups, []dh.DumpDatabase{d})
+		}
+	case SplitPerTable:
+		for _, d := range databases {
+			tables := d.Tables
+			if tables == nil {
+				var err error
+				if tables, err = dh.ListTables(ctx, conn, d.Name); err != nil {
+					return nil, err
+				}
+			}
+			for _, t := range tables {
+				groups = append(groups, []dh.DumpDatabase{{Name: d.Name, Tables: []string{t}}})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown split mode %q, expected one of %s, %s or %s", split, SplitSingleFile, SplitPerDatabase, SplitPerTable)
+	}
+
+	files := make([]dh.DumpFile, 0, len(groups))
+	seen := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		v := vars
+		v.Tables = nil
+		if len(group) == 1 {
+			v.Database = group[0].Name
+			if len(group[0].Tables) == 1 {
+				v.Table = group[0].Tables[0]
+			}
+		}
+		for _, d := range group {
+			if d.Tables == nil {
+				v.Tables = nil
+				break
+			}
+			for _, t := range d.Tables {
+				v.Tables = append(v.Tables, d.Name+"."+t)
+			}
+		}
+		name, err := util.RenderFilename(template, v)
+		if err != nil {
+			return nil, err
+		}
+		if filepath.Ext(name) == "" {
+			name += opts.extension(conn)
+		}
+		path := filepath.Join(dir, name)
+		if seen[path] {
+			return nil, fmt.Errorf("dump file name template %q names several files %s, add {db} or {table} to it", template, path)
+		}
+		seen[path] = true
+		files = append(files, dh.DumpFile{Path: path, Databases: group})
+	}
+	return files, nil
+}
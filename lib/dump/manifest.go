@@ -0,0 +1,193 @@
+package dump
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	dh "seraphim/lib/db/query"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ManifestSuffix is appended to the name of a dump file to name its manifest.
+const ManifestSuffix = ".manifest.json"
+
+// Manifest is the sidecar file describing where a dump comes from and what
+// it holds.
+type Manifest struct {
+	File            string             `json:"file"`
+	Connection      string             `json:"connection"`
+	Provider        string             `json:"provider"`
+	Dialect         string             `json:"dialect,omitempty"`
+	ServerVersion   string             `json:"server_version,omitempty"`
+	Databases       []DatabaseManifest `json:"databases"`
+	Consistent      bool               `json:"consistent,omitempty"`
+	Binlog          *BinlogPosition    `json:"binlog,omitempty"`
+	Size            int64              `json:"size"`
+	Checksum        string             `json:"checksum"`
+	SeraphimVersion string             `json:"seraphim_version"`
+	CreatedAt       time.Time          `json:"created_at"`
+	DurationMs      int64              `json:"duration_ms"`
+
+	// FollowForeignKeys tells that the rows referenced by the filtered rows
+	// were dumped along with them
+	FollowForeignKeys bool `json:"follow_foreign_keys,omitempty"`
+	// Objects records what the dump holds when it is not every object the
+	// engine writing it dumps by default
+	Objects *dh.DumpObjects `json:"objects,omitempty"`
+
+	// Path is the location of the dump file, set when the manifest is read
+	Path string `json:"-"`
+}
+
+type DatabaseManifest struct {
+	Name   string          `json:"name"`
+	Tables []TableManifest `json:"tables"`
+	// LSN is the postgres WAL location of the snapshot of a consistent dump
+	LSN string `json:"lsn,omitempty"`
+}
+
+// TableManifest records the rows of a table, counted when the dump started
+// for dumps written by the client tools of the provider.
+type TableManifest struct {
+	Name string `json:"name"`
+	Rows int64  `json:"rows"`
+	// Filter describes the rows selected by a filtered dump
+	Filter string `json:"filter,omitempty"`
+	// Masked holds the masking strategy of the masked columns
+	Masked map[string]string `json:"masked,omitempty"`
+}
+
+// Rows returns the number of rows of all the tables of the dump.
+func (m Manifest) Rows() int64 {
+	var rows int64
+	for _, d := range m.Databases {
+		for _, t := range d.Tables {
+			rows += t.Rows
+		}
+	}
+	return rows
+}
+
+// Tables returns the number of tables of the dump.
+func (m Manifest) Tables() int {
+	tables := 0
+	for _, d := range m.Databases {
+		tables += len(d.Tables)
+	}
+	return tables
+}
+
+func (m Manifest) Duration() time.Duration {
+	return time.Duration(m.DurationMs) * time.Millisecond
+}
+
+// DatabaseNames returns the names of the databases of the dump.
+func (m Manifest) DatabaseNames() []string {
+	names := make([]string, len(m.Databases))
+	for i, d := range m.Databases {
+		names[i] = d.Name
+	}
+	return names
+}
+
+// ManifestPath returns the path of the manifest of a dump file.
+func ManifestPath(file string) string {
+	return file + ManifestSuffix
+}
+
+// Checksum returns the SHA-256 digest of the file at path, prefixed with the
+// name of the algorithm.
+func Checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// WriteManifest completes m with the size and checksum of the dump file at
+// path and writes it next to the file.
+func WriteManifest(path string, m Manifest) (Manifest, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return m, err
+	}
+	m.File = filepath.Base(path)
+	m.Size = info.Size()
+	if m.Checksum, err = Checksum(path); err != nil {
+		return m, err
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return m, err
+	}
+	m.Path = path
+	return m, os.WriteFile(ManifestPath(path), append(b, '\n'), 0o644)
+}
+
+// ReadManifest reads the manifest of a dump, path naming either the dump
+// file or its manifest.
+func ReadManifest(path string) (Manifest, error) {
+	manifest := path
+	if !strings.HasSuffix(path, ManifestSuffix) {
+		manifest = ManifestPath(path)
+	}
+	b, err := os.ReadFile(manifest)
+	if os.IsNotExist(err) {
+		return Manifest{}, fmt.Errorf("no manifest found for %s", path)
+	}
+	if err != nil {
+		return Manifest{}, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return Manifest{}, fmt.Errorf("%s: %w", manifest, err)
+	}
+	m.Path = strings.TrimSuffix(manifest, ManifestSuffix)
+	return m, nil
+}
+
+// ListManifests reads the manifests found in dir and its subdirectories,
+// newest first. Manifests that cannot be read are returned as errors next to
+// the others.
+func ListManifests(dir string) ([]Manifest, []error) {
+	paths := make([]string, 0)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ManifestSuffix) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, []error{err}
+	}
+	manifests := make([]Manifest, 0, len(paths))
+	errs := make([]error, 0)
+	for _, p := range paths {
+		m, err := ReadManifest(p)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		manifests = append(manifests, m)
+	}
+	sort.SliceStable(manifests, func(i, j int) bool {
+		return manifests[i].CreatedAt.After(manifests[j].CreatedAt)
+	})
+	return manifests, errs
+}
@@ -0,0 +1,201 @@
+package dump
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"seraphim/lib/config"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a dump found in a directory, described by its manifest or, when
+// it has none, by the timestamp of its file name.
+type Entry struct {
+	Path       string
+	Connection string
+	CreatedAt  time.Time
+	Size       int64
+	Manifest   bool
+}
+
+// dumpName matches the names of the dump files, which start with the unix
+// time of their creation.
+var dumpName = regexp.MustCompile(`^dump-(\d{9,})\b`)
+
+// ScanDir returns the dumps of dir and its subdirectories, newest first.
+// Files without a manifest are only considered when their name carries a
+// timestamp.
+func ScanDir(dir string) ([]Entry, []error) {
+	manifests, errs := ListManifests(dir)
+	entries := make([]Entry, 0, len(manifests))
+	described := make(map[string]bool, len(manifests))
+	for _, m := range manifests {
+		entries = append(entries, Entry{Path: m.Path, Connection: m.Connection, CreatedAt: m.CreatedAt, Size: m.Size, Manifest: true})
+		described[filepath.Clean(m.Path)] = true
+	}
+
+	err := filepath.WalkDir(dir, func(path string, f fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := f.Name()
+		if f.IsDir() && strings.HasSuffix(name, ".parts") {
+			// The chunks of a resumable dump still running or to resume
+			return filepath.SkipDir
+		}
+		if f.IsDir() || described[filepath.Clean(path)] || strings.HasSuffix(name, ManifestSuffix) || strings.Contains(name, StateSuffix) {
+			return nil
+		}
+		match := dumpName.FindStringSubmatch(name)
+		if match == nil {
+			return nil
+		}
+		unix, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil
+		}
+		info, err := f.Info()
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		entries = append(entries, Entry{Path: path, CreatedAt: time.Unix(unix, 0), Size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+	return entries, errs
+}
+
+// Decision tells whether a dump survives a prune and which rules keep it.
+type Decision struct {
+	Entry
+	Keep    bool
+	Reasons []string
+}
+
+// bucket returns the period of t a rule keeps a single dump for.
+type bucket func(t time.Time) string
+
+func dayBucket(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func weekBucket(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+func monthBucket(t time.Time) string {
+	return t.Format("2006-01")
+}
+
+// run is the files of a dump written at once, the files of a split dump
+// sharing the connection and creation time of their manifests.
+type run struct {
+	created time.Time
+	indexes []int
+}
+
+// ApplyRetention decides which of entries survive the policies of retention,
+// each connection tag being handled on its own. Dumps without a known tag
+// follow the default policy. Rules count runs rather than files, the files of
+// a split dump being kept or removed together. Daily, weekly and monthly
+// rules keep the newest run of each of the last D days, W ISO weeks or M
+// calendar months, the current one included.
+func ApplyRetention(entries []Entry, retention config.RetentionConfig, now time.Time) []Decision {
+	decisions := make([]Decision, len(entries))
+	groups := make(map[string][]*run)
+	runs := make(map[string]*run)
+	for i, e := range entries {
+		decisions[i] = Decision{Entry: e}
+		key := e.Connection + "@" + strconv.FormatInt(e.CreatedAt.Unix(), 10)
+		r, ok := runs[key]
+		if !ok {
+			r = &run{created: e.CreatedAt}
+			runs[key] = r
+			groups[e.Connection] = append(groups[e.Connection], r)
+		}
+		r.indexes = append(r.indexes, i)
+	}
+	keep := func(r *run, reason string) {
+		for _, i := range r.indexes {
+			decisions[i].Keep = true
+			decisions[i].Reasons = append(decisions[i].Reasons, reason)
+		}
+	}
+
+	now = now.Local()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	for tag, runs := range groups {
+		sort.SliceStable(runs, func(a, b int) bool {
+			return runs[a].created.After(runs[b].created)
+		})
+		policy := retention.Policy(tag)
+		if policy.IsZero() {
+			for _, r := range runs {
+				keep(r, "no retention policy")
+			}
+			continue
+		}
+
+		for n, r := range runs {
+			if n < policy.KeepLast {
+				keep(r, fmt.Sprintf("last %d", policy.KeepLast))
+			}
+		}
+		rules := []struct {
+			name   string
+			count  int
+			since  time.Time
+			bucket bucket
+		}{
+			{"daily", policy.KeepDaily, today.AddDate(0, 0, 1-policy.KeepDaily), dayBucket},
+			{"weekly", policy.KeepWeekly, monday.AddDate(0, 0, 7*(1-policy.KeepWeekly)), weekBucket},
+			{"monthly", policy.KeepMonthly, month.AddDate(0, 1-policy.KeepMonthly, 0), monthBucket},
+		}
+		for _, rule := range rules {
+			if rule.count == 0 {
+				continue
+			}
+			seen := make(map[string]bool)
+			for _, r := range runs {
+				created := r.created.Local()
+				if created.Before(rule.since) {
+					break
+				}
+				period := rule.bucket(created)
+				if seen[period] {
+					continue
+				}
+				seen[period] = true
+				keep(r, rule.name+" "+period)
+			}
+		}
+	}
+	return decisions
+}
+
+// Remove deletes the dump file of e and its manifest.
+func (e Entry) Remove() error {
+	if err := os.Remove(e.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if e.Manifest {
+		if err := os.Remove(ManifestPath(e.Path)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
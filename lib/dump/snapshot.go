@@ -0,0 +1,323 @@
+package dump
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"seraphim/lib/config"
+	dh "seraphim/lib/db/query"
+	"strconv"
+	"strings"
+)
+
+// queryer reads the rows of a dump from a database, a connection or a
+// transaction holding a snapshot.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// sourceFunc returns what the rows of database are read from along with a
+// func releasing it once they are.
+type sourceFunc func(ctx context.Context, database string) (queryer, func(), error)
+
+// directSource reads every database through a connection of its own, each
+// query seeing the latest data.
+func directSource(conn config.StoredConnection) sourceFunc {
+	return func(ctx context.Context, database string) (queryer, func(), error) {
+		db, err := dh.Open(conn, database)
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, func() { db.Close() }, nil
+	}
+}
+
+// BinlogPosition is the position of the binary log of a mysql server when a
+// snapshot was taken.
+type BinlogPosition struct {
+	File     string `json:"file"`
+	Position int64  `json:"position"`
+	GTIDSet  string `json:"gtid_set,omitempty"`
+}
+
+// snapshot holds transactions sharing a single view of the databases of a
+// dump until it is released.
+//
+// mysql has no way to share a snapshot, hence every worker gets a connection
+// starting its transaction while a global read lock is held. postgres
+// exports a snapshot per database, imported by the transactions of the
+// workers; a postgres snapshot cannot span databases, so databases are only
+// consistent on their own. sqlite reads through a single transaction.
+type snapshot struct {
+	conn     config.StoredConnection
+	provider string
+	// pool holds the mysql and sqlite connections inside the snapshot
+	pool chan *sql.Conn
+	// exported holds the postgres snapshot of each database, valid as long
+	// as the transactions exporting them are open
+	exported  map[string]string
+	exporters []*sql.Tx
+	closers   []io.Closer
+
+	Binlog *BinlogPosition
+	// LSN is the postgres WAL location of the snapshot of each database
+	LSN map[string]string
+}
+
+// takeSnapshot starts the transactions of a consistent dump of databases,
+// workers of them reading at once.
+func takeSnapshot(ctx context.Context, conn config.StoredConnection, databases []string, workers int) (*snapshot, error) {
+	s := &snapshot{
+		conn:     conn,
+		provider: dh.NormalizeProvider(conn.Provider),
+		exported: make(map[string]string),
+		LSN:      make(map[string]string),
+	}
+	var err error
+	switch s.provider {
+	case "mysql":
+		err = s.takeMySQL(ctx, workers)
+	case "postgres":
+		err = s.takePostgres(ctx, databases)
+	case "sqlite":
+		err = s.takeSQLite(ctx)
+	default:
+		err = fmt.Errorf("unknown provider %q", conn.Provider)
+	}
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *snapshot) takeMySQL(ctx context.Context, workers int) error {
+	db, err := dh.Open(s.conn, "")
+	if err != nil {
+		return err
+	}
+	s.closers = append(s.closers, db)
+	lock, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	// Nothing commits while the transactions of the workers start
+	if _, err := lock.ExecContext(ctx, "FLUSH TABLES WITH READ LOCK"); err != nil {
+		return fmt.Errorf("could not lock the tables, consistent mysql dumps need the RELOAD privilege: %w", err)
+	}
+	defer lock.ExecContext(context.Background(), "UNLOCK TABLES")
+
+	s.pool = make(chan *sql.Conn, workers)
+	for i := 0; i < workers; i++ {
+		c, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		s.closers = append(s.closers, c)
+		if _, err := c.ExecContext(ctx, "SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ"); err != nil {
+			return err
+		}
+		if _, err := c.ExecContext(ctx, "START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY"); err != nil {
+			return err
+		}
+		s.pool <- c
+	}
+	s.Binlog, err = binlogPosition(ctx, lock)
+	return err
+}
+
+// binlogPosition returns the current position of the binary log, nil when
+// the server does not log.
+func binlogPosition(ctx context.Context, q queryer) (*BinlogPosition, error) {
+	var logBin int
+	if err := q.QueryRowContext(ctx, "SELECT @@log_bin").Scan(&logBin); err != nil || logBin == 0 {
+		return nil, err
+	}
+	// SHOW MASTER STATUS was renamed in mysql 8.2
+	rows, err := q.QueryContext(ctx, "SHOW BINARY LOG STATUS")
+	if err != nil {
+		if rows, err = q.QueryContext(ctx, "SHOW MASTER STATUS"); err != nil {
+			return nil, err
+		}
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	position := &BinlogPosition{}
+	for i, c := range columns {
+		switch c {
+		case "File":
+			position.File = values[i].String
+		case "Position":
+			position.Position, _ = strconv.ParseInt(values[i].String, 10, 64)
+		case "Executed_Gtid_Set":
+			position.GTIDSet = strings.ReplaceAll(values[i].String, "\n", "")
+		}
+	}
+	return position, rows.Err()
+}
+
+// sourceDataOption returns the mysqldump option recording the position of
+// the binary log, empty when the server does not log. The option was renamed
+// in mysql 8.0.26.
+func sourceDataOption(ctx context.Context, conn config.StoredConnection, version string) string {
+	db, err := dh.Open(conn, "")
+	if err != nil {
+		return ""
+	}
+	defer db.Close()
+	var logBin int
+	if err := db.QueryRowContext(ctx, "SELECT @@log_bin").Scan(&logBin); err != nil || logBin == 0 {
+		return ""
+	}
+	var major, minor, patch int
+	fmt.Sscanf(version, "%d.%d.%d", &major, &minor, &patch)
+	if strings.Contains(strings.ToLower(version), "mariadb") || major*10000+minor*100+patch < 80026 {
+		return "--master-data=2"
+	}
+	return "--source-data=2"
+}
+
+// sourceDataPattern matches the position of the binary log written by
+// mysqldump --source-data.
+var sourceDataPattern = regexp.MustCompile(`(?:MASTER|SOURCE)_LOG_FILE='([^']+)',\s*(?:MASTER|SOURCE)_LOG_POS=(\d+)`)
+
+// readSourceData reads the position of the binary log mysqldump wrote at the
+// top of the dump at path.
+func readSourceData(path string) (*BinlogPosition, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, 64*1024)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	match := sourceDataPattern.FindSubmatch(head[:n])
+	if match == nil {
+		return nil, fmt.Errorf("no binary log position found in %s", path)
+	}
+	position, _ := strconv.ParseInt(string(match[2]), 10, 64)
+	return &BinlogPosition{File: string(match[1]), Position: position}, nil
+}
+
+func (s *snapshot) takePostgres(ctx context.Context, databases []string) error {
+	for _, database := range databases {
+		db, err := dh.Open(s.conn, database)
+		if err != nil {
+			return err
+		}
+		s.closers = append(s.closers, db)
+		tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		if err != nil {
+			return err
+		}
+		s.exporters = append(s.exporters, tx)
+		var id, lsn string
+		err = tx.QueryRowContext(ctx, `SELECT pg_export_snapshot(),
+			(CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END)::text`).Scan(&id, &lsn)
+		if err != nil {
+			return fmt.Errorf("could not export a snapshot of %s: %w", database, err)
+		}
+		s.exported[database] = id
+		s.LSN[database] = lsn
+	}
+	return nil
+}
+
+func (s *snapshot) takeSQLite(ctx context.Context) error {
+	db, err := dh.Open(s.conn, dh.SQLiteDatabase)
+	if err != nil {
+		return err
+	}
+	s.closers = append(s.closers, db)
+	c, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	s.closers = append(s.closers, c)
+	if _, err := c.ExecContext(ctx, "BEGIN"); err != nil {
+		return err
+	}
+	// The snapshot of a sqlite transaction starts with its first read
+	var tables int
+	if err := c.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master").Scan(&tables); err != nil {
+		return err
+	}
+	s.pool = make(chan *sql.Conn, 1)
+	s.pool <- c
+	return nil
+}
+
+// source reads databases within the snapshot.
+func (s *snapshot) source(ctx context.Context, database string) (queryer, func(), error) {
+	if s.provider == "postgres" {
+		id, ok := s.exported[database]
+		if !ok {
+			return nil, nil, fmt.Errorf("no snapshot of %s", database)
+		}
+		db, err := dh.Open(s.conn, database)
+		if err != nil {
+			return nil, nil, err
+		}
+		tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "SET TRANSACTION SNAPSHOT '"+id+"'")
+		}
+		if err != nil {
+			db.Close()
+			return nil, nil, fmt.Errorf("could not import the snapshot of %s: %w", database, err)
+		}
+		return tx, func() { tx.Rollback(); db.Close() }, nil
+	}
+
+	var c *sql.Conn
+	select {
+	case c = <-s.pool:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	if s.provider == "mysql" {
+		if _, err := c.ExecContext(ctx, "USE "+dh.QuoteIdent(s.provider, database)); err != nil {
+			s.pool <- c
+			return nil, nil, err
+		}
+	}
+	return c, func() { s.pool <- c }, nil
+}
+
+// release ends the transactions of the snapshot.
+func (s *snapshot) release() {
+	ctx := context.Background()
+	for _, tx := range s.exporters {
+		tx.Rollback()
+	}
+	if s.pool != nil {
+		for len(s.pool) > 0 {
+			(<-s.pool).ExecContext(ctx, "ROLLBACK")
+		}
+	}
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		s.closers[i].Close()
+	}
+}
@@ -0,0 +1,191 @@
+package dump
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	dh "seraphim/lib/db/query"
+	"strings"
+)
+
+// statement is a statement of a SQL dump along with the line it starts on.
+type statement struct {
+	Text string
+	Line int
+}
+
+// scanState is what the scanner is reading.
+type scanState int
+
+const (
+	scanCode scanState = iota
+	scanQuoted
+	scanComment
+	scanDollar
+)
+
+// statementScanner reads the statements of a SQL dump in the syntax of
+// provider. Statements end with ; or with the delimiter set by the DELIMITER
+// command of the mysql client, and keep their comments, mysql running the
+// version comments of mysqldump.
+type statementScanner struct {
+	provider string
+	// comment, when set, receives the lines holding a comment only
+	comment func(line string)
+	// LastLine is the last line of the dump holding more than blanks
+	LastLine string
+	// Statements is the number of statements read
+	Statements int
+}
+
+// scan calls each with every statement of r. It fails when r ends within a
+// string or a comment, with a statement left unterminated or with
+// parentheses left open, as a truncated dump does.
+func (s *statementScanner) scan(r io.Reader, each func(statement) error) error {
+	mysql := dh.NormalizeProvider(s.provider) == "mysql"
+	in := bufio.NewReaderSize(r, 1<<20)
+	delimiter := ";"
+	var buf strings.Builder
+	// code tells that the statement holds more than comments and blanks
+	code := false
+	depth, start, opened := 0, 0, 0
+	state := scanCode
+	closer := ""
+	backslash, escaped := false, false
+
+	for line := 1; ; line++ {
+		text, err := in.ReadString('\n')
+		if text == "" && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		trimmed := strings.TrimSpace(text)
+		if trimmed != "" {
+			s.LastLine = trimmed
+		}
+		if state == scanCode && !code {
+			if fields := strings.Fields(trimmed); len(fields) == 2 && strings.EqualFold(fields[0], "DELIMITER") {
+				delimiter = fields[1]
+				continue
+			}
+		}
+		if state == scanCode && s.comment != nil && (strings.HasPrefix(trimmed, "--") || mysql && strings.HasPrefix(trimmed, "#")) {
+			s.comment(trimmed)
+		}
+
+		for i := 0; i < len(text); i++ {
+			c := text[i]
+			switch state {
+			case scanQuoted:
+				buf.WriteByte(c)
+				switch {
+				case escaped:
+					escaped = false
+				case c == '\\' && backslash:
+					escaped = true
+				case c == closer[0]:
+					if i+1 < len(text) && text[i+1] == c {
+						buf.WriteByte(c)
+						i++
+					} else {
+						state = scanCode
+					}
+				}
+				continue
+			case scanComment, scanDollar:
+				if strings.HasPrefix(text[i:], closer) {
+					buf.WriteString(closer)
+					i += len(closer) - 1
+					state = scanCode
+				} else {
+					buf.WriteByte(c)
+				}
+				continue
+			}
+
+			switch {
+			case strings.HasPrefix(text[i:], delimiter):
+				if code {
+					if depth != 0 {
+						return fmt.Errorf("the statement on line %d leaves %d parenthesis open", start, depth)
+					}
+					s.Statements++
+					if err := each(statement{Text: strings.TrimSpace(buf.String()), Line: start}); err != nil {
+						return err
+					}
+				}
+				buf.Reset()
+				code, depth = false, 0
+				i += len(delimiter) - 1
+				continue
+			case strings.HasPrefix(text[i:], "--") && (!mysql || i+2 == len(text) || strings.ContainsRune(" \t\r\n", rune(text[i+2]))),
+				c == '#' && mysql:
+				buf.WriteString(text[i:])
+				i = len(text)
+				continue
+			case strings.HasPrefix(text[i:], "/*"):
+				state, closer, opened = scanComment, "*/", line
+				// Version comments and hints of mysql are run
+				if !(mysql && i+2 < len(text) && (text[i+2] == '!' || text[i+2] == '+')) {
+					buf.WriteString("/*")
+					i++
+					continue
+				}
+			case c == '\'' || c == '"' || c == '`':
+				state, closer, opened = scanQuoted, string(c), line
+				backslash = mysql && c != '`' || c == '\'' && i > 0 && (text[i-1] == 'E' || text[i-1] == 'e')
+			case c == '$' && !mysql && (i == 0 || !isIdentByte(text[i-1])):
+				if tag := dollarTag(text[i:]); tag != "" {
+					state, closer, opened = scanDollar, tag, line
+					if !code {
+						code, start = true, line
+					}
+					buf.WriteString(tag)
+					i += len(tag) - 1
+					continue
+				}
+			case c == '(':
+				depth++
+			case c == ')':
+				if depth--; depth < 0 {
+					return fmt.Errorf("line %d closes a parenthesis that was not opened", line)
+				}
+			}
+			if !code && !strings.ContainsRune(" \t\r\n", rune(c)) {
+				code, start = true, line
+			}
+			buf.WriteByte(c)
+		}
+	}
+
+	switch state {
+	case scanQuoted, scanDollar:
+		return fmt.Errorf("the dump ends within the quoted text opened on line %d", opened)
+	case scanComment:
+		return fmt.Errorf("the dump ends within the comment opened on line %d", opened)
+	}
+	if code {
+		return fmt.Errorf("the statement on line %d is not terminated", start)
+	}
+	return nil
+}
+
+// dollarTag returns the postgres dollar quote starting text, e.g. $$ or
+// $body$, empty when text starts with none.
+func dollarTag(text string) string {
+	for i := 1; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '$':
+			return text[:i+1]
+		case !isIdentByte(c) || i == 1 && c >= '0' && c <= '9':
+			return ""
+		}
+	}
+	return ""
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
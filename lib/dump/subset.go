@@ -0,0 +1,338 @@
+package dump
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	dh "seraphim/lib/db/query"
+	"strconv"
+	"strings"
+)
+
+// subsetBatch is the number of keys looked up per query when following
+// foreign keys.
+const subsetBatch = 200
+
+// Sample keeps part of the rows of a table: Percent of them picked at
+// random, at most Limit of them in primary key order, or both.
+type Sample struct {
+	Percent float64 `json:"percent,omitempty"`
+	Limit   int64   `json:"limit,omitempty"`
+}
+
+// ParseSample reads a sample written as a percentage, e.g. 1% or 0.5%, or as
+// a number of rows.
+func ParseSample(s string) (Sample, error) {
+	s = strings.TrimSpace(s)
+	if percent, ok := strings.CutSuffix(s, "%"); ok {
+		p, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return Sample{}, fmt.Errorf("invalid sample %q, expected a percentage between 0 and 100", s)
+		}
+		return Sample{Percent: p}, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return Sample{}, fmt.Errorf("invalid sample %q, expected a percentage such as 1%% or a number of rows", s)
+	}
+	return Sample{Limit: n}, nil
+}
+
+// IsZero tells whether the sample keeps every row.
+func (s Sample) IsZero() bool {
+	return (s.Percent <= 0 || s.Percent >= 100) && s.Limit <= 0
+}
+
+// Filter selects the rows of a table dumped: those matching Where, an SQL
+// condition of the provider of the connection, then sampled.
+type Filter struct {
+	Where string `json:"where,omitempty"`
+	Sample
+}
+
+// IsZero tells whether the filter keeps every row.
+func (f Filter) IsZero() bool {
+	return strings.TrimSpace(f.Where) == "" && f.Sample.IsZero()
+}
+
+func (f Filter) String() string {
+	parts := make([]string, 0, 2)
+	if where := strings.TrimSpace(f.Where); where != "" {
+		parts = append(parts, "where "+where)
+	}
+	if f.Percent > 0 && f.Percent < 100 {
+		parts = append(parts, "sample "+strconv.FormatFloat(f.Percent, 'f', -1, 64)+"%")
+	}
+	if f.Limit > 0 {
+		parts = append(parts, fmt.Sprintf("limit %d", f.Limit))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// conditions returns the SQL conditions of the filter for provider, the
+// limit of its sample being left to the query.
+func (f Filter) conditions(provider string) []string {
+	conditions := make([]string, 0, 2)
+	if where := strings.TrimSpace(f.Where); where != "" {
+		conditions = append(conditions, "("+where+")")
+	}
+	if f.Percent > 0 && f.Percent < 100 {
+		conditions = append(conditions, randomCondition(provider, f.Percent))
+	}
+	return conditions
+}
+
+// randomCondition holds for about percent of the rows.
+func randomCondition(provider string, percent float64) string {
+	fraction := strconv.FormatFloat(percent/100, 'f', -1, 64)
+	switch dh.NormalizeProvider(provider) {
+	case "mysql":
+		return "RAND() < " + fraction
+	case "postgres":
+		return "random() < " + fraction
+	}
+	// sqlite random() returns a signed 64 bit integer
+	return fmt.Sprintf("abs(random() %% 1000000) < %d", int64(percent*10000))
+}
+
+// filter returns the filter of table in database, the default sample of the
+// options applying when the filter has none.
+func (o Options) filter(database string, table string) Filter {
+	f := o.Filters[database+"."+table]
+	if f.Sample.IsZero() {
+		f.Sample = o.Sample
+	}
+	return f
+}
+
+// subset tells whether the options select part of the rows.
+func (o Options) subset() bool {
+	if !o.Sample.IsZero() {
+		return true
+	}
+	for _, f := range o.Filters {
+		if !f.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// subsetTable holds the rows of a table of a subset dump.
+type subsetTable struct {
+	infos []dh.ColumnInfo
+	rows  [][]any
+	// seen holds the identity of the rows read, their primary key or every
+	// column without one
+	seen     map[string]bool
+	identity []int
+	// asked holds the keys already looked up, a key missing from the table
+	// being looked up once
+	asked map[string]bool
+}
+
+// readSubset reads the rows of plan selected by the filters of its tables
+// along with the rows they reference, so that every foreign key of the dump
+// holds. Children are read before their parents, the rows a parent must
+// hold depending on them; a foreign key of a parent to a table dumped after
+// it, as with cycles between tables, is not followed. The rows are held in
+// memory until written.
+func readSubset(ctx context.Context, db queryer, provider string, plan DialectPlan) (map[string]*subsetTable, error) {
+	subset := make(map[string]*subsetTable, len(plan.Tables))
+	read := make([]dialectTable, 0, len(plan.Tables))
+	for i := len(plan.Tables) - 1; i >= 0; i-- {
+		t := plan.Tables[i]
+		st := &subsetTable{seen: make(map[string]bool), asked: make(map[string]bool)}
+		st.identity = columnIndexes(t.Source, t.Source.PrimaryKey)
+		if len(st.identity) == 0 {
+			for c := range t.Source.Columns {
+				st.identity = append(st.identity, c)
+			}
+		}
+		subset[t.Source.Name] = st
+		read = append(read, t)
+
+		query := rowQuery(provider, t, t.Filter.conditions(provider), int(t.Filter.Limit))
+		if _, err := st.read(ctx, db, query, nil); err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Source.Name, err)
+		}
+		// Rows referencing rows of the table itself lead to more rows
+		for {
+			added := 0
+			for _, child := range read {
+				for _, fk := range child.Source.ForeignKeys {
+					if fk.ReferencedTable != t.Source.Name {
+						continue
+					}
+					n, err := st.follow(ctx, db, provider, t, fk.ReferencedColumns, subset[child.Source.Name].keys(child, fk.Columns))
+					if err != nil {
+						return nil, fmt.Errorf("%s: %w", t.Source.Name, err)
+					}
+					added += n
+				}
+			}
+			if added == 0 {
+				break
+			}
+		}
+	}
+	return subset, nil
+}
+
+// read adds the rows returned by query that were not read yet and returns
+// their number.
+func (st *subsetTable) read(ctx context.Context, db queryer, query string, args []any) (int, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if st.infos == nil {
+		if st.infos, err = dh.ColumnInfos(rows); err != nil {
+			return 0, err
+		}
+	}
+	added := 0
+	for rows.Next() {
+		values := make([]any, len(st.infos))
+		dest := make([]any, len(values))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return added, err
+		}
+		id := tupleKey(values, st.identity)
+		if st.seen[id] {
+			continue
+		}
+		st.seen[id] = true
+		st.rows = append(st.rows, values)
+		added++
+	}
+	return added, rows.Err()
+}
+
+// keys returns the distinct values of columns of t among the rows read,
+// leaving out those holding NULL.
+func (st *subsetTable) keys(t dialectTable, columns []string) [][]any {
+	indexes := make([]int, 0, len(columns))
+	for _, name := range columns {
+		for i, c := range t.Source.Columns {
+			if c.Name == name {
+				indexes = append(indexes, i)
+			}
+		}
+	}
+	keys := make([][]any, 0)
+	seen := make(map[string]bool)
+	for _, row := range st.rows {
+		key := make([]any, 0, len(indexes))
+		for _, i := range indexes {
+			if row[i] == nil {
+				key = nil
+				break
+			}
+			key = append(key, dh.KeyArg(row[i]))
+		}
+		if key == nil {
+			continue
+		}
+		k := tupleKey(key, nil)
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// follow reads the rows of t whose columns hold one of keys and returns the
+// number of rows added.
+func (st *subsetTable) follow(ctx context.Context, db queryer, provider string, t dialectTable, columns []string, keys [][]any) (int, error) {
+	present := make(map[string]bool, len(st.rows))
+	indexes := make([]int, 0, len(columns))
+	for _, name := range columns {
+		for i, c := range t.Source.Columns {
+			if c.Name == name {
+				indexes = append(indexes, i)
+			}
+		}
+	}
+	if len(indexes) != len(columns) {
+		return 0, fmt.Errorf("unknown referenced columns %s", strings.Join(columns, ", "))
+	}
+	for _, row := range st.rows {
+		present[tupleKey(row, indexes)] = true
+	}
+	scope := strings.Join(columns, ",") + "\x00"
+	missing := make([][]any, 0)
+	for _, key := range keys {
+		k := tupleKey(key, nil)
+		if present[k] || st.asked[scope+k] {
+			continue
+		}
+		st.asked[scope+k] = true
+		missing = append(missing, key)
+	}
+
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = dh.QuoteIdent(provider, c)
+	}
+	added := 0
+	for start := 0; start < len(missing); start += subsetBatch {
+		batch := missing[start:min(start+subsetBatch, len(missing))]
+		args := make([]any, 0, len(batch)*len(columns))
+		var condition string
+		if len(columns) == 1 {
+			params := make([]string, len(batch))
+			for i, key := range batch {
+				args = append(args, key[0])
+				params[i] = dh.Placeholder(provider, len(args))
+			}
+			condition = quoted[0] + " IN (" + strings.Join(params, ", ") + ")"
+		} else {
+			matches := make([]string, len(batch))
+			for i, key := range batch {
+				equals := make([]string, len(columns))
+				for c := range columns {
+					args = append(args, key[c])
+					equals[c] = quoted[c] + " = " + dh.Placeholder(provider, len(args))
+				}
+				matches[i] = "(" + strings.Join(equals, " AND ") + ")"
+			}
+			condition = "(" + strings.Join(matches, " OR ") + ")"
+		}
+		n, err := st.read(ctx, db, rowQuery(provider, t, []string{condition}, 0), args)
+		if err != nil {
+			return added, err
+		}
+		added += n
+	}
+	return added, nil
+}
+
+// write writes the rows of t read into the subset and returns their number.
+func (st *subsetTable) write(out *bufio.Writer, dialect string, t dialectTable, progress func(rows int64)) int64 {
+	w := newInsertWriter(out, dialect, t, st.infos, progress)
+	for _, row := range st.rows {
+		w.write(row)
+	}
+	return w.close()
+}
+
+// tupleKey identifies the values at indexes, every value without indexes.
+func tupleKey(values []any, indexes []int) string {
+	parts := make([]string, 0, len(values))
+	if indexes == nil {
+		for _, v := range values {
+			parts = append(parts, fmt.Sprintf("%v", dh.KeyArg(v)))
+		}
+	} else {
+		for _, i := range indexes {
+			parts = append(parts, fmt.Sprintf("%v", dh.KeyArg(values[i])))
+		}
+	}
+	return strings.Join(parts, "\x00")
+}
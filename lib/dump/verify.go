@@ -0,0 +1,526 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"seraphim/lib/config"
+	dh "seraphim/lib/db/query"
+	"seraphim/lib/doctor"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VerifyOptions tells how far a dump is verified.
+type VerifyOptions struct {
+	// Restore restores the dump into scratch databases and compares the rows
+	// of its tables with its manifest
+	Restore bool
+	// Scratch is the connection of the server mysql and postgres dumps are
+	// restored on, a local server standing in for the source, sqlite dumps
+	// being restored into temporary files
+	Scratch *config.StoredConnection
+}
+
+// archiveMagic starts the custom archives of pg_dump.
+const archiveMagic = "PGDMP"
+
+// maxMismatches is the number of tables whose rows differ listed per
+// database.
+const maxMismatches = 5
+
+// Verify checks that the dump at path is whole: its size and checksum match
+// its manifest, its statements are well formed and end with the completion
+// markers of the tool that wrote it. With opts.Restore the dump is then
+// restored into scratch databases and the rows of its tables counted.
+func Verify(ctx context.Context, path string, opts VerifyOptions) []doctor.Check {
+	path = strings.TrimSuffix(path, ManifestSuffix)
+	m, err := ReadManifest(path)
+	checks := verifyManifest(path, m, err)
+	if _, serr := os.Stat(path); serr != nil {
+		return checks
+	}
+	hasManifest := err == nil
+
+	archive, err := isArchive(path)
+	if err != nil {
+		return append(checks, doctor.Check{Section: "Structure", Name: "File", Status: doctor.Fail, Detail: err.Error()})
+	}
+	provider := m.Dialect
+	if provider == "" {
+		provider = m.Provider
+	}
+	if !hasManifest {
+		provider = sniffProvider(path)
+	}
+	if archive {
+		checks = append(checks, verifyArchive(ctx, path))
+	} else {
+		checks = append(checks, verifyStatements(path, provider, m, hasManifest)...)
+	}
+	if !opts.Restore {
+		return checks
+	}
+	for _, c := range checks {
+		if c.Status == doctor.Fail {
+			return append(checks, doctor.Check{Section: "Restore", Name: "Restore", Status: doctor.Warn, Detail: "skipped, the dump is damaged"})
+		}
+	}
+	switch {
+	case !hasManifest:
+		return append(checks, doctor.Check{Section: "Restore", Name: "Restore", Status: doctor.Warn, Detail: "skipped, the restored rows are compared with the manifest and there is none"})
+	case m.Objects != nil && m.Objects.DataOnly:
+		return append(checks, doctor.Check{Section: "Restore", Name: "Restore", Status: doctor.Warn, Detail: "skipped, a data only dump fills tables it does not create"})
+	}
+	return append(checks, verifyRestore(ctx, path, provider, archive, m, opts)...)
+}
+
+// verifyManifest compares the size and checksum of the dump with its
+// manifest, err being the error met reading it.
+func verifyManifest(path string, m Manifest, err error) []doctor.Check {
+	checks := make([]doctor.Check, 0, 3)
+	info, serr := os.Stat(path)
+	if serr != nil {
+		return append(checks, doctor.Check{Section: "Manifest", Name: "File", Status: doctor.Fail, Detail: serr.Error()})
+	}
+	if err != nil {
+		status := doctor.Fail
+		if strings.HasPrefix(err.Error(), "no manifest found") {
+			status = doctor.Warn
+		}
+		return append(checks, doctor.Check{Section: "Manifest", Name: "Manifest", Status: status, Detail: err.Error() + ", the size and checksum cannot be checked"})
+	}
+	checks = append(checks, doctor.Check{Section: "Manifest", Name: "Manifest", Status: doctor.Pass, Detail: ManifestPath(path)})
+
+	size := doctor.Check{Section: "Manifest", Name: "Size", Status: doctor.Pass, Detail: fmt.Sprintf("%d bytes", info.Size())}
+	if info.Size() != m.Size {
+		size.Status = doctor.Fail
+		size.Detail = fmt.Sprintf("%d bytes, the manifest records %d", info.Size(), m.Size)
+	}
+	checks = append(checks, size)
+
+	checksum := doctor.Check{Section: "Manifest", Name: "Checksum", Status: doctor.Pass}
+	sum, err := Checksum(path)
+	switch {
+	case err != nil:
+		checksum.Status = doctor.Fail
+		checksum.Detail = err.Error()
+	case sum != m.Checksum:
+		checksum.Status = doctor.Fail
+		checksum.Detail = fmt.Sprintf("%s, the manifest records %s", sum, m.Checksum)
+	default:
+		checksum.Detail = sum
+	}
+	return append(checks, checksum)
+}
+
+func isArchive(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	head := make([]byte, len(archiveMagic))
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	return string(head[:n]) == archiveMagic, nil
+}
+
+// sniffProvider guesses the provider of the statements of a dump without a
+// manifest from its first line.
+func sniffProvider(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	first, _, _ := strings.Cut(string(head[:n]), "\n")
+	if _, after, ok := strings.Cut(first, " written by seraphim for "); ok {
+		dialect, _, _ := strings.Cut(after, " ")
+		return dialect
+	}
+	if strings.HasPrefix(first, "-- MySQL dump") || strings.HasPrefix(first, "-- MariaDB dump") {
+		return "mysql"
+	}
+	return ""
+}
+
+// verifyArchive reads every entry of a pg_dump archive, pg_restore failing
+// on a truncated one.
+func verifyArchive(ctx context.Context, path string) doctor.Check {
+	check := doctor.Check{Section: "Structure", Name: "Archive"}
+	if _, err := exec.LookPath("pg_restore"); err != nil {
+		check.Status = doctor.Warn
+		check.Detail = "pg_restore was not found in $PATH, the archive was not read"
+		return check
+	}
+	cmd := exec.CommandContext(ctx, "pg_restore", "--file="+os.DevNull, path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		check.Status = doctor.Fail
+		check.Detail = strings.TrimSpace(err.Error() + ": " + stderr.String())
+		return check
+	}
+	check.Status = doctor.Pass
+	check.Detail = "pg_restore read every entry of the archive"
+	return check
+}
+
+// verifyStatements scans the statements of a SQL dump and looks for the
+// completion markers of the tool that wrote it.
+func verifyStatements(path string, provider string, m Manifest, hasManifest bool) []doctor.Check {
+	f, err := os.Open(path)
+	if err != nil {
+		return []doctor.Check{{Section: "Structure", Name: "Statements", Status: doctor.Fail, Detail: err.Error()}}
+	}
+	defer f.Close()
+
+	started := make([]string, 0)
+	completed := make(map[string]bool)
+	mysqldumps := 0
+	s := &statementScanner{provider: provider, comment: func(line string) {
+		if database, ok := seraphimHeader(line); ok {
+			started = append(started, database)
+		}
+		if database, ok := strings.CutPrefix(line, "-- Dump of "); ok && strings.HasSuffix(database, " completed") {
+			completed[strings.TrimSuffix(database, " completed")] = true
+		}
+		if strings.HasPrefix(line, "-- Dump completed") {
+			mysqldumps++
+		}
+	}}
+	checks := make([]doctor.Check, 0, 2)
+	statements := doctor.Check{Section: "Structure", Name: "Statements", Status: doctor.Pass}
+	if err := s.scan(f, func(statement) error { return nil }); err != nil {
+		statements.Status = doctor.Fail
+		statements.Detail = err.Error()
+	} else {
+		statements.Detail = fmt.Sprintf("%d statement(s), every string, comment and parenthesis closed", s.Statements)
+	}
+	checks = append(checks, statements)
+
+	completion := doctor.Check{Section: "Structure", Name: "Completion", Status: doctor.Pass}
+	switch {
+	case len(started) > 0 || hasManifest && m.Dialect != "":
+		expected := started
+		if hasManifest {
+			expected = m.DatabaseNames()
+		}
+		missing := make([]string, 0)
+		for _, database := range expected {
+			if !completed[database] {
+				missing = append(missing, database)
+			}
+		}
+		switch {
+		case len(missing) > 0:
+			completion.Status = doctor.Fail
+			completion.Detail = fmt.Sprintf("no completion marker for %s, the dump is truncated", strings.Join(missing, ", "))
+		case !strings.HasPrefix(s.LastLine, "-- Dump of ") || !strings.HasSuffix(s.LastLine, " completed"):
+			completion.Status = doctor.Fail
+			completion.Detail = "statements follow the last completion marker"
+		default:
+			completion.Detail = fmt.Sprintf("every database of the dump is marked completed (%d)", len(expected))
+		}
+	case mysqldumps > 0 || provider == "mysql":
+		runs := 1
+		if hasManifest && !m.Consistent {
+			// mysqldump runs once per database of the file
+			runs = len(m.Databases)
+		}
+		switch {
+		case !strings.HasPrefix(s.LastLine, "-- Dump completed"):
+			completion.Status = doctor.Fail
+			completion.Detail = "the dump does not end with the completion line of mysqldump, it is truncated"
+		case mysqldumps < runs:
+			completion.Status = doctor.Fail
+			completion.Detail = fmt.Sprintf("%d completion line(s) of mysqldump, %d expected", mysqldumps, runs)
+		default:
+			completion.Detail = s.LastLine
+		}
+	default:
+		completion.Status = doctor.Warn
+		completion.Detail = "the tool that wrote the dump is unknown, its completion cannot be checked"
+	}
+	return append(checks, completion)
+}
+
+// seraphimHeader returns the database whose statements a comment line of a
+// dialect dump starts, the preamble written by seraphim.
+func seraphimHeader(line string) (string, bool) {
+	if rest, ok := strings.CutPrefix(line, "-- Dump of "); ok {
+		if database, _, ok := strings.Cut(rest, " written by seraphim"); ok {
+			return database, true
+		}
+	}
+	return "", false
+}
+
+// mysqldumpHeader returns the database whose statements a comment line of
+// mysqldump starts.
+func mysqldumpHeader(line string) (string, bool) {
+	if rest, ok := strings.CutPrefix(line, "-- Current Database: "); ok {
+		return strings.Trim(rest, "`"), true
+	}
+	if strings.HasPrefix(line, "-- Host: ") {
+		if _, database, ok := strings.Cut(line, "Database: "); ok && strings.TrimSpace(database) != "" {
+			return strings.TrimSpace(database), true
+		}
+	}
+	return "", false
+}
+
+// scratchDB is a scratch database the statements of a database of a dump
+// are restored into.
+type scratchDB struct {
+	conn config.StoredConnection
+	name string
+	db   *sql.DB
+	c    *sql.Conn
+}
+
+// scratchSet creates the scratch databases of a restore and drops them once
+// done.
+type scratchSet struct {
+	provider string
+	server   *config.StoredConnection
+	dir      string
+	created  map[string]*scratchDB
+	order    []string
+}
+
+// open returns the scratch database of database, created on first use: a
+// temporary file for sqlite, a database of the scratch server otherwise.
+func (s *scratchSet) open(ctx context.Context, database string) (*scratchDB, error) {
+	if db, ok := s.created[database]; ok {
+		return db, nil
+	}
+	sdb := &scratchDB{}
+	if s.provider == "sqlite" {
+		sdb.name = filepath.Join(s.dir, fmt.Sprintf("%d.db", len(s.created)))
+		if err := os.WriteFile(sdb.name, nil, 0o600); err != nil {
+			return nil, err
+		}
+		sdb.conn = config.StoredConnection{Provider: "sqlite", Host: sdb.name}
+	} else {
+		sdb.conn = *s.server
+		sdb.name = fmt.Sprintf("seraphim_verify_%d_%d", time.Now().Unix(), len(s.created))
+		admin, err := dh.Open(sdb.conn, "")
+		if err != nil {
+			return nil, err
+		}
+		_, err = admin.ExecContext(ctx, "CREATE DATABASE "+dh.QuoteIdent(s.provider, sdb.name))
+		admin.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not create the scratch database: %w", err)
+		}
+	}
+	s.created[database] = sdb
+	s.order = append(s.order, database)
+	var err error
+	if sdb.db, err = dh.Open(sdb.conn, sdb.name); err != nil {
+		return nil, err
+	}
+	// Statements such as BEGIN and LOCK TABLES hold for their session only
+	if sdb.c, err = sdb.db.Conn(ctx); err != nil {
+		return nil, err
+	}
+	return sdb, nil
+}
+
+// drop closes the scratch databases and removes them.
+func (s *scratchSet) drop() {
+	for _, database := range s.order {
+		sdb := s.created[database]
+		if sdb.c != nil {
+			sdb.c.Close()
+		}
+		if sdb.db != nil {
+			sdb.db.Close()
+		}
+		if s.provider == "sqlite" {
+			continue
+		}
+		if admin, err := dh.Open(sdb.conn, ""); err == nil {
+			admin.Exec("DROP DATABASE IF EXISTS " + dh.QuoteIdent(s.provider, sdb.name))
+			admin.Close()
+		}
+	}
+	if s.dir != "" {
+		os.RemoveAll(s.dir)
+	}
+}
+
+// verifyRestore restores the dump into scratch databases, then compares the
+// rows of their tables with the manifest.
+func verifyRestore(ctx context.Context, path string, provider string, archive bool, m Manifest, opts VerifyOptions) []doctor.Check {
+	provider = dh.NormalizeProvider(provider)
+	restore := doctor.Check{Section: "Restore", Name: "Restore", Status: doctor.Pass}
+	set := &scratchSet{provider: provider, server: opts.Scratch, created: make(map[string]*scratchDB)}
+	defer set.drop()
+	switch {
+	case provider == "sqlite":
+		dir, err := os.MkdirTemp("", "seraphim-verify-")
+		if err != nil {
+			restore.Status, restore.Detail = doctor.Fail, err.Error()
+			return []doctor.Check{restore}
+		}
+		set.dir = dir
+	case opts.Scratch == nil:
+		restore.Status = doctor.Fail
+		restore.Detail = fmt.Sprintf("restoring a %s dump needs a scratch connection to a local %s server", provider, provider)
+		return []doctor.Check{restore}
+	case dh.NormalizeProvider(opts.Scratch.Provider) != provider:
+		restore.Status = doctor.Fail
+		restore.Detail = fmt.Sprintf("the scratch connection is a %s server, the dump is written for %s", dh.NormalizeProvider(opts.Scratch.Provider), provider)
+		return []doctor.Check{restore}
+	}
+
+	var err error
+	if archive {
+		err = restoreArchive(ctx, path, set, m)
+	} else {
+		err = restoreStatements(ctx, path, provider, set, m)
+	}
+	if err != nil {
+		restore.Status, restore.Detail = doctor.Fail, err.Error()
+		return []doctor.Check{restore}
+	}
+	names := make([]string, 0, len(set.order))
+	for _, database := range set.order {
+		names = append(names, filepath.Base(set.created[database].name))
+	}
+	restore.Detail = "restored into " + strings.Join(names, ", ")
+	checks := []doctor.Check{restore}
+
+	// Rows counted apart from the snapshot of the dump may have changed since
+	mismatch := doctor.Fail
+	if m.Dialect == "" && !m.Consistent {
+		mismatch = doctor.Warn
+	}
+	for _, d := range m.Databases {
+		check := doctor.Check{Section: "Restore", Name: "Rows of " + d.Name, Status: doctor.Pass}
+		sdb, ok := set.created[d.Name]
+		if !ok {
+			check.Status, check.Detail = doctor.Fail, "no statement of the database was restored"
+			checks = append(checks, check)
+			continue
+		}
+		differ := make([]string, 0)
+		var rows int64
+		for _, t := range d.Tables {
+			var n int64
+			if err := sdb.c.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+dh.QuoteTable(provider, t.Name)).Scan(&n); err != nil {
+				differ = append(differ, fmt.Sprintf("%s: %s", t.Name, err))
+				continue
+			}
+			rows += n
+			if n != t.Rows {
+				differ = append(differ, fmt.Sprintf("%s has %d row(s), the manifest records %d", t.Name, n, t.Rows))
+			}
+		}
+		switch {
+		case len(differ) > 0:
+			check.Status = mismatch
+			if len(differ) > maxMismatches {
+				differ = append(differ[:maxMismatches], fmt.Sprintf("%d more", len(differ)-maxMismatches))
+			}
+			check.Detail = strings.Join(differ, "; ")
+			if mismatch == doctor.Warn {
+				check.Detail += " (counted before a dump that is not consistent)"
+			}
+		default:
+			check.Detail = fmt.Sprintf("%d table(s), %d row(s), as recorded", len(d.Tables), rows)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// restoreStatements runs the statements of a SQL dump in the scratch
+// databases, those of every database of the dump in their own.
+func restoreStatements(ctx context.Context, path string, provider string, set *scratchSet, m Manifest) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	current := ""
+	if len(m.Databases) > 0 {
+		current = m.Databases[0].Name
+	}
+	s := &statementScanner{provider: provider, comment: func(line string) {
+		if database, ok := seraphimHeader(line); ok {
+			current = database
+		} else if database, ok := mysqldumpHeader(line); ok && m.Dialect == "" {
+			current = database
+		}
+	}}
+	return s.scan(f, func(st statement) error {
+		// The scratch database stands in for the databases of the dump
+		if words := leadingWords(st.Text); provider == "mysql" && (strings.HasPrefix(words, "USE ") || strings.HasPrefix(words, "CREATE DATABASE ")) {
+			return nil
+		}
+		sdb, err := set.open(ctx, current)
+		if err != nil {
+			return err
+		}
+		if _, err := sdb.c.ExecContext(ctx, st.Text); err != nil {
+			return fmt.Errorf("the statement on line %d failed: %w", st.Line, err)
+		}
+		return nil
+	})
+}
+
+// restoreArchive restores a pg_dump archive, which holds a single database,
+// with pg_restore.
+func restoreArchive(ctx context.Context, path string, set *scratchSet, m Manifest) error {
+	if len(m.Databases) != 1 {
+		return fmt.Errorf("a postgres archive holds a single database, the manifest records %d", len(m.Databases))
+	}
+	if _, err := exec.LookPath("pg_restore"); err != nil {
+		return fmt.Errorf("pg_restore was not found in $PATH")
+	}
+	sdb, err := set.open(ctx, m.Databases[0].Name)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "pg_restore", "--no-owner", "--no-acl", "--exit-on-error",
+		"--host="+sdb.conn.Host, "--port="+strconv.Itoa(sdb.conn.Port), "--username="+sdb.conn.User, "--dbname="+sdb.name, path)
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+sdb.conn.Password)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// leadingWords returns the start of a statement past its leading comments,
+// upper cased.
+func leadingWords(text string) string {
+	for {
+		text = strings.TrimSpace(text)
+		switch {
+		case strings.HasPrefix(text, "--") || strings.HasPrefix(text, "#"):
+			_, rest, _ := strings.Cut(text, "\n")
+			text = rest
+		case strings.HasPrefix(text, "/*") && !strings.HasPrefix(text, "/*!"):
+			_, rest, _ := strings.Cut(text, "*/")
+			text = rest
+		default:
+			return strings.ToUpper(text[:min(len(text), 32)])
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Package export writes the rows of tables to CSV, NDJSON or JSON files, one
+// file per table.
+package export
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"seraphim/lib/config"
+	dh "seraphim/lib/db/query"
+	"seraphim/lib/util"
+	"strings"
+	"time"
+)
+
+// Formats lists the file formats of an export.
+var Formats = []string{"csv", "ndjson", "json"}
+
+// utf8BOM lets Excel detect UTF-8 encoded CSV files.
+const utf8BOM = "\ufeff"
+
+type Options struct {
+	// Format is one of Formats
+	Format string
+	// Writer configures CSV files
+	Writer dh.WriterOptions
+	// BOM starts CSV files with a UTF-8 byte order mark
+	BOM bool
+	// Where holds WHERE conditions keyed by table or db.table
+	Where map[string]string
+}
+
+// Result describes an exported table.
+type Result struct {
+	Database string
+	Table    string
+	Path     string
+	Rows     int64
+	Duration time.Duration
+}
+
+// where returns the condition filtering table of database, the db.table key
+// taking precedence over the bare table name.
+func (o Options) where(database string, table string) string {
+	if w, ok := o.Where[database+"."+table]; ok {
+		return w
+	}
+	return o.Where[table]
+}
+
+// fileName returns the name of the export of table, path separators of the
+// name being replaced.
+func fileName(database string, table string, format string) string {
+	name := strings.NewReplacer("/", "_", `\`, "_").Replace(database + "." + table)
+	return name + "." + format
+}
+
+// ExportTable streams the rows of table into a file of dir. The file is
+// removed when the export fails.
+func ExportTable(ctx context.Context, conn config.StoredConnection, database string, table string, dir string, opts Options) (Result, error) {
+	result := Result{Database: database, Table: table, Path: filepath.Join(dir, fileName(database, table, opts.Format))}
+	start := time.Now()
+
+	statement := "SELECT * FROM " + dh.QuoteTable(conn.Provider, table)
+	if where := strings.TrimSpace(opts.where(database, table)); where != "" {
+		statement += " WHERE " + where
+	}
+
+	f, err := os.Create(result.Path)
+	if err != nil {
+		return result, err
+	}
+	fail := func(err error) (Result, error) {
+		f.Close()
+		os.Remove(result.Path)
+		return result, err
+	}
+	if opts.BOM && opts.Format == "csv" {
+		if _, err := f.WriteString(utf8BOM); err != nil {
+			return fail(err)
+		}
+	}
+	w, err := dh.NewRowWriter(opts.Format, f, opts.Writer)
+	if err != nil {
+		return fail(err)
+	}
+	if result.Rows, err = dh.StreamQuery(ctx, conn, database, statement, w); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(result.Path)
+		return result, err
+	}
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+// CreateExport exports the selected tables of selected into dir, whole
+// databases when "All" is selected. Every exported table is printed.
+func CreateExport(selected config.StoredConnection, dir string, selectedDbs []util.DbListItem, selectedTables []util.TableListItem, opts Options) bool {
+	ctx := context.Background()
+	for _, db := range selectedDbs {
+		tables := util.TablesOf(db.Name, selectedTables)
+		if tables == nil {
+			var err error
+			if tables, err = dh.ListTables(ctx, selected, db.Name); err != nil {
+				fmt.Println(err)
+				return false
+			}
+		}
+		for _, table := range tables {
+			r, err := ExportTable(ctx, selected, db.Name, table, dir, opts)
+			if err != nil {
+				fmt.Printf("%s.%s: %s\n", db.Name, table, err)
+				return false
+			}
+			fmt.Printf("---> %s.%s: %d row(s) written to %s in %s\n", db.Name, table, r.Rows, r.Path, r.Duration.Round(time.Millisecond))
+		}
+	}
+	return true
+}
@@ -0,0 +1,328 @@
+// Package masking replaces the values of sensitive columns while a dump is
+// written. Every strategy derives its values from a keyed hash of the
+// original value, hence equal values are masked alike wherever they appear
+// and joins across tables still line up.
+package masking
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"seraphim/lib/config"
+	dh "seraphim/lib/db/query"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// defaultDays bounds the shift of date_shift rules setting no days.
+const defaultDays = 30
+
+// Func masks a value scanned from a column of the given kind, NULL staying
+// NULL.
+type Func func(v any, kind dh.ValueKind) any
+
+// Masker matches the columns of a dump with the masking rules.
+type Masker struct {
+	salt  []byte
+	rules []rule
+}
+
+type rule struct {
+	config.MaskingRule
+	pattern *regexp.Regexp
+}
+
+// New compiles the masking rules of cfg, nil when there are none.
+func New(cfg config.MaskingConfig) (*Masker, error) {
+	if len(cfg.Rules) == 0 {
+		return nil, nil
+	}
+	m := &Masker{salt: []byte(cfg.Salt)}
+	for i, r := range cfg.Rules {
+		compiled := rule{MaskingRule: r}
+		if r.Pattern != "" {
+			var err error
+			if compiled.pattern, err = regexp.Compile(r.Pattern); err != nil {
+				return nil, fmt.Errorf("masking rule %d: %w", i, err)
+			}
+		}
+		if !slices.Contains(config.MaskingStrategies, r.Strategy) {
+			return nil, fmt.Errorf("masking rule %d: unknown strategy %q", i, r.Strategy)
+		}
+		m.rules = append(m.rules, compiled)
+	}
+	return m, nil
+}
+
+// Strategy returns the strategy of the first rule matching column of table
+// in database, empty when none does.
+func (m *Masker) Strategy(database string, table string, column string) string {
+	if r, ok := m.match(database, table, column); ok {
+		return r.Strategy
+	}
+	return ""
+}
+
+// Column returns the func masking the values of column of table in
+// database, nil when no rule matches it.
+func (m *Masker) Column(database string, table string, column string) Func {
+	r, ok := m.match(database, table, column)
+	if !ok {
+		return nil
+	}
+	masked := func(mask func(v any) any) Func {
+		return func(v any, kind dh.ValueKind) any {
+			if v == nil {
+				return nil
+			}
+			if n, ok := v.(int64); ok && kind == dh.KindBool {
+				// sqlite keeps booleans as integers
+				return mask(n != 0)
+			}
+			b, ok := v.([]byte)
+			if !ok {
+				return mask(v)
+			}
+			out := mask(number(string(b), kind))
+			if d, ok := out.(decimal); ok {
+				// Decimals stay text, keeping their precision
+				return []byte(d)
+			}
+			return out
+		}
+	}
+	switch r.Strategy {
+	case "fixed":
+		return masked(func(any) any { return r.Value })
+	case "null":
+		return func(any, dh.ValueKind) any { return nil }
+	case "hash":
+		return masked(m.hash)
+	case "email":
+		return masked(m.email)
+	case "name":
+		return masked(m.name)
+	case "phone":
+		return masked(func(v any) any { return m.scramble(v, false) })
+	case "scramble":
+		return masked(func(v any) any { return m.scramble(v, true) })
+	case "date_shift":
+		days := r.Days
+		if days == 0 {
+			days = defaultDays
+		}
+		return masked(func(v any) any { return m.shift(v, days) })
+	}
+	return nil
+}
+
+func (m *Masker) match(database string, table string, column string) (rule, bool) {
+	if m == nil {
+		return rule{}, false
+	}
+	name := database + "." + table + "." + column
+	for _, r := range m.rules {
+		if r.pattern != nil && r.pattern.MatchString(column) {
+			return r, true
+		}
+		if r.Column != "" {
+			if ok, _ := path.Match(r.Column, name); ok {
+				return r, true
+			}
+		}
+	}
+	return rule{}, false
+}
+
+// decimal is the text of a decimal number, which a float64 could not hold
+// without losing precision.
+type decimal string
+
+// number parses the text of a numeric or boolean column, as the text
+// protocol of mysql returns every value, so that it masks to a value of its
+// kind and alike whichever protocol read it.
+func number(text string, kind dh.ValueKind) any {
+	switch kind {
+	case dh.KindInteger:
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return n
+		}
+	case dh.KindDecimal:
+		if _, err := strconv.ParseFloat(text, 64); err == nil {
+			return decimal(text)
+		}
+	case dh.KindBool:
+		if b, err := strconv.ParseBool(text); err == nil {
+			return b
+		}
+	}
+	return text
+}
+
+// digest is the keyed hash of a value, extended with a counter when more
+// bytes are needed.
+func (m *Masker) digest(v any, n int) []byte {
+	text := fmt.Sprint(v)
+	if t, ok := v.(time.Time); ok {
+		text = t.UTC().Format(time.RFC3339Nano)
+	}
+	out := make([]byte, 0, n+sha256.Size)
+	for counter := uint32(0); len(out) < n; counter++ {
+		mac := hmac.New(sha256.New, m.salt)
+		binary.Write(mac, binary.BigEndian, counter)
+		mac.Write([]byte(text))
+		out = mac.Sum(out)
+	}
+	return out[:n]
+}
+
+// hash replaces text with its keyed hash, numbers with a number of as many
+// digits and the same scale and booleans with a boolean, so that the value
+// still fits its column. Short numbers have few values to pick from, hashing
+// them may turn distinct values into equal ones.
+func (m *Masker) hash(v any) any {
+	switch n := v.(type) {
+	case int64:
+		digits := len(strconv.FormatInt(abs(n), 10))
+		value := int64(binary.BigEndian.Uint64(m.digest(v, 8)) % uint64(pow10(digits)))
+		if n < 0 {
+			value = -value
+		}
+		return value
+	case decimal:
+		return decimal(m.hashDigits(string(n)))
+	case float64:
+		f, _ := strconv.ParseFloat(m.hashDigits(strconv.FormatFloat(n, 'f', -1, 64)), 64)
+		return f
+	case bool:
+		return m.digest(v, 1)[0]&1 == 1
+	}
+	return hex.EncodeToString(m.digest(v, 16))
+}
+
+// hashDigits replaces the digits of a decimal number written as text with
+// digits of its keyed hash, keeping its sign and scale. Leading zeros are
+// dropped, the number never having more digits than before.
+func (m *Masker) hashDigits(text string) string {
+	d := m.digest(text, len(text))
+	digits := []byte(text)
+	for i, c := range digits {
+		if c >= '0' && c <= '9' {
+			digits[i] = '0' + d[i]%10
+		}
+	}
+	sign, rest := "", string(digits)
+	if strings.HasPrefix(rest, "-") || strings.HasPrefix(rest, "+") {
+		sign, rest = rest[:1], rest[1:]
+	}
+	for len(rest) > 1 && rest[0] == '0' && rest[1] >= '0' && rest[1] <= '9' {
+		rest = rest[1:]
+	}
+	return sign + rest
+}
+
+func (m *Masker) email(v any) any {
+	return "user_" + hex.EncodeToString(m.digest(v, 5)) + "@example.com"
+}
+
+func (m *Masker) name(v any) any {
+	d := m.digest(v, 4)
+	first := firstNames[int(binary.BigEndian.Uint16(d))%len(firstNames)]
+	last := lastNames[int(binary.BigEndian.Uint16(d[2:]))%len(lastNames)]
+	if len(strings.Fields(fmt.Sprint(v))) < 2 {
+		return first
+	}
+	return first + " " + last
+}
+
+// scramble replaces every digit with another digit and, with letters, every
+// letter with another letter of the same case, keeping the format of the
+// value.
+func (m *Masker) scramble(v any, letters bool) any {
+	if t, ok := v.(time.Time); ok {
+		return t
+	}
+	if _, ok := v.(string); !ok {
+		// Numbers keep their exponent
+		letters = false
+	}
+	text := fmt.Sprint(v)
+	runes := []rune(text)
+	d := m.digest(v, len(runes))
+	for i, r := range runes {
+		switch {
+		case unicode.IsDigit(r):
+			runes[i] = rune('0' + d[i]%10)
+		case letters && unicode.IsUpper(r):
+			runes[i] = rune('A' + d[i]%26)
+		case letters && unicode.IsLower(r):
+			runes[i] = rune('a' + d[i]%26)
+		}
+	}
+	switch v.(type) {
+	case int64:
+		if n, err := strconv.ParseInt(string(runes), 10, 64); err == nil {
+			return n
+		}
+	case float64:
+		if f, err := strconv.ParseFloat(string(runes), 64); err == nil {
+			return f
+		}
+	case decimal:
+		return decimal(runes)
+	}
+	return string(runes)
+}
+
+// dateLayouts are the layouts dates written as text are read with.
+var dateLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02 15:04:05", "2006-01-02"}
+
+// shift moves a date by up to days days either way, text keeping its layout.
+func (m *Masker) shift(v any, days int) any {
+	offset := time.Duration(int64(binary.BigEndian.Uint64(m.digest(v, 8))%uint64(2*days+1))-int64(days)) * 24 * time.Hour
+	switch t := v.(type) {
+	case time.Time:
+		return t.Add(offset)
+	case string:
+		for _, layout := range dateLayouts {
+			if parsed, err := time.Parse(layout, t); err == nil {
+				return parsed.Add(offset).Format(layout)
+			}
+		}
+	}
+	return v
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n && i < 18; i++ {
+		p *= 10
+	}
+	return p
+}
+
+var firstNames = []string{
+	"Alex", "Anna", "Ben", "Chloe", "Daniel", "Elena", "Felix", "Grace", "Hugo", "Ines",
+	"Jonas", "Julia", "Karim", "Laura", "Leo", "Maya", "Noah", "Olivia", "Paul", "Quinn",
+	"Rosa", "Sam", "Sofia", "Tom", "Uma", "Victor", "Wendy", "Yusuf", "Zoe", "Marco",
+}
+
+var lastNames = []string{
+	"Adams", "Baker", "Costa", "Dubois", "Evans", "Fischer", "Garcia", "Hansen", "Ivanov", "Jensen",
+	"Kowalski", "Larsen", "Martin", "Novak", "Olsen", "Petrov", "Rossi", "Schmidt", "Silva", "Tanaka",
+	"Urban", "Vargas", "Weber", "Xu", "Young", "Zimmer", "Moreau", "Nilsson", "Ortiz", "Kim",
+}
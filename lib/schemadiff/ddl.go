@@ -0,0 +1,376 @@
+package schemadiff
+
+import (
+	"fmt"
+	dh "seraphim/lib/db/query"
+	"strconv"
+	"strings"
+)
+
+// ddlBuilder accumulates the statements of a migration for one provider.
+type ddlBuilder struct {
+	provider   string
+	statements []string
+}
+
+func (b *ddlBuilder) add(format string, args ...any) {
+	b.statements = append(b.statements, fmt.Sprintf(format, args...)+";")
+}
+
+// manual records a change the provider cannot express as a plain statement.
+func (b *ddlBuilder) manual(format string, args ...any) {
+	b.statements = append(b.statements, "-- "+fmt.Sprintf(format, args...))
+}
+
+func (b *ddlBuilder) ident(name string) string {
+	return dh.QuoteIdent(b.provider, name)
+}
+
+func (b *ddlBuilder) table(name string) string {
+	return dh.QuoteTable(b.provider, name)
+}
+
+func (b *ddlBuilder) idents(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = b.ident(n)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// MigrationDDL returns the statements turning the from schema of r into its
+// to schema. Both sides must use the same provider.
+func MigrationDDL(r Result) ([]string, error) {
+	provider := dh.NormalizeProvider(r.From.Provider)
+	if provider != dh.NormalizeProvider(r.To.Provider) {
+		return nil, fmt.Errorf("cannot generate DDL between %s and %s, both sides must use the same provider", r.From.Provider, r.To.Provider)
+	}
+	b := &ddlBuilder{provider: provider, statements: make([]string, 0)}
+
+	// Foreign keys go first and come back last so that tables can be
+	// created, dropped and altered in any order
+	for _, t := range r.Tables {
+		if t.Kind != Changed {
+			continue
+		}
+		for _, c := range t.Changes {
+			if c.Object == ForeignKeyObject && c.Kind != Added {
+				b.dropForeignKey(t.Table, c.Name)
+			}
+		}
+	}
+	b.dropTables(r.Tables)
+	for _, t := range r.Tables {
+		if t.Kind == Added {
+			b.createTable(*t.New)
+		}
+	}
+	for _, t := range r.Tables {
+		if t.Kind == Changed {
+			b.alterTable(t)
+		}
+	}
+	for _, t := range r.Tables {
+		switch t.Kind {
+		case Added:
+			if provider != "sqlite" {
+				for _, fk := range t.New.ForeignKeys {
+					b.addForeignKey(t.Table, fk)
+				}
+			}
+		case Changed:
+			for _, c := range t.Changes {
+				if c.Object == ForeignKeyObject && c.Kind != Removed {
+					b.addForeignKey(t.Table, findForeignKey(t.New, c.Name))
+				}
+			}
+		}
+	}
+	return b.statements, nil
+}
+
+// dropTables drops the removed tables of diffs, the tables referencing
+// others before the tables they reference. Tables referencing each other in
+// a cycle first lose their foreign keys to the tables dropped before them.
+func (b *ddlBuilder) dropTables(diffs []TableDiff) {
+	removed := make([]dh.TableDetails, 0)
+	for _, t := range diffs {
+		if t.Kind == Removed {
+			removed = append(removed, *t.Old)
+		}
+	}
+	order := dh.DependencyOrder(removed)
+	dropped := make(map[string]int, len(order))
+	for i := range order {
+		dropped[order[i].Name] = len(order) - 1 - i
+	}
+	if b.provider != "sqlite" {
+		for i := len(order) - 1; i >= 0; i-- {
+			for _, fk := range order[i].ForeignKeys {
+				if at, ok := dropped[fk.ReferencedTable]; ok && at < dropped[order[i].Name] {
+					b.dropForeignKey(order[i].Name, fk.Name)
+				}
+			}
+		}
+	}
+	for i := len(order) - 1; i >= 0; i-- {
+		b.add("DROP TABLE %s", b.table(order[i].Name))
+	}
+}
+
+// CreateTableDDL returns the statements creating t and its indexes on
+// provider. Foreign keys are only included for sqlite, which cannot add them
+// later, the other providers take them from ForeignKeyDDL once every
+// referenced table exists.
+func CreateTableDDL(provider string, t dh.TableDetails) []string {
+	b := &ddlBuilder{provider: dh.NormalizeProvider(provider), statements: make([]string, 0)}
+	b.createTable(t)
+	return b.statements
+}
+
+// ForeignKeyDDL returns the statement adding fk to table, empty for sqlite
+// where foreign keys are part of CREATE TABLE.
+func ForeignKeyDDL(provider string, table string, fk dh.ForeignKey) string {
+	b := &ddlBuilder{provider: dh.NormalizeProvider(provider), statements: make([]string, 0)}
+	if b.provider == "sqlite" {
+		return ""
+	}
+	b.addForeignKey(table, fk)
+	return b.statements[0]
+}
+
+func findColumn(t *dh.TableDetails, name string) dh.Column {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+	return dh.Column{Name: name}
+}
+
+func findIndex(t *dh.TableDetails, name string) dh.Index {
+	for _, i := range t.Indexes {
+		if i.Name == name {
+			return i
+		}
+	}
+	return dh.Index{Name: name}
+}
+
+func findForeignKey(t *dh.TableDetails, name string) dh.ForeignKey {
+	for _, fk := range t.ForeignKeys {
+		if fk.Name == name {
+			return fk
+		}
+	}
+	return dh.ForeignKey{Name: name}
+}
+
+func primaryIndexName(t *dh.TableDetails) string {
+	for _, i := range t.Indexes {
+		if i.Primary {
+			return i.Name
+		}
+	}
+	return ""
+}
+
+// defaultSQL renders the default of c as an SQL expression. mysql reports
+// literal defaults unquoted, the other providers report expressions.
+func (b *ddlBuilder) defaultSQL(c dh.Column) string {
+	value := c.Default.String
+	if b.provider != "mysql" {
+		return value
+	}
+	upper := strings.ToUpper(value)
+	if _, err := strconv.ParseFloat(value, 64); err == nil ||
+		upper == "NULL" ||
+		strings.HasPrefix(upper, "CURRENT_") ||
+		strings.HasPrefix(upper, "NOW(") ||
+		strings.HasPrefix(value, "(") ||
+		strings.HasPrefix(value, "'") ||
+		strings.HasPrefix(upper, "B'") {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// columnDefinition renders the type, nullability, default and attributes of
+// c. Postgres columns backed by a sequence are created as serial columns.
+func (b *ddlBuilder) columnDefinition(c dh.Column, creating bool) string {
+	if creating && b.provider == "postgres" && strings.HasPrefix(c.Default.String, "nextval(") {
+		serial := map[string]string{"integer": "serial", "bigint": "bigserial", "smallint": "smallserial"}
+		if t, ok := serial[c.Type]; ok {
+			return b.ident(c.Name) + " " + t
+		}
+	}
+	def := b.ident(c.Name) + " " + c.Type
+	if !c.Nullable {
+		def += " NOT NULL"
+	}
+	if c.Default.Valid {
+		def += " DEFAULT " + b.defaultSQL(c)
+	}
+	// Generated columns need their expression, which is not introspected
+	if c.Extra != "" && !strings.Contains(strings.ToUpper(c.Extra), "GENERATED") {
+		def += " " + c.Extra
+	}
+	return def
+}
+
+func (b *ddlBuilder) createTable(t dh.TableDetails) {
+	lines := make([]string, 0, len(t.Columns)+1)
+	for _, c := range t.Columns {
+		lines = append(lines, "  "+b.columnDefinition(c, true))
+	}
+	if len(t.PrimaryKey) > 0 {
+		lines = append(lines, "  PRIMARY KEY ("+b.idents(t.PrimaryKey)+")")
+	}
+	// sqlite cannot add foreign keys to an existing table
+	if b.provider == "sqlite" {
+		for _, fk := range t.ForeignKeys {
+			lines = append(lines, fmt.Sprintf("  FOREIGN KEY (%s) REFERENCES %s (%s)",
+				b.idents(fk.Columns), b.table(fk.ReferencedTable), b.idents(fk.ReferencedColumns)))
+		}
+	}
+	b.add("CREATE TABLE %s (\n%s\n)", b.table(t.Name), strings.Join(lines, ",\n"))
+	for _, i := range t.Indexes {
+		if !i.Primary {
+			b.createIndex(t.Name, i)
+		}
+	}
+}
+
+func (b *ddlBuilder) alterTable(t TableDiff) {
+	table := b.table(t.Table)
+	for _, c := range t.Changes {
+		if c.Object == IndexObject && c.Kind != Added {
+			b.dropIndex(t.Table, c.Name)
+		}
+	}
+	for _, c := range t.Changes {
+		if c.Object == PrimaryKeyObject && c.Kind != Added {
+			b.dropPrimaryKey(t)
+		}
+	}
+	for _, c := range t.Changes {
+		if c.Object != ColumnObject {
+			continue
+		}
+		switch c.Kind {
+		case Added:
+			b.add("ALTER TABLE %s ADD COLUMN %s", table, b.columnDefinition(findColumn(t.New, c.Name), true))
+		case Removed:
+			b.add("ALTER TABLE %s DROP COLUMN %s", table, b.ident(c.Name))
+		case Changed:
+			b.alterColumn(t.Table, findColumn(t.Old, c.Name), findColumn(t.New, c.Name))
+		}
+	}
+	for _, c := range t.Changes {
+		if c.Object == PrimaryKeyObject && c.Kind != Removed {
+			if b.provider == "sqlite" {
+				b.manual("sqlite cannot change the primary key of %s, the table has to be rebuilt", t.Table)
+				continue
+			}
+			b.add("ALTER TABLE %s ADD PRIMARY KEY (%s)", table, b.idents(t.New.PrimaryKey))
+		}
+	}
+	for _, c := range t.Changes {
+		if c.Object == IndexObject && c.Kind != Removed {
+			b.createIndex(t.Table, findIndex(t.New, c.Name))
+		}
+	}
+}
+
+func (b *ddlBuilder) alterColumn(table string, from dh.Column, to dh.Column) {
+	quoted := b.table(table)
+	column := b.ident(to.Name)
+	switch b.provider {
+	case "mysql":
+		b.add("ALTER TABLE %s MODIFY COLUMN %s", quoted, b.columnDefinition(to, false))
+	case "postgres":
+		if !strings.EqualFold(from.Type, to.Type) {
+			b.add("ALTER TABLE %s ALTER COLUMN %s TYPE %s", quoted, column, to.Type)
+		}
+		if from.Nullable != to.Nullable {
+			if to.Nullable {
+				b.add("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL", quoted, column)
+			} else {
+				b.add("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", quoted, column)
+			}
+		}
+		if from.Default != to.Default {
+			if to.Default.Valid {
+				b.add("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s", quoted, column, to.Default.String)
+			} else {
+				b.add("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT", quoted, column)
+			}
+		}
+	default:
+		b.manual("sqlite cannot alter column %s.%s to %s, the table has to be rebuilt", table, to.Name, DescribeColumn(to))
+	}
+}
+
+func (b *ddlBuilder) dropPrimaryKey(t TableDiff) {
+	switch b.provider {
+	case "mysql":
+		b.add("ALTER TABLE %s DROP PRIMARY KEY", b.table(t.Table))
+	case "postgres":
+		b.add("ALTER TABLE %s DROP CONSTRAINT %s", b.table(t.Table), b.ident(primaryIndexName(t.Old)))
+	}
+}
+
+func (b *ddlBuilder) createIndex(table string, i dh.Index) {
+	for _, c := range i.Columns {
+		if c == "(expression)" {
+			b.manual("index %s on %s uses expressions and has to be created manually", i.Name, table)
+			return
+		}
+	}
+	name := i.Name
+	// Names of indexes backing sqlite constraints are reserved
+	if strings.HasPrefix(name, "sqlite_autoindex_") {
+		name = table + "_" + strings.Join(i.Columns, "_") + "_key"
+	}
+	unique := ""
+	if i.Unique {
+		unique = "UNIQUE "
+	}
+	b.add("CREATE %sINDEX %s ON %s (%s)", unique, b.ident(name), b.table(table), b.idents(i.Columns))
+}
+
+func (b *ddlBuilder) dropIndex(table string, name string) {
+	switch b.provider {
+	case "mysql":
+		b.add("DROP INDEX %s ON %s", b.ident(name), b.table(table))
+	case "postgres":
+		// Indexes live in the schema of their table
+		if schema, _, ok := strings.Cut(table, "."); ok {
+			b.add("DROP INDEX %s.%s", b.ident(schema), b.ident(name))
+			return
+		}
+		b.add("DROP INDEX %s", b.ident(name))
+	default:
+		b.add("DROP INDEX %s", b.ident(name))
+	}
+}
+
+func (b *ddlBuilder) addForeignKey(table string, fk dh.ForeignKey) {
+	if b.provider == "sqlite" {
+		b.manual("sqlite cannot add foreign key %s to %s, the table has to be rebuilt", describeForeignKey(fk), table)
+		return
+	}
+	b.add("ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)",
+		b.table(table), b.ident(fk.Name), b.idents(fk.Columns), b.table(fk.ReferencedTable), b.idents(fk.ReferencedColumns))
+}
+
+func (b *ddlBuilder) dropForeignKey(table string, name string) {
+	switch b.provider {
+	case "mysql":
+		b.add("ALTER TABLE %s DROP FOREIGN KEY %s", b.table(table), b.ident(name))
+	case "postgres":
+		b.add("ALTER TABLE %s DROP CONSTRAINT %s", b.table(table), b.ident(name))
+	default:
+		b.manual("sqlite cannot drop foreign key %s of %s, the table has to be rebuilt", name, table)
+	}
+}
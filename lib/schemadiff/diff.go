@@ -0,0 +1,219 @@
+// Package schemadiff compares the structure of two databases as described by
+// the query package and derives the DDL migrating one into the other.
+package schemadiff
+
+import (
+	"fmt"
+	dh "seraphim/lib/db/query"
+	"strings"
+)
+
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Kinds of schema objects reported in a Change.
+const (
+	ColumnObject     = "column"
+	PrimaryKeyObject = "primary_key"
+	IndexObject      = "index"
+	ForeignKeyObject = "foreign_key"
+)
+
+// Target identifies one side of a diff.
+type Target struct {
+	Tag      string `json:"tag"`
+	Database string `json:"database"`
+	Provider string `json:"provider"`
+}
+
+func (t Target) String() string {
+	return t.Tag + "/" + t.Database
+}
+
+// Change is a single difference inside a table. From and To describe the
+// object on each side, in the syntax of the respective provider.
+type Change struct {
+	Kind   string `json:"kind"`
+	Object string `json:"object"`
+	Name   string `json:"name"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+type TableDiff struct {
+	Table   string   `json:"table"`
+	Kind    string   `json:"kind"`
+	Changes []Change `json:"changes,omitempty"`
+
+	// Old and New are the table on each side, nil when it does not exist
+	Old *dh.TableDetails `json:"-"`
+	New *dh.TableDetails `json:"-"`
+}
+
+type Result struct {
+	From   Target      `json:"from"`
+	To     Target      `json:"to"`
+	Tables []TableDiff `json:"tables"`
+}
+
+// Empty reports whether both schemas are identical.
+func (r Result) Empty() bool {
+	return len(r.Tables) == 0
+}
+
+// Counts returns how many tables were added, removed and changed.
+func (r Result) Counts() (added int, removed int, changed int) {
+	for _, t := range r.Tables {
+		switch t.Kind {
+		case Added:
+			added++
+		case Removed:
+			removed++
+		case Changed:
+			changed++
+		}
+	}
+	return
+}
+
+// Compare reports the differences needed to turn the from schema into the to
+// schema: tables only in to are added, tables only in from are removed.
+func Compare(from Target, fromTables []dh.TableDetails, to Target, toTables []dh.TableDetails) Result {
+	r := Result{From: from, To: to, Tables: make([]TableDiff, 0)}
+	old := make(map[string]*dh.TableDetails, len(fromTables))
+	for i := range fromTables {
+		old[fromTables[i].Name] = &fromTables[i]
+	}
+	seen := make(map[string]bool, len(toTables))
+	for i := range toTables {
+		t := &toTables[i]
+		seen[t.Name] = true
+		o, ok := old[t.Name]
+		if !ok {
+			r.Tables = append(r.Tables, TableDiff{Table: t.Name, Kind: Added, New: t})
+			continue
+		}
+		if changes := compareTable(o, t); len(changes) > 0 {
+			r.Tables = append(r.Tables, TableDiff{Table: t.Name, Kind: Changed, Changes: changes, Old: o, New: t})
+		}
+	}
+	for i := range fromTables {
+		if t := &fromTables[i]; !seen[t.Name] {
+			r.Tables = append(r.Tables, TableDiff{Table: t.Name, Kind: Removed, Old: t})
+		}
+	}
+	return r
+}
+
+// DescribeColumn renders the definition of a column, e.g.
+// "varchar(20) NOT NULL DEFAULT 'x'".
+func DescribeColumn(c dh.Column) string {
+	parts := []string{c.Type}
+	if !c.Nullable {
+		parts = append(parts, "NOT NULL")
+	}
+	if c.Default.Valid {
+		parts = append(parts, "DEFAULT "+c.Default.String)
+	}
+	if c.Extra != "" {
+		parts = append(parts, c.Extra)
+	}
+	return strings.Join(parts, " ")
+}
+
+func describeIndex(i dh.Index) string {
+	s := "(" + strings.Join(i.Columns, ", ") + ")"
+	if i.Unique {
+		s = "UNIQUE " + s
+	}
+	return s
+}
+
+func describeForeignKey(fk dh.ForeignKey) string {
+	return fmt.Sprintf("(%s) → %s(%s)", strings.Join(fk.Columns, ", "), fk.ReferencedTable, strings.Join(fk.ReferencedColumns, ", "))
+}
+
+func describeKey(columns []string) string {
+	if len(columns) == 0 {
+		return ""
+	}
+	return "(" + strings.Join(columns, ", ") + ")"
+}
+
+// namedItem is an object compared by name between both sides of a table.
+type namedItem struct {
+	Name        string
+	Description string
+}
+
+// compareNamed reports added, removed and changed items, in the order of the
+// to side followed by removals in the order of the from side.
+func compareNamed(object string, from []namedItem, to []namedItem) []Change {
+	changes := make([]Change, 0)
+	old := make(map[string]string, len(from))
+	for _, item := range from {
+		old[item.Name] = item.Description
+	}
+	seen := make(map[string]bool, len(to))
+	for _, item := range to {
+		seen[item.Name] = true
+		description, ok := old[item.Name]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Kind: Added, Object: object, Name: item.Name, To: item.Description})
+		case !strings.EqualFold(description, item.Description):
+			changes = append(changes, Change{Kind: Changed, Object: object, Name: item.Name, From: description, To: item.Description})
+		}
+	}
+	for _, item := range from {
+		if !seen[item.Name] {
+			changes = append(changes, Change{Kind: Removed, Object: object, Name: item.Name, From: item.Description})
+		}
+	}
+	return changes
+}
+
+func compareTable(from *dh.TableDetails, to *dh.TableDetails) []Change {
+	columns := func(t *dh.TableDetails) []namedItem {
+		items := make([]namedItem, len(t.Columns))
+		for i, c := range t.Columns {
+			items[i] = namedItem{c.Name, DescribeColumn(c)}
+		}
+		return items
+	}
+	indexes := func(t *dh.TableDetails) []namedItem {
+		items := make([]namedItem, 0, len(t.Indexes))
+		for _, i := range t.Indexes {
+			// The primary key index is compared as the primary key
+			if !i.Primary {
+				items = append(items, namedItem{i.Name, describeIndex(i)})
+			}
+		}
+		return items
+	}
+	foreignKeys := func(t *dh.TableDetails) []namedItem {
+		items := make([]namedItem, len(t.ForeignKeys))
+		for i, fk := range t.ForeignKeys {
+			items[i] = namedItem{fk.Name, describeForeignKey(fk)}
+		}
+		return items
+	}
+
+	changes := compareNamed(ColumnObject, columns(from), columns(to))
+	if a, b := describeKey(from.PrimaryKey), describeKey(to.PrimaryKey); a != b {
+		kind := Changed
+		switch {
+		case a == "":
+			kind = Added
+		case b == "":
+			kind = Removed
+		}
+		changes = append(changes, Change{Kind: kind, Object: PrimaryKeyObject, Name: "PRIMARY KEY", From: a, To: b})
+	}
+	changes = append(changes, compareNamed(IndexObject, indexes(from), indexes(to))...)
+	changes = append(changes, compareNamed(ForeignKeyObject, foreignKeys(from), foreignKeys(to))...)
+	return changes
+}
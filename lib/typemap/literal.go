@@ -0,0 +1,64 @@
+package typemap
+
+import (
+	"encoding/hex"
+	"math"
+	dh "seraphim/lib/db/query"
+	"strconv"
+	"time"
+)
+
+// LiteralFunc renders a value scanned from the source as an SQL literal for
+// the target column.
+type LiteralFunc func(v any, kind dh.ValueKind) string
+
+// Literal returns the LiteralFunc writing values into column, a column
+// definition of provider such as returned by Mapper.Table.
+func Literal(provider string, column dh.Column) LiteralFunc {
+	provider = dh.NormalizeProvider(provider)
+	target := parseType(provider, column.Type)
+	return func(v any, kind dh.ValueKind) string {
+		if v == nil {
+			return "NULL"
+		}
+		if target.Base == baseBool {
+			if text, _ := dh.FormatTypedValue(v, kind); text != "" {
+				if b, ok := truthy(text); ok {
+					return boolLiteral(provider, b)
+				}
+			}
+		}
+		switch val := v.(type) {
+		case bool:
+			return boolLiteral(provider, val)
+		case int64:
+			return strconv.FormatInt(val, 10)
+		case float64:
+			if math.IsInf(val, 0) || math.IsNaN(val) {
+				return quoteString(provider, strconv.FormatFloat(val, 'f', -1, 64))
+			}
+			return strconv.FormatFloat(val, 'g', -1, 64)
+		case []byte:
+			if kind == dh.KindBinary || target.Base == baseBlob || target.Base == baseBinary {
+				if provider == "postgres" {
+					return `'\x` + hex.EncodeToString(val) + `'`
+				}
+				return "X'" + hex.EncodeToString(val) + "'"
+			}
+			if target.isNumeric() && (kind == dh.KindInteger || kind == dh.KindDecimal) {
+				if _, err := strconv.ParseFloat(string(val), 64); err == nil {
+					return string(val)
+				}
+			}
+			return quoteString(provider, string(val))
+		case time.Time:
+			// mysql has no time zone aware type, the values are stored in UTC
+			if provider == "mysql" && kind == dh.KindTimestampTZ {
+				return quoteString(provider, val.UTC().Format("2006-01-02 15:04:05.999999"))
+			}
+			text, _ := dh.FormatTypedValue(val, kind)
+			return quoteString(provider, text)
+		}
+		return quoteString(provider, dh.FormatValue(v))
+	}
+}
@@ -0,0 +1,319 @@
+package typemap
+
+import (
+	"fmt"
+	dh "seraphim/lib/db/query"
+	"strings"
+)
+
+// rendered is a type in the syntax of the target provider. Lossy explains
+// what the conversion loses, empty when nothing is lost.
+type rendered struct {
+	Type  string
+	Extra string
+	Lossy string
+}
+
+func quoteValues(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// enumCheck renders an enum as text restricted to its members.
+func enumCheck(provider string, typ string, column string, values []string) string {
+	return fmt.Sprintf("%s CHECK (%s IN (%s))", typ, dh.QuoteIdent(provider, column), quoteValues(values))
+}
+
+func withLength(name string, length int) string {
+	if length < 0 {
+		return name
+	}
+	return fmt.Sprintf("%s(%d)", name, length)
+}
+
+func withPrecision(name string, precision int) string {
+	if precision < 0 {
+		return name
+	}
+	return fmt.Sprintf("%s(%d)", name, precision)
+}
+
+// timePrecision returns the fractional digits of a time type, postgres and
+// sqlite keeping microseconds when none are declared.
+func timePrecision(from string, g generic) int {
+	if g.Precision >= 0 {
+		return g.Precision
+	}
+	if from == "mysql" {
+		return 0
+	}
+	return 6
+}
+
+func renderMySQL(from string, g generic, column string, auto bool) rendered {
+	r := rendered{}
+	if auto {
+		r.Extra = "auto_increment"
+	}
+	switch g.Base {
+	case baseBool:
+		r.Type = "tinyint(1)"
+	case baseInt:
+		r.Type = map[int]string{8: "tinyint", 16: "smallint", 24: "mediumint", 32: "int", 64: "bigint"}[g.Bits]
+		if g.Unsigned {
+			r.Type += " unsigned"
+		}
+	case baseDecimal:
+		precision, scale := g.Precision, g.Scale
+		if precision < 0 {
+			precision, scale = 65, 30
+			r.Lossy = "unconstrained numeric limited to 65 digits with 30 decimals"
+		}
+		if precision > 65 || scale > 30 {
+			precision, scale = min(precision, 65), min(scale, 30)
+			r.Lossy = "precision limited to 65 digits with 30 decimals"
+		}
+		r.Type = fmt.Sprintf("decimal(%d,%d)", precision, scale)
+	case baseFloat:
+		r.Type = "double"
+		if g.Bits == 32 {
+			r.Type = "float"
+		}
+	case baseChar:
+		if g.Length > 255 {
+			r.Type = withLength("varchar", g.Length)
+		} else {
+			r.Type = withLength("char", max(g.Length, 1))
+		}
+	case baseVarchar:
+		switch {
+		case g.Length < 0:
+			r.Type = "longtext"
+		case g.Length > 16383:
+			r.Type = "mediumtext"
+		default:
+			r.Type = withLength("varchar", g.Length)
+		}
+	case baseText:
+		switch {
+		case g.Length < 0:
+			r.Type = "longtext"
+		case g.Length <= 255:
+			r.Type = "tinytext"
+		case g.Length <= 65535:
+			r.Type = "text"
+		default:
+			r.Type = "mediumtext"
+		}
+	case baseBinary:
+		r.Type = withLength("binary", max(g.Length, 1))
+	case baseBlob:
+		if g.Length > 0 && g.Length <= 65535 {
+			r.Type = withLength("varbinary", g.Length)
+		} else {
+			r.Type = "longblob"
+		}
+	case baseDate:
+		r.Type = "date"
+	case baseTime:
+		r.Type = withPrecision("time", min(timePrecision(from, g), 6))
+	case baseTimeTZ:
+		r.Type = withPrecision("time", min(timePrecision(from, g), 6))
+		r.Lossy = "time zone dropped"
+	case baseTimestamp:
+		r.Type = withPrecision("datetime", min(timePrecision(from, g), 6))
+	case baseTimestampTZ:
+		r.Type = withPrecision("datetime", min(timePrecision(from, g), 6))
+		r.Lossy = "time zone dropped, values are stored in UTC"
+	case baseYear:
+		r.Type = "year"
+	case baseJSON:
+		r.Type = "json"
+	case baseUUID:
+		r.Type = "char(36)"
+	case baseEnum:
+		r.Type = "enum(" + quoteValues(g.Values) + ")"
+	case baseSet:
+		r.Type = "set(" + quoteValues(g.Values) + ")"
+	case baseBit:
+		r.Type = withLength("varchar", max(g.Length, 64))
+		r.Lossy = "bit string stored as text"
+	case baseInterval:
+		r.Type = "varchar(64)"
+		r.Lossy = "interval stored as text"
+	case baseArray:
+		r.Type = "longtext"
+		r.Lossy = "array stored as its text form"
+	default:
+		r.Type = "longtext"
+		r.Lossy = "unknown type stored as text"
+	}
+	return r
+}
+
+func renderPostgres(from string, g generic, column string, auto bool) rendered {
+	r := rendered{}
+	switch g.Base {
+	case baseBool:
+		r.Type = "boolean"
+	case baseInt:
+		bits := g.Bits
+		// Unsigned values need the next wider type
+		if g.Unsigned {
+			bits *= 2
+		}
+		switch {
+		case bits <= 16:
+			r.Type = "smallint"
+		case bits <= 32:
+			r.Type = "integer"
+		case bits <= 64:
+			r.Type = "bigint"
+		default:
+			r.Type = "numeric(20,0)"
+		}
+		if auto {
+			switch r.Type {
+			case "smallint":
+				r.Type = "smallserial"
+			case "integer":
+				r.Type = "serial"
+			case "bigint":
+				r.Type = "bigserial"
+			default:
+				r.Lossy = "auto increment of unsigned bigint dropped"
+			}
+		}
+		return r
+	case baseDecimal:
+		r.Type = "numeric"
+		if g.Precision >= 0 {
+			r.Type = fmt.Sprintf("numeric(%d,%d)", g.Precision, max(g.Scale, 0))
+		}
+	case baseFloat:
+		r.Type = "double precision"
+		if g.Bits == 32 {
+			r.Type = "real"
+		}
+	case baseChar:
+		r.Type = withLength("char", max(g.Length, 1))
+	case baseVarchar:
+		r.Type = withLength("varchar", g.Length)
+	case baseText:
+		r.Type = "text"
+	case baseBinary, baseBlob:
+		r.Type = "bytea"
+	case baseDate:
+		r.Type = "date"
+	case baseTime:
+		r.Type = withPrecision("time", g.Precision)
+	case baseTimeTZ:
+		r.Type = withPrecision("timetz", g.Precision)
+	case baseTimestamp:
+		r.Type = withPrecision("timestamp", g.Precision)
+	case baseTimestampTZ:
+		r.Type = withPrecision("timestamptz", g.Precision)
+	case baseYear:
+		r.Type = "smallint"
+	case baseJSON:
+		r.Type = "jsonb"
+	case baseUUID:
+		r.Type = "uuid"
+	case baseEnum:
+		r.Type = enumCheck("postgres", withLength("varchar", g.maxLength()), column, g.Values)
+	case baseSet:
+		r.Type = "text"
+		r.Lossy = "set members are not enforced"
+	case baseBit:
+		if from == "postgres" {
+			r.Type = withLength("varbit", g.Length)
+		} else {
+			r.Type = "bytea"
+			r.Lossy = "bit value stored as bytes"
+		}
+	case baseInterval:
+		r.Type = "interval"
+	case baseArray:
+		r.Type = g.Raw
+	default:
+		r.Type = "text"
+		r.Lossy = "unknown type stored as text"
+	}
+	if auto {
+		r.Lossy = "auto increment dropped on a non integer column"
+	}
+	return r
+}
+
+func renderSQLite(from string, g generic, column string, auto bool) rendered {
+	r := rendered{}
+	switch g.Base {
+	case baseBool:
+		r.Type = "BOOLEAN"
+	case baseInt, baseYear:
+		r.Type = "INTEGER"
+		if g.Unsigned && g.Bits == 64 {
+			r.Lossy = "unsigned bigint values above 2^63 do not fit"
+		}
+		return r
+	case baseDecimal:
+		r.Type = "NUMERIC"
+		if g.Precision >= 0 {
+			r.Type = fmt.Sprintf("NUMERIC(%d,%d)", g.Precision, max(g.Scale, 0))
+		}
+		if g.Precision < 0 || g.Precision > 15 {
+			r.Lossy = "decimals beyond 15 significant digits lose precision"
+		}
+	case baseFloat:
+		r.Type = "REAL"
+	case baseChar, baseVarchar:
+		r.Type = "TEXT"
+		if g.Length >= 0 {
+			r.Type = withLength("VARCHAR", g.Length)
+		}
+	case baseText, baseJSON, baseUUID, baseInterval:
+		r.Type = "TEXT"
+	case baseBinary, baseBlob:
+		r.Type = "BLOB"
+	case baseDate:
+		r.Type = "DATE"
+	case baseTime, baseTimeTZ:
+		r.Type = "TIME"
+	case baseTimestamp, baseTimestampTZ:
+		r.Type = "DATETIME"
+	case baseEnum:
+		r.Type = enumCheck("sqlite", "TEXT", column, g.Values)
+	case baseSet:
+		r.Type = "TEXT"
+		r.Lossy = "set members are not enforced"
+	case baseBit:
+		r.Type = "BLOB"
+		if from == "postgres" {
+			r.Type = "TEXT"
+		}
+	case baseArray:
+		r.Type = "TEXT"
+		r.Lossy = "array stored as its text form"
+	default:
+		r.Type = "TEXT"
+		r.Lossy = "unknown type stored as text"
+	}
+	if auto {
+		r.Lossy = "auto increment dropped on a non integer column"
+	}
+	return r
+}
+
+func render(from string, to string, g generic, column string, auto bool) rendered {
+	switch to {
+	case "mysql":
+		return renderMySQL(from, g, column, auto)
+	case "postgres":
+		return renderPostgres(from, g, column, auto)
+	default:
+		return renderSQLite(from, g, column, auto)
+	}
+}
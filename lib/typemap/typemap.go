@@ -0,0 +1,297 @@
+package typemap
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	dh "seraphim/lib/db/query"
+	"strconv"
+	"strings"
+)
+
+// Issue is a conversion that loses information.
+type Issue struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason"`
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s.%s: %s → %s, %s", i.Table, i.Column, i.From, i.To, i.Reason)
+}
+
+// Mapper translates definitions of the From provider into the To provider.
+type Mapper struct {
+	From string
+	To   string
+}
+
+func New(from string, to string) Mapper {
+	return Mapper{From: dh.NormalizeProvider(from), To: dh.NormalizeProvider(to)}
+}
+
+// Identity reports whether both providers are the same, in which case
+// definitions are kept as they are.
+func (m Mapper) Identity() bool {
+	return m.From == m.To
+}
+
+// autoIncrement reports whether c is filled by the provider when omitted.
+func (m Mapper) autoIncrement(t dh.TableDetails, c dh.Column) bool {
+	switch m.From {
+	case "mysql":
+		return strings.Contains(strings.ToLower(c.Extra), "auto_increment")
+	case "postgres":
+		return strings.HasPrefix(c.Default.String, "nextval(") || strings.HasSuffix(strings.ToLower(c.Type), "serial")
+	case "sqlite":
+		// An INTEGER primary key is an alias of the rowid
+		return len(t.PrimaryKey) == 1 && t.PrimaryKey[0] == c.Name && strings.EqualFold(c.Type, "INTEGER")
+	}
+	return false
+}
+
+// Table returns the definition of t for the target provider along with the
+// lossy conversions. Index names are prefixed with the table name when
+// leaving mysql, where they are only unique per table, and foreign key names
+// when entering mysql, where they must be unique per database.
+func (m Mapper) Table(t dh.TableDetails) (dh.TableDetails, []Issue) {
+	if m.Identity() {
+		return t, nil
+	}
+	mapped := t
+	issues := make([]Issue, 0)
+	indexed := make(map[string]bool)
+	for _, c := range t.PrimaryKey {
+		indexed[c] = true
+	}
+	for _, index := range t.Indexes {
+		for _, c := range index.Columns {
+			indexed[c] = true
+		}
+	}
+	for _, fk := range t.ForeignKeys {
+		for _, c := range fk.Columns {
+			indexed[c] = true
+		}
+	}
+	mapped.Columns = make([]dh.Column, len(t.Columns))
+	for i, c := range t.Columns {
+		auto := m.autoIncrement(t, c)
+		if auto && m.To == "sqlite" && !(len(t.PrimaryKey) == 1 && t.PrimaryKey[0] == c.Name) {
+			issues = append(issues, Issue{Table: t.Name, Column: c.Name, From: c.Type, To: "INTEGER", Reason: "auto increment is only kept on a single column primary key"})
+			auto = false
+		}
+		column, columnIssues := m.column(t.Name, c, auto, indexed[c.Name])
+		mapped.Columns[i] = column
+		issues = append(issues, columnIssues...)
+	}
+	if m.From == "mysql" {
+		mapped.Indexes = make([]dh.Index, len(t.Indexes))
+		for i, index := range t.Indexes {
+			if !index.Primary && !strings.HasPrefix(index.Name, t.Name+"_") {
+				index.Name = t.Name + "_" + index.Name
+			}
+			mapped.Indexes[i] = index
+		}
+	}
+	if m.To == "mysql" {
+		mapped.ForeignKeys = make([]dh.ForeignKey, len(t.ForeignKeys))
+		for i, fk := range t.ForeignKeys {
+			if !strings.HasPrefix(fk.Name, t.Name+"_") {
+				fk.Name = t.Name + "_" + fk.Name
+			}
+			mapped.ForeignKeys[i] = fk
+		}
+	}
+	return mapped, issues
+}
+
+// column maps c, indexed telling whether c is part of a key or an index.
+func (m Mapper) column(table string, c dh.Column, auto bool, indexed bool) (dh.Column, []Issue) {
+	issues := make([]Issue, 0)
+	issue := func(to string, reason string) {
+		issues = append(issues, Issue{Table: table, Column: c.Name, From: c.Type, To: to, Reason: reason})
+	}
+
+	g := parseType(m.From, c.Type)
+	r := render(m.From, m.To, g, c.Name, auto)
+	if r.Lossy != "" {
+		issue(r.Type, r.Lossy)
+	}
+	// mysql only indexes text and blob columns by a prefix
+	if m.To == "mysql" && indexed {
+		switch r.Type {
+		case "tinytext", "text", "mediumtext", "longtext":
+			r.Type = "varchar(255)"
+			issue(r.Type, "indexed text limited to 255 characters")
+		case "longblob":
+			r.Type = "varbinary(255)"
+			issue(r.Type, "indexed binary limited to 255 bytes")
+		}
+	}
+	mapped := dh.Column{Name: c.Name, Type: r.Type, Nullable: c.Nullable, Extra: r.Extra}
+	if m.From == "mysql" && strings.Contains(strings.ToLower(c.Extra), "on update") {
+		issue(r.Type, "ON UPDATE clause dropped")
+	}
+	if auto {
+		return mapped, issues
+	}
+
+	target := parseType(m.To, r.Type)
+	def, reason := m.defaultExpression(c, g, target, r.Type)
+	if reason != "" {
+		issue(r.Type, reason)
+	}
+	mapped.Default = def
+	return mapped, issues
+}
+
+var (
+	pgCast        = regexp.MustCompile(`^\(?('(?:[^']|'')*'|-?[0-9.]+)\)?::[a-z0-9 _"().\[\]]+$`)
+	parenthesized = regexp.MustCompile(`^\((-?[0-9.]+)\)$`)
+	nowFunction   = regexp.MustCompile(`^(current_timestamp|now|localtimestamp|localtime)(\(\d*\))?$`)
+)
+
+// defaultValue is a parsed default: a literal, NULL, the current time or an
+// expression of the source provider.
+type defaultValue struct {
+	Null       bool
+	Literal    string
+	IsLiteral  bool
+	Now        string
+	Expression string
+}
+
+func unquote(s string) string {
+	return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+}
+
+func (m Mapper) parseDefault(c dh.Column) defaultValue {
+	value := strings.TrimSpace(c.Default.String)
+	lower := strings.ToLower(value)
+	switch {
+	case lower == "null" || strings.HasPrefix(lower, "null::"):
+		return defaultValue{Null: true}
+	case nowFunction.MatchString(lower), lower == "datetime('now')", lower == "(datetime('now'))":
+		return defaultValue{Now: "CURRENT_TIMESTAMP"}
+	case lower == "current_date", lower == "curdate()", lower == "(curdate())", lower == "(current_date)":
+		return defaultValue{Now: "CURRENT_DATE"}
+	case lower == "current_time", lower == "curtime()", lower == "(curtime())", lower == "(current_time)":
+		return defaultValue{Now: "CURRENT_TIME"}
+	}
+
+	switch m.From {
+	case "mysql":
+		// mysql reports literals unquoted and expressions in parentheses
+		if strings.HasPrefix(value, "(") {
+			return defaultValue{Expression: value}
+		}
+		if len(value) >= 2 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") {
+			return defaultValue{Literal: unquote(value), IsLiteral: true}
+		}
+		return defaultValue{Literal: value, IsLiteral: true}
+	default:
+		if match := pgCast.FindStringSubmatch(value); match != nil {
+			value = match[1]
+		}
+		if match := parenthesized.FindStringSubmatch(value); match != nil {
+			value = match[1]
+		}
+		if len(value) >= 2 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") {
+			return defaultValue{Literal: unquote(value), IsLiteral: true}
+		}
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return defaultValue{Literal: value, IsLiteral: true}
+		}
+		if l := strings.ToLower(value); l == "true" || l == "false" {
+			return defaultValue{Literal: l, IsLiteral: true}
+		}
+		return defaultValue{Expression: value}
+	}
+}
+
+// truthy reports whether a literal stands for true in any provider.
+func truthy(s string) (bool, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "1", "true", "t", "yes", "y", "on", "b'1'":
+		return true, true
+	case "0", "false", "f", "no", "n", "off", "b'0'":
+		return false, true
+	}
+	return false, false
+}
+
+// quoteString renders s as a string literal for provider. mysql treats the
+// backslash as an escape character by default.
+func quoteString(provider string, s string) string {
+	s = strings.ReplaceAll(s, "'", "''")
+	if provider == "mysql" {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+	}
+	return "'" + s + "'"
+}
+
+func boolLiteral(provider string, b bool) string {
+	switch {
+	case provider == "postgres" && b:
+		return "TRUE"
+	case provider == "postgres":
+		return "FALSE"
+	case b:
+		return "1"
+	}
+	return "0"
+}
+
+// defaultExpression translates the default of c into an expression of the
+// target provider. reason is set when the default could not be kept.
+func (m Mapper) defaultExpression(c dh.Column, source generic, target generic, targetType string) (sql.NullString, string) {
+	if !c.Default.Valid {
+		return sql.NullString{}, ""
+	}
+	d := m.parseDefault(c)
+	valid := func(s string) (sql.NullString, string) {
+		return sql.NullString{String: s, Valid: true}, ""
+	}
+	switch {
+	case d.Null:
+		return valid("NULL")
+	case d.Now != "":
+		if m.To == "mysql" {
+			switch target.Base {
+			case baseTimestamp:
+				if target.Precision > 0 {
+					return valid(fmt.Sprintf("CURRENT_TIMESTAMP(%d)", target.Precision))
+				}
+				return valid("CURRENT_TIMESTAMP")
+			case baseDate, baseTime:
+				// Only datetime columns take a bare function default
+				return valid("(" + d.Now + ")")
+			}
+			return sql.NullString{}, "default " + c.Default.String + " dropped"
+		}
+		return valid(d.Now)
+	case d.Expression != "":
+		return sql.NullString{}, "default expression " + d.Expression + " dropped"
+	}
+
+	if m.To == "mysql" && (strings.Contains(targetType, "text") || strings.Contains(targetType, "blob") || targetType == "json") {
+		return sql.NullString{}, "mysql does not allow literal defaults on " + targetType + " columns, default dropped"
+	}
+	switch {
+	case target.Base == baseBool:
+		if b, ok := truthy(d.Literal); ok {
+			return valid(boolLiteral(m.To, b))
+		}
+	case target.isNumeric():
+		if b, ok := truthy(d.Literal); ok && source.Base == baseBool {
+			return valid(boolLiteral(m.To, b))
+		}
+		if _, err := strconv.ParseFloat(d.Literal, 64); err == nil {
+			return valid(d.Literal)
+		}
+	}
+	return valid(quoteString(m.To, d.Literal))
+}
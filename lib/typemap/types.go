@@ -0,0 +1,270 @@
+// Package typemap translates column definitions, defaults and values between
+// the supported providers, reporting every conversion that loses information.
+package typemap
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Base types every provider type is parsed into.
+const (
+	baseBool        = "bool"
+	baseInt         = "int"
+	baseDecimal     = "decimal"
+	baseFloat       = "float"
+	baseChar        = "char"
+	baseVarchar     = "varchar"
+	baseText        = "text"
+	baseBinary      = "binary"
+	baseBlob        = "blob"
+	baseDate        = "date"
+	baseTime        = "time"
+	baseTimeTZ      = "timetz"
+	baseTimestamp   = "timestamp"
+	baseTimestampTZ = "timestamptz"
+	baseYear        = "year"
+	baseJSON        = "json"
+	baseUUID        = "uuid"
+	baseEnum        = "enum"
+	baseSet         = "set"
+	baseBit         = "bit"
+	baseInterval    = "interval"
+	baseArray       = "array"
+	baseOther       = "other"
+)
+
+// generic is a provider independent description of a column type. Length,
+// Precision and Scale are -1 when the source type leaves them unspecified.
+type generic struct {
+	Base      string
+	Bits      int
+	Unsigned  bool
+	Length    int
+	Precision int
+	Scale     int
+	Values    []string
+	Raw       string
+}
+
+// parseArgs splits the parenthesized arguments of a type into numbers and
+// quoted values, the latter for enum and set members.
+func parseArgs(args string) ([]int, []string) {
+	numbers := make([]int, 0)
+	values := make([]string, 0)
+	var current strings.Builder
+	quoted, inQuote := false, false
+	for i := 0; i < len(args); i++ {
+		ch := args[i]
+		switch {
+		case ch == '\'' && inQuote && i+1 < len(args) && args[i+1] == '\'':
+			current.WriteByte('\'')
+			i++
+		case ch == '\'':
+			inQuote = !inQuote
+			quoted = true
+		case ch == ',' && !inQuote:
+			values, numbers = appendArg(values, numbers, current.String(), quoted)
+			current.Reset()
+			quoted = false
+		default:
+			current.WriteByte(ch)
+		}
+	}
+	if current.Len() > 0 || quoted {
+		values, numbers = appendArg(values, numbers, current.String(), quoted)
+	}
+	return numbers, values
+}
+
+func appendArg(values []string, numbers []int, arg string, quoted bool) ([]string, []int) {
+	if quoted {
+		return append(values, arg), numbers
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(arg)); err == nil {
+		return values, append(numbers, n)
+	}
+	return values, numbers
+}
+
+// parseType parses a column type as reported by provider.
+func parseType(provider string, typ string) generic {
+	g := generic{Length: -1, Precision: -1, Scale: -1, Raw: typ}
+	t := strings.ToLower(strings.TrimSpace(typ))
+	if strings.HasSuffix(t, "[]") {
+		g.Base = baseArray
+		return g
+	}
+	var numbers []int
+	if i := strings.Index(t, "("); i >= 0 {
+		if j := strings.LastIndex(t, ")"); j > i {
+			numbers, g.Values = parseArgs(strings.TrimSpace(typ)[i+1 : j])
+			t = t[:i] + " " + t[j+1:]
+		}
+	}
+	words := make([]string, 0)
+	for _, w := range strings.Fields(t) {
+		switch w {
+		case "unsigned":
+			g.Unsigned = true
+		case "zerofill", "signed":
+		default:
+			words = append(words, w)
+		}
+	}
+	name := strings.Join(words, " ")
+	arg := func(i int) int {
+		if i < len(numbers) {
+			return numbers[i]
+		}
+		return -1
+	}
+	integer := func(bits int) generic {
+		g.Base, g.Bits = baseInt, bits
+		return g
+	}
+
+	switch name {
+	case "bool", "boolean":
+		g.Base = baseBool
+	case "tinyint":
+		if provider == "mysql" && arg(0) == 1 {
+			g.Base = baseBool
+			return g
+		}
+		return integer(8)
+	case "smallint", "int2", "smallserial":
+		return integer(16)
+	case "mediumint":
+		return integer(24)
+	case "int", "integer", "int4", "serial":
+		// sqlite integers are always 64 bits wide
+		if provider == "sqlite" {
+			return integer(64)
+		}
+		return integer(32)
+	case "bigint", "int8", "bigserial":
+		return integer(64)
+	case "decimal", "numeric", "dec", "fixed":
+		g.Base, g.Precision, g.Scale = baseDecimal, arg(0), arg(1)
+		if g.Precision >= 0 && g.Scale < 0 {
+			g.Scale = 0
+		}
+	case "float", "float4":
+		g.Base, g.Bits = baseFloat, 32
+		// mysql float(p) is a double from 25 bits of precision on
+		if arg(0) > 24 {
+			g.Bits = 64
+		}
+	case "real":
+		g.Base, g.Bits = baseFloat, 32
+		if provider == "sqlite" {
+			g.Bits = 64
+		}
+	case "double", "double precision", "float8":
+		g.Base, g.Bits = baseFloat, 64
+	case "char", "character", "nchar", "bpchar":
+		g.Base, g.Length = baseChar, arg(0)
+	case "varchar", "character varying", "nvarchar", "varchar2", "nvarchar2":
+		g.Base, g.Length = baseVarchar, arg(0)
+	case "tinytext":
+		g.Base, g.Length = baseText, 255
+	case "text":
+		g.Base = baseText
+		if provider == "mysql" {
+			g.Length = 65535
+		}
+	case "mediumtext":
+		g.Base, g.Length = baseText, 16777215
+	case "longtext", "clob", "citext", "xml", "name":
+		g.Base = baseText
+	case "binary":
+		g.Base, g.Length = baseBinary, arg(0)
+	case "varbinary":
+		g.Base, g.Length = baseBlob, arg(0)
+	case "tinyblob":
+		g.Base, g.Length = baseBlob, 255
+	case "blob":
+		g.Base = baseBlob
+		if provider == "mysql" {
+			g.Length = 65535
+		}
+	case "mediumblob":
+		g.Base, g.Length = baseBlob, 16777215
+	case "longblob", "bytea":
+		g.Base = baseBlob
+	case "date":
+		g.Base = baseDate
+	case "time", "time without time zone":
+		g.Base, g.Precision = baseTime, arg(0)
+	case "time with time zone", "timetz":
+		g.Base, g.Precision = baseTimeTZ, arg(0)
+	case "datetime", "timestamp", "timestamp without time zone":
+		g.Base, g.Precision = baseTimestamp, arg(0)
+	case "timestamp with time zone", "timestamptz":
+		g.Base, g.Precision = baseTimestampTZ, arg(0)
+	case "year":
+		g.Base = baseYear
+	case "json", "jsonb":
+		g.Base = baseJSON
+	case "uuid":
+		g.Base = baseUUID
+	case "enum":
+		g.Base = baseEnum
+	case "set":
+		g.Base = baseSet
+	case "bit", "bit varying", "varbit":
+		g.Base, g.Length = baseBit, arg(0)
+	case "interval":
+		g.Base = baseInterval
+	case "inet", "cidr", "macaddr", "macaddr8":
+		g.Base, g.Length = baseVarchar, 43
+	default:
+		if provider == "sqlite" {
+			return sqliteAffinity(g, name, arg(0))
+		}
+		g.Base = baseOther
+	}
+	return g
+}
+
+// sqliteAffinity applies the rules sqlite uses to derive the affinity of
+// arbitrary type names.
+func sqliteAffinity(g generic, name string, length int) generic {
+	switch {
+	case strings.Contains(name, "int"):
+		g.Base, g.Bits = baseInt, 64
+	case strings.Contains(name, "char"), strings.Contains(name, "clob"), strings.Contains(name, "text"):
+		g.Base, g.Length = baseText, -1
+		if length > 0 {
+			g.Base, g.Length = baseVarchar, length
+		}
+	case name == "", strings.Contains(name, "blob"):
+		g.Base = baseBlob
+	case strings.Contains(name, "real"), strings.Contains(name, "floa"), strings.Contains(name, "doub"):
+		g.Base, g.Bits = baseFloat, 64
+	default:
+		g.Base = baseDecimal
+	}
+	return g
+}
+
+// isNumeric reports whether values of g are written as bare numbers.
+func (g generic) isNumeric() bool {
+	switch g.Base {
+	case baseInt, baseDecimal, baseFloat, baseYear:
+		return true
+	}
+	return false
+}
+
+// maxLength returns the longest member of an enum or set.
+func (g generic) maxLength() int {
+	n := 1
+	for _, v := range g.Values {
+		if len(v) > n {
+			n = len(v)
+		}
+	}
+	return n
+}
@@ -0,0 +1,136 @@
+package util
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilenamePlaceholders lists the placeholders of file name templates:
+//
+//	{tag}       tag of the connection
+//	{provider}  provider of the connection
+//	{db}        database, "all" when a file holds several
+//	{table}     table, "all" when a file holds several
+//	{tables}    short hash of the dumped tables, "all" for whole databases
+//	{unix}      unix time of the start of the dump
+//	{date}      date of the start of the dump, {date:<go layout>} to format it
+//	{time}      time of the start of the dump, {time:<go layout>} to format it
+var FilenamePlaceholders = []string{"tag", "provider", "db", "table", "tables", "unix", "date", "time"}
+
+// FilenameVars holds the values substituted into a file name template.
+type FilenameVars struct {
+	Tag      string
+	Provider string
+	Database string
+	Table    string
+	// Tables are the db.table names hashed by {tables}, nil for whole
+	// databases
+	Tables []string
+	Time   time.Time
+}
+
+var placeholderPattern = regexp.MustCompile(`\{([a-z]+)(?::([^}]*))?\}`)
+
+// unsafeFilenameChars matches what is replaced in substituted values so that
+// they cannot add directories or need quoting in a shell.
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// CheckFilenameTemplate reports the first problem of template: unknown
+// placeholders, layouts given to placeholders other than {date} and {time},
+// and paths leaving the dump directory.
+func CheckFilenameTemplate(template string) error {
+	if strings.TrimSpace(template) == "" {
+		return fmt.Errorf("template cannot be empty")
+	}
+	for _, m := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		known := false
+		for _, p := range FilenamePlaceholders {
+			known = known || p == m[1]
+		}
+		if !known {
+			return fmt.Errorf("unknown placeholder {%s}, expected one of %s", m[1], strings.Join(FilenamePlaceholders, ", "))
+		}
+		if m[2] != "" && m[1] != "date" && m[1] != "time" {
+			return fmt.Errorf("placeholder {%s} does not take a layout", m[1])
+		}
+	}
+	name, err := RenderFilename(template, FilenameVars{Tag: "tag", Provider: "provider", Database: "db", Table: "table", Time: time.Now()})
+	if err != nil {
+		return err
+	}
+	if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("template must name a file inside the dump directory")
+	}
+	return nil
+}
+
+// RenderFilename substitutes the placeholders of template with vars and
+// returns the cleaned relative path it names.
+func RenderFilename(template string, vars FilenameVars) (string, error) {
+	var err error
+	name := placeholderPattern.ReplaceAllStringFunc(template, func(s string) string {
+		m := placeholderPattern.FindStringSubmatch(s)
+		switch m[1] {
+		case "tag":
+			return safeFilename(vars.Tag)
+		case "provider":
+			return safeFilename(vars.Provider)
+		case "db":
+			return safeFilename(orAll(vars.Database))
+		case "table":
+			return safeFilename(orAll(vars.Table))
+		case "tables":
+			return tablesHash(vars.Tables)
+		case "unix":
+			return strconv.FormatInt(vars.Time.Unix(), 10)
+		case "date":
+			return vars.Time.Format(orDefault(m[2], "2006-01-02"))
+		case "time":
+			return vars.Time.Format(orDefault(m[2], "150405"))
+		}
+		err = fmt.Errorf("unknown placeholder {%s}", m[1])
+		return s
+	})
+	if err != nil {
+		return "", err
+	}
+	name = filepath.Clean(filepath.FromSlash(name))
+	if name == "." || strings.HasSuffix(name, string(filepath.Separator)) {
+		return "", fmt.Errorf("template %q does not name a file", template)
+	}
+	return name, nil
+}
+
+func safeFilename(value string) string {
+	return unsafeFilenameChars.ReplaceAllString(value, "_")
+}
+
+func orAll(value string) string {
+	return orDefault(value, "all")
+}
+
+func orDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// tablesHash returns a short digest of a set of tables, independent of their
+// order.
+func tablesHash(tables []string) string {
+	if len(tables) == 0 {
+		return "all"
+	}
+	sorted := append([]string(nil), tables...)
+	sort.Strings(sorted)
+	sum := sha1.Sum([]byte(strings.Join(sorted, ",")))
+	return hex.EncodeToString(sum[:])[:8]
+}
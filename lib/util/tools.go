@@ -0,0 +1,51 @@
+package util
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DumpTools lists the external binaries the dump flow may shell out to.
+var DumpTools = []string{"mysqldump", "pg_dump", "pg_restore"}
+
+type ExternalTool struct {
+	Name    string `json:"name"`
+	Path    string `json:"path,omitempty"`
+	Version string `json:"version,omitempty"`
+	Found   bool   `json:"found"`
+	Err     string `json:"error,omitempty"`
+}
+
+// DetectTool looks name up in $PATH and returns the first line printed by
+// `name --version`.
+func DetectTool(name string) ExternalTool {
+	tool := ExternalTool{Name: name}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		tool.Err = "not found in $PATH"
+		return tool
+	}
+	tool.Path = path
+	tool.Found = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+	if err != nil {
+		tool.Err = err.Error()
+		return tool
+	}
+	tool.Version = strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	return tool
+}
+
+// DetectDumpTools runs DetectTool on every entry of DumpTools.
+func DetectDumpTools() []ExternalTool {
+	tools := make([]ExternalTool, len(DumpTools))
+	for i, name := range DumpTools {
+		tools[i] = DetectTool(name)
+	}
+	return tools
+}
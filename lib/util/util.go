@@ -1,6 +1,10 @@
 package util
 
-import "github.com/charmbracelet/lipgloss"
+import (
+	"fmt"
+
+	"github.com/charmbracelet/lipgloss"
+)
 
 var (
 	titleStyle = lipgloss.NewStyle().
@@ -43,13 +47,57 @@ type TableListItem struct {
 	Name     string
 	Db       string
 	Selected bool
+	// Where and Sample select the rows of the table dumped, as typed in the
+	// table selection
+	Where  string
+	Sample string
 }
 
 func (t TableListItem) Title() string { return t.Name }
 func (t TableListItem) Description() string {
+	description := t.Db
 	if t.Selected {
-		return t.Db + " | " + titleStyle.Render("\u2713")
+		description += " | " + titleStyle.Render("\u2713")
+	}
+	if t.Where != "" {
+		description += " | where " + t.Where
 	}
-	return t.Db
+	if t.Sample != "" {
+		description += " | sample " + t.Sample
+	}
+	return description
 }
 func (t TableListItem) FilterValue() string { return t.Name }
+
+// TablesOf returns the names of the tables selected in db, nil when the whole
+// database is selected through "All" or no table at all.
+func TablesOf(db string, selected []TableListItem) []string {
+	tables := make([]string, 0)
+	for _, t := range selected {
+		if t.Db != db {
+			continue
+		}
+		if t.Name == "All" {
+			return nil
+		}
+		tables = append(tables, t.Name)
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tables
+}
+
+// HumanSize formats a byte count with binary units, e.g. 1.5 MiB.
+func HumanSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}